@@ -9,6 +9,7 @@
 #include <string>
 #include <vector>
 
+#include "gateway_util.h"
 #include "manifest.h"
 #include "network/download.h"
 #include "notify/messages.h"
@@ -31,7 +32,7 @@ const unsigned kDownloadRetries = 1;   // 2 attempts in total
 namespace notify {
 
 int DoPublish(const std::string& server_url, const std::string& repository_url,
-              bool verbose) {
+              const std::string& key_file, bool verbose) {
   const std::string repo_url = MakeCanonicalPath(repository_url);
 
   if (verbose) {
@@ -92,8 +93,21 @@ int DoPublish(const std::string& server_url, const std::string& repository_url,
 
   const std::string repository_name = manifest->repository_name();
 
+  // Read the key signing the message
+  const std::string key_file_name =
+      key_file.empty() ? "/etc/cvmfs/keys/" + repository_name + ".gw"
+                       : key_file;
+  std::string key_id;
+  std::string secret;
+  if (!gateway::ReadKeys(key_file_name, &key_id, &secret)) {
+    LogCvmfs(kLogCvmfs, kLogError, "Could not read the gateway key %s",
+             key_file_name.c_str());
+    return 10;
+  }
+
   // Publish message
-  UniquePtr<notify::Publisher> publisher(new notify::PublisherHTTP(server_url));
+  UniquePtr<notify::Publisher> publisher(
+      new notify::PublisherHTTP(server_url, key_id, secret));
 
   std::string msg_text;
   notify::msg::Activity msg;
@@ -13,10 +13,12 @@ namespace notify {
  * Encapsulates a publish operation to a notification server
  *
  * Publish the manifest of a CernVM-FS repository located at "repository_url" to
- * a CernVM-FS notification server located at "server_url"
+ * a CernVM-FS notification server located at "server_url". The message is
+ * signed with the gateway key of the repository read from "key_file", by
+ * default /etc/cvmfs/keys/<REPOSITORY>.gw
  */
 int DoPublish(const std::string& server_url, const std::string& repository_url,
-              bool verbose);
+              const std::string& key_file, bool verbose);
 
 }  // namespace notify
 
@@ -8,6 +8,7 @@
 
 #include "cvmfs_config.h"
 
+#include "crypto/hash.h"
 #include "util/logging.h"
 #include "util/string.h"
 
@@ -35,8 +36,12 @@ size_t RecvCB(void* buffer, size_t size, size_t nmemb, void* userp) {
 
 namespace notify {
 
-PublisherHTTP::PublisherHTTP(const std::string& server_url)
-    : server_url_(server_url + "/notifications/publish") {}
+PublisherHTTP::PublisherHTTP(const std::string& server_url,
+                             const std::string& key_id,
+                             const std::string& secret)
+    : server_url_(server_url + "/notifications/publish"),
+      key_id_(key_id),
+      secret_(secret) {}
 
 PublisherHTTP::~PublisherHTTP() {}
 
@@ -57,6 +62,14 @@ bool PublisherHTTP::Publish(const std::string& msg, const std::string& topic) {
     return false;
   }
 
+  shash::Any hmac(shash::kSha1);
+  shash::HmacString(secret_, msg, &hmac);
+  const std::string header_str = std::string("Authorization: ") + key_id_ +
+                                 " " + Base64(hmac.ToString(false));
+  struct curl_slist* auth_header = NULL;
+  auth_header = curl_slist_append(auth_header, header_str.c_str());
+  curl_easy_setopt(h_curl, CURLOPT_HTTPHEADER, auth_header);
+
   CurlBuffer buffer;
   // Make request to acquire lease from repo services
   curl_easy_setopt(h_curl, CURLOPT_URL, server_url_.c_str());
@@ -74,6 +87,7 @@ bool PublisherHTTP::Publish(const std::string& msg, const std::string& topic) {
 
   curl_easy_cleanup(h_curl);
   h_curl = NULL;
+  curl_slist_free_all(auth_header);
 
   return !ret;
 }
@@ -14,17 +14,22 @@ namespace notify {
 /**
  * Implementation of Publisher based on HTTP
  *
- * Messages are published to the notification system backend using cURL
+ * Messages are published to the notification system backend using cURL. The
+ * gateway only accepts the messages signed with a key of the repository: the
+ * HMAC of the message is sent in the Authorization header.
  */
 class PublisherHTTP : public Publisher {
  public:
-  explicit PublisherHTTP(const std::string& server_url);
+  PublisherHTTP(const std::string& server_url, const std::string& key_id,
+                const std::string& secret);
   virtual ~PublisherHTTP();
 
   virtual bool Publish(const std::string& msg, const std::string& topic);
 
  private:
   std::string server_url_;
+  std::string key_id_;
+  std::string secret_;
 };
 
 }  // namespace notify
@@ -6,6 +6,7 @@
 
 #include <time.h>
 
+#include <map>
 #include <vector>
 
 #include "catalog_diff_tool.h"
@@ -74,6 +75,29 @@ bool CreateNewTag(const RepositoryTag& repo_tag, const std::string& repo_name,
   return true;
 }
 
+std::string GetRepoName(const std::string& lease_path) {
+  return SplitString(lease_path, '/').front();
+}
+
+/**
+ * Adds the counters of from to the counters of the same name of to, which are
+ * registered if needed
+ */
+void AddStatistics(perf::Statistics* from, perf::Statistics* to) {
+  std::map<std::string, int64_t> counters;
+  uint64_t timestamp_ns;
+  from->SnapshotCounters(&counters, &timestamp_ns);
+  for (std::map<std::string, int64_t>::const_iterator i = counters.begin(),
+       iEnd = counters.end(); i != iEnd; ++i)
+  {
+    perf::Counter* counter = to->Lookup(i->first);
+    if (counter == NULL) {
+      counter = to->Register(i->first, from->LookupDesc(i->first));
+    }
+    counter->Xadd(i->second);
+  }
+}
+
 }  // namespace
 
 namespace receiver {
@@ -102,23 +126,69 @@ CommitProcessor::Result CommitProcessor::Process(
     const std::string& lease_path, const shash::Any& old_root_hash,
     const shash::Any& new_root_hash, const RepositoryTag& tag,
     uint64_t *final_revision) {
-  RepositoryTag final_tag = tag;
-  // If tag_name is a generic tag, update the time stamp
-  if (final_tag.HasGenericName()) {
-    final_tag.SetGenericName();
+  std::vector<LeaseCommit> commits;
+  commits.push_back(LeaseCommit(lease_path, old_root_hash, new_root_hash, tag));
+  return ProcessBatch(commits, final_revision);
+}
+
+/**
+ * Applies the changes of several disjoint leases of a repository, one after
+ * the other, onto the current root catalog of the repository (see Process).
+ * The repository manifest is signed once, after all the changes were merged,
+ * so that the commits are published together in a single revision. Nothing is
+ * published if any of the merges fails.
+ *
+ * The tags of the commits are all added to the history. The generic tags are
+ * replaced by a single one.
+ */
+CommitProcessor::Result CommitProcessor::ProcessBatch(
+    const std::vector<LeaseCommit>& commits, uint64_t *final_revision) {
+  if (commits.empty()) {
+    LogCvmfs(kLogReceiver, kLogSyslogErr,
+             "CommitProcessor - error: no lease to commit");
+    return kError;
   }
 
-  LogCvmfs(kLogReceiver, kLogSyslog,
-           "CommitProcessor - lease_path: %s, old hash: %s, new hash: %s, "
-           "tag_name: %s, tag_description: %s",
-           lease_path.c_str(), old_root_hash.ToString(true).c_str(),
-           new_root_hash.ToString(true).c_str(), final_tag.name().c_str(),
-           final_tag.description().c_str());
+  std::vector<RepositoryTag> final_tags;
+  bool has_generic_tag = false;
+  std::string lease_paths;
+  for (size_t i = 0; i < commits.size(); ++i) {
+    const LeaseCommit& commit = commits[i];
+    RepositoryTag final_tag = commit.tag;
+    // If tag_name is a generic tag, update the time stamp. The batch gets a
+    // single generic tag.
+    bool skip_tag = false;
+    if (final_tag.HasGenericName()) {
+      skip_tag = has_generic_tag;
+      has_generic_tag = true;
+      final_tag.SetGenericName();
+    }
 
-  const std::vector<std::string> lease_path_tokens =
-      SplitString(lease_path, '/');
+    LogCvmfs(kLogReceiver, kLogSyslog,
+             "CommitProcessor - lease_path: %s, old hash: %s, new hash: %s, "
+             "tag_name: %s, tag_description: %s",
+             commit.lease_path.c_str(),
+             commit.old_root_hash.ToString(true).c_str(),
+             commit.new_root_hash.ToString(true).c_str(),
+             final_tag.name().c_str(), final_tag.description().c_str());
+
+    for (size_t j = 0; j < final_tags.size(); ++j) {
+      skip_tag |= (final_tags[j].name() == final_tag.name());
+    }
+    if (!skip_tag)
+      final_tags.push_back(final_tag);
+    lease_paths += (i == 0 ? "" : ",") + commit.lease_path;
+  }
 
-  const std::string repo_name = lease_path_tokens.front();
+  const std::string repo_name = GetRepoName(commits.front().lease_path);
+  for (size_t i = 1; i < commits.size(); ++i) {
+    if (GetRepoName(commits[i].lease_path) != repo_name) {
+      LogCvmfs(kLogReceiver, kLogSyslogErr,
+               "CommitProcessor - error: the leases %s belong to different "
+               "repositories", lease_paths.c_str());
+      return kError;
+    }
+  }
 
   Params params;
   if (!GetParamsFromFile(repo_name, &params)) {
@@ -158,7 +228,7 @@ CommitProcessor::Result CommitProcessor::Process(
 
   LogCvmfs(kLogReceiver, kLogSyslog,
            "CommitProcessor - lease_path: %s, target root hash: %s",
-           lease_path.c_str(),
+           lease_paths.c_str(),
            manifest->catalog_hash().ToString(false).c_str());
 
   const std::string spooler_temp_dir =
@@ -168,29 +238,46 @@ CommitProcessor::Result CommitProcessor::Process(
   const std::string temp_dir_root =
       spooler_temp_dir + "/receiver/commit_processor";
 
-  const PathString relative_lease_path = RemoveRepoName(PathString(lease_path));
+  // Add the C_N root catalog hashes to reflog through SigningTool,
+  // so garbage collector can later delete them. The same goes for the
+  // intermediate root catalogs of a batch, which are replaced by the next
+  // merge.
+  std::vector<shash::Any> reflog_catalogs;
+  std::string new_manifest_path;
+  for (size_t i = 0; i < commits.size(); ++i) {
+    const LeaseCommit& commit = commits[i];
+    const PathString relative_lease_path =
+        RemoveRepoName(PathString(commit.lease_path));
 
-  LogCvmfs(kLogReceiver, kLogSyslog,
-           "CommitProcessor - lease_path: %s, merging catalogs",
-           lease_path.c_str());
-
-  CatalogMergeTool<catalog::WritableCatalogManager,
-                   catalog::SimpleCatalogManager>
-      merge_tool(params.stratum0, old_root_hash, new_root_hash,
-                 relative_lease_path, temp_dir_root,
-                 server_tool->download_manager(), manifest.weak_ref(),
-                 statistics_);
-  if (!merge_tool.Init()) {
-    LogCvmfs(kLogReceiver, kLogSyslogErr,
-             "Error: Could not initialize the catalog merge tool");
-    return kError;
-  }
+    LogCvmfs(kLogReceiver, kLogSyslog,
+             "CommitProcessor - lease_path: %s, merging catalogs",
+             commit.lease_path.c_str());
+
+    // The merge tool registers its counters, which are added to the
+    // statistics of the commit afterwards since a batch runs several merges
+    perf::Statistics merge_statistics;
+    CatalogMergeTool<catalog::WritableCatalogManager,
+                     catalog::SimpleCatalogManager>
+        merge_tool(params.stratum0, commit.old_root_hash, commit.new_root_hash,
+                   relative_lease_path, temp_dir_root,
+                   server_tool->download_manager(), manifest.weak_ref(),
+                   &merge_statistics);
+    if (!merge_tool.Init()) {
+      LogCvmfs(kLogReceiver, kLogSyslogErr,
+               "Error: Could not initialize the catalog merge tool");
+      return kError;
+    }
 
-  std::string new_manifest_path;
-  if (!merge_tool.Run(params, &new_manifest_path, final_revision)) {
-    LogCvmfs(kLogReceiver, kLogSyslogErr,
-             "CommitProcessor - error: Catalog merge failed");
-    return kMergeFailure;
+    if (i > 0)
+      reflog_catalogs.push_back(manifest->catalog_hash());
+    if (!merge_tool.Run(params, &new_manifest_path, final_revision)) {
+      LogCvmfs(kLogReceiver, kLogSyslogErr,
+               "CommitProcessor - error: Catalog merge failed");
+      return kMergeFailure;
+    }
+    reflog_catalogs.push_back(commit.new_root_hash);
+    if (statistics_ != NULL)
+      AddStatistics(&merge_statistics, statistics_);
   }
 
   UniquePtr<RaiiTempDir> raii_temp_dir(RaiiTempDir::Create(temp_dir_root));
@@ -198,11 +285,13 @@ CommitProcessor::Result CommitProcessor::Process(
   const std::string certificate = "/etc/cvmfs/keys/" + repo_name + ".crt";
   const std::string private_key = "/etc/cvmfs/keys/" + repo_name + ".key";
 
-  if (!CreateNewTag(final_tag, repo_name, params, temp_dir, new_manifest_path,
-                    public_key, params.proxy)) {
-    LogCvmfs(kLogReceiver, kLogSyslogErr, "Error creating tag: %s",
-             final_tag.name().c_str());
-    return kError;
+  for (size_t i = 0; i < final_tags.size(); ++i) {
+    if (!CreateNewTag(final_tags[i], repo_name, params, temp_dir,
+                      new_manifest_path, public_key, params.proxy)) {
+      LogCvmfs(kLogReceiver, kLogSyslogErr, "Error creating tag: %s",
+               final_tags[i].name().c_str());
+      return kError;
+    }
   }
 
   // We need to re-initialize the ServerTool component for signing
@@ -211,12 +300,7 @@ CommitProcessor::Result CommitProcessor::Process(
 
   LogCvmfs(kLogReceiver, kLogSyslog,
            "CommitProcessor - lease_path: %s, signing manifest",
-           lease_path.c_str());
-
-  // Add C_N root catalog hash to reflog through SigningTool,
-  // so garbage collector can later delete it.
-  std::vector<shash::Any> reflog_catalogs;
-  reflog_catalogs.push_back(new_root_hash);
+           lease_paths.c_str());
 
   SigningTool signing_tool(server_tool.weak_ref());
   SigningTool::Result res = signing_tool.Run(
@@ -241,7 +325,7 @@ CommitProcessor::Result CommitProcessor::Process(
     case SigningTool::kSuccess:
       LogCvmfs(kLogReceiver, kLogSyslog,
                "CommitProcessor - lease_path: %s, success.",
-               lease_path.c_str());
+               lease_paths.c_str());
   }
 
   {
@@ -268,7 +352,7 @@ CommitProcessor::Result CommitProcessor::Process(
 
     LogCvmfs(kLogReceiver, kLogSyslog,
              "CommitProcessor - lease_path: %s, new root hash: %s",
-             lease_path.c_str(),
+             lease_paths.c_str(),
              manifest->catalog_hash().ToString(false).c_str());
   }
 
@@ -6,6 +6,7 @@
 #define CVMFS_RECEIVER_COMMIT_PROCESSOR_H_
 
 #include <string>
+#include <vector>
 
 #include "repository_tag.h"
 #include "server_tool.h"
@@ -22,11 +23,32 @@ namespace receiver {
  * Its responsibility is updating the repository (sub-)catalogs with the changes
  * introduced during the lease. After all the catalogs have been updated, the
  * repository manifest is also updated and resigned.
+ *
+ * The kCommitBatch events merge the commits of several disjoint leases of a
+ * repository, which are then published together under a single signature.
  */
 class CommitProcessor {
  public:
   enum Result { kSuccess, kError, kMergeFailure, kMissingReflog};
 
+  /**
+   * One of the lease commits merged by ProcessBatch
+   */
+  struct LeaseCommit {
+    LeaseCommit(const std::string& lease_path, const shash::Any& old_root_hash,
+                const shash::Any& new_root_hash, const RepositoryTag& tag)
+      : lease_path(lease_path)
+      , old_root_hash(old_root_hash)
+      , new_root_hash(new_root_hash)
+      , tag(tag)
+    { }
+
+    std::string lease_path;
+    shash::Any old_root_hash;
+    shash::Any new_root_hash;
+    RepositoryTag tag;
+  };
+
   CommitProcessor();
   virtual ~CommitProcessor();
 
@@ -34,6 +56,9 @@ class CommitProcessor {
                  const shash::Any& new_root_hash, const RepositoryTag& tag,
                  uint64_t *final_revision);
 
+  Result ProcessBatch(const std::vector<LeaseCommit>& commits,
+                      uint64_t *final_revision);
+
   int GetNumErrors() const { return num_errors_; }
 
   void SetStatistics(perf::Statistics *st, const std::string &start_time);
@@ -386,6 +386,36 @@ bool Reactor::HandleSubmitPayload(int fdin, const std::string& req,
   return true;
 }
 
+namespace {
+
+void AddCommitResult(CommitProcessor::Result res, uint64_t final_revision,
+                     JsonStringGenerator* reply_input) {
+  switch (res) {
+    case CommitProcessor::kSuccess:
+      reply_input->Add("status", "ok");
+      reply_input->Add("final_revision", static_cast<int64_t>(final_revision));
+      break;
+    case CommitProcessor::kError:
+      reply_input->Add("status", "error");
+      reply_input->Add("reason", "miscellaneous");
+      break;
+    case CommitProcessor::kMergeFailure:
+      reply_input->Add("status", "error");
+      reply_input->Add("reason", "merge_error");
+      break;
+    case CommitProcessor::kMissingReflog:
+      reply_input->Add("status", "error");
+      reply_input->Add("reason", "missing_reflog");
+      break;
+    default:
+      PANIC(kLogSyslogErr,
+            "Unknown value of CommitProcessor::Result encountered.");
+      break;
+  }
+}
+
+}  // anonymous namespace
+
 bool Reactor::HandleCommit(const std::string& req, std::string* reply) {
   if (!reply) {
     PANIC(kLogSyslogErr, "HandleCommit: Invalid reply pointer.");
@@ -440,29 +470,88 @@ bool Reactor::HandleCommit(const std::string& req, std::string* reply) {
                     repo_tag, &final_revision);
 
   JsonStringGenerator reply_input;
-  switch (res) {
-    case CommitProcessor::kSuccess:
-      reply_input.Add("status", "ok");
-      reply_input.Add("final_revision", static_cast<int64_t>(final_revision));
-      break;
-    case CommitProcessor::kError:
-      reply_input.Add("status", "error");
-      reply_input.Add("reason", "miscellaneous");
-      break;
-    case CommitProcessor::kMergeFailure:
-      reply_input.Add("status", "error");
-      reply_input.Add("reason", "merge_error");
-      break;
-    case CommitProcessor::kMissingReflog:
-      reply_input.Add("status", "error");
-      reply_input.Add("reason", "missing_reflog");
-      break;
-    default:
-      PANIC(kLogSyslogErr,
-            "Unknown value of CommitProcessor::Result encountered.");
-      break;
+  AddCommitResult(res, final_revision, &reply_input);
+
+  std::string json = reply_input.GenerateString();
+  *reply = json;
+
+  return true;
+}
+
+/**
+ * Commits several disjoint leases in a single revision. The request holds the
+ * "commits" array, with the fields of the kCommit requests for each lease, and
+ * the "statistics" counters of all the leases.
+ */
+bool Reactor::HandleCommitBatch(const std::string& req, std::string* reply) {
+  if (!reply) {
+    PANIC(kLogSyslogErr, "HandleCommitBatch: Invalid reply pointer.");
+  }
+  UniquePtr<JsonDocument> req_json(JsonDocument::Create(req));
+  if (!req_json.IsValid()) {
+    LogCvmfs(kLogReceiver, kLogSyslogErr,
+             "HandleCommitBatch: Invalid JSON request.");
+    return false;
   }
 
+  const JSON* commits_json =
+      JsonDocument::SearchInObject(req_json->root(), "commits", JSON_ARRAY);
+  if (commits_json == NULL) {
+    LogCvmfs(kLogReceiver, kLogSyslogErr,
+             "HandleCommitBatch: Missing commits in request.");
+    return false;
+  }
+
+  std::vector<CommitProcessor::LeaseCommit> commits;
+  for (const JSON* c = commits_json->first_child; c != NULL;
+       c = c->next_sibling) {
+    const JSON* lease_path_json =
+        JsonDocument::SearchInObject(c, "lease_path", JSON_STRING);
+    const JSON* old_root_hash_json =
+        JsonDocument::SearchInObject(c, "old_root_hash", JSON_STRING);
+    const JSON* new_root_hash_json =
+        JsonDocument::SearchInObject(c, "new_root_hash", JSON_STRING);
+    const JSON* tag_name_json =
+        JsonDocument::SearchInObject(c, "tag_name", JSON_STRING);
+    const JSON* tag_description_json =
+        JsonDocument::SearchInObject(c, "tag_description", JSON_STRING);
+
+    if (lease_path_json == NULL || old_root_hash_json == NULL ||
+        new_root_hash_json == NULL || tag_name_json == NULL ||
+        tag_description_json == NULL) {
+      LogCvmfs(kLogReceiver, kLogSyslogErr,
+               "HandleCommitBatch: Missing fields in request.");
+      return false;
+    }
+
+    commits.push_back(CommitProcessor::LeaseCommit(
+        lease_path_json->string_value,
+        shash::MkFromSuffixedHexPtr(
+            shash::HexPtr(old_root_hash_json->string_value)),
+        shash::MkFromSuffixedHexPtr(
+            shash::HexPtr(new_root_hash_json->string_value)),
+        RepositoryTag(tag_name_json->string_value,
+                      tag_description_json->string_value)));
+  }
+
+  perf::Statistics statistics;
+  std::string start_time;
+  if (!Reactor::ExtractStatsFromReq(req_json.weak_ref(), &statistics,
+                                    &start_time)) {
+    LogCvmfs(
+        kLogReceiver, kLogSyslogErr,
+        "HandleCommitBatch: Could not extract statistics counters from "
+        "request");
+  }
+  uint64_t final_revision = 0;
+
+  UniquePtr<CommitProcessor> proc(MakeCommitProcessor());
+  proc->SetStatistics(&statistics, start_time);
+  CommitProcessor::Result res = proc->ProcessBatch(commits, &final_revision);
+
+  JsonStringGenerator reply_input;
+  AddCommitResult(res, final_revision, &reply_input);
+
   std::string json = reply_input.GenerateString();
   *reply = json;
 
@@ -486,7 +575,9 @@ bool Reactor::HandleRequest(Request req, const std::string& data) {
         ok = WriteReply(fdout_, "ok");
         break;
       case kEcho:
-        ok = WriteReply(fdout_, std::string("PID: ") + StringifyUint(getpid()));
+        // The features following the PID are checked by the gateway
+        ok = WriteReply(fdout_, std::string("PID: ") + StringifyUint(getpid()) +
+                                " features: commit_batch");
         break;
       case kGenerateToken:
         ok &= HandleGenerateToken(data, &reply);
@@ -508,6 +599,10 @@ bool Reactor::HandleRequest(Request req, const std::string& data) {
         ok &= HandleCommit(data, &reply);
         ok &= WriteReply(fdout_, reply);
         break;
+      case kCommitBatch:
+        ok &= HandleCommitBatch(data, &reply);
+        ok &= WriteReply(fdout_, reply);
+        break;
       case kTestCrash:
         PANIC(kLogSyslogErr,
               "Crash for test purposes. Should never happen in production "
@@ -36,7 +36,8 @@ class Reactor {
     kSubmitPayload,
     kCommit,
     kError,
-    kTestCrash  // use to test the gateway
+    kTestCrash,  // use to test the gateway
+    kCommitBatch
   };
 
   static Request ReadRequest(int fd, std::string* data);
@@ -63,6 +64,7 @@ class Reactor {
   virtual bool HandleSubmitPayload(int fdin, const std::string& req,
                                    std::string* reply);
   virtual bool HandleCommit(const std::string& req, std::string* reply);
+  virtual bool HandleCommitBatch(const std::string& req, std::string* reply);
 
   virtual PayloadProcessor* MakePayloadProcessor();
   virtual CommitProcessor* MakeCommitProcessor();
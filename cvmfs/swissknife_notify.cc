@@ -58,6 +58,9 @@ ParameterList CommandNotify::GetParams() const {
   l.push_back(Parameter::Mandatory('u', "notification server URL"));
   l.push_back(
       Parameter::Optional('r', "URL of repository with manifest to publish"));
+  l.push_back(Parameter::Optional(
+      'k', "gateway key file signing the publication "
+           "(default: /etc/cvmfs/keys/<REPOSITORY>.gw)"));
   l.push_back(Parameter::Optional('t', "subscription topic"));
   l.push_back(Parameter::Optional('m', "minimum revision number of interest"));
   l.push_back(Parameter::Switch(
@@ -77,7 +80,11 @@ int CommandNotify::Main(const ArgumentList& args) {
   bool publish = args.count('p') > 0;
   if (publish) {
     std::string repository_url = *args.find('r')->second;
-    ret = notify::DoPublish(server_url, repository_url, verbose);
+    std::string key_file;
+    if (args.count('k') > 0) {
+      key_file = *args.find('k')->second;
+    }
+    ret = notify::DoPublish(server_url, repository_url, key_file, verbose);
   } else {  // subscribe
     std::string topic = *args.find('t')->second;
     bool continuous = args.count('c') > 0;
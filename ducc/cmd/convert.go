@@ -42,14 +42,6 @@ var convertCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		AliveMessage()
 
-		if (skipLayers == false) && (skipThinImage == false) {
-			_, err := lib.GetPassword()
-			if err != nil {
-				l.LogE(err).Error("No password provide to upload the docker images")
-				os.Exit(NoPasswordError)
-			}
-		}
-
 		defer exec.ExecCommand("docker", "system", "prune", "--force", "--all")
 
 		data, err := ioutil.ReadFile(args[0])
@@ -57,44 +49,61 @@ var convertCmd = &cobra.Command{
 			l.LogE(err).Error("Impossible to read the recipe file")
 			os.Exit(GetRecipeFileError)
 		}
-		recipe, err := lib.ParseYamlRecipeV1(data)
+		recipe, err := lib.ParseRecipe(args[0], data)
 		if err != nil {
 			l.LogE(err).Error("Impossible to parse the recipe file")
 			os.Exit(ParseRecipeFileError)
 		}
-		if !cvmfs.RepositoryExists(recipe.Repo) {
-			l.LogE(err).Error("The repository does not seems to exists.")
-			os.Exit(RepoNotExistsError)
+		if (skipLayers == false) && (skipThinImage == false) {
+			err := lib.CheckPushCredentials(recipe.OutputImages)
+			if err != nil {
+				l.LogE(err).Error("No credentials provided to upload the docker images")
+				os.Exit(NoPasswordError)
+			}
+		}
+		for _, repo := range recipe.Repos {
+			if !cvmfs.RepositoryExists(repo) {
+				l.Log().WithFields(log.Fields{"repository": repo}).Error("The repository does not seems to exists.")
+				os.Exit(RepoNotExistsError)
+			}
 		}
 		for wish := range recipe.Wishes {
 			fields := log.Fields{"input image": wish.InputName,
 				"repository":   wish.CvmfsRepo,
 				"output image": wish.OutputName}
 			l.Log().WithFields(fields).Info("Start conversion of wish")
-			if !skipLayers {
+			if !skipLayers && wish.Options.Outputs.Layers {
 				err = lib.ConvertWish(wish, convertAgain, overwriteLayer)
 				if err != nil {
 					l.LogE(err).WithFields(fields).Error("Error in converting wish (layers), going on")
 				}
 			}
-			if !skipThinImage {
+			if !skipThinImage && wish.Options.Outputs.Thin {
 				err = lib.ConvertWishDocker(wish)
 				if err != nil {
 					l.LogE(err).WithFields(fields).Error("Error in converting wish (docker), going on")
 				}
 			}
-			if !skipPodman {
+			if !skipPodman && wish.Options.Outputs.Podman {
 				err = lib.ConvertWishPodman(wish, convertAgain)
 				if err != nil {
 					l.LogE(err).WithFields(fields).Error("Error in converting wish (podman), going on")
 				}
 			}
-			if !skipFlat {
+			if !skipFlat && wish.Options.Outputs.Flat {
 				err = lib.ConvertWishFlat(wish)
 				if err != nil {
 					l.LogE(err).WithFields(fields).Error("Error in converting wish (singularity), going on")
 				}
 			}
+			err = lib.PublishPlatformManifests(wish)
+			if err != nil {
+				l.LogE(err).WithFields(fields).Error("Error in recording the converted platforms, going on")
+			}
+			err = lib.RetireExpiredTags(wish)
+			if err != nil {
+				l.LogE(err).WithFields(fields).Error("Error in removing the tags out of the retention, going on")
+			}
 		}
 	},
 }
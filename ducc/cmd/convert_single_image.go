@@ -14,6 +14,7 @@ import (
 var (
 	thinImageName string
 	attempts      int
+	platforms     []string
 )
 
 func init() {
@@ -24,6 +25,7 @@ func init() {
 	convertSingleImageCmd.Flags().StringVarP(&username, "username", "u", "", "username to use when pushing thin image into the docker registry")
 	convertSingleImageCmd.Flags().StringVarP(&thinImageName, "thin-image-name", "", "", "name to use for the thin image to upload, if empty implies --skip-thin-image.")
 	convertSingleImageCmd.Flags().IntVarP(&attempts, "attempts", "r", 1, "number of time to try to unpack the image, default one")
+	convertSingleImageCmd.Flags().StringSliceVarP(&platforms, "platform", "", []string{}, "platform (os/architecture[/variant]) to convert from a multi-architecture image, may be repeated, default linux/amd64")
 	rootCmd.AddCommand(convertSingleImageCmd)
 }
 
@@ -50,7 +52,11 @@ var convertSingleImageCmd = &cobra.Command{
 		}
 
 		if skipThinImage == false {
-			_, err := lib.GetPassword()
+			output, err := lib.ParseImage(thinImageName)
+			if err == nil {
+				output.User = username
+				err = lib.CheckPushCredentials([]lib.Image{output})
+			}
 			if err != nil {
 				l.LogE(err).Warning("Asked to create the docker thin image but did not provide the password for the registry, we cannot push the thin image to the registry, hence we won't create it. We will unpack the layers.")
 				skipThinImage = true
@@ -62,8 +68,18 @@ var convertSingleImageCmd = &cobra.Command{
 			os.Exit(RepoNotExistsError)
 		}
 
+		options := lib.WishOptions{Outputs: lib.AllOutputs}
+		for _, p := range platforms {
+			platform, err := lib.ParsePlatform(p)
+			if err != nil {
+				l.LogE(err).Error("Error in parsing the platform")
+				os.Exit(1)
+			}
+			options.Platforms = append(options.Platforms, platform)
+		}
+
 		input, err := lib.ParseImage(inputImage)
-		wish, err := lib.CreateWish(input, thinImageName, cvmfsRepo, username, username)
+		wish, err := lib.CreateWish(input, thinImageName, cvmfsRepo, username, username, options)
 		if err != nil {
 			l.LogE(err).Error("Error in creating the wish to convert")
 			os.Exit(1)
@@ -128,5 +144,9 @@ var convertSingleImageCmd = &cobra.Command{
 				}
 			}
 		}
+
+		if err := lib.PublishPlatformManifests(wish); err != nil {
+			l.LogE(err).WithFields(fields).Error("Error in recording the converted platforms")
+		}
 	},
 }
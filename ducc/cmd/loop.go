@@ -57,44 +57,54 @@ var loopCmd = &cobra.Command{
 				l.LogE(err).Fatal("Impossible to read the recipe file")
 				os.Exit(1)
 			}
-			recipe, err := lib.ParseYamlRecipeV1(data)
+			recipe, err := lib.ParseRecipe(args[0], data)
 			if err != nil {
 				l.LogE(err).Fatal("Impossible to parse the recipe file")
 				os.Exit(1)
 			}
-			if !cvmfs.RepositoryExists(recipe.Repo) {
-				l.LogE(err).Error("The repository does not exists.")
-				os.Exit(RepoNotExistsError)
+			for _, repo := range recipe.Repos {
+				if !cvmfs.RepositoryExists(repo) {
+					l.Log().WithFields(log.Fields{"repository": repo}).Error("The repository does not exists.")
+					os.Exit(RepoNotExistsError)
+				}
 			}
 			for wish := range recipe.Wishes {
 				fields := log.Fields{"input image": wish.InputName,
 					"repository":   wish.CvmfsRepo,
 					"output image": wish.OutputName}
 				l.Log().WithFields(fields).Info("Start conversion of wish")
-				if !skipLayers {
+				if !skipLayers && wish.Options.Outputs.Layers {
 					err = lib.ConvertWish(wish, convertAgain, overwriteLayer)
 					if err != nil {
 						l.LogE(err).WithFields(fields).Error("Error in converting wish (layers), going on")
 					}
 				}
-				if !skipThinImage {
+				if !skipThinImage && wish.Options.Outputs.Thin {
 					err = lib.ConvertWishDocker(wish)
 					if err != nil {
 						l.LogE(err).WithFields(fields).Error("Error in converting wish (docker), going on")
 					}
 				}
-				if !skipPodman {
+				if !skipPodman && wish.Options.Outputs.Podman {
 					err = lib.ConvertWishPodman(wish, convertAgain)
 					if err != nil {
 						l.LogE(err).WithFields(fields).Error("Error in converting wish (podman), going on")
 					}
 				}
-				if !skipFlat {
+				if !skipFlat && wish.Options.Outputs.Flat {
 					err = lib.ConvertWishFlat(wish)
 					if err != nil {
 						l.LogE(err).WithFields(fields).Error("Error in converting wish (singularity), going on")
 					}
 				}
+				err = lib.PublishPlatformManifests(wish)
+				if err != nil {
+					l.LogE(err).WithFields(fields).Error("Error in recording the converted platforms, going on")
+				}
+				err = lib.RetireExpiredTags(wish)
+				if err != nil {
+					l.LogE(err).WithFields(fields).Error("Error in removing the tags out of the retention, going on")
+				}
 				checkQuitSignal()
 			}
 			checkQuitSignal()
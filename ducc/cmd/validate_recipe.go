@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cvmfs/ducc/lib"
+)
+
+func init() {
+	rootCmd.AddCommand(validateRecipeCmd)
+}
+
+var validateRecipeCmd = &cobra.Command{
+	Use:     "validate-recipe wish-list.yaml",
+	Short:   "Check the recipe and its includes, reporting the file, line and column of each problem",
+	Aliases: []string{"check-recipe"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(GetRecipeFileError)
+		}
+		errs := lib.ValidateRecipe(args[0], data)
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		if len(errs) > 0 {
+			os.Exit(ParseRecipeFileError)
+		}
+		fmt.Println("The recipe is valid")
+		os.Exit(0)
+	},
+}
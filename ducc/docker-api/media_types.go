@@ -0,0 +1,136 @@
+package dockerutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Media types of the manifests, following the docker distribution and the
+// OCI image specifications
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+
+	MediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+	MediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+)
+
+// Media types of the layers
+const (
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+
+	MediaTypeOCILayer     = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
+	MediaTypeOCINondistributableLayer     = "application/vnd.oci.image.layer.nondistributable.v1.tar"
+	MediaTypeOCINondistributableLayerGzip = "application/vnd.oci.image.layer.nondistributable.v1.tar+gzip"
+	MediaTypeOCINondistributableLayerZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+)
+
+// AcceptManifest is the Accept header asking for the manifest of an image
+var AcceptManifest = strings.Join([]string{MediaTypeDockerManifest, MediaTypeOCIManifest}, ", ")
+
+// AcceptManifestList is the Accept header asking for the manifest list of an
+// image, the registries answer with the manifest if the image has no list
+var AcceptManifestList = strings.Join([]string{
+	MediaTypeDockerManifestList, MediaTypeOCIIndex,
+	MediaTypeDockerManifest, MediaTypeOCIManifest}, ", ")
+
+// IsManifestList returns true for the media types of the manifest lists and
+// of the OCI indexes
+func IsManifestList(mediaType string) bool {
+	return mediaType == MediaTypeDockerManifestList || mediaType == MediaTypeOCIIndex
+}
+
+// Compression is the compression algorithm of a layer
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// GetCompression returns the compression of the layer, from its media type.
+// Layers without a media type are assumed to be gzipped, like the layers of
+// the docker images.
+func (l Layer) GetCompression() (Compression, error) {
+	switch l.MediaType {
+	case "", MediaTypeDockerLayer, MediaTypeDockerForeignLayer,
+		MediaTypeOCILayerGzip, MediaTypeOCINondistributableLayerGzip:
+		return Gzip, nil
+	case MediaTypeOCILayerZstd, MediaTypeOCINondistributableLayerZstd:
+		return Zstd, nil
+	case MediaTypeOCILayer, MediaTypeOCINondistributableLayer:
+		return Uncompressed, nil
+	}
+	return Uncompressed, fmt.Errorf("unsupported layer media type: %s", l.MediaType)
+}
+
+// IsForeign returns true if the layer is not distributed by the registry, in
+// which case it is not downloaded nor ingested
+func (l Layer) IsForeign() bool {
+	switch l.MediaType {
+	case MediaTypeDockerForeignLayer, MediaTypeOCINondistributableLayer,
+		MediaTypeOCINondistributableLayerGzip, MediaTypeOCINondistributableLayerZstd:
+		return true
+	}
+	return false
+}
+
+// IsArtifact returns true if the manifest describes an OCI artifact (signature,
+// SBOM, helm chart, ...) rather than a container image. Artifacts either
+// declare their type or use a configuration that is not an image one.
+func (m Manifest) IsArtifact() bool {
+	if m.ArtifactType != "" {
+		return true
+	}
+	switch m.Config.MediaType {
+	case "", MediaTypeDockerConfig, MediaTypeOCIConfig:
+		return false
+	}
+	return true
+}
+
+// IsArtifact returns true if the entry of the manifest list is not the image
+// of a platform, like the attestations attached by buildkit
+func (i ManifestListItem) IsArtifact() bool {
+	if i.ArtifactType != "" {
+		return true
+	}
+	if i.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+		return true
+	}
+	switch i.MediaType {
+	case "", MediaTypeDockerManifest, MediaTypeOCIManifest:
+		return false
+	}
+	return true
+}
+
+// Images returns the entries of the manifest list describing images, leaving
+// out the artifacts
+func (m ManifestList) Images() []ManifestListItem {
+	images := []ManifestListItem{}
+	for _, item := range m.Manifests {
+		if !item.IsArtifact() {
+			images = append(images, item)
+		}
+	}
+	return images
+}
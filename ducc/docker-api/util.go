@@ -23,6 +23,7 @@ type Layer struct {
 type Manifest struct {
 	SchemaVersion int
 	MediaType     string
+	ArtifactType  string `json:",omitempty"`
 	Config        ConfigType
 	Layers        []Layer
 }
@@ -34,16 +35,77 @@ type ManifestList struct {
 }
 
 type ManifestListItem struct {
-	MediaType string
-	Size      int
-	Digest    string
-	Platform  struct {
+	MediaType    string
+	ArtifactType string
+	Size         int
+	Digest       string
+	Annotations  map[string]string
+	Platform     struct {
 		Architecture string
 		OS           string
 		Variant      *string
 	}
 }
 
+// GetPlatform returns the platform of the manifest
+func (i ManifestListItem) GetPlatform() Platform {
+	p := Platform{OS: i.Platform.OS, Architecture: i.Platform.Architecture}
+	if i.Platform.Variant != nil {
+		p.Variant = *i.Platform.Variant
+	}
+	return p
+}
+
+// Platform identifies the os, architecture and (optional) variant of the
+// images of a manifest list
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// DefaultPlatform is the platform converted when a recipe does not ask for
+// any, its images are stored in the original layout of the repository
+var DefaultPlatform = Platform{OS: "linux", Architecture: "amd64"}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// Dir is the directory containing the images of the platform
+func (p Platform) Dir() string {
+	return strings.Replace(p.String(), "/", "-", -1)
+}
+
+// Matches returns true if the platform is selected by the requested one, a
+// request without variant selects all the variants of the architecture
+func (p Platform) Matches(requested Platform) bool {
+	return p.OS == requested.OS && p.Architecture == requested.Architecture &&
+		(requested.Variant == "" || p.Variant == requested.Variant)
+}
+
+// PlatformManifest describes the image of a platform, converted from a
+// manifest list
+type PlatformManifest struct {
+	Platform Platform `json:"platform"`
+	// Digest is the digest of the manifest of the platform
+	Digest string `json:"digest"`
+	// Config is the digest of the configuration, the ID of the image
+	Config string `json:"config"`
+	// Path is the directory containing the images of the platform, empty for
+	// the default platform
+	Path string `json:"path"`
+}
+
+// PlatformManifests is the record of the platforms converted from a manifest
+// list, which consumers use to select the image of their architecture
+type PlatformManifests struct {
+	Manifests []PlatformManifest `json:"manifests"`
+}
+
 type ThinImageLayer struct {
 	Digest string `json:"digest"`
 	Url    string `json:"url,omitempty"`
@@ -101,7 +163,7 @@ func (m Manifest) GetChainIDs() []digest.Digest {
 	result := []digest.Digest{}
 	iChain := 0
 	for _, l := range m.Layers {
-		if l.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if l.IsForeign() {
 			result = append(result, "")
 			continue;
 		}
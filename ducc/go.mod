@@ -3,6 +3,7 @@ module github.com/cvmfs/ducc
 require (
 	github.com/docker/docker v0.0.0-20190123164140-de86ba27fbea
 	github.com/google/uuid v1.2.0
+	github.com/klauspost/compress v1.16.7
 	github.com/olekukonko/tablewriter v0.0.1
 	github.com/opencontainers/go-digest v1.0.0-rc1
 	github.com/otiai10/copy v1.3.0
@@ -12,6 +13,7 @@ require (
 	github.com/spf13/cobra v0.0.3
 	golang.org/x/sys v0.1.0
 	gopkg.in/yaml.v2 v2.2.8
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,613 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	exec "github.com/cvmfs/ducc/exec"
+	l "github.com/cvmfs/ducc/log"
+)
+
+// Credentials authenticate DUCC against a registry, either with a username
+// and a password, like the ones of a robot account, with an identity token
+// exchanged for registry tokens, or with a registry token sent as it is.
+type Credentials struct {
+	username      string
+	password      string
+	identityToken string
+	registryToken string
+}
+
+func (c Credentials) empty() bool {
+	return c.username == "" && c.password == "" && c.identityToken == "" && c.registryToken == ""
+}
+
+type RegistryConfig struct {
+	baseUrl string
+	proxy   string
+	creds   Credentials
+}
+
+var inputRegistries []RegistryConfig
+
+// SetupRegistries loads the docker configuration and the registries listed in
+// $DUCC_AUTH_REGISTRIES, for each registry R:
+//
+//	DUCC_<R>_IDENT the host of the registry
+//	DUCC_<R>_USER and DUCC_<R>_PASS the credentials to authenticate
+//	DUCC_<R>_TOKEN a bearer token to send to the registry
+//	DUCC_<R>_PROXY a pull-through proxy of the registry
+//
+// The password and the token can also be read from the file in
+// DUCC_<R>_PASS_FILE and DUCC_<R>_TOKEN_FILE. The registries not completely
+// configured are skipped.
+func SetupRegistries() {
+	loadDockerConfig(dockerConfigPath())
+
+	inputRegistries = nil
+	regs := os.Getenv("DUCC_AUTH_REGISTRIES")
+	for _, r := range strings.Split(regs, ",") {
+		if r == "" {
+			continue
+		}
+
+		iEnv := "DUCC_" + r + "_IDENT"
+		uEnv := "DUCC_" + r + "_USER"
+		uPass := "DUCC_" + r + "_PASS"
+		tokenEnv := "DUCC_" + r + "_TOKEN"
+		proxyEnv := "DUCC_" + r + "_PROXY"
+		ident := os.Getenv(iEnv)
+		user := os.Getenv(uEnv)
+		pass := getSecret(uPass)
+		token := getSecret(tokenEnv)
+		proxy := os.Getenv(proxyEnv)
+
+		if ident == "" || ((user == "" || pass == "") && token == "" && proxy == "") {
+			l.Log().WithFields(log.Fields{"registry": r}).Errorf(
+				"missing either $%s, ($%s and $%s), $%s or $%s, ignoring the registry",
+				iEnv, uEnv, uPass, tokenEnv, proxyEnv)
+			continue
+		}
+
+		inputRegistries = append(inputRegistries, RegistryConfig{
+			ident,
+			proxy,
+			Credentials{username: user, password: pass, registryToken: token},
+		})
+	}
+}
+
+// getSecret reads a secret from the environment variable, or from the file
+// in the same variable with the _FILE suffix.
+func getSecret(envVar string) string {
+	if secret := os.Getenv(envVar); secret != "" {
+		return secret
+	}
+	path := os.Getenv(envVar + "_FILE")
+	if path == "" {
+		return ""
+	}
+	secret, err := ioutil.ReadFile(path)
+	if err != nil {
+		l.LogE(err).WithFields(log.Fields{"file": path}).Error("Impossible to read the secret")
+		return ""
+	}
+	return strings.TrimSpace(string(secret))
+}
+
+func getRegistry(url string) *RegistryConfig {
+	for _, reg := range inputRegistries {
+		if strings.Contains(url, reg.baseUrl) {
+			return &reg
+		}
+	}
+	return nil
+}
+
+// dockerConfig is the part of the docker configuration about the registries.
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+var dockerConfiguration dockerConfig
+
+// dockerHubServer is the key of the docker hub in the docker configuration,
+// whatever the name used for the registry.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+var dockerHubHosts = map[string]bool{
+	"docker.io":               true,
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"registry.hub.docker.com": true,
+}
+
+func dockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+func loadDockerConfig(path string) {
+	dockerConfiguration = dockerConfig{}
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.LogE(err).WithFields(log.Fields{"file": path}).Warning("Impossible to read the docker configuration")
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &dockerConfiguration); err != nil {
+		l.LogE(err).WithFields(log.Fields{"file": path}).Warning("Impossible to parse the docker configuration")
+		dockerConfiguration = dockerConfig{}
+		return
+	}
+	l.Log().WithFields(log.Fields{"file": path, "registries": len(dockerConfiguration.Auths)}).
+		Info("Loaded the docker configuration")
+}
+
+// registryHost returns the host of a registry, given as a server of the
+// docker configuration, an URL or just its name. All the names of the docker
+// hub return the same host.
+func registryHost(registry string) string {
+	if u, err := url.Parse(registry); err == nil && u.Host != "" {
+		registry = u.Host
+	}
+	host := strings.SplitN(registry, "/", 2)[0]
+	if dockerHubHosts[host] {
+		return "docker.io"
+	}
+	return host
+}
+
+func (a dockerAuth) credentials() Credentials {
+	creds := Credentials{
+		username:      a.Username,
+		password:      a.Password,
+		identityToken: a.IdentityToken,
+		registryToken: a.RegistryToken,
+	}
+	if a.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			l.LogE(err).Warning("Impossible to decode the auth of the docker configuration")
+			return creds
+		}
+		user, pass, found := strings.Cut(string(decoded), ":")
+		if found {
+			creds.username, creds.password = user, pass
+		}
+	}
+	return creds
+}
+
+// dockerCredentials returns the credentials of the docker configuration for
+// the registry host. Like docker, the credential helper of the registry is
+// preferred to the credentials store, which is preferred to the auths.
+func dockerCredentials(host string) (Credentials, bool) {
+	helper := dockerConfiguration.CredsStore
+	for server, h := range dockerConfiguration.CredHelpers {
+		if registryHost(server) == host {
+			helper = h
+		}
+	}
+	if helper != "" {
+		serverURL := host
+		if host == "docker.io" {
+			serverURL = dockerHubServer
+		}
+		creds, err := helperCredentials.get(helper, serverURL)
+		if err == nil && !creds.empty() {
+			return creds, true
+		}
+		if err != nil {
+			l.LogE(err).WithFields(log.Fields{"helper": helper, "registry": host}).
+				Warning("Impossible to get the credentials from the helper")
+		}
+	}
+	for server, auth := range dockerConfiguration.Auths {
+		if registryHost(server) != host {
+			continue
+		}
+		if creds := auth.credentials(); !creds.empty() {
+			return creds, true
+		}
+	}
+	return Credentials{}, false
+}
+
+// helperCredentialsTTL is how long the credentials from a helper are reused,
+// to avoid running the helper for every request.
+const helperCredentialsTTL = 10 * time.Minute
+
+type cachedCredentials struct {
+	creds   Credentials
+	expires time.Time
+}
+
+type helperCache struct {
+	lock  sync.Mutex
+	creds map[string]cachedCredentials
+}
+
+var helperCredentials = helperCache{creds: make(map[string]cachedCredentials)}
+
+func (c *helperCache) get(helper, serverURL string) (Credentials, error) {
+	key := helper + "|" + serverURL
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached, ok := c.creds[key]; ok && time.Now().Before(cached.expires) {
+		return cached.creds, nil
+	}
+	// failures are cached as well, so that a broken helper is not run, and
+	// reported, for every request
+	creds, err := runCredentialHelper(helper, serverURL)
+	c.creds[key] = cachedCredentials{creds, time.Now().Add(helperCredentialsTTL)}
+	return creds, err
+}
+
+// runCredentialHelper runs `docker-credential-<helper> get` following the
+// protocol of the docker credential helpers.
+func runCredentialHelper(helper, serverURL string) (Credentials, error) {
+	cmd := exec.ExecCommand("docker-credential-"+helper, "get")
+	if cmd == nil {
+		return Credentials{}, fmt.Errorf("impossible to run the credential helper %s", helper)
+	}
+	err, stdout, stderr := cmd.StdIn(ioutil.NopCloser(strings.NewReader(serverURL))).StartWithOutput()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("error from the credential helper %s: %s %s %s",
+			helper, err, strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()))
+	}
+	var resp struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err = json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return Credentials{}, fmt.Errorf("wrong output from the credential helper %s: %s", helper, err)
+	}
+	// the helpers return identity tokens with this special username
+	if resp.Username == "<token>" {
+		return Credentials{identityToken: resp.Secret}, nil
+	}
+	return Credentials{username: resp.Username, password: resp.Secret}, nil
+}
+
+// registryCredentials returns the credentials for the registry serving url,
+// from the docker configuration first and then from the environment. Proxies
+// are accessed anonymously.
+func registryCredentials(url string) Credentials {
+	reg := getRegistry(url)
+	if reg != nil && reg.proxy != "" {
+		return Credentials{}
+	}
+	if creds, ok := dockerCredentials(registryHost(url)); ok {
+		return creds
+	}
+	if reg != nil {
+		return reg.creds
+	}
+	return Credentials{}
+}
+
+// pushCredentials returns the credentials to push the thin images, from the
+// docker configuration or the user of the recipe with the password in
+// $DUCC_OUTPUT_REGISTRY_PASS.
+func pushCredentials(outputImage Image) (Credentials, error) {
+	if creds, ok := dockerCredentials(registryHost(outputImage.Registry)); ok {
+		return creds, nil
+	}
+	password, err := GetPassword()
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{username: outputImage.User, password: password}, nil
+}
+
+// CheckPushCredentials returns an error if there are no credentials to push
+// one of the thin images to its registry, neither in the docker configuration
+// nor in the environment.
+func CheckPushCredentials(outputImages []Image) error {
+	for _, outputImage := range outputImages {
+		if _, err := pushCredentials(outputImage); err != nil {
+			return fmt.Errorf("no credentials to push to %s: %w", outputImage.Registry, err)
+		}
+	}
+	return nil
+}
+
+const (
+	// defaultTokenLifetime is the lifetime of the tokens without expires_in,
+	// the minimum one of the docker token specification.
+	defaultTokenLifetime = 60 * time.Second
+	// tokenExpiryMargin avoids to use a token about to expire.
+	tokenExpiryMargin = 10 * time.Second
+)
+
+func tokenLifetime(expiresIn int) time.Duration {
+	if expiresIn <= 0 {
+		return defaultTokenLifetime
+	}
+	lifetime := time.Duration(expiresIn) * time.Second
+	if lifetime > 2*tokenExpiryMargin {
+		lifetime -= tokenExpiryMargin
+	}
+	return lifetime
+}
+
+type cachedToken struct {
+	token   string
+	expires time.Time
+}
+
+// tokenCache keeps the tokens of each repository and credentials until they
+// expire, so that the manifests and all the layers of an image share a single
+// token.
+type tokenCache struct {
+	lock   sync.Mutex
+	tokens map[string]cachedToken
+}
+
+var registryTokens = tokenCache{tokens: make(map[string]cachedToken)}
+
+// tokenScope is the part of the url a token is valid for, the repository.
+func tokenScope(url string) string {
+	for _, marker := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.Index(url, marker); i >= 0 {
+			return url[:i]
+		}
+	}
+	return url
+}
+
+// tokenKey identifies the tokens obtained with creds for the repository of
+// url. The credentials are hashed, not to keep them in the keys.
+func tokenKey(url string, creds Credentials) string {
+	h := sha256.New()
+	for _, field := range []string{creds.username, creds.password, creds.identityToken, creds.registryToken} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return tokenScope(url) + "|" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *tokenCache) get(url string, creds Credentials) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	cached, ok := c.tokens[tokenKey(url, creds)]
+	if !ok || time.Now().After(cached.expires) {
+		return "", false
+	}
+	return cached.token, true
+}
+
+func (c *tokenCache) put(url string, creds Credentials, token string, lifetime time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.tokens[tokenKey(url, creds)] = cachedToken{token, time.Now().Add(lifetime)}
+}
+
+// invalidate the tokens of the repository of url, whatever the credentials
+func (c *tokenCache) invalidate(url string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	scope := tokenScope(url) + "|"
+	for key := range c.tokens {
+		if strings.HasPrefix(key, scope) {
+			delete(c.tokens, key)
+		}
+	}
+}
+
+// GetAuthToken returns the value of the Authorization header for url, empty
+// if the registry does not need it. The first non empty credentials are
+// used, otherwise the ones configured for the registry.
+func GetAuthToken(url string, credentials []Credentials) (token string, err error) {
+	creds := registryCredentials(url)
+	for _, c := range credentials {
+		if !c.empty() {
+			creds = c
+			break
+		}
+	}
+	if token, ok := registryTokens.get(url, creds); ok {
+		return token, nil
+	}
+	token, lifetime, err := firstRequestForAuth_internal(url, creds)
+	if err != nil {
+		return "", err
+	}
+	if lifetime > 0 {
+		registryTokens.put(url, creds, token, lifetime)
+	}
+	return token, nil
+}
+
+func firstRequestForAuth(url string) (token string, err error) {
+	credentials := []Credentials{}
+	return GetAuthToken(url, credentials)
+}
+
+func firstRequestForAuth_internal(url string, creds Credentials) (token string, lifetime time.Duration, err error) {
+	lifetime = defaultTokenLifetime
+	if creds.registryToken != "" {
+		return "Bearer " + creds.registryToken, lifetime, nil
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		l.LogE(err).Error("Error in making the first request for auth")
+		return "", lifetime, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 && resp.StatusCode >= 200 {
+		log.WithFields(log.Fields{
+			"status code": resp.StatusCode,
+		}).Info("Return valid response, token not necessary.")
+		return
+	}
+	if resp.StatusCode != 401 {
+		log.WithFields(log.Fields{
+			"url":         url,
+			"status code": resp.StatusCode,
+		}).Info("Expected status code 401.")
+		// nothing to cache, the next request will try again
+		return "", 0, err
+	}
+	WwwAuthenticate := resp.Header.Get("Www-Authenticate")
+	if strings.HasPrefix(strings.ToLower(WwwAuthenticate), "basic") {
+		if creds.username == "" || creds.password == "" {
+			err = fmt.Errorf("the registry asks for basic authentication, but there are no credentials for it")
+			l.LogE(err).WithFields(log.Fields{"url": url}).Error("Error in getting the authentication token")
+			return "", lifetime, err
+		}
+		basic := base64.StdEncoding.EncodeToString([]byte(creds.username + ":" + creds.password))
+		return "Basic " + basic, lifetime, nil
+	}
+	// we first try to get the token with the authentication
+	// if we fail, and we might since the docker hub might not have our user
+	// we try again without authentication
+	token, lifetime, err = requestAuthToken(WwwAuthenticate, creds)
+	if err == nil {
+		// happy path
+		return token, lifetime, nil
+	}
+	// some error, we should retry without auth
+	if !creds.empty() {
+		l.LogE(err).WithFields(log.Fields{"url": url}).
+			Warning("We failed with authentication and we now go without")
+		token, lifetime, err = requestAuthToken(WwwAuthenticate, Credentials{})
+		if err == nil {
+			// happy path without auth
+			return token, lifetime, nil
+		}
+	}
+	l.LogE(err).Error("Error in getting the authentication token")
+	return "", lifetime, err
+}
+
+func parseBearerToken(token string) (realm string, options map[string]string, err error) {
+	options = make(map[string]string)
+	if len(token) < 7 || !strings.EqualFold(token[:7], "bearer ") {
+		err = fmt.Errorf("unsupported authentication challenge: %s", token)
+		return
+	}
+	args := token[7:]
+	keyValue := strings.Split(args, ",")
+	for _, kv := range keyValue {
+		splitted := strings.Split(kv, "=")
+		if len(splitted) != 2 {
+			err = fmt.Errorf("wrong formatting of the token")
+			return
+		}
+		splitted[1] = strings.Trim(splitted[1], `"`)
+		if splitted[0] == "realm" {
+			realm = splitted[1]
+		} else {
+			options[splitted[0]] = splitted[1]
+		}
+	}
+	return
+}
+
+// tokenResponse is the answer of the token servers, older ones only set
+// token, the OAuth2 ones only access_token.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func requestAuthToken(token string, creds Credentials) (authToken string, lifetime time.Duration, err error) {
+	realm, options, err := parseBearerToken(token)
+	if err != nil {
+		return
+	}
+	var req *http.Request
+	if creds.identityToken != "" {
+		// identity tokens are exchanged with the OAuth2 refresh token flow
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", creds.identityToken)
+		form.Set("client_id", "ducc")
+		for k, v := range options {
+			form.Set(k, v)
+		}
+		req, err = http.NewRequest("POST", realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequest("GET", realm, nil)
+		if err != nil {
+			return
+		}
+		query := req.URL.Query()
+		for k, v := range options {
+			query.Add(k, v)
+		}
+		if creds.username != "" && creds.password != "" {
+			query.Add("offline_token", "true")
+			req.SetBasicAuth(creds.username, creds.password)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		err = fmt.Errorf("error in getting the token, http request failed %s", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		err = fmt.Errorf("authorization error %s", resp.Status)
+		return
+	}
+
+	var jsonResp tokenResponse
+	err = json.NewDecoder(resp.Body).Decode(&jsonResp)
+	if err != nil {
+		return
+	}
+	if jsonResp.Token == "" {
+		jsonResp.Token = jsonResp.AccessToken
+	}
+	if jsonResp.Token == "" {
+		err = fmt.Errorf("didn't get the token key from the server")
+		return
+	}
+	return "Bearer " + jsonResp.Token, tokenLifetime(jsonResp.ExpiresIn), nil
+}
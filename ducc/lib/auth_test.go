@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegistryHost(t *testing.T) {
+	cases := map[string]string{
+		"https://index.docker.io/v1/":                        "docker.io",
+		"registry.hub.docker.com":                            "docker.io",
+		"https://registry-1.docker.io/v2/library/ubuntu/":    "docker.io",
+		"gitlab-registry.cern.ch":                            "gitlab-registry.cern.ch",
+		"https://gitlab-registry.cern.ch/v2/atlas/athena/":   "gitlab-registry.cern.ch",
+		"localhost:5000":                                     "localhost:5000",
+		"localhost:5000/library/ubuntu":                      "localhost:5000",
+		"http://127.0.0.1:5000/v2/library/ubuntu/blobs/sha1": "127.0.0.1:5000",
+	}
+	for registry, expected := range cases {
+		if host := registryHost(registry); host != expected {
+			t.Errorf("Wrong host of %s: %s, expected %s", registry, host, expected)
+		}
+	}
+}
+
+// restoreAuthState puts back, at the end of the test, the registries,
+// credentials and tokens which the test changes
+func restoreAuthState(t *testing.T) {
+	registries := inputRegistries
+	config := dockerConfiguration
+	registryTokens.lock.Lock()
+	tokens := registryTokens.tokens
+	registryTokens.tokens = make(map[string]cachedToken)
+	registryTokens.lock.Unlock()
+	helperCredentials.lock.Lock()
+	creds := helperCredentials.creds
+	helperCredentials.creds = make(map[string]cachedCredentials)
+	helperCredentials.lock.Unlock()
+	t.Cleanup(func() {
+		inputRegistries = registries
+		dockerConfiguration = config
+		registryTokens.lock.Lock()
+		registryTokens.tokens = tokens
+		registryTokens.lock.Unlock()
+		helperCredentials.lock.Lock()
+		helperCredentials.creds = creds
+		helperCredentials.lock.Unlock()
+	})
+}
+
+func writeDockerConfig(t *testing.T, config string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatalf("Error in writing the docker configuration: %s", err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+	loadDockerConfig(dockerConfigPath())
+}
+
+// writeCredentialHelper installs in the PATH a docker-credential-test
+// helper answering with the server it is asked for
+func writeCredentialHelper(t *testing.T, username, secret string) {
+	dir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nread server\necho '{\"ServerURL\": \"'$server'\", \"Username\": \"%s\", \"Secret\": \"%s\"}'\n", username, secret)
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-test"), []byte(script), 0755); err != nil {
+		t.Fatalf("Error in writing the credential helper: %s", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestDockerConfigCredentials(t *testing.T) {
+	restoreAuthState(t)
+	auth := base64.StdEncoding.EncodeToString([]byte("robot$ci:s3cr:et"))
+	writeDockerConfig(t, `{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "`+auth+`"},
+		"gitlab-registry.cern.ch": {"identitytoken": "refresh"},
+		"helper.example.org": {}
+	},
+	"credHelpers": {"helper.example.org": "test"}
+}`)
+	writeCredentialHelper(t, "helper-user", "helper-secret")
+
+	creds := registryCredentials("https://registry-1.docker.io/v2/library/ubuntu/manifests/latest")
+	if creds.username != "robot$ci" || creds.password != "s3cr:et" {
+		t.Errorf("Wrong credentials of the docker hub: %+v", creds)
+	}
+	creds = registryCredentials("https://gitlab-registry.cern.ch/v2/atlas/athena/manifests/22.0.1")
+	if creds.identityToken != "refresh" || creds.username != "" {
+		t.Errorf("Wrong identity token: %+v", creds)
+	}
+	creds = registryCredentials("https://helper.example.org/v2/library/ubuntu/manifests/latest")
+	if creds.username != "helper-user" || creds.password != "helper-secret" {
+		t.Errorf("Wrong credentials from the helper: %+v", creds)
+	}
+	if creds := registryCredentials("https://quay.io/v2/library/ubuntu/manifests/latest"); !creds.empty() {
+		t.Errorf("Credentials for an unknown registry: %+v", creds)
+	}
+
+	t.Setenv("DUCC_OUTPUT_REGISTRY_PASS", "")
+	hub, _ := ParseImage("https://registry.hub.docker.com/library/ubuntu_thin:latest")
+	if err := CheckPushCredentials([]Image{hub}); err != nil {
+		t.Errorf("No push credentials with a docker configuration: %s", err)
+	}
+	quay, _ := ParseImage("https://quay.io/library/ubuntu_thin:latest")
+	if err := CheckPushCredentials([]Image{hub, quay}); err == nil {
+		t.Errorf("Push credentials for a registry missing from the docker configuration")
+	}
+	t.Setenv("DUCC_OUTPUT_REGISTRY_PASS", "secret")
+	if err := CheckPushCredentials([]Image{hub, quay}); err != nil {
+		t.Errorf("No push credentials with the password in the environment: %s", err)
+	}
+}
+
+func TestCredentialHelperIdentityToken(t *testing.T) {
+	restoreAuthState(t)
+	writeDockerConfig(t, `{"credsStore": "test"}`)
+	writeCredentialHelper(t, "<token>", "refresh")
+	creds := registryCredentials("https://registry.example.org/v2/library/ubuntu/manifests/latest")
+	if creds.identityToken != "refresh" || creds.username != "" {
+		t.Errorf("Wrong identity token from the helper: %+v", creds)
+	}
+}
+
+func TestSetupRegistriesFromEnv(t *testing.T) {
+	restoreAuthState(t)
+	dir := t.TempDir()
+	passFile := filepath.Join(dir, "pass")
+	if err := os.WriteFile(passFile, []byte("robot-secret\n"), 0600); err != nil {
+		t.Fatalf("Error in writing the password: %s", err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DUCC_AUTH_REGISTRIES", "INCOMPLETE,ROBOT,TOKEN")
+	t.Setenv("DUCC_INCOMPLETE_IDENT", "incomplete.example.org")
+	t.Setenv("DUCC_INCOMPLETE_USER", "user")
+	t.Setenv("DUCC_ROBOT_IDENT", "robot.example.org")
+	t.Setenv("DUCC_ROBOT_USER", "robot$ci")
+	t.Setenv("DUCC_ROBOT_PASS_FILE", passFile)
+	t.Setenv("DUCC_TOKEN_IDENT", "token.example.org")
+	t.Setenv("DUCC_TOKEN_TOKEN", "bearer-token")
+
+	// incomplete registries are skipped instead of stopping DUCC
+	SetupRegistries()
+	if len(inputRegistries) != 2 {
+		t.Fatalf("Wrong registries: %+v", inputRegistries)
+	}
+	creds := registryCredentials("https://robot.example.org/v2/library/ubuntu/manifests/latest")
+	if creds.username != "robot$ci" || creds.password != "robot-secret" {
+		t.Errorf("Wrong credentials of the robot account: %+v", creds)
+	}
+	token, err := GetAuthToken("https://token.example.org/v2/library/ubuntu/manifests/latest", nil)
+	if err != nil || token != "Bearer bearer-token" {
+		t.Errorf("Wrong registry token: %s %v", token, err)
+	}
+}
+
+// registryWithTokenServer serves a repository behind bearer tokens, given
+// only to robot$ci or in exchange of the identity token "refresh"
+func registryWithTokenServer(tokenRequests *int32) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			atomic.AddInt32(tokenRequests, 1)
+			if r.FormValue("scope") != "repository:library/app:pull" {
+				http.Error(w, "wrong scope", http.StatusBadRequest)
+				return
+			}
+			if r.Method == "POST" {
+				if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+					http.Error(w, "wrong refresh token", http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprint(w, `{"access_token": "oauth", "expires_in": 300}`)
+				return
+			}
+			if user, pass, ok := r.BasicAuth(); !ok || user != "robot$ci" || pass != "robot-secret" {
+				http.Error(w, "wrong credentials", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "basic", "expires_in": 300}`)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if auth != "Bearer basic" && auth != "Bearer oauth" {
+			w.Header().Set("Www-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:library/app:pull"`, server.URL))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, auth)
+	}))
+	return server
+}
+
+func TestTokenCache(t *testing.T) {
+	var tokenRequests int32
+	registry := registryWithTokenServer(&tokenRequests)
+	defer registry.Close()
+	restoreAuthState(t)
+	host := strings.TrimPrefix(registry.URL, "http://")
+	writeDockerConfig(t, `{"auths": {"`+host+`": {"username": "robot$ci", "password": "robot-secret"}}}`)
+
+	base := registry.URL + "/v2/library/app/"
+	urls := []string{base + "manifests/latest", base + "blobs/sha256:config", base + "blobs/sha256:layer"}
+	for _, url := range urls {
+		token, err := firstRequestForAuth(url)
+		if err != nil || token != "Bearer basic" {
+			t.Fatalf("Wrong token for %s: %s %v", url, token, err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("The token is not shared by the requests of the repository: %d requests", tokenRequests)
+	}
+
+	// after a 401 the token is requested again
+	registryTokens.invalidate(urls[2])
+	if _, err := firstRequestForAuth(urls[2]); err != nil || tokenRequests != 2 {
+		t.Errorf("Token not requested again: %d requests, %v", tokenRequests, err)
+	}
+
+	// identity tokens are exchanged with the OAuth2 flow, the tokens of
+	// other credentials are not reused
+	token, err := GetAuthToken(urls[0], []Credentials{{identityToken: "refresh"}})
+	if err != nil || token != "Bearer oauth" || tokenRequests != 3 {
+		t.Errorf("Wrong token from the identity token: %s %v, %d requests", token, err, tokenRequests)
+	}
+	if token, err := firstRequestForAuth(urls[0]); err != nil || token != "Bearer basic" || tokenRequests != 3 {
+		t.Errorf("Wrong token of the docker configuration: %s %v, %d requests", token, err, tokenRequests)
+	}
+}
+
+func TestTokenLifetime(t *testing.T) {
+	if tokenLifetime(0) != defaultTokenLifetime {
+		t.Errorf("Wrong default lifetime: %s", tokenLifetime(0))
+	}
+	if lifetime := tokenLifetime(300); lifetime.Seconds() != 290 {
+		t.Errorf("Wrong lifetime: %s", lifetime)
+	}
+}
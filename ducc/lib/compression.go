@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"compress/gzip"
+	"io"
+
+	da "github.com/cvmfs/ducc/docker-api"
+	"github.com/klauspost/compress/zstd"
+)
+
+// layerReader reads the uncompressed tar of a layer, closing it closes both
+// the decompressor and the body of the response
+type layerReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *layerReader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if errC := c.Close(); errC != nil && err == nil {
+			err = errC
+		}
+	}
+	return err
+}
+
+// newLayerReader decompresses the body of a layer according to its media
+// type: gzip, zstd or none at all
+func newLayerReader(layer da.Layer, body io.ReadCloser) (io.ReadCloser, error) {
+	compression, err := layer.GetCompression()
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case da.Gzip:
+		gread, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return &layerReader{Reader: gread, closers: []io.Closer{gread, body}}, nil
+	case da.Zstd:
+		zread, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &layerReader{Reader: zread, closers: []io.Closer{zread.IOReadCloser(), body}}, nil
+	}
+	return body, nil
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	da "github.com/cvmfs/ducc/docker-api"
+	"github.com/klauspost/compress/zstd"
+)
+
+// layerTar is the uncompressed content of the layer fixtures
+func layerTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("hello from the layer\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "etc/motd", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatalf("Error in writing the tar header: %s", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatalf("Error in writing the tar content: %s", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error in closing the tar: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func compressLayer(t *testing.T, layer []byte, compression da.Compression) []byte {
+	var buf bytes.Buffer
+	switch compression {
+	case da.Gzip:
+		w := gzip.NewWriter(&buf)
+		w.Write(layer)
+		w.Close()
+	case da.Zstd:
+		w, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("Error in creating the zstd writer: %s", err)
+		}
+		w.Write(layer)
+		w.Close()
+	default:
+		buf.Write(layer)
+	}
+	return buf.Bytes()
+}
+
+type layerFixture struct {
+	mediaType string
+	blob      []byte
+}
+
+// layerFixtures maps the digest of a layer fixture to its media type and
+// compressed content, one for each of the media types we support
+func layerFixtures(t *testing.T) map[string]layerFixture {
+	tarball := layerTar(t)
+	mediaTypes := map[string]da.Compression{
+		"":                                       da.Gzip,
+		da.MediaTypeDockerLayer:                  da.Gzip,
+		da.MediaTypeOCILayerGzip:                 da.Gzip,
+		da.MediaTypeOCILayerZstd:                 da.Zstd,
+		da.MediaTypeOCILayer:                     da.Uncompressed,
+		da.MediaTypeOCINondistributableLayerZstd: da.Zstd,
+	}
+	fixtures := make(map[string]layerFixture)
+	i := 0
+	for mediaType, compression := range mediaTypes {
+		digest := "sha256:" + strings.Repeat(string(rune('a'+i)), 64)
+		fixtures[digest] = layerFixture{mediaType, compressLayer(t, tarball, compression)}
+		i++
+	}
+	return fixtures
+}
+
+func TestDownloadLayerMediaTypes(t *testing.T) {
+	tarball := layerTar(t)
+	fixtures := layerFixtures(t)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for digest, fixture := range fixtures {
+			if strings.HasSuffix(r.URL.Path, "/blobs/"+digest) {
+				w.Write(fixture.blob)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	defer registry.Close()
+
+	image, err := ParseImage(registry.URL + "/library/layers:latest")
+	if err != nil {
+		t.Fatalf("Error in parsing the image: %s", err)
+	}
+	for digest, fixture := range fixtures {
+		layer := da.Layer{MediaType: fixture.mediaType, Digest: digest}
+		downloaded, err := image.downloadLayer(layer, "")
+		if err != nil {
+			t.Errorf("Error in downloading the layer %q: %s", fixture.mediaType, err)
+			continue
+		}
+		content, err := ioutil.ReadAll(downloaded.Path)
+		downloaded.Close()
+		if err != nil {
+			t.Errorf("Error in reading the layer %q: %s", fixture.mediaType, err)
+			continue
+		}
+		if !bytes.Equal(content, tarball) {
+			t.Errorf("Wrong content of the layer %q", fixture.mediaType)
+		}
+		if downloaded.GetSize() != int64(len(tarball)) {
+			t.Errorf("Wrong size of the layer %q: %d", fixture.mediaType, downloaded.GetSize())
+		}
+	}
+}
+
+func TestLayerMediaTypes(t *testing.T) {
+	layer := da.Layer{MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip"}
+	if _, err := layer.GetCompression(); err == nil {
+		t.Errorf("No error for an unsupported media type")
+	}
+	if _, err := newLayerReader(layer, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Errorf("Reader created for an unsupported media type")
+	}
+
+	foreign := []string{da.MediaTypeDockerForeignLayer, da.MediaTypeOCINondistributableLayer,
+		da.MediaTypeOCINondistributableLayerGzip, da.MediaTypeOCINondistributableLayerZstd}
+	for _, mediaType := range foreign {
+		if !(da.Layer{MediaType: mediaType}).IsForeign() {
+			t.Errorf("Layer not foreign: %s", mediaType)
+		}
+	}
+	if (da.Layer{MediaType: da.MediaTypeOCILayerZstd}).IsForeign() {
+		t.Errorf("Distributable layer reported as foreign")
+	}
+}
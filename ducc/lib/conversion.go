@@ -208,6 +208,71 @@ func ConvertWishFlat(wish WishFriendly) error {
 	return firstError
 }
 
+// RetireExpiredTags removes the tags left out by the retention, whatever the
+// outputs of the wish: the public links to their flat images, their manifests
+// in .metadata and their images in the podman stores, of every platform. The
+// garbage collection then removes the flat images and the layers no longer
+// used.
+func RetireExpiredTags(wish WishFriendly) error {
+	if wish.InputImage == nil || len(wish.ExpiredTags) == 0 {
+		return nil
+	}
+	n := notification.NewNotification(NotificationService).AddField("image_request", wish.InputName)
+	repoRoot := filepath.Join("/", "cvmfs", wish.CvmfsRepo)
+	var firstError error
+	for _, tag := range wish.ExpiredTags {
+		expiredImg := *wish.InputImage
+		expiredImg.Tag = tag
+		expiredImg.TagWildcard = false
+		name := expiredImg.GetSimpleName()
+
+		publicPath := expiredImg.GetPublicSymlinkPath()
+		links, _ := filepath.Glob(filepath.Join(repoRoot, "*", publicPath))
+		links = append(links, filepath.Join(repoRoot, publicPath))
+		for _, link := range links {
+			info, err := os.Lstat(link)
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				continue
+			}
+			l.Log().WithFields(log.Fields{"image": name, "public path": link}).
+				Info("Tag out of the retention, unpublishing the flat image")
+			err = cvmfs.RemoveDirectory(wish.CvmfsRepo, cvmfs.TrimCVMFSRepoPrefix(link))
+			if err != nil {
+				if firstError == nil {
+					firstError = err
+				}
+				continue
+			}
+			n.Action("unpublish_flat_image").AddField("public_path", cvmfs.TrimCVMFSRepoPrefix(link)).Send()
+		}
+
+		// without their manifests, the layers of the tag are no longer used
+		metadataDirs, _ := filepath.Glob(filepath.Join(repoRoot, ".metadata", "*", name))
+		metadataDirs = append(metadataDirs, filepath.Join(repoRoot, ".metadata", name))
+		for _, dir := range metadataDirs {
+			if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err != nil {
+				continue
+			}
+			l.Log().WithFields(log.Fields{"image": name, "metadata": dir}).
+				Info("Tag out of the retention, removing its manifest")
+			err := cvmfs.RemoveDirectory(wish.CvmfsRepo, cvmfs.TrimCVMFSRepoPrefix(dir))
+			if err != nil && firstError == nil {
+				firstError = err
+			}
+		}
+
+		stores, _ := filepath.Glob(filepath.Join(repoRoot, "*", rootPath))
+		stores = append(stores, filepath.Join(repoRoot, rootPath))
+		for _, store := range stores {
+			err := removePodmanImages(wish.CvmfsRepo, cvmfs.TrimCVMFSRepoPrefix(store), name)
+			if err != nil && firstError == nil {
+				firstError = err
+			}
+		}
+	}
+	return firstError
+}
+
 func ConvertWishDocker(wish WishFriendly) (err error) {
 	inputImage := wish.InputImage
 	if inputImage == nil {
@@ -233,7 +298,15 @@ func ConvertWishDocker(wish WishFriendly) (err error) {
 			outputWithTag.Tag = outputImage.Tag
 		}
 
-		manifestPath := filepath.Join("/", "cvmfs", wish.CvmfsRepo, ".metadata", expandedImgTag.GetSimpleName(), "manifest.json")
+		// a thin image has a single manifest, we create it only for the
+		// default platform
+		if expandedImgTag.platformPath("") != "" {
+			l.Log().WithFields(log.Fields{"platform": expandedImgTag.Platform.String()}).
+				Info("Thin images are only created for the default platform, moving on")
+			continue
+		}
+
+		manifestPath := filepath.Join("/", "cvmfs", wish.CvmfsRepo, expandedImgTag.GetMetadataPath(), "manifest.json")
 		if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
 			l.Log().Info("Layers not downloaded yet, not converting for docker, moving on")
 			continue
@@ -270,7 +343,7 @@ func ConvertWishPodman(wish WishFriendly, convertAgain bool) (err error) {
 		imageID := strings.Split(manifest.Config.Digest, ":")[1]
 
 		// check if image is already present in podman store
-		manifestPath := filepath.Join("/", "cvmfs", wish.CvmfsRepo, rootPath, imageMetadataDir, imageID, "manifest")
+		manifestPath := filepath.Join("/", "cvmfs", wish.CvmfsRepo, expandedImgTag.podmanStorePath(), imageMetadataDir, imageID, "manifest")
 		alreadyConverted := AlreadyConverted(manifestPath, manifest.Config.Digest)
 		if alreadyConverted == ConversionMatch {
 			if convertAgain == false {
@@ -280,7 +353,7 @@ func ConvertWishPodman(wish WishFriendly, convertAgain bool) (err error) {
 		}
 
 		// convert for podman only after manifest is stored in .metadata
-		manifestPath = filepath.Join("/", "cvmfs", wish.CvmfsRepo, ".metadata", expandedImgTag.GetSimpleName(), "manifest.json")
+		manifestPath = filepath.Join("/", "cvmfs", wish.CvmfsRepo, expandedImgTag.GetMetadataPath(), "manifest.json")
 		if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
 			l.Log().Info("Layers not downloaded yet, not converting for podman, moving on")
 			continue
@@ -311,12 +384,83 @@ func ConvertWish(wish WishFriendly, convertAgain, forceDownload bool) (err error
 	return firstError
 }
 
+// PublishPlatformManifests stores, next to the manifest of each image
+// converted for several platforms, the record of the converted platforms
+// (manifest-list.json). It runs after the conversion of the wish, whatever
+// its outputs.
+func PublishPlatformManifests(wish WishFriendly) error {
+	images := append(append([]*Image{}, wish.ExpandedTagImagesLayer...), wish.ExpandedTagImagesFlat...)
+	return publishPlatformManifests(wish.CvmfsRepo, images)
+}
+
+func publishPlatformManifests(repo string, images []*Image) error {
+	records := make(map[string]*da.PlatformManifests)
+	var names []string
+	recorded := make(map[string]bool)
+	for _, img := range images {
+		if img.Platform == nil || recorded[img.GetPublicSymlinkPath()] {
+			continue
+		}
+		manifest, err := img.GetManifest()
+		if err != nil {
+			continue
+		}
+		// only the platforms already converted are recorded
+		if !platformConverted(repo, img, manifest) {
+			continue
+		}
+		recorded[img.GetPublicSymlinkPath()] = true
+		record, err := img.GetPlatformManifest()
+		if err != nil {
+			continue
+		}
+		name := img.GetSimpleName()
+		if records[name] == nil {
+			records[name] = &da.PlatformManifests{}
+			names = append(names, name)
+		}
+		records[name].Manifests = append(records[name].Manifests, record)
+	}
+
+	var firstError error
+	for _, name := range names {
+		recordBytes, err := json.MarshalIndent(records[name], "", "  ")
+		if err != nil {
+			return err
+		}
+		recordPath := filepath.Join(".metadata", name, "manifest-list.json")
+		if existing, err := ioutil.ReadFile(filepath.Join("/", "cvmfs", repo, recordPath)); err == nil && bytes.Equal(existing, recordBytes) {
+			continue
+		}
+		err = cvmfs.WriteDataToCvmfs(repo, recordPath, recordBytes)
+		if err != nil {
+			l.LogE(err).WithFields(log.Fields{"image": name}).Error("Error in writing the record of the platforms")
+			if firstError == nil {
+				firstError = err
+			}
+		}
+	}
+	return firstError
+}
+
+// platformConverted returns true if the image of a platform is converted,
+// either into layers or into an up to date flat image
+func platformConverted(repo string, img *Image, manifest da.Manifest) bool {
+	manifestPath := filepath.Join("/", "cvmfs", repo, img.GetMetadataPath(), "manifest.json")
+	if AlreadyConverted(manifestPath, manifest.Config.Digest) == ConversionMatch {
+		return true
+	}
+	pubDirInfo, errPub := os.Stat(filepath.Join("/", "cvmfs", repo, img.GetPublicSymlinkPath()))
+	priDirInfo, errPri := os.Stat(filepath.Join("/", "cvmfs", repo, manifest.GetSingularityPath()))
+	return errPub == nil && errPri == nil && os.SameFile(pubDirInfo, priDirInfo)
+}
+
 func convertInputOutput(inputImage *Image, repo string, convertAgain, forceDownload bool) (err error) {
 	manifest, err := inputImage.GetManifest()
 	if err != nil {
 		return
 	}
-	manifestPath := filepath.Join("/", "cvmfs", repo, ".metadata", inputImage.GetSimpleName(), "manifest.json")
+	manifestPath := filepath.Join("/", "cvmfs", repo, inputImage.GetMetadataPath(), "manifest.json")
 	alreadyConverted := AlreadyConverted(manifestPath, manifest.Config.Digest)
 
 	if alreadyConverted == ConversionMatch {
@@ -437,7 +581,7 @@ func convertInputOutput(inputImage *Image, repo string, convertAgain, forceDownl
 	}
 
 	if noErrorInConversionValue {
-		manifestPath := filepath.Join(".metadata", inputImage.GetSimpleName(), "manifest.json")
+		manifestPath := filepath.Join(inputImage.GetMetadataPath(), "manifest.json")
 		errIng := cvmfs.PublishToCVMFS(repo, manifestPath, <-manifestChanell)
 		if errIng != nil {
 			l.LogE(errIng).Error("Error in storing the manifest in the repository")
@@ -521,16 +665,15 @@ func CreateThinImage(manifest da.Manifest, layerLocations map[string]string, inp
 func PushImageToRegistry(outputImage Image) (err error) {
 	// the authentication must be provided for the ImagePush api,
 	// even if the documentation says otherwise
-	password, err := GetPassword()
+	creds, err := pushCredentials(outputImage)
 	if err != nil {
 		return err
 	}
-	authStruct := struct {
-		Username string
-		Password string
-	}{
-		Username: outputImage.User,
-		Password: password,
+	authStruct := types.AuthConfig{
+		Username:      creds.username,
+		Password:      creds.password,
+		IdentityToken: creds.identityToken,
+		RegistryToken: creds.registryToken,
 	}
 	authBytes, _ := json.Marshal(authStruct)
 	authCredential := base64.StdEncoding.EncodeToString(authBytes)
@@ -127,7 +127,7 @@ func FindAllUsedLayers(CVMFSRepo string) ([]string, error) {
 				return filepath.SkipDir
 			}
 			for _, layerStruct := range manifest.Layers {
-				if layerStruct.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+				if layerStruct.IsForeign() {
 					continue;
 				}
 				layer := strings.Split(layerStruct.Digest, ":")[1]
@@ -142,9 +142,11 @@ func FindAllUsedLayers(CVMFSRepo string) ([]string, error) {
 	return result, nil
 }
 
+// FindPodmanPathsToDelete returns the paths of the layers to delete in the
+// podman stores, the one of the default platform and the ones of the other
+// platforms (<platform>/podmanStore)
 func FindPodmanPathsToDelete(CVMFSRepo string, layersToDelete []string) ([]string, error) {
 	podmanPathsToDelete := make([]string, 0)
-	layerInfoPath := filepath.Join("/cvmfs", CVMFSRepo, "podmanStore", "overlay-layers", "layers.json")
 
 	layersToDeleteMap := make(map[string]bool)
 	for _, layerpath := range layersToDelete {
@@ -153,6 +155,22 @@ func FindPodmanPathsToDelete(CVMFSRepo string, layersToDelete []string) ([]strin
 		layersToDeleteMap[layerid] = true
 	}
 
+	storePaths, _ := filepath.Glob(filepath.Join("/cvmfs", CVMFSRepo, "*", rootPath))
+	storePaths = append([]string{filepath.Join("/cvmfs", CVMFSRepo, rootPath)}, storePaths...)
+	for _, storePath := range storePaths {
+		paths, err := findPodmanStorePathsToDelete(CVMFSRepo, storePath, layersToDeleteMap)
+		podmanPathsToDelete = append(podmanPathsToDelete, paths...)
+		if err != nil {
+			return podmanPathsToDelete, err
+		}
+	}
+	return podmanPathsToDelete, nil
+}
+
+func findPodmanStorePathsToDelete(CVMFSRepo, storePath string, layersToDeleteMap map[string]bool) ([]string, error) {
+	podmanPathsToDelete := make([]string, 0)
+	layerInfoPath := filepath.Join(storePath, layerMetadataDir, "layers.json")
+
 	_, err := os.Stat(layerInfoPath)
 	if err == nil {
 		layersdata := []LayerInfo{}
@@ -167,7 +185,7 @@ func FindPodmanPathsToDelete(CVMFSRepo string, layersToDelete []string) ([]strin
 		for _, info := range layersdata {
 			layerid := strings.Split(info.CompressedDiffDigest, ":")[1]
 			if layersToDeleteMap[layerid] {
-				podmanLayerPath := filepath.Join("/cvmfs", CVMFSRepo, "podmanStore", "overlay", info.ID)
+				podmanLayerPath := filepath.Join(storePath, rootfsDir, info.ID)
 
 				linkFilePath := filepath.Join(podmanLayerPath, "link")
 				data, err := ioutil.ReadFile(linkFilePath)
@@ -177,7 +195,7 @@ func FindPodmanPathsToDelete(CVMFSRepo string, layersToDelete []string) ([]strin
 				}
 				id := string(data)
 
-				linkDirPath := filepath.Join("/cvmfs", CVMFSRepo, "overlay", "l", id)
+				linkDirPath := filepath.Join(storePath, rootfsDir, "l", id)
 				podmanPathsToDelete = append(podmanPathsToDelete, podmanLayerPath)
 				podmanPathsToDelete = append(podmanPathsToDelete, linkDirPath)
 				continue
@@ -2,7 +2,6 @@ package lib
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -12,6 +11,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -44,48 +44,12 @@ type Image struct {
 	TagWildcard bool
 	Manifest    *da.Manifest
 	OCIImage    *image.Image
-}
-
-type Credentials struct {
-	username string
-	password string
-}
-
-type RegistryConfig struct {
-	baseUrl string
-	proxy   string
-	creds   Credentials
-}
-
-var inputRegistries []RegistryConfig
-
-func SetupRegistries() {
-	regs := os.Getenv("DUCC_AUTH_REGISTRIES")
-	for _, r := range strings.Split(regs, ",") {
-		if r == "" {
-			continue
-		}
-
-		iEnv := "DUCC_" + r + "_IDENT"
-		uEnv := "DUCC_" + r + "_USER"
-		uPass := "DUCC_" + r + "_PASS"
-		proxyEnv := "DUCC_" + r + "_PROXY"
-		ident := os.Getenv(iEnv)
-		user := os.Getenv(uEnv)
-		pass := os.Getenv(uPass)
-		proxy := os.Getenv(proxyEnv)
-
-		if ident == "" || ((user == "" || pass == "") && proxy == "") {
-			log.Fatalf("missing either $%s, ($%s or $%s) or %s for %s",
-			           iEnv, uEnv, uPass, proxyEnv, r)
-		}
-
-		inputRegistries = append(inputRegistries, RegistryConfig{
-			ident,
-			proxy,
-			Credentials{user, pass},
-		})
-	}
+	// Platform is the platform of the image, when it was selected among the
+	// platforms of a recipe
+	Platform *da.Platform
+	// ManifestDigest is the digest of the manifest of Platform in the
+	// manifest list
+	ManifestDigest string
 }
 
 func (i *Image) GetSimpleName() string {
@@ -176,7 +140,11 @@ func (img *Image) PrintImage(machineFriendly, csv_header bool) {
 }
 
 func (img *Image) fetchManifest() (*da.Manifest, error) {
-	bytes, err := img.getByteManifest("")
+	return img.fetchManifestReference("")
+}
+
+func (img *Image) fetchManifestReference(reference string) (*da.Manifest, error) {
+	bytes, err := img.getByteManifest(reference)
 	if err != nil {
 		return nil, err
 	}
@@ -194,49 +162,185 @@ func (img *Image) fetchManifest() (*da.Manifest, error) {
 }
 
 func (img *Image) fetchManifestList() (*da.Manifest, error) {
-	bytes1, err := img.getByteManifestList()
-	if err != nil {
-		return nil, err
-	}
-
-	var manifestList da.ManifestList
-	err = json.Unmarshal(bytes1, &manifestList)
+	manifestList, err := img.getManifestList()
 	if err != nil {
 		return nil, err
 	}
-	if reflect.DeepEqual(da.ManifestList{}, manifestList) {
+	if manifestList == nil {
 		return nil, fmt.Errorf("got empty manifest list")
 	}
 
+	images := manifestList.Images()
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no image in the manifest list")
+	}
+
 	var manifestReference string
-	if len(manifestList.Manifests) == 1 {
-		manifestReference = manifestList.Manifests[0].Digest
+	if len(images) == 1 {
+		manifestReference = images[0].Digest
 	} else {
-		// TODO: In case of a manifest list with multiple architectures, default to amd64
-		// TODO: Support multi-arch images
-		for _, v := range manifestList.Manifests {
+		// In case of a manifest list with multiple architectures, and no
+		// platform asked for, default to amd64
+		for _, v := range images {
 			if v.Platform.Architecture == "amd64" {
 				manifestReference = v.Digest
 			}
 		}
 	}
 
-	bytes2, err := img.getByteManifest(manifestReference)
+	return img.fetchManifestReference(manifestReference)
+}
+
+// getManifestList returns the manifest list of the image, or nil if the image
+// has a single manifest
+func (img *Image) getManifestList() (*da.ManifestList, error) {
+	bytes, err := img.getByteManifestList()
 	if err != nil {
 		return nil, err
 	}
 
-	var manifest da.Manifest
-	err = json.Unmarshal(bytes2, &manifest)
+	var manifestList da.ManifestList
+	err = json.Unmarshal(bytes, &manifestList)
 	if err != nil {
 		return nil, err
 	}
-	if reflect.DeepEqual(da.Manifest{}, manifest) {
-		return nil, fmt.Errorf("got empty manifest")
+	if len(manifestList.Manifests) == 0 {
+		return nil, nil
 	}
+	return &manifestList, nil
+}
 
-	img.Manifest = &manifest
-	return &manifest, nil
+// PlatformImages returns an image for each manifest of the manifest list
+// matching one of the platforms. Without platforms, the image itself is
+// returned. Images with a single manifest are returned if their configuration
+// matches one of the platforms. The platforms whose manifest can not be
+// retrieved are skipped, the error is returned only if none is left.
+func (img *Image) PlatformImages(platforms []da.Platform) ([]*Image, error) {
+	if len(platforms) == 0 {
+		manifest, err := img.GetManifest()
+		if err != nil {
+			return nil, err
+		}
+		if manifest.IsArtifact() {
+			img.logArtifact(manifest)
+			return nil, nil
+		}
+		return []*Image{img}, nil
+	}
+
+	manifestList, err := img.getManifestList()
+	if err != nil {
+		return nil, err
+	}
+	if manifestList == nil {
+		manifest, err := img.GetManifest()
+		if err != nil {
+			return nil, err
+		}
+		if manifest.IsArtifact() {
+			img.logArtifact(manifest)
+			return nil, nil
+		}
+		config, err := img.GetOCIImage()
+		if err != nil {
+			return nil, err
+		}
+		platform := da.Platform{OS: config.OS, Architecture: config.Architecture}
+		for _, requested := range platforms {
+			// the configuration does not record the variant
+			if requested.Variant == "" && platform.Matches(requested) {
+				img.Platform = &platform
+				return []*Image{img}, nil
+			}
+		}
+		l.Log().WithFields(log.Fields{"image": img.GetSimpleName(), "platform": platform.String()}).
+			Info("Image not available for the requested platforms, skipping")
+		return nil, nil
+	}
+
+	var images []*Image
+	var firstError error
+	for _, item := range manifestList.Images() {
+		platform := item.GetPlatform()
+		for _, requested := range platforms {
+			if !platform.Matches(requested) {
+				continue
+			}
+			platformImg := *img
+			platformImg.Platform = &platform
+			platformImg.ManifestDigest = item.Digest
+			platformImg.Manifest = nil
+			platformImg.OCIImage = nil
+			manifest, err := platformImg.GetManifest()
+			if err != nil {
+				l.LogE(err).WithFields(log.Fields{"image": img.GetSimpleName(), "platform": platform.String()}).
+					Warning("Impossible to get the manifest of the platform, skipping it")
+				if firstError == nil {
+					firstError = err
+				}
+				break
+			}
+			if manifest.IsArtifact() {
+				platformImg.logArtifact(manifest)
+				break
+			}
+			images = append(images, &platformImg)
+			break
+		}
+	}
+	if len(images) == 0 {
+		if firstError != nil {
+			return nil, firstError
+		}
+		l.Log().WithFields(log.Fields{"image": img.GetSimpleName()}).
+			Info("Image not available for the requested platforms, skipping")
+	}
+	return images, nil
+}
+
+// logArtifact reports an OCI artifact, which is not an image and it is not
+// converted
+func (img *Image) logArtifact(manifest da.Manifest) {
+	artifactType := manifest.ArtifactType
+	if artifactType == "" {
+		artifactType = manifest.Config.MediaType
+	}
+	l.Log().WithFields(log.Fields{"image": img.GetSimpleName(), "artifact type": artifactType}).
+		Info("The reference is an OCI artifact and not an image, skipping")
+}
+
+// GetPlatformManifest returns the record of the platform of the image
+func (img *Image) GetPlatformManifest() (da.PlatformManifest, error) {
+	manifest, err := img.GetManifest()
+	if err != nil {
+		return da.PlatformManifest{}, err
+	}
+	record := da.PlatformManifest{
+		Platform: da.DefaultPlatform,
+		Digest:   img.ManifestDigest,
+		Config:   manifest.Config.Digest,
+		Path:     img.platformPath(""),
+	}
+	if img.Platform != nil {
+		record.Platform = *img.Platform
+	}
+	return record, nil
+}
+
+// platformPath returns the path of a file of the image, within the directory
+// of its platform. The images of the default platform are stored in the
+// original layout of the repository.
+func (img *Image) platformPath(path string) string {
+	if img.Platform == nil || *img.Platform == da.DefaultPlatform {
+		return path
+	}
+	return filepath.Join(img.Platform.Dir(), path)
+}
+
+// GetMetadataPath returns the directory containing the manifest of the image,
+// relative to the root of the repository
+func (img *Image) GetMetadataPath() string {
+	return filepath.Join(".metadata", img.platformPath(img.GetSimpleName()))
 }
 
 func (img *Image) GetManifest() (da.Manifest, error) {
@@ -244,9 +348,20 @@ func (img *Image) GetManifest() (da.Manifest, error) {
 		return *img.Manifest, nil
 	}
 
+	// The images of a platform are selected in the manifest list
+	if img.ManifestDigest != "" {
+		manifest, err := img.fetchManifestReference(img.ManifestDigest)
+		if err != nil {
+			return da.Manifest{}, fmt.Errorf("could not retrieve manifest for %s (%s)", img.WholeName(), img.Platform)
+		}
+		return *manifest, nil
+	}
+
 	// First try to fetch a simple manifest
 	manifest, err := img.fetchManifest()
-	if err != nil || manifest.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json" || manifest.MediaType == "application/vnd.oci.image.index.v1+json" {
+	// OCI indexes are not required to declare their media type, but have no
+	// configuration
+	if err != nil || da.IsManifestList(manifest.MediaType) || manifest.Config.Digest == "" {
 		// If the first fetch fails, try to fetch from a manifest list
 		manifest, err := img.fetchManifestList()
 		if err != nil {
@@ -347,7 +462,11 @@ func (img *Image) GetTagListUrl() string {
 	return img.baseUrl() + "tags/list"
 }
 
-func (img *Image) ExpandWildcard() (<-chan *Image, <-chan *Image, error) {
+// ExpandWildcard returns the images of all the tags matching the tag of the
+// image and selected by the tag filter, for each of the platforms. The tags
+// left out by the retention are returned as expired.
+func (img *Image) ExpandWildcard(options WishOptions) (<-chan *Image, <-chan *Image, []string, error) {
+	platforms := options.Platforms
 	r1 := make(chan *Image, 500)
 	r2 := make(chan *Image, 500)
 	var wg sync.WaitGroup
@@ -359,10 +478,11 @@ func (img *Image) ExpandWildcard() (<-chan *Image, <-chan *Image, error) {
 		}()
 	}()
 	if !img.TagWildcard {
-		img.GetManifest()
-		r1 <- img
-		r2 <- img
-		return r1, r2, nil
+		for _, platformImg := range img.sendablePlatformImages(platforms) {
+			r1 <- platformImg
+			r2 <- platformImg
+		}
+		return r1, r2, nil, nil
 	}
 	var tagsList struct {
 		Tags []string
@@ -372,7 +492,7 @@ func (img *Image) ExpandWildcard() (<-chan *Image, <-chan *Image, error) {
 	if err != nil {
 		errF := fmt.Errorf("error in authenticating for retrieving the tags: %s", err)
 		l.LogE(err).Error(errF)
-		return r1, r2, errF
+		return r1, r2, nil, errF
 	}
 
 	client := http.Client{}
@@ -383,24 +503,31 @@ func (img *Image) ExpandWildcard() (<-chan *Image, <-chan *Image, error) {
 	if err != nil {
 		errF := fmt.Errorf("error making the request for retrieving the tags: %s", err)
 		l.LogE(err).WithFields(log.Fields{"url": url}).Error(errF)
-		return r1, r2, errF
+		return r1, r2, nil, errF
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode >= 400 {
 		errF := fmt.Errorf("error status code (%d) trying to retrieve the tags", resp.StatusCode)
 		l.LogE(err).WithFields(log.Fields{"status code": resp.StatusCode, "url": url}).Error(errF)
-		return r1, r2, errF
+		return r1, r2, nil, errF
 	}
 	if err = json.NewDecoder(resp.Body).Decode(&tagsList); err != nil {
 		errF := fmt.Errorf("error in decoding the tags from the server: %s", err)
 		l.LogE(err).Error(errF)
-		return r1, r2, errF
+		return r1, r2, nil, errF
 	}
 	pattern := img.Tag
 	filteredTags, err := filterUsingGlob(pattern, tagsList.Tags)
 	if err != nil {
-		return r1, r2, nil
+		return r1, r2, nil, nil
+	}
+	filteredTags, err = options.Tags.Filter(filteredTags)
+	if err != nil {
+		errF := fmt.Errorf("error in filtering the tags: %s", err)
+		l.LogE(err).Error(errF)
+		return r1, r2, nil, errF
 	}
+	filteredTags, expired := img.retainTags(filteredTags, options.Retention)
 
 	tagChan := make(chan string, 40)
 	wg.Add(1)
@@ -419,14 +546,91 @@ func (img *Image) ExpandWildcard() (<-chan *Image, <-chan *Image, error) {
 			for tag := range tagChan {
 				taggedImg := *img
 				taggedImg.Tag = tag
-				taggedImg.GetManifest()
-				r1 <- &taggedImg
-				r2 <- &taggedImg
+				for _, platformImg := range taggedImg.sendablePlatformImages(platforms) {
+					r1 <- platformImg
+					r2 <- platformImg
+				}
 			}
 		}()
 	}
 
-	return r1, r2, nil
+	return r1, r2, expired, nil
+}
+
+// retainTags splits the tags into the most recently created ones, kept by the
+// retention, and the expired ones. If the creation date of a tag is unknown,
+// all the tags are kept.
+func (img *Image) retainTags(tags []string, retention Retention) (retained, expired []string) {
+	if retention.MaxTags <= 0 || len(tags) <= retention.MaxTags {
+		return tags, nil
+	}
+	created := make(map[string]time.Time)
+	for _, tag := range tags {
+		taggedImg := *img
+		taggedImg.Tag = tag
+		taggedImg.TagWildcard = false
+		date := taggedImg.creationDate()
+		if date.IsZero() {
+			l.Log().WithFields(log.Fields{"image": taggedImg.GetSimpleName()}).
+				Warning("Impossible to know when the image was created, keeping all the tags")
+			return tags, nil
+		}
+		created[tag] = date
+	}
+	sorted := append([]string{}, tags...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return created[sorted[i]].After(created[sorted[j]])
+	})
+	return sorted[:retention.MaxTags], sorted[retention.MaxTags:]
+}
+
+// creationDatesTTL is how long the creation date of a tag is reused by the
+// retention. Without it, each pass of the loop would fetch the manifest and
+// the configuration of every tag of the wildcards.
+const creationDatesTTL = 6 * time.Hour
+
+type creationDate struct {
+	created time.Time
+	fetched time.Time
+}
+
+var creationDates = struct {
+	sync.Mutex
+	dates map[string]creationDate
+}{dates: make(map[string]creationDate)}
+
+// creationDate returns when the image was created, the zero time if unknown
+func (img *Image) creationDate() time.Time {
+	name := img.GetSimpleName()
+	creationDates.Lock()
+	cached, ok := creationDates.dates[name]
+	creationDates.Unlock()
+	if ok && time.Since(cached.fetched) < creationDatesTTL {
+		return cached.created
+	}
+	config, err := img.GetOCIImage()
+	if err != nil || config.Created.IsZero() {
+		return time.Time{}
+	}
+	creationDates.Lock()
+	creationDates.dates[name] = creationDate{created: config.Created, fetched: time.Now()}
+	creationDates.Unlock()
+	return config.Created
+}
+
+// sendablePlatformImages returns the images of the platforms to convert. An
+// image without platforms is returned even if its manifest can not be
+// retrieved, and fails later in the conversion.
+func (img *Image) sendablePlatformImages(platforms []da.Platform) []*Image {
+	images, err := img.PlatformImages(platforms)
+	if err != nil {
+		l.LogE(err).WithFields(log.Fields{"image": img.GetSimpleName()}).
+			Warning("Error in retrieving the images of the platforms")
+		if len(platforms) == 0 {
+			return []*Image{img}
+		}
+	}
+	return images
 }
 
 func filterUsingGlob(pattern string, toFilter []string) ([]string, error) {
@@ -462,74 +666,17 @@ func (img *Image) GetSingularityPath() (string, error) {
 // the one that the user see, without the /cvmfs/$repo.cern.ch prefix
 // used mostly by Singularity
 func (i *Image) GetPublicSymlinkPath() string {
-	return filepath.Join(i.Registry, i.Repository+":"+i.GetSimpleReference())
+	return i.platformPath(filepath.Join(i.Registry, i.Repository+":"+i.GetSimpleReference()))
 }
 
 func (img *Image) getByteManifestList() ([]byte, error) {
 	url := img.GetManifestUrl("")
-	return makeGetRequest(url, map[string]string{"Accept":
-		"application/vnd.docker.distribution.manifest.list.v2+json, application/vnd.oci.image.index.v1+json"})
+	return makeGetRequest(url, map[string]string{"Accept": da.AcceptManifestList})
 }
 
 func (img *Image) getByteManifest(reference string) ([]byte, error) {
 	url := img.GetManifestUrl(reference)
-	return makeGetRequest(url, map[string]string{"Accept":
-		"application/vnd.docker.distribution.manifest.v2+json, application/vnd.oci.image.manifest.v1+json"})
-}
-
-func GetAuthToken(url string, credentials []Credentials) (token string, err error) {
-	reg := getRegistry(url)
-	if reg != nil && reg.proxy == "" {
-		return firstRequestForAuth_internal(url, reg.creds.username, reg.creds.password)
-	}
-	return firstRequestForAuth_internal(url, "", "")
-}
-
-func firstRequestForAuth(url string) (token string, err error) {
-	credentials := []Credentials{}
-	return GetAuthToken(url, credentials)
-}
-
-func firstRequestForAuth_internal(url, user, pass string) (token string, err error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		l.LogE(err).Error("Error in making the first request for auth")
-		return "", err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 300 && resp.StatusCode >= 200 {
-		log.WithFields(log.Fields{
-			"status code": resp.StatusCode,
-		}).Info("Return valid response, token not necessary.")
-		return
-	}
-	if resp.StatusCode != 401 {
-		log.WithFields(log.Fields{
-			"url": url,
-			"status code": resp.StatusCode,
-		}).Info("Expected status code 401.")
-		return "", err
-	}
-	WwwAuthenticate := resp.Header["Www-Authenticate"][0]
-	// we first try to get the token with the authentication
-	// if we fail, and we might since the docker hub might not have our user
-	// we try again without authentication
-	token, err = requestAuthToken(WwwAuthenticate, user, pass)
-	if err == nil {
-		// happy path
-		return token, nil
-	}
-	fmt.Printf("We failed with authentication and we now go without for %s\n", url)
-	// some error, we should retry without auth
-	if user != "" || pass != "" {
-		token, err = requestAuthToken(WwwAuthenticate, "", "")
-		if err == nil {
-			// happy path without auth
-			return token, nil
-		}
-	}
-	l.LogE(err).Error("Error in getting the authentication token")
-	return "", err
+	return makeGetRequest(url, map[string]string{"Accept": da.AcceptManifest})
 }
 
 func getLayerUrl(img *Image, layerDigest string) string {
@@ -630,7 +777,7 @@ func (img *Image) GetLayers(layersChan chan<- downloadedLayer, manifestChan chan
 	defer wg.Wait()
 	// at this point we iterate each layer and we download it.
 	for _, layer := range manifest.Layers {
-		if layer.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if layer.IsForeign() {
 			continue;
 		}
 
@@ -686,6 +833,11 @@ func (img *Image) GetLayers(layersChan chan<- downloadedLayer, manifestChan chan
 
 func (img *Image) downloadLayer(layer da.Layer, token string) (toSend downloadedLayer, err error) {
 	layerUrl := getLayerUrl(img, layer.Digest)
+	// no point in downloading a layer we can not decompress
+	if _, err = layer.GetCompression(); err != nil {
+		l.LogE(err).WithFields(log.Fields{"layer": layer.Digest}).Error("Impossible to decompress the layer")
+		return
+	}
 	if token == "" {
 		token, err = firstRequestForAuth(layerUrl)
 		if err != nil {
@@ -711,20 +863,23 @@ func (img *Image) downloadLayer(layer da.Layer, token string) (toSend downloaded
 			break
 		}
 		if 200 <= resp.StatusCode && resp.StatusCode < 300 {
-			gread, errG := gzip.NewReader(resp.Body)
-			if errG != nil {
-				err = errG
-				l.LogE(err).Warning("Error in creating the zip to unzip the layer")
+			layerReader, errD := newLayerReader(layer, resp.Body)
+			if errD != nil {
+				err = errD
+				l.LogE(err).WithFields(log.Fields{"media type": layer.MediaType}).
+					Warning("Error in creating the reader to decompress the layer")
+				resp.Body.Close()
 				continue
 			}
-			path := NewReadAndHash(gread)
+			path := NewReadAndHash(layerReader)
 			toSend = newDownloadedLayer(layer.Digest, path)
 			return toSend, nil
 		} else {
 			err = fmt.Errorf("layer not received, status code: %d", resp.StatusCode)
 			l.LogE(err).Warning("Received status code ", resp.StatusCode)
 			if resp.StatusCode == 401 {
-				// try to get the token again
+				// the token expired or was revoked, get a new one
+				registryTokens.invalidate(layerUrl)
 				newToken, errToken := firstRequestForAuth(layerUrl)
 				if errToken != nil {
 					l.LogE(errToken).Warning("Error in refreshing the token")
@@ -738,74 +893,6 @@ func (img *Image) downloadLayer(layer da.Layer, token string) (toSend downloaded
 	return
 }
 
-func parseBearerToken(token string) (realm string, options map[string]string, err error) {
-	options = make(map[string]string)
-	args := token[7:]
-	keyValue := strings.Split(args, ",")
-	for _, kv := range keyValue {
-		splitted := strings.Split(kv, "=")
-		if len(splitted) != 2 {
-			err = fmt.Errorf("wrong formatting of the token")
-			return
-		}
-		splitted[1] = strings.Trim(splitted[1], `"`)
-		if splitted[0] == "realm" {
-			realm = splitted[1]
-		} else {
-			options[splitted[0]] = splitted[1]
-		}
-	}
-	return
-}
-
-func requestAuthToken(token, user, pass string) (authToken string, err error) {
-	realm, options, err := parseBearerToken(token)
-	if err != nil {
-		return
-	}
-	req, err := http.NewRequest("GET", realm, nil)
-	if err != nil {
-		return
-	}
-
-	query := req.URL.Query()
-	for k, v := range options {
-		query.Add(k, v)
-	}
-	if user != "" && pass != "" {
-		query.Add("offline_token", "true")
-		req.SetBasicAuth(user, pass)
-	}
-	req.URL.RawQuery = query.Encode()
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		err = fmt.Errorf("error in getting the token, http request failed %s", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode >= 400 {
-		err = fmt.Errorf("authorization error %s", resp.Status)
-		return
-	}
-
-	var jsonResp map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&jsonResp)
-	if err != nil {
-		return
-	}
-	authTokenInterface, ok := jsonResp["token"]
-	if ok {
-		authToken = "Bearer " + authTokenInterface.(string)
-	} else {
-		err = fmt.Errorf("didn't get the token key from the server")
-		return
-	}
-	return
-}
-
 type LayerDownloader struct {
 	image    *Image
 	token    string
@@ -830,7 +917,7 @@ func (ld *LayerDownloader) getToken() (token string, err error) {
 
 	firstLayer := manifest.Layers[0]
 	for _, l := range manifest.Layers {
-		if l.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if l.IsForeign() {
 			continue;
 		}
 		firstLayer = l
@@ -1030,15 +1117,6 @@ func (img *Image) CreateSneakyChainStructure(CVMFSRepo string) (err error, lastC
 	return
 }
 
-func getRegistry(url string) *RegistryConfig {
-	for _, reg := range inputRegistries {
-		if strings.Contains(url, reg.baseUrl) {
-			return &reg
-		}
-	}
-	return nil
-}
-
 func (i *Image) baseUrl() string {
 	var url string
 	reg := getRegistry(i.Registry)
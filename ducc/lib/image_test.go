@@ -1,7 +1,14 @@
 package lib
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	da "github.com/cvmfs/ducc/docker-api"
 )
 
 func TestParseTags(t *testing.T) {
@@ -153,3 +160,165 @@ func TestFilterUsingGlobRealLifeImages02(t *testing.T) {
 		}
 	}
 }
+
+// registryWithManifestList serves a manifest list for the tag "multi", and
+// the manifest of each of its platforms but s390x
+func registryWithManifestList() *httptest.Server {
+	platforms := []string{"amd64", "arm64/v8", "arm/v7", "ppc64le", "s390x"}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/manifests/multi") {
+			var items []string
+			for i, p := range platforms {
+				arch, variant, _ := strings.Cut(p, "/")
+				item := fmt.Sprintf(`{"digest": "sha256:m%d", "platform": {"architecture": "%s", "os": "linux"`, i, arch)
+				if variant != "" {
+					item += fmt.Sprintf(`, "variant": "%s"`, variant)
+				}
+				items = append(items, item+"}}")
+			}
+			fmt.Fprintf(w, `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [%s]}`,
+				strings.Join(items, ","))
+			return
+		}
+		for i := range platforms[:len(platforms)-1] {
+			if strings.HasSuffix(r.URL.Path, fmt.Sprintf("/manifests/sha256:m%d", i)) {
+				fmt.Fprintf(w, `{"schemaVersion": 2, "config": {"digest": "sha256:c%d"}, "layers": [{"digest": "sha256:l%d"}]}`, i, i)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestPlatformImages(t *testing.T) {
+	registry := registryWithManifestList()
+	defer registry.Close()
+
+	image, err := ParseImage(registry.URL + "/library/multi:multi")
+	if err != nil {
+		t.Fatalf("Error in parsing the image: %s", err)
+	}
+	name := filepath.Join(image.Registry, "library/multi:multi")
+
+	platforms := []da.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "ppc64le"},
+		{OS: "linux", Architecture: "s390x"},
+	}
+	images, err := image.PlatformImages(platforms)
+	if err != nil {
+		t.Fatalf("Error in retrieving the images of the platforms: %s", err)
+	}
+	if len(images) != 3 {
+		t.Fatalf("Wrong number of platform images: %d", len(images))
+	}
+	// the manifest of s390x can not be retrieved, the other platforms are
+	// converted anyway, the error is returned when none is left
+	if _, err := image.PlatformImages([]da.Platform{{OS: "linux", Architecture: "s390x"}}); err == nil {
+		t.Errorf("No error without any platform image")
+	}
+	// the images of the default platform keep the original layout
+	expected := []struct {
+		platform, config, dir string
+	}{
+		{"linux/amd64", "sha256:c0", ""},
+		{"linux/arm64/v8", "sha256:c1", "linux-arm64-v8"},
+		{"linux/ppc64le", "sha256:c3", "linux-ppc64le"},
+	}
+	for i, e := range expected {
+		img := images[i]
+		manifest, err := img.GetManifest()
+		if err != nil {
+			t.Errorf("Error in getting the manifest of %s: %s", e.platform, err)
+			continue
+		}
+		if img.Platform.String() != e.platform || manifest.Config.Digest != e.config {
+			t.Errorf("Wrong platform image: %s %s", img.Platform, manifest.Config.Digest)
+		}
+		if img.GetPublicSymlinkPath() != filepath.Join(e.dir, name) {
+			t.Errorf("Wrong public path for %s: %s", e.platform, img.GetPublicSymlinkPath())
+		}
+		if img.GetMetadataPath() != filepath.Join(".metadata", e.dir, name) {
+			t.Errorf("Wrong metadata path for %s: %s", e.platform, img.GetMetadataPath())
+		}
+		if img.podmanStorePath() != filepath.Join(e.dir, "podmanStore") {
+			t.Errorf("Wrong podman store for %s: %s", e.platform, img.podmanStorePath())
+		}
+	}
+
+	record, err := images[1].GetPlatformManifest()
+	if err != nil || record.Digest != "sha256:m1" || record.Config != "sha256:c1" || record.Path != "linux-arm64-v8" {
+		t.Errorf("Wrong platform record: %+v %v", record, err)
+	}
+
+	// without platforms, the amd64 image is converted in the original layout
+	image.Manifest = nil
+	images, err = image.PlatformImages(nil)
+	if err != nil || len(images) != 1 || images[0].Platform != nil {
+		t.Fatalf("Wrong images without platforms: %v %v", images, err)
+	}
+	if manifest, _ := images[0].GetManifest(); manifest.Config.Digest != "sha256:c0" {
+		t.Errorf("Wrong default manifest: %s", manifest.Config.Digest)
+	}
+}
+
+// registryWithArtifacts serves an OCI index with an attestation attached to
+// its image for the tag "attested", and a helm chart for the tag "chart"
+func registryWithArtifacts() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/attested"):
+			// the media type of an OCI index is optional
+			fmt.Fprint(w, `{"schemaVersion": 2, "manifests": [
+				{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:m0",
+				 "platform": {"architecture": "amd64", "os": "linux"}},
+				{"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:a0",
+				 "platform": {"architecture": "unknown", "os": "unknown"},
+				 "annotations": {"vnd.docker.reference.type": "attestation-manifest", "vnd.docker.reference.digest": "sha256:m0"}}]}`)
+		case strings.HasSuffix(r.URL.Path, "/manifests/sha256:m0"):
+			fmt.Fprint(w, `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"config": {"mediaType": "application/vnd.oci.image.config.v1+json", "digest": "sha256:c0"},
+				"layers": [{"mediaType": "application/vnd.oci.image.layer.v1.tar+zstd", "digest": "sha256:l0"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/manifests/sha256:a0"):
+			fmt.Fprint(w, `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"config": {"mediaType": "application/vnd.in-toto+json", "digest": "sha256:ca"},
+				"layers": [{"mediaType": "application/vnd.in-toto+json", "digest": "sha256:la"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/manifests/chart"):
+			fmt.Fprint(w, `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json",
+				"config": {"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": "sha256:ch"},
+				"layers": [{"mediaType": "application/vnd.cncf.helm.chart.content.v1.tar+gzip", "digest": "sha256:lh"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestPlatformImagesSkipsArtifacts(t *testing.T) {
+	registry := registryWithArtifacts()
+	defer registry.Close()
+
+	for _, platforms := range [][]da.Platform{nil, {da.DefaultPlatform, {OS: "unknown", Architecture: "unknown"}}} {
+		image, err := ParseImage(registry.URL + "/library/artifacts:attested")
+		if err != nil {
+			t.Fatalf("Error in parsing the image: %s", err)
+		}
+		images, err := image.PlatformImages(platforms)
+		if err != nil || len(images) != 1 {
+			t.Fatalf("Wrong images of the index with %v: %v %v", platforms, images, err)
+		}
+		manifest, _ := images[0].GetManifest()
+		if manifest.Config.Digest != "sha256:c0" {
+			t.Errorf("Wrong manifest with %v: %s", platforms, manifest.Config.Digest)
+		}
+
+		chart, err := ParseImage(registry.URL + "/library/artifacts:chart")
+		if err != nil {
+			t.Fatalf("Error in parsing the image: %s", err)
+		}
+		images, err = chart.PlatformImages(platforms)
+		if err != nil || len(images) != 0 {
+			t.Errorf("Artifact not skipped with %v: %v %v", platforms, images, err)
+		}
+	}
+}
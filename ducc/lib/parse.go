@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+
+	da "github.com/cvmfs/ducc/docker-api"
 )
 
 func ParseImage(image string) (img Image, err error) {
@@ -109,3 +111,22 @@ func ParseImage(image string) (img Image, err error) {
 	}
 	return Image{}, fmt.Errorf("Impossible to parse the image: %s", image)
 }
+
+// ParsePlatform parses a platform written as os/architecture[/variant], like
+// linux/arm64/v8
+func ParsePlatform(platform string) (da.Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return da.Platform{}, fmt.Errorf("Impossible to parse the platform, expected os/architecture[/variant]: %s", platform)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return da.Platform{}, fmt.Errorf("Impossible to parse the platform, empty component in: %s", platform)
+		}
+	}
+	p := da.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
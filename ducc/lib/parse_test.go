@@ -138,3 +138,25 @@ func TestParseImageWithoutProtocol(t *testing.T) {
 	// this call might panic if we are not able to manage the string
 	image.GetReference()
 }
+
+func TestParsePlatform(t *testing.T) {
+	platform, err := ParsePlatform("linux/arm64/v8")
+	if err != nil {
+		t.Errorf("Error in parsing the platform: %s", err)
+	}
+	if platform.OS != "linux" || platform.Architecture != "arm64" || platform.Variant != "v8" {
+		t.Errorf("Error in parse wrong platform: %v", platform)
+	}
+	if platform.String() != "linux/arm64/v8" || platform.Dir() != "linux-arm64-v8" {
+		t.Errorf("Error in formatting the platform: %s %s", platform.String(), platform.Dir())
+	}
+	platform, err = ParsePlatform("linux/ppc64le")
+	if err != nil || platform.Variant != "" {
+		t.Errorf("Error in parsing platform without variant: %v %s", platform, err)
+	}
+	for _, invalid := range []string{"", "linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if _, err := ParsePlatform(invalid); err == nil {
+			t.Errorf("Invalid platform parsed: %s", invalid)
+		}
+	}
+}
@@ -68,6 +68,12 @@ var (
 	layerMetadataDir = "overlay-layers"
 )
 
+// podmanStorePath returns the podman store of the image, relative to the root
+// of the repository. Each platform has its own store.
+func (img *Image) podmanStorePath() string {
+	return img.platformPath(rootPath)
+}
+
 // creates layers.json file in podmanStore.
 func (img *Image) PublishLayerInfo(CVMFSRepo string, digestMap map[string]string) (err error) {
 	manifest, err := img.GetManifest()
@@ -79,7 +85,7 @@ func (img *Image) PublishLayerInfo(CVMFSRepo string, digestMap map[string]string
 	l.Log().WithFields(log.Fields{"action": "Ingesting layers.json in podman store"}).Info(img.GetSimpleName())
 
 	layersdata := []LayerInfo{}
-	layerInfoPath := filepath.Join("/", "cvmfs", CVMFSRepo, rootPath, layerMetadataDir, "layers.json")
+	layerInfoPath := filepath.Join("/", "cvmfs", CVMFSRepo, img.podmanStorePath(), layerMetadataDir, "layers.json")
 	//check if layers.json already exist and append to data
 	if _, err := os.Stat(layerInfoPath); err == nil {
 		file, err := ioutil.ReadFile(layerInfoPath)
@@ -92,7 +98,7 @@ func (img *Image) PublishLayerInfo(CVMFSRepo string, digestMap map[string]string
 
 	storedlayersdata := []LayerInfo{}
 	for _, layer := range manifest.Layers {
-		if layer.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if layer.IsForeign() {
 			continue;
 		}
 		storedlayerdata := []LayerInfo{}
@@ -118,7 +124,7 @@ func (img *Image) PublishLayerInfo(CVMFSRepo string, digestMap map[string]string
 	sizeMap := make(map[string]int)
 	lastLayer := ""
 	for _, layer := range manifest.Layers {
-		if layer.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if layer.IsForeign() {
 			continue;
 		}
 		sizeMap[layer.Digest] = layer.Size
@@ -159,7 +165,7 @@ func (img *Image) PublishImageInfo(CVMFSRepo string, digestMap map[string]string
 	//create images.json file
 	l.Log().WithFields(log.Fields{"action": "Ingesting images.json in podman store"}).Info(img.GetSimpleName())
 	imagedata := []ImageInfo{}
-	imageInfoPath := filepath.Join("/", "cvmfs", CVMFSRepo, rootPath, imageMetadataDir, "images.json")
+	imageInfoPath := filepath.Join("/", "cvmfs", CVMFSRepo, img.podmanStorePath(), imageMetadataDir, "images.json")
 
 	//check if images.json already exist and append to data
 	if _, err := os.Stat(imageInfoPath); err == nil {
@@ -210,14 +216,14 @@ func (img *Image) LinkRootfsIntoPodmanStore(CVMFSRepo, subDirInsideRepo string,
 	// this is extremelly bad, we are making one transaction for each layer
 	// all of them could be done in a single transaction
 	for _, layer := range manifest.Layers {
-		if layer.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if layer.IsForeign() {
 			continue;
 		}
 		layerid := strings.Split(layer.Digest, ":")[1]
 		layerdir := digestMap[layer.Digest]
 
 		//symlinkPath will contain the rootfs of the corresponding layer in podman store.
-		symlinkPath := filepath.Join(rootPath, rootfsDir, layerdir, "diff")
+		symlinkPath := filepath.Join(img.podmanStorePath(), rootfsDir, layerdir, "diff")
 		targetPath := filepath.Join(subDirInsideRepo, layerid[:2], layerid, "layerfs")
 
 		if _, err := os.Stat(symlinkPath); os.IsNotExist(err) {
@@ -241,11 +247,11 @@ func (img *Image) CreateLinkDir(CVMFSRepo, subDirInsideRepo string, digestMap, l
 	}
 	// also here, we are making one transaction for each layer
 	for _, layer := range manifest.Layers {
-		if layer.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if layer.IsForeign() {
 			continue;
 		}
 		layerdir := digestMap[layer.Digest]
-		linkPath := filepath.Join(rootPath, rootfsDir, layerdir, "link")
+		linkPath := filepath.Join(img.podmanStorePath(), rootfsDir, layerdir, "link")
 		if _, err := os.Stat(linkPath); os.IsNotExist(err) {
 			//generate the link id
 			lid, err := generateID(26)
@@ -261,8 +267,8 @@ func (img *Image) CreateLinkDir(CVMFSRepo, subDirInsideRepo string, digestMap, l
 			}
 
 			//Create link dir
-			symlinkPath := filepath.Join(rootPath, rootfsDir, "l", lid)
-			targetPath := filepath.Join(rootPath, rootfsDir, layerdir, "diff")
+			symlinkPath := filepath.Join(img.podmanStorePath(), rootfsDir, "l", lid)
+			targetPath := filepath.Join(img.podmanStorePath(), rootfsDir, layerdir, "diff")
 
 			err = cvmfs.CreateSymlinkIntoCVMFS(CVMFSRepo, symlinkPath, targetPath)
 			if err != nil {
@@ -294,12 +300,12 @@ func (img *Image) CreateLowerFiles(CVMFSRepo string, digestMap, layerIdMap map[s
 	lastDigest := ""
 	// again, for each layer, one more transaction
 	for _, layer := range manifest.Layers {
-		if layer.MediaType == "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip" {
+		if layer.IsForeign() {
 			continue;
 		}
 		if lastDigest != "" {
 			layerdir := digestMap[layer.Digest]
-			lowerPath := filepath.Join(rootPath, rootfsDir, layerdir, "lower")
+			lowerPath := filepath.Join(img.podmanStorePath(), rootfsDir, layerdir, "lower")
 			if _, err := os.Stat(lowerPath); os.IsNotExist(err) {
 				lowerdata := layerIdMap[lastDigest]
 				if lastLowerData != "" {
@@ -341,7 +347,7 @@ func (img *Image) CreateConfigFile(CVMFSRepo string) (err error) {
 	}
 
 	imageID := strings.Split(manifest.Config.Digest, ":")[1]
-	configFilePath := filepath.Join(rootPath, imageMetadataDir, imageID, fname)
+	configFilePath := filepath.Join(img.podmanStorePath(), imageMetadataDir, imageID, fname)
 	if _, err := os.Stat(configFilePath); os.IsNotExist(err) {
 		configUrl := fmt.Sprintf("%sblobs/%s", img.GetBaseUrl(), manifest.Config.Digest)
 
@@ -388,8 +394,8 @@ func (img *Image) PublishImageManifest(CVMFSRepo string) (err error) {
 	}
 	imageID := strings.Split(manifest.Config.Digest, ":")[1]
 
-	symlinkPath := filepath.Join(rootPath, imageMetadataDir, imageID, "manifest")
-	targetPath := filepath.Join(".metadata", img.Registry, img.Repository+img.GetReference(), "manifest.json")
+	symlinkPath := filepath.Join(img.podmanStorePath(), imageMetadataDir, imageID, "manifest")
+	targetPath := filepath.Join(".metadata", img.platformPath(filepath.Join(img.Registry, img.Repository+img.GetReference())), "manifest.json")
 
 	err = cvmfs.CreateSymlinkIntoCVMFS(CVMFSRepo, symlinkPath, targetPath)
 	if err != nil {
@@ -403,8 +409,8 @@ func (img *Image) PublishImageManifest(CVMFSRepo string) (err error) {
 // Libpod expects these files to be present in its image stores.
 func (img *Image) CreateLockFiles(CVMFSRepo string) (err error) {
 	l.Log().WithFields(log.Fields{"action": "Creating lock file for the image"}).Info(img.GetSimpleName())
-	layerlockpath := filepath.Join("/cvmfs", CVMFSRepo, rootPath, layerMetadataDir, "layers.lock")
-	imagelockpath := filepath.Join("/cvmfs", CVMFSRepo, rootPath, imageMetadataDir, "images.lock")
+	layerlockpath := filepath.Join("/cvmfs", CVMFSRepo, img.podmanStorePath(), layerMetadataDir, "layers.lock")
+	imagelockpath := filepath.Join("/cvmfs", CVMFSRepo, img.podmanStorePath(), imageMetadataDir, "images.lock")
 	var paths []string
 	if _, err := os.Stat(layerlockpath); os.IsNotExist(err) {
 		paths = append(paths, layerlockpath)
@@ -435,50 +441,76 @@ func (img *Image) CreateLockFiles(CVMFSRepo string) (err error) {
 // Note: The layers are not removed. Only the manifest, config file and images.json are updated.
 func (img *Image) CheckImageChanged(CVMFSRepo string) error {
 	l.Log().WithFields(log.Fields{"action": "checking if old image version with same tag exists"}).Info(img.GetSimpleName())
-	path := filepath.Join("/cvmfs", CVMFSRepo, rootPath, imageMetadataDir, "images.json")
-	if _, err := os.Stat(path); err == nil {
-		var imagesinfo []ImageInfo
-		newimagesinfo := []ImageInfo{}
+	// we have already checked if the same image is present in the podman store or not.
+	// hence if the we find another image with same name, it means its content (digest) has changed.
+	return removePodmanImages(CVMFSRepo, img.podmanStorePath(), img.GetSimpleName())
+}
 
-		file, err := ioutil.ReadFile(path)
-		if err != nil {
-			l.LogE(err).Error("error in reading images.json file")
-			return err
-		}
+// removePodmanImages removes the images named name from the podman store at
+// storePath, relative to the root of the repository: their entries in
+// images.json, and their manifest and config file unless another image of the
+// store shares them.
+func removePodmanImages(CVMFSRepo, storePath, name string) error {
+	path := filepath.Join("/cvmfs", CVMFSRepo, storePath, imageMetadataDir, "images.json")
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	var imagesinfo []ImageInfo
+	newimagesinfo := []ImageInfo{}
 
-		json.Unmarshal(file, &imagesinfo)
-		for _, image := range imagesinfo {
-			present := false
-			for _, name := range image.Names {
-				// we have already checked if the same image is present in the podman store or not.
-				// hence if the we find another image with same name, it means its content (digest) has changed.
-				if name == img.GetSimpleName() {
-					l.Log().WithFields(log.Fields{"image": img.GetSimpleName()}).Info("older image version present, cleaning and ingesting newer version")
-					present = true
-					err := cvmfs.RemoveDirectory(CVMFSRepo, rootPath, imageMetadataDir, image.ID)
-					if err != nil {
-						l.LogE(err).Error("error while removing older image version from podman store")
-						return err
-					}
-					break
-				}
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		l.LogE(err).Error("error in reading images.json file")
+		return err
+	}
+
+	json.Unmarshal(file, &imagesinfo)
+	var removed []string
+	for _, image := range imagesinfo {
+		present := false
+		for _, n := range image.Names {
+			if n == name {
+				l.Log().WithFields(log.Fields{"image": name, "store": storePath}).Info("removing image from podman store")
+				present = true
+				removed = append(removed, image.ID)
+				break
 			}
-			if !present {
-				newimagesinfo = append(newimagesinfo, image)
+		}
+		if !present {
+			newimagesinfo = append(newimagesinfo, image)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	for _, id := range removed {
+		shared := false
+		for _, image := range newimagesinfo {
+			if image.ID == id {
+				shared = true
+				break
 			}
 		}
-		imgInfo, err := json.MarshalIndent(newimagesinfo, "", " ")
-		if err != nil {
-			l.LogE(err).Error("Error in marshaling json data for images.json")
-			return err
+		if shared {
+			continue
 		}
-
-		err = cvmfs.WriteDataToCvmfs(CVMFSRepo, cvmfs.TrimCVMFSRepoPrefix(path), imgInfo)
+		err := cvmfs.RemoveDirectory(CVMFSRepo, storePath, imageMetadataDir, id)
 		if err != nil {
-			l.LogE(err).Error("Error in writing images.json")
+			l.LogE(err).Error("error while removing image from podman store")
 			return err
 		}
 	}
+	imgInfo, err := json.MarshalIndent(newimagesinfo, "", " ")
+	if err != nil {
+		l.LogE(err).Error("Error in marshaling json data for images.json")
+		return err
+	}
+
+	err = cvmfs.WriteDataToCvmfs(CVMFSRepo, cvmfs.TrimCVMFSRepoPrefix(path), imgInfo)
+	if err != nil {
+		l.LogE(err).Error("Error in writing images.json")
+		return err
+	}
 	return nil
 }
 
@@ -501,7 +533,8 @@ func (img *Image) CreatePodmanImageStore(CVMFSRepo, subDirInsideRepo string) (er
 	}
 
 	l.Log().WithFields(log.Fields{"action": "Ingest the image into podman store"}).Info(img.GetSimpleName())
-	createCatalogIntoDirs := []string{rootPath, filepath.Join(rootPath, rootfsDir), filepath.Join(rootPath, imageMetadataDir), filepath.Join(rootPath, layerMetadataDir)}
+	storePath := img.podmanStorePath()
+	createCatalogIntoDirs := []string{storePath, filepath.Join(storePath, rootfsDir), filepath.Join(storePath, imageMetadataDir), filepath.Join(storePath, layerMetadataDir)}
 	for _, dir := range createCatalogIntoDirs {
 		err = cvmfs.CreateCatalogIntoDir(CVMFSRepo, dir)
 		if err != nil {
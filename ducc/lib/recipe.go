@@ -18,18 +18,78 @@ type YamlRecipeV1 struct {
 	CVMFSRepo    string   `yaml:"cvmfs_repo"`
 	OutputFormat string   `yaml:"output_format"`
 	Input        []string `yaml:"input"`
+	// Platforms are the os/architecture[/variant] of the images to convert
+	// from the manifest lists, linux/amd64 if empty
+	Platforms []string `yaml:"platforms"`
 }
 
 type Recipe struct {
-	Repo   string
-	Wishes chan WishFriendly
+	Repo string
+	// Repos are all the repositories the images of the recipe are converted
+	// into
+	Repos []string
+	// OutputImages are the thin images of the recipe, with the user pushing
+	// them, one for each registry and user
+	OutputImages []Image
+	Wishes       chan WishFriendly
+}
+
+// ParseRecipe parses a recipe read from path, of any version. The v1 recipes
+// are parsed leniently, see ValidateRecipe.
+func ParseRecipe(path string, data []byte) (Recipe, error) {
+	var header struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &header); err == nil && header.Version == 2 {
+		return ParseYamlRecipeV2(path, data)
+	}
+	return ParseYamlRecipeV1(data)
 }
 
 func ParseYamlRecipeV1(data []byte) (Recipe, error) {
 	recipeYamlV1 := YamlRecipeV1{}
 	err := yaml.Unmarshal(data, &recipeYamlV1)
-	recipe := Recipe{}
+	if err != nil {
+		return makeRecipeV1(recipeYamlV1, nil), err
+	}
+
+	options := WishOptions{Outputs: AllOutputs}
+	for _, p := range recipeYamlV1.Platforms {
+		platform, err := ParsePlatform(p)
+		if err != nil {
+			return makeRecipeV1(recipeYamlV1, nil), err
+		}
+		options.Platforms = append(options.Platforms, platform)
+	}
+
+	var entries []RecipeEntry
+	for _, inputImage := range recipeYamlV1.Input {
+		input, err := ParseImage(inputImage)
+		if err != nil {
+			l.LogE(err).WithFields(log.Fields{"image": inputImage}).Warning("Impossible to parse the image")
+		}
+		entries = append(entries, RecipeEntry{
+			Input:        input,
+			User:         recipeYamlV1.User,
+			CVMFSRepo:    recipeYamlV1.CVMFSRepo,
+			OutputFormat: recipeYamlV1.OutputFormat,
+			Options:      options,
+		})
+	}
+	return makeRecipeV1(recipeYamlV1, entries), nil
+}
+
+func makeRecipeV1(recipeYamlV1 YamlRecipeV1, entries []RecipeEntry) Recipe {
+	recipe := makeRecipe(entries)
 	recipe.Repo = recipeYamlV1.CVMFSRepo
+	recipe.Repos = []string{recipeYamlV1.CVMFSRepo}
+	return recipe
+}
+
+// makeRecipe creates the wishes of the images of a recipe, in the background.
+// The images of each registry are processed one after the other.
+func makeRecipe(entries []RecipeEntry) Recipe {
+	recipe := Recipe{}
 	recipe.Wishes = make(chan WishFriendly, 500)
 	var wg sync.WaitGroup
 	defer func() {
@@ -38,42 +98,52 @@ func ParseYamlRecipeV1(data []byte) (Recipe, error) {
 			close(recipe.Wishes)
 		}()
 	}()
-	if err != nil {
-		return recipe, err
-	}
 
-	registryMap := make(map[string][]Image)
-	for _, inputImage := range recipeYamlV1.Input {
-		input, err := ParseImage(inputImage)
-		if err != nil {
-			l.LogE(err).WithFields(log.Fields{"image": inputImage}).Warning("Impossible to parse the image")
+	repos := make(map[string]bool)
+	pushers := make(map[string]bool)
+	registryMap := make(map[string][]RecipeEntry)
+	for _, entry := range entries {
+		if !repos[entry.CVMFSRepo] {
+			repos[entry.CVMFSRepo] = true
+			recipe.Repos = append(recipe.Repos, entry.CVMFSRepo)
+		}
+		if entry.Options.Outputs.Thin {
+			output, err := ParseImage(formatOutputImage(entry.OutputFormat, entry.Input))
+			if err == nil && !pushers[output.Registry+"|"+entry.User] {
+				pushers[output.Registry+"|"+entry.User] = true
+				output.User = entry.User
+				recipe.OutputImages = append(recipe.OutputImages, output)
+			}
 		}
-		registryMap[input.Registry] = append(registryMap[input.Registry], input)
+		registryMap[entry.Input.Registry] = append(registryMap[entry.Input.Registry], entry)
+	}
+	if len(recipe.Repos) > 0 {
+		recipe.Repo = recipe.Repos[0]
 	}
-	for reg, inputImages := range registryMap {
+	for reg, regEntries := range registryMap {
 		wg.Add(1)
-		go func(inputImages []Image, reg string) {
-			l.Log().Info("Starting with", inputImages[0])
+		go func(regEntries []RecipeEntry, reg string) {
+			l.Log().Info("Starting with", regEntries[0].Input)
 			defer wg.Done()
-			for _, input := range inputImages {
+			for _, entry := range regEntries {
 				if reg == "gitlab-registry.cern.ch" {
 					time.Sleep(500*time.Millisecond + time.Duration(rand.Intn(500))*time.Millisecond)
 				}
 
 				l.Log().Info(reg)
-				l.Log().Info(input)
+				l.Log().Info(entry.Input)
 
-				output := formatOutputImage(recipeYamlV1.OutputFormat, input)
-				wish, err := CreateWish(input, output, recipeYamlV1.CVMFSRepo, recipeYamlV1.User, recipeYamlV1.User)
+				output := formatOutputImage(entry.OutputFormat, entry.Input)
+				wish, err := CreateWish(entry.Input, output, entry.CVMFSRepo, entry.User, entry.User, entry.Options)
 				if err != nil {
 					l.LogE(err).Warning("Error in creating the wish")
 				} else {
 					recipe.Wishes <- wish
 				}
 			}
-		}(inputImages, reg)
+		}(regEntries, reg)
 	}
-	return recipe, nil
+	return recipe
 }
 
 func formatOutputImage(OutputFormat string, inputImage Image) string {
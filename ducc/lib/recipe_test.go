@@ -0,0 +1,276 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	da "github.com/cvmfs/ducc/docker-api"
+)
+
+func writeRecipe(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Error in writing the recipe: %s", err)
+	}
+	return path
+}
+
+func TestLoadRecipeV2(t *testing.T) {
+	dir := t.TempDir()
+	writeRecipe(t, dir, "common.yaml", `
+version: 2
+defaults:
+  output_format: '$(scheme)://registry.example.org/common/$(image)'
+images:
+  - https://registry.hub.docker.com/library/centos:centos7
+`)
+	path := writeRecipe(t, dir, "recipe.yaml", `
+version: 2
+defaults:
+  user: cvmfsunpacker
+  cvmfs_repo: unpacked.cern.ch
+  platforms: [linux/amd64, linux/arm64]
+include:
+  - common.yaml
+images:
+  - https://registry.hub.docker.com/library/ubuntu:20.04
+  - image: https://registry.hub.docker.com/atlas/athena:22.0.*
+    cvmfs_repo: atlas.cern.ch
+    outputs: [flat]
+    tags:
+      include: ['22.0.1*']
+      exclude: ['*-rc*']
+    retention:
+      max_tags: 5
+`)
+	data, _ := os.ReadFile(path)
+	entries, errs := loadRecipe(path, data)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors:\n%s", errs)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Wrong number of images: %d", len(entries))
+	}
+
+	common, ubuntu, athena := entries[0], entries[1], entries[2]
+	// the included recipe starts from the defaults of the including one
+	if common.Input.Repository != "library/centos" || common.CVMFSRepo != "unpacked.cern.ch" ||
+		common.User != "cvmfsunpacker" || !strings.Contains(common.OutputFormat, "/common/") {
+		t.Errorf("Wrong included image: %+v", common)
+	}
+	if ubuntu.OutputFormat != "" || ubuntu.Options.Outputs != AllOutputs || len(ubuntu.Options.Platforms) != 2 {
+		t.Errorf("Wrong image with the defaults: %+v", ubuntu)
+	}
+	if athena.CVMFSRepo != "atlas.cern.ch" || athena.User != "cvmfsunpacker" {
+		t.Errorf("Wrong overridden image: %+v", athena)
+	}
+	if athena.Options.Outputs != (WishOutputs{Flat: true}) {
+		t.Errorf("Wrong outputs: %+v", athena.Options.Outputs)
+	}
+	expectedTags := TagFilter{Include: []string{"22.0.1*"}, Exclude: []string{"*-rc*"}}
+	if !reflect.DeepEqual(athena.Options.Tags, expectedTags) || athena.Options.Retention.MaxTags != 5 {
+		t.Errorf("Wrong tags and retention: %+v %+v", athena.Options.Tags, athena.Options.Retention)
+	}
+
+	// only the images with the thin output are pushed
+	outputs := makeRecipe(entries).OutputImages
+	if len(outputs) != 2 || outputs[0].Registry != "registry.example.org" ||
+		outputs[1].Registry != "registry.hub.docker.com" || outputs[1].User != "cvmfsunpacker" {
+		t.Errorf("Wrong output images: %+v", outputs)
+	}
+
+	recipe, err := ParseYamlRecipeV2(path, []byte("version: 2\n"))
+	if err != nil || len(recipe.Repos) != 0 {
+		t.Errorf("Wrong empty recipe: %+v %v", recipe, err)
+	}
+	if _, ok := <-recipe.Wishes; ok {
+		t.Errorf("Wish from an empty recipe")
+	}
+}
+
+func TestLoadRecipeDiamondInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeRecipe(t, dir, "common.yaml", `
+version: 2
+images:
+  - https://registry.hub.docker.com/library/centos:centos7
+`)
+	writeRecipe(t, dir, "atlas.yaml", "version: 2\ninclude: [common.yaml]\n")
+	writeRecipe(t, dir, "cms.yaml", "version: 2\ninclude: [common.yaml]\n")
+	path := writeRecipe(t, dir, "recipe.yaml", `
+version: 2
+defaults:
+  cvmfs_repo: unpacked.cern.ch
+include: [atlas.yaml, cms.yaml]
+`)
+	data, _ := os.ReadFile(path)
+	entries, errs := loadRecipe(path, data)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors:\n%s", errs)
+	}
+	if len(entries) != 1 || entries[0].Input.Repository != "library/centos" {
+		t.Errorf("The recipe included twice is not read once: %+v", entries)
+	}
+}
+
+func TestValidateRecipe(t *testing.T) {
+	dir := t.TempDir()
+	writeRecipe(t, dir, "loop.yaml", `
+version: 2
+include: [recipe.yaml]
+`)
+	writeRecipe(t, dir, "v1.yaml", `
+version: 1
+cvmfs_repo: unpacked.cern.ch
+`)
+	path := writeRecipe(t, dir, "recipe.yaml", `version: 2
+include: [loop.yaml, v1.yaml, missing.yaml]
+defaults:
+  cvmfs_repo: unpacked.cern.ch
+  platforms: [linux/amd64, linux]
+  output: [flat]
+images:
+  - https://registry.hub.docker.com/library/ubuntu:20.04
+  - image: https://registry.hub.docker.com/library/centos:centos7
+    outputs: [thin, squashfs]
+    tags:
+      exclude: ['*-rc*']
+  - image: https://registry.hub.docker.com/atlas/athena:22.0.*
+    retention:
+      max_tags: -1
+  - cvmfs_repo: atlas.cern.ch
+  - image: https://registry.hub.docker.com
+`)
+	data, _ := os.ReadFile(path)
+	errs := ValidateRecipe(path, data)
+	loop := filepath.Join(dir, "loop.yaml")
+	v1 := filepath.Join(dir, "v1.yaml")
+	expected := []string{
+		path + ":6:3: unknown field output",
+		path + ":5:28: Impossible to parse the platform, expected os/architecture[/variant]: linux",
+		loop + ":3:11: include cycle, " + path + " is already being included",
+		v1 + ":2:1: only v2 recipes can be included",
+		path + ":2:31: impossible to read the included recipe: open " + filepath.Join(dir, "missing.yaml") + ": no such file or directory",
+		path + ":10:21: unknown output squashfs, expected layers, flat, podman or thin",
+		path + ":10:14: the podman and thin outputs need the layers output",
+		path + ":12:7: tags only apply to images with a wildcard tag",
+		path + ":15:17: max_tags must be a positive integer",
+		path + ":16:5: missing image",
+		path + ":17:12: Impossible to identify the repository of the image: https://registry.hub.docker.com",
+	}
+	if errs.Error() != strings.Join(expected, "\n") {
+		t.Errorf("Wrong errors:\n%s\nexpected:\n%s", errs, strings.Join(expected, "\n"))
+	}
+
+	errs = ValidateRecipe("v1.yaml", []byte("cvmfs_repo: unpacked.cern.ch\ninput:\n  - https://registry.hub.docker.com/library/ubuntu:20.04\noutput: [flat]\n"))
+	if errs.Error() != "v1.yaml:4:1: unknown field output" {
+		t.Errorf("Wrong errors of the v1 recipe: %s", errs)
+	}
+	errs = ValidateRecipe("broken.yaml", []byte("version: 2\nimages:\n  - [\n"))
+	if len(errs) != 1 || errs[0].Line == 0 {
+		t.Errorf("Wrong syntax error: %v", errs)
+	}
+}
+
+func TestTagFilter(t *testing.T) {
+	tags := []string{"22.0.1", "22.0.10", "22.0.10-rc1", "22.0.2", "latest"}
+	filter := TagFilter{Include: []string{"22.0.1*", "latest"}, Exclude: []string{"*-rc*"}}
+	filtered, err := filter.Filter(tags)
+	if err != nil {
+		t.Fatalf("Error in filtering the tags: %s", err)
+	}
+	if !reflect.DeepEqual(filtered, []string{"22.0.1", "22.0.10", "latest"}) {
+		t.Errorf("Wrong filtered tags: %v", filtered)
+	}
+	if filtered, _ := (TagFilter{}).Filter(tags); !reflect.DeepEqual(filtered, tags) {
+		t.Errorf("Empty filter changed the tags: %v", filtered)
+	}
+}
+
+// registryWithTags serves the tags of a repository, each created on a
+// different day, and counts the requests of their configurations
+func registryWithTags(tags []string, created map[string]string, configRequests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			fmt.Fprintf(w, `{"tags": ["%s"]}`, strings.Join(tags, `", "`))
+			return
+		}
+		for _, tag := range tags {
+			if strings.HasSuffix(r.URL.Path, "/manifests/"+tag) {
+				fmt.Fprintf(w, `{"schemaVersion": 2, "config": {"digest": "sha256:c%s"}, "layers": [{"digest": "sha256:l%s"}]}`, tag, tag)
+				return
+			}
+			if strings.HasSuffix(r.URL.Path, "/blobs/sha256:c"+tag) {
+				atomic.AddInt32(configRequests, 1)
+				fmt.Fprintf(w, `{"created": "%s", "os": "linux", "architecture": "amd64"}`, created[tag])
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestExpandWildcardRetention(t *testing.T) {
+	tags := []string{"1.0", "1.1", "1.2", "1.3-rc1", "2.0"}
+	created := map[string]string{
+		"1.0":     "2023-01-01T00:00:00Z",
+		"1.1":     "2023-03-01T00:00:00Z",
+		"1.2":     "2023-02-01T00:00:00Z",
+		"1.3-rc1": "2023-04-01T00:00:00Z",
+		"2.0":     "2023-05-01T00:00:00Z",
+	}
+	var configRequests int32
+	registry := registryWithTags(tags, created, &configRequests)
+	defer registry.Close()
+
+	image, err := ParseImage(registry.URL + "/library/app:1.*")
+	if err != nil {
+		t.Fatalf("Error in parsing the image: %s", err)
+	}
+	options := WishOptions{
+		Tags:      TagFilter{Exclude: []string{"*-rc*"}},
+		Retention: Retention{MaxTags: 2},
+	}
+	r1, r2, expired, err := image.ExpandWildcard(options)
+	if err != nil {
+		t.Fatalf("Error in expanding the wildcard: %s", err)
+	}
+	go func() {
+		for range r2 {
+		}
+	}()
+	converted := make(map[string]bool)
+	for img := range r1 {
+		converted[img.Tag] = true
+	}
+	if !reflect.DeepEqual(converted, map[string]bool{"1.1": true, "1.2": true}) {
+		t.Errorf("Wrong converted tags: %v", converted)
+	}
+	if !reflect.DeepEqual(expired, []string{"1.0"}) {
+		t.Errorf("Wrong expired tags: %v", expired)
+	}
+
+	// the creation dates are reused by the next passes
+	requests := atomic.LoadInt32(&configRequests)
+	if _, _, expired, err = image.ExpandWildcard(options); err != nil || !reflect.DeepEqual(expired, []string{"1.0"}) {
+		t.Errorf("Wrong expired tags from the cached creation dates: %v %v", expired, err)
+	}
+	if atomic.LoadInt32(&configRequests) != requests {
+		t.Errorf("Configurations fetched again: %d requests, %d before", configRequests, requests)
+	}
+
+	// without a known creation date, nothing expires
+	creationDates.dates = make(map[string]creationDate)
+	delete(created, "1.0")
+	_, _, expired, err = image.ExpandWildcard(WishOptions{Retention: Retention{MaxTags: 1}, Platforms: []da.Platform{da.DefaultPlatform}})
+	if err != nil || len(expired) != 0 {
+		t.Errorf("Tags expired without creation dates: %v %v", expired, err)
+	}
+}
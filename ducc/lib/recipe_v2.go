@@ -0,0 +1,449 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	da "github.com/cvmfs/ducc/docker-api"
+)
+
+var (
+	recipeV1Keys      = []string{"version", "user", "cvmfs_repo", "output_format", "input", "platforms"}
+	recipeV2Keys      = []string{"version", "include", "defaults", "images"}
+	recipeOptionsKeys = []string{"user", "cvmfs_repo", "output_format", "platforms", "outputs", "tags", "retention"}
+)
+
+// RecipeError is a problem in a recipe, at the position of the faulty value
+type RecipeError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e RecipeError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// RecipeErrors are all the problems of a recipe
+type RecipeErrors []RecipeError
+
+func (e RecipeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// RecipeEntry is an image of a recipe, with the options of its conversion
+type RecipeEntry struct {
+	Input        Image
+	User         string
+	CVMFSRepo    string
+	OutputFormat string
+	Options      WishOptions
+}
+
+// ParseYamlRecipeV2 parses a v2 recipe, read from path. All the problems of
+// the recipe are returned as RecipeErrors.
+//
+// The v2 recipes set the options of the conversion for each image, on top of
+// the defaults of the recipe, and include other recipes:
+//
+//	version: 2
+//	include:
+//	  - common.yaml
+//	defaults:
+//	  user: cvmfsunpacker
+//	  cvmfs_repo: unpacked.cern.ch
+//	  output_format: '$(scheme)://registry.example.org/unpacked/$(image)'
+//	  platforms: [linux/amd64, linux/arm64]
+//	  outputs: [layers, flat, podman, thin]
+//	images:
+//	  - https://registry.hub.docker.com/library/centos:centos7
+//	  - image: https://registry.hub.docker.com/atlas/athena:22.0.*
+//	    cvmfs_repo: atlas.cern.ch
+//	    outputs: [flat]
+//	    tags:
+//	      exclude: ['*-rc*']
+//	    retention:
+//	      max_tags: 5
+//
+// An option set for an image replaces the default one. The included recipes
+// start from the defaults of the including one. A recipe included several
+// times, for example by two included recipes, is only read the first time.
+func ParseYamlRecipeV2(path string, data []byte) (Recipe, error) {
+	entries, errs := loadRecipe(path, data)
+	if len(errs) > 0 {
+		return makeRecipe(nil), errs
+	}
+	return makeRecipe(entries), nil
+}
+
+// ValidateRecipe returns all the problems of a recipe, of any version. The v1
+// recipes are checked like the v2 ones, with their unknown fields and invalid
+// images reported, while ParseRecipe reads them with ParseYamlRecipeV1, which
+// ignores the unknown fields and only logs the invalid images, so that the
+// existing v1 recipes keep converting.
+func ValidateRecipe(path string, data []byte) RecipeErrors {
+	_, errs := loadRecipe(path, data)
+	return errs
+}
+
+type recipeParser struct {
+	errors  RecipeErrors
+	entries []RecipeEntry
+	// including are the recipes being parsed, to detect the include cycles
+	including map[string]bool
+	// included are all the recipes parsed, each is included only once
+	included map[string]bool
+}
+
+func loadRecipe(path string, data []byte) ([]RecipeEntry, RecipeErrors) {
+	p := recipeParser{including: make(map[string]bool), included: make(map[string]bool)}
+	defaults := RecipeEntry{Options: WishOptions{Outputs: AllOutputs}}
+	p.parseRecipe(path, data, defaults, true)
+	return p.entries, p.errors
+}
+
+func (p *recipeParser) errorf(file string, node *yaml.Node, format string, args ...interface{}) {
+	err := RecipeError{File: file, Msg: fmt.Sprintf(format, args...)}
+	if node != nil {
+		err.Line = node.Line
+		err.Column = node.Column
+	}
+	p.errors = append(p.errors, err)
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+func (p *recipeParser) syntaxError(file string, err error) {
+	recipeErr := RecipeError{File: file, Msg: err.Error()}
+	if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		recipeErr.Line, _ = strconv.Atoi(match[1])
+		recipeErr.Msg = match[2]
+	}
+	p.errors = append(p.errors, recipeErr)
+}
+
+// parseRecipe parses a recipe file, the top level one can be a v1 recipe
+func (p *recipeParser) parseRecipe(path string, data []byte, defaults RecipeEntry, topLevel bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	p.including[abs] = true
+	p.included[abs] = true
+	defer delete(p.including, abs)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		p.syntaxError(path, err)
+		return
+	}
+	if len(doc.Content) == 0 {
+		p.errorf(path, nil, "empty recipe")
+		return
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		p.errorf(path, root, "the recipe must be a mapping")
+		return
+	}
+
+	version := 1
+	if node := mappingValue(root, "version"); node != nil {
+		if err := node.Decode(&version); err != nil || (version != 1 && version != 2) {
+			p.errorf(path, node, "unsupported recipe version %s, expected 1 or 2", node.Value)
+			return
+		}
+	}
+	if !topLevel && version != 2 {
+		p.errorf(path, root, "only v2 recipes can be included")
+		return
+	}
+	if version == 1 {
+		p.parseV1(path, root, defaults)
+		return
+	}
+
+	fields := p.fields(path, root, recipeV2Keys)
+	if node := fields["defaults"]; node != nil {
+		defaults, _ = p.parseOptions(path, node, defaults, recipeOptionsKeys)
+	}
+	if node := fields["include"]; node != nil {
+		for _, item := range p.sequence(path, node, "include") {
+			include, ok := p.scalar(path, item, "include")
+			if !ok {
+				continue
+			}
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(path), include)
+			}
+			p.include(path, item, include, defaults)
+		}
+	}
+	if node := fields["images"]; node != nil {
+		for _, item := range p.sequence(path, node, "images") {
+			p.parseImage(path, item, defaults)
+		}
+	}
+}
+
+func (p *recipeParser) include(path string, node *yaml.Node, include string, defaults RecipeEntry) {
+	abs, err := filepath.Abs(include)
+	if err != nil {
+		abs = include
+	}
+	if p.including[abs] {
+		p.errorf(path, node, "include cycle, %s is already being included", include)
+		return
+	}
+	if p.included[abs] {
+		return
+	}
+	data, err := ioutil.ReadFile(include)
+	if err != nil {
+		p.errorf(path, node, "impossible to read the included recipe: %s", err)
+		return
+	}
+	p.parseRecipe(include, data, defaults, false)
+}
+
+// parseV1 parses a v1 recipe, where the options are the same for all the
+// images
+func (p *recipeParser) parseV1(path string, root *yaml.Node, defaults RecipeEntry) {
+	defaults, fields := p.parseOptions(path, root, defaults, recipeV1Keys)
+	if node := fields["input"]; node != nil {
+		for _, item := range p.sequence(path, node, "input") {
+			if item.Kind != yaml.ScalarNode {
+				p.errorf(path, item, "the input must be a list of images")
+				continue
+			}
+			p.parseImage(path, item, defaults)
+		}
+	}
+}
+
+// parseImage parses an image, either just its name or a mapping with the
+// image and its options
+func (p *recipeParser) parseImage(path string, node *yaml.Node, defaults RecipeEntry) {
+	entry := defaults
+	imageNode := node
+	var fields map[string]*yaml.Node
+	switch node.Kind {
+	case yaml.ScalarNode:
+	case yaml.MappingNode:
+		entry, fields = p.parseOptions(path, node, defaults, append([]string{"image"}, recipeOptionsKeys...))
+		imageNode = fields["image"]
+		if imageNode == nil {
+			p.errorf(path, node, "missing image")
+			return
+		}
+	default:
+		p.errorf(path, node, "an image must be either a name or a mapping")
+		return
+	}
+
+	name, ok := p.scalar(path, imageNode, "image")
+	if !ok {
+		return
+	}
+	input, err := ParseImage(name)
+	if err != nil {
+		p.errorf(path, imageNode, "%s", err)
+		return
+	}
+	entry.Input = input
+	if entry.CVMFSRepo == "" {
+		p.errorf(path, imageNode, "no cvmfs_repo for the image %s", name)
+		return
+	}
+	if !input.TagWildcard {
+		for _, key := range []string{"tags", "retention"} {
+			if n := fields[key]; n != nil {
+				p.errorf(path, n, "%s only apply to images with a wildcard tag", key)
+			}
+		}
+	}
+	p.entries = append(p.entries, entry)
+}
+
+// parseOptions returns the options of a mapping on top of the defaults, and
+// the values of all the fields of the mapping
+func (p *recipeParser) parseOptions(path string, node *yaml.Node, defaults RecipeEntry, keys []string) (RecipeEntry, map[string]*yaml.Node) {
+	entry := defaults
+	fields := p.fields(path, node, keys)
+	if n := fields["user"]; n != nil {
+		entry.User, _ = p.scalar(path, n, "user")
+	}
+	if n := fields["cvmfs_repo"]; n != nil {
+		entry.CVMFSRepo, _ = p.scalar(path, n, "cvmfs_repo")
+	}
+	if n := fields["output_format"]; n != nil {
+		entry.OutputFormat, _ = p.scalar(path, n, "output_format")
+	}
+	if n := fields["platforms"]; n != nil {
+		entry.Options.Platforms = p.parsePlatforms(path, n)
+	}
+	if n := fields["outputs"]; n != nil {
+		entry.Options.Outputs = p.parseOutputs(path, n)
+	}
+	if n := fields["tags"]; n != nil {
+		entry.Options.Tags = p.parseTagFilter(path, n)
+	}
+	if n := fields["retention"]; n != nil {
+		entry.Options.Retention = p.parseRetention(path, n)
+	}
+	return entry, fields
+}
+
+func (p *recipeParser) parsePlatforms(path string, node *yaml.Node) []da.Platform {
+	platforms := []da.Platform{}
+	for _, item := range p.sequence(path, node, "platforms") {
+		value, ok := p.scalar(path, item, "platform")
+		if !ok {
+			continue
+		}
+		platform, err := ParsePlatform(value)
+		if err != nil {
+			p.errorf(path, item, "%s", err)
+			continue
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms
+}
+
+func (p *recipeParser) parseOutputs(path string, node *yaml.Node) WishOutputs {
+	var outputs WishOutputs
+	items := p.sequence(path, node, "outputs")
+	for _, item := range items {
+		value, ok := p.scalar(path, item, "output")
+		if !ok {
+			continue
+		}
+		switch value {
+		case "layers":
+			outputs.Layers = true
+		case "flat":
+			outputs.Flat = true
+		case "podman":
+			outputs.Podman = true
+		case "thin":
+			outputs.Thin = true
+		default:
+			p.errorf(path, item, "unknown output %s, expected layers, flat, podman or thin", value)
+		}
+	}
+	if node.Kind == yaml.SequenceNode && len(items) == 0 {
+		p.errorf(path, node, "no outputs")
+	}
+	if (outputs.Podman || outputs.Thin) && !outputs.Layers {
+		p.errorf(path, node, "the podman and thin outputs need the layers output")
+	}
+	return outputs
+}
+
+func (p *recipeParser) parseTagFilter(path string, node *yaml.Node) TagFilter {
+	var filter TagFilter
+	fields := p.fields(path, node, []string{"include", "exclude"})
+	patterns := func(key string) []string {
+		var result []string
+		n := fields[key]
+		if n == nil {
+			return nil
+		}
+		for _, item := range p.sequence(path, n, key) {
+			pattern, ok := p.scalar(path, item, "tag pattern")
+			if !ok {
+				continue
+			}
+			if _, err := filterUsingGlob(pattern, nil); err != nil {
+				p.errorf(path, item, "invalid tag pattern %s: %s", pattern, err)
+				continue
+			}
+			result = append(result, pattern)
+		}
+		return result
+	}
+	filter.Include = patterns("include")
+	filter.Exclude = patterns("exclude")
+	return filter
+}
+
+func (p *recipeParser) parseRetention(path string, node *yaml.Node) Retention {
+	var retention Retention
+	fields := p.fields(path, node, []string{"max_tags"})
+	n := fields["max_tags"]
+	if n == nil {
+		if node.Kind == yaml.MappingNode {
+			p.errorf(path, node, "missing max_tags")
+		}
+		return retention
+	}
+	if err := n.Decode(&retention.MaxTags); err != nil || retention.MaxTags <= 0 {
+		p.errorf(path, n, "max_tags must be a positive integer")
+		retention.MaxTags = 0
+	}
+	return retention
+}
+
+// fields returns the values of the fields of a mapping, reporting the
+// unknown ones
+func (p *recipeParser) fields(path string, node *yaml.Node, keys []string) map[string]*yaml.Node {
+	fields := make(map[string]*yaml.Node)
+	if node.Kind != yaml.MappingNode {
+		p.errorf(path, node, "expected a mapping")
+		return fields
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		known := false
+		for _, k := range keys {
+			known = known || k == key.Value
+		}
+		if !known {
+			p.errorf(path, key, "unknown field %s", key.Value)
+			continue
+		}
+		fields[key.Value] = value
+	}
+	return fields
+}
+
+func (p *recipeParser) sequence(path string, node *yaml.Node, what string) []*yaml.Node {
+	if node.Kind != yaml.SequenceNode {
+		p.errorf(path, node, "%s must be a list", what)
+		return nil
+	}
+	return node.Content
+}
+
+func (p *recipeParser) scalar(path string, node *yaml.Node, what string) (string, bool) {
+	if node.Kind != yaml.ScalarNode {
+		p.errorf(path, node, "%s must be a string", what)
+		return "", false
+	}
+	return node.Value, true
+}
+
+// mappingValue returns the value of a key of a mapping, nil if missing
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 
+	da "github.com/cvmfs/ducc/docker-api"
 	l "github.com/cvmfs/ducc/log"
 	log "github.com/sirupsen/logrus"
 )
@@ -27,9 +28,93 @@ type WishFriendly struct {
 	OutputImage            *Image
 	ExpandedTagImagesLayer []*Image
 	ExpandedTagImagesFlat  []*Image
+	Options                WishOptions
+	// ExpiredTags are the tags of the wildcard left out by the retention,
+	// their flat images are unpublished
+	ExpiredTags []string
 }
 
-func CreateWish(inputImg Image, outputImage, cvmfsRepo, userInput, userOutput string) (wish WishFriendly, err error) {
+// WishOptions are the options of the conversion of a wish
+type WishOptions struct {
+	// Platforms are the platforms to convert from the manifest lists, only
+	// amd64 if empty
+	Platforms []da.Platform
+	Outputs   WishOutputs
+	Tags      TagFilter
+	Retention Retention
+}
+
+// WishOutputs are the formats the images of a wish are converted into
+type WishOutputs struct {
+	Layers bool
+	Flat   bool
+	Podman bool
+	Thin   bool
+}
+
+// AllOutputs converts the images into all the formats
+var AllOutputs = WishOutputs{Layers: true, Flat: true, Podman: true, Thin: true}
+
+// TagFilter selects, among the tags matching the wildcard of an image, the
+// ones to convert. Both lists are glob patterns, an empty include list selects
+// all the tags.
+type TagFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Filter returns the tags selected by the filter
+func (f TagFilter) Filter(tags []string) ([]string, error) {
+	if len(f.Include) > 0 {
+		included := make(map[string]bool)
+		for _, pattern := range f.Include {
+			matching, err := filterUsingGlob(pattern, tags)
+			if err != nil {
+				return nil, err
+			}
+			for _, tag := range matching {
+				included[tag] = true
+			}
+		}
+		var selected []string
+		for _, tag := range tags {
+			if included[tag] {
+				selected = append(selected, tag)
+			}
+		}
+		tags = selected
+	}
+	for _, pattern := range f.Exclude {
+		excluded, err := filterUsingGlob(pattern, tags)
+		if err != nil {
+			return nil, err
+		}
+		excludedMap := make(map[string]bool)
+		for _, tag := range excluded {
+			excludedMap[tag] = true
+		}
+		var selected []string
+		for _, tag := range tags {
+			if !excludedMap[tag] {
+				selected = append(selected, tag)
+			}
+		}
+		tags = selected
+	}
+	return tags, nil
+}
+
+// Retention limits the tags of a wildcard kept in the repository
+type Retention struct {
+	// MaxTags is the number of most recently created tags to convert, zero
+	// to convert all of them
+	MaxTags int
+}
+
+// CreateWish prepares the conversion of the input image, for each of the
+// platforms of the options. Without platforms, only the amd64 image is
+// converted.
+func CreateWish(inputImg Image, outputImage, cvmfsRepo, userInput, userOutput string, options WishOptions) (wish WishFriendly, err error) {
 
 	inputImg.User = userInput
 
@@ -48,6 +133,7 @@ func CreateWish(inputImg Image, outputImage, cvmfsRepo, userInput, userOutput st
 	wish.CvmfsRepo = cvmfsRepo
 	wish.UserInput = userInput
 	wish.UserOutput = userOutput
+	wish.Options = options
 
 	iImage, errI := ParseImage(wish.InputName)
 	wish.InputImage = &iImage
@@ -57,7 +143,7 @@ func CreateWish(inputImg Image, outputImage, cvmfsRepo, userInput, userOutput st
 		err = errI
 		return
 	}
-	r1, r2, errEx := iImage.ExpandWildcard()
+	r1, r2, expired, errEx := iImage.ExpandWildcard(options)
 	if errEx != nil {
 		err = errEx
 		l.LogE(err).WithFields(log.Fields{
@@ -85,6 +171,7 @@ func CreateWish(inputImg Image, outputImage, cvmfsRepo, userInput, userOutput st
 
 	wish.ExpandedTagImagesLayer = expandedTagImagesLayer
 	wish.ExpandedTagImagesFlat = expandedTagImagesFlat
+	wish.ExpiredTags = expired
 
 	oImage, errO := ParseImage(wish.OutputName)
 	wish.OutputImage = &oImage
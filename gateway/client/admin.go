@@ -0,0 +1,291 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Repository is the access configuration of a repository
+type Repository struct {
+	// Keys maps the IDs of the keys registered for the repository to the
+	// subpath where they are valid
+	Keys    map[string]string `json:"keys"`
+	Enabled bool              `json:"enabled"`
+	// Limits are only set for the repositories registered through the API
+	Limits *RepositoryLimits `json:"limits,omitempty"`
+	// Replication is only set for the repositories with tracked replicas
+	Replication *Replication `json:"replication,omitempty"`
+}
+
+// Replication is the replication state of a repository on its stratum 1
+// replicas
+type Replication struct {
+	// Revision is the latest revision of the repository, on the stratum 0
+	Revision uint64    `json:"revision"`
+	Replicas []Replica `json:"replicas"`
+}
+
+// Replica is the state of a stratum 1 replica of a repository
+type Replica struct {
+	URL             string `json:"url"`
+	Revision        uint64 `json:"revision"`
+	RevisionsBehind uint64 `json:"revisions_behind"`
+	// Lag is the publication delay of the replica in seconds
+	Lag       float64 `json:"lag"`
+	LastCheck string  `json:"last_check,omitempty"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+// RepositoryLimits are the limits of a registered repository. Zero values
+// mean the gateway defaults apply.
+type RepositoryLimits struct {
+	// MaxLeaseTime is the maximum lease duration in seconds
+	MaxLeaseTime int64 `json:"max_lease_time,omitempty"`
+	// MaxLeases is the maximum number of concurrent leases
+	MaxLeases int `json:"max_leases,omitempty"`
+}
+
+// RepoRegistration describes a repository registered through the API
+type RepoRegistration struct {
+	Name string `json:"name"`
+	// Keys maps the IDs of the keys allowed to publish to the repository to
+	// the subpath where they are valid
+	Keys map[string]string `json:"keys"`
+	RepositoryLimits
+}
+
+// ReceiverStatistics are the task counters of the receiver pool of the gateway
+type ReceiverStatistics struct {
+	Workers     int    `json:"workers"`
+	Busy        int    `json:"busy"`
+	Tasks       uint64 `json:"tasks"`
+	Failed      uint64 `json:"failed"`
+	TimedOut    uint64 `json:"timed_out"`
+	OutOfMemory uint64 `json:"out_of_memory"`
+}
+
+// PublicationStatistics are the publication counters of a repository, summed
+// over the commits of a key during a day (UTC)
+type PublicationStatistics struct {
+	Day                  string `json:"day"`
+	KeyID                string `json:"key_id"`
+	Commits              int64  `json:"commits"`
+	ChunksAdded          int64  `json:"n_chunks_added"`
+	ChunksDuplicated     int64  `json:"n_chunks_duplicated"`
+	CatalogsAdded        int64  `json:"n_catalogs_added"`
+	UploadedBytes        int64  `json:"sz_uploaded_bytes"`
+	UploadedCatalogBytes int64  `json:"sz_uploaded_catalog_bytes"`
+	CommitDurationMs     int64  `json:"commit_duration_ms"`
+	MaxCommitDurationMs  int64  `json:"max_commit_duration_ms"`
+}
+
+// GCOptions are the options of a garbage collection run
+type GCOptions struct {
+	Repository   string    `json:"repo"`
+	NumRevisions int       `json:"num_revisions,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	DryRun       bool      `json:"dry_run,omitempty"`
+	Verbose      bool      `json:"verbose,omitempty"`
+}
+
+// GetRepos returns the repositories served by the gateway
+func (c *Client) GetRepos(ctx context.Context) (map[string]Repository, error) {
+	var reply struct {
+		Data map[string]Repository `json:"data"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/repos"}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// GetReceiverStatistics returns the utilization of the receiver pool and the
+// number of failed and killed tasks
+func (c *Client) GetReceiverStatistics(ctx context.Context) (*ReceiverStatistics, error) {
+	var reply struct {
+		Data ReceiverStatistics `json:"data"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/receivers"}, &reply); err != nil {
+		return nil, err
+	}
+	return &reply.Data, nil
+}
+
+// GetRepo returns the access configuration of a repository
+func (c *Client) GetRepo(ctx context.Context, name string) (*Repository, error) {
+	var reply struct {
+		Data Repository `json:"data"`
+	}
+	r := request{method: http.MethodGet, path: "/repos/" + escapePath(name)}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return &reply.Data, nil
+}
+
+// GetRepoStatistics returns the publication statistics of a repository per key
+// and day, between the from and to days (YYYY-MM-DD, ignored if empty)
+func (c *Client) GetRepoStatistics(ctx context.Context, name, from, to string) ([]PublicationStatistics, error) {
+	var reply struct {
+		Data []PublicationStatistics `json:"data"`
+	}
+	query := url.Values{}
+	if from != "" {
+		query.Set("from", from)
+	}
+	if to != "" {
+		query.Set("to", to)
+	}
+	r := request{method: http.MethodGet, path: "/repos/" + escapePath(name) + "/stats", query: query}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// SetRepoEnabled enables or disables a repository (requires an admin key)
+func (c *Client) SetRepoEnabled(ctx context.Context, name string, enable bool) error {
+	msg := map[string]bool{"enable": enable}
+	r, err := jsonRequest(http.MethodPost, "/repos/"+escapePath(name), msg, true)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, r, nil)
+}
+
+// RegisterRepo registers a repository served by the stratum 0 of the gateway
+// (requires an admin key)
+func (c *Client) RegisterRepo(ctx context.Context, reg RepoRegistration) error {
+	r, err := jsonRequest(http.MethodPost, "/repos", reg, true)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, r, nil)
+}
+
+// DeregisterRepo removes a repository registered through the API. Repositories
+// with active leases are not removed (requires an admin key).
+func (c *Client) DeregisterRepo(ctx context.Context, name string) error {
+	path := "/repos/" + name
+	r := request{method: http.MethodDelete, path: path, hmacInput: []byte(APIRoot + path)}
+	return c.call(ctx, r, nil)
+}
+
+// CancelLeasesByPath cancels all the leases below a repository path
+// ("<REPO_NAME>/<SUBPATH>", requires an admin key)
+func (c *Client) CancelLeasesByPath(ctx context.Context, repoPath string) error {
+	repoPath = strings.TrimPrefix(repoPath, "/")
+	path := "/leases-by-path/" + repoPath
+	r := request{
+		method:    http.MethodDelete,
+		path:      "/leases-by-path/" + escapePath(repoPath),
+		hmacInput: []byte(APIRoot + path),
+	}
+	return c.call(ctx, r, nil)
+}
+
+// HookRun is the record of a commit hook run
+type HookRun struct {
+	Hook      string `json:"hook"`
+	Stage     string `json:"stage"`
+	LeasePath string `json:"lease_path"`
+	Revision  uint64 `json:"revision,omitempty"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	Output    string `json:"output,omitempty"`
+	Started   string `json:"started"`
+	Finished  string `json:"finished,omitempty"`
+}
+
+// GetHookRuns returns the recent commit hook runs of a repository, most
+// recent first (requires an admin key)
+func (c *Client) GetHookRuns(ctx context.Context, name string) ([]HookRun, error) {
+	path := "/repos/" + name + "/hooks"
+	r := request{method: http.MethodGet, path: path, hmacInput: []byte(APIRoot + path)}
+	var reply struct {
+		Data []HookRun `json:"data"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// RunGC runs garbage collection on a repository and returns the output of the
+// run (requires an admin key)
+func (c *Client) RunGC(ctx context.Context, options GCOptions) (string, error) {
+	r, err := jsonRequest(http.MethodPost, "/gc", options, true)
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		Output string `json:"output"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return "", err
+	}
+	return reply.Output, nil
+}
+
+// Config is the effective configuration of the gateway, with the secrets
+// redacted, and the problems found in its configuration files
+type Config struct {
+	// Gateway is the configuration of the service, keyed like the user
+	// configuration file
+	Gateway      map[string]interface{} `json:"gateway"`
+	Repositories map[string]Repository  `json:"repositories"`
+	Keys         map[string]ConfigKey   `json:"keys"`
+	Problems     []string               `json:"problems,omitempty"`
+}
+
+// ConfigKey is a key of the repository access configuration
+type ConfigKey struct {
+	Secret string `json:"secret"`
+	Admin  bool   `json:"admin"`
+}
+
+// GetConfig returns the effective configuration of the gateway and the
+// problems found in its configuration files (requires an admin key)
+func (c *Client) GetConfig(ctx context.Context) (*Config, error) {
+	r := request{method: http.MethodGet, path: "/config", hmacInput: []byte(APIRoot + "/config")}
+	var reply struct {
+		Data Config `json:"data"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return &reply.Data, nil
+}
+
+// Relay is the state of the subscription of the gateway to the notifications
+// of a repository on an upstream gateway
+type Relay struct {
+	Upstream   string `json:"upstream"`
+	Repository string `json:"repository"`
+	Connected  bool   `json:"connected"`
+	// Received is the number of notifications received from the upstream
+	Received uint64 `json:"received"`
+	// Dropped is the number of received notifications which were not
+	// re-published
+	Dropped     uint64 `json:"dropped"`
+	Reconnects  int    `json:"reconnects"`
+	LastMessage string `json:"last_message,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	NextAttempt string `json:"next_attempt,omitempty"`
+}
+
+// GetRelays returns the state of the subscriptions to the upstream gateways
+// relayed by the gateway (requires an admin key)
+func (c *Client) GetRelays(ctx context.Context) ([]Relay, error) {
+	r := request{method: http.MethodGet, path: "/relays", hmacInput: []byte(APIRoot + "/relays")}
+	var reply struct {
+		Data []Relay `json:"data"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
@@ -0,0 +1,239 @@
+// Package client implements a client of the CernVM-FS repository gateway HTTP
+// API, including the HMAC request signing scheme used by the gateway.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// APIProtocolVersion is the gateway API protocol version spoken by the client
+	APIProtocolVersion = 4
+	// APIRoot is the path of the gateway API root
+	APIRoot = "/api/v1"
+	// APIVersionHeader is the request header announcing the client protocol version
+	APIVersionHeader = "X-Gateway-API-Version"
+	// RequestIDHeader is the reply header carrying the ID of the request
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Client of the repository gateway API. Requests are signed with the KeyID
+// and Secret; read-only requests can be made with an empty key.
+type Client struct {
+	// BaseURL is the gateway URL, e.g. "http://gateway.example.org:4929"
+	BaseURL string
+	KeyID   string
+	Secret  string
+	// HTTP is the client used for requests (http.DefaultClient if nil)
+	HTTP *http.Client
+	// PayloadEncoding compresses the payloads uploaded by SubmitPayload ("gzip"
+	// or "zstd"), which are sent uncompressed if empty. The legacy endpoint
+	// does not accept compressed payloads, since the HMAC is computed over the
+	// uncompressed request message.
+	PayloadEncoding string
+}
+
+// New creates a client for the gateway at baseURL. A base URL which already
+// includes the API root ("/api/v1") is accepted.
+func New(baseURL, keyID, secret string) *Client {
+	baseURL = strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), APIRoot)
+	return &Client{BaseURL: baseURL, KeyID: keyID, Secret: secret}
+}
+
+// NewUnix creates a client for a gateway listening on the Unix domain socket
+// at socketPath
+func NewUnix(socketPath, keyID, secret string) *Client {
+	var dialer net.Dialer
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	c := New("http://localhost", keyID, secret)
+	c.HTTP = &http.Client{Transport: transport}
+	return c
+}
+
+// Error is returned when the gateway replies with an error
+type Error struct {
+	// StatusCode is the HTTP status code of the reply
+	StatusCode int
+	// Status is the "status" field of the reply ("error", "path_busy", ...)
+	Status string
+	// Code is the machine-readable error code (invalid_lease, path_busy, ...)
+	Code string
+	// Reason is the human-readable explanation of the error
+	Reason string
+	// Fields contains the complete reply
+	Fields map[string]interface{}
+	// RequestID is the ID of the request in the logs and traces of the
+	// gateway, if it was returned
+	RequestID string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("gateway error (%v): %v", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("gateway error (%v): %v", e.Status, e.Reason)
+}
+
+// IsCode returns true if err is a gateway error with the given code
+func IsCode(err error, code string) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Code == code
+	}
+	return false
+}
+
+// ComputeHMAC of a message, using the same algorithm as the gateway
+// (hex-encoded HMAC-SHA1)
+func ComputeHMAC(message []byte, secret string) []byte {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(message)
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Authorization returns the value of the Authorization header for the given
+// HMAC input
+func (c *Client) Authorization(hmacInput []byte) string {
+	return c.KeyID + " " + base64.StdEncoding.EncodeToString(ComputeHMAC(hmacInput, c.Secret))
+}
+
+type traceparentKey struct{}
+
+// WithTraceparent returns a context whose requests carry a W3C traceparent
+// header, so that the spans of the gateway are part of the trace of the caller
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, traceparent)
+}
+
+// request is a prepared API request
+type request struct {
+	method string
+	path   string // relative to APIRoot, already escaped
+	query  url.Values
+	body   io.Reader
+	size   int64
+	header http.Header
+	// hmacInput is signed when not nil
+	hmacInput []byte
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
+
+// do sends a request and returns the raw HTTP response
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	u := c.BaseURL + APIRoot + r.path
+	if len(r.query) > 0 {
+		u += "?" + r.query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, u, r.body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	if r.size > 0 {
+		req.ContentLength = r.size
+	}
+	for k, vs := range r.header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set(APIVersionHeader, strconv.Itoa(APIProtocolVersion))
+	if r.hmacInput != nil {
+		req.Header.Set("Authorization", c.Authorization(r.hmacInput))
+	}
+	if traceparent, ok := ctx.Value(traceparentKey{}).(string); ok {
+		req.Header.Set("traceparent", traceparent)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// call sends a request and decodes the JSON reply into out (if not nil).
+// Replies with a status other than "ok" are returned as *Error.
+func (c *Client) call(ctx context.Context, r request, out interface{}) error {
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read reply: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return &Error{
+			StatusCode: resp.StatusCode,
+			Status:     "error",
+			Reason:     strings.TrimSpace(string(body)),
+			RequestID:  resp.Header.Get(RequestIDHeader),
+		}
+	}
+
+	status, _ := fields["status"].(string)
+	if resp.StatusCode != http.StatusOK || (status != "" && status != "ok") {
+		e := &Error{
+			StatusCode: resp.StatusCode, Status: status, Fields: fields,
+			RequestID: resp.Header.Get(RequestIDHeader)}
+		e.Code, _ = fields["code"].(string)
+		e.Reason, _ = fields["reason"].(string)
+		return e
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(body, out); err != nil {
+			return fmt.Errorf("could not decode reply: %w", err)
+		}
+	}
+	return nil
+}
+
+// jsonRequest prepares a request with a JSON body, which is also used as HMAC
+// input if sign is true
+func jsonRequest(method, path string, msg interface{}, sign bool) (request, error) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return request{}, fmt.Errorf("could not encode request: %w", err)
+	}
+	r := request{method: method, path: path, body: bytes.NewReader(buf), size: int64(len(buf))}
+	if sign {
+		r.hmacInput = buf
+	}
+	return r, nil
+}
+
+// escapePath escapes each component of a slash-separated path
+func escapePath(p string) string {
+	tokens := strings.Split(p, "/")
+	for i, t := range tokens {
+		tokens[i] = url.PathEscape(t)
+	}
+	return strings.Join(tokens, "/")
+}
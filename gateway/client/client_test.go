@@ -0,0 +1,380 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	fe "github.com/cvmfs/gateway/internal/gateway/frontend"
+)
+
+// startGateway runs the frontend with a backend using the mock receiver. The
+// configuration of the backend can be modified before it starts relaying
+// notifications.
+func startGateway(t *testing.T, configure ...func(cfg *gw.Config)) (string, func()) {
+	services, tmp := be.StartTestBackend("client_test", be.TestMaxLeaseTime)
+	ns, err := be.NewNotificationSystem(tmp)
+	if err != nil {
+		t.Fatalf("could not start notification system: %v", err)
+	}
+	services.Notifications = ns
+	for _, f := range configure {
+		f(&services.Config)
+	}
+	services.Relays = services.StartRelays()
+
+	srv := httptest.NewServer(fe.NewFrontend(services, 0, 10*time.Second).Handler)
+	return srv.URL, func() {
+		srv.Close()
+		services.Stop()
+		os.RemoveAll(tmp)
+	}
+}
+
+func TestComputeHMACMatchesGateway(t *testing.T) {
+	msg := []byte("some message")
+	if !bytes.Equal(ComputeHMAC(msg, "secret"), fe.ComputeHMAC(msg, "secret")) {
+		t.Errorf("HMAC differs from the one computed by the gateway")
+	}
+}
+
+func TestClientLeaseLifecycle(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	ctx := context.Background()
+	c := New(url+APIRoot, "keyid2", "secret2")
+	leasePath := "test2.repo.org/restricted/to/subdir"
+
+	lease, err := c.NewLease(ctx, leasePath, "publisher.example.org")
+	if err != nil {
+		t.Fatalf("could not obtain lease: %v", err)
+	}
+	if lease.MaxAPIVersion < APIProtocolVersion {
+		t.Errorf("unexpected max API version: %v", lease.MaxAPIVersion)
+	}
+
+	_, err = c.NewLease(WithTraceparent(ctx, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"), leasePath, "")
+	if !IsCode(err, "path_busy") {
+		t.Errorf("expected path_busy error, got: %v", err)
+	} else if err.(*Error).RequestID == "" {
+		t.Errorf("missing request ID in error: %+v", err)
+	}
+
+	info, err := c.GetLease(ctx, lease.Token)
+	if err != nil {
+		t.Fatalf("could not query lease: %v", err)
+	}
+	if info.LeasePath != leasePath || info.Hostname != "publisher.example.org" || info.Quota == nil {
+		t.Errorf("unexpected lease: %+v", info)
+	}
+
+	payload := []byte("object pack")
+	if err := c.SubmitPayload(ctx, lease.Token, "abcdef", 3, bytes.NewReader(payload), int64(len(payload))); err != nil {
+		t.Fatalf("could not submit payload: %v", err)
+	}
+	if err := c.SubmitPayloadLegacy(ctx, lease.Token, "fedcba", 3, strings.NewReader("another pack"), -1); err != nil {
+		t.Fatalf("could not submit legacy payload: %v", err)
+	}
+	payloads, err := c.GetLeasePayloads(ctx, lease.Token)
+	if err != nil {
+		t.Fatalf("could not list payloads: %v", err)
+	}
+	if len(payloads) != 2 || payloads[0].Size != int64(len(payload)) {
+		t.Errorf("unexpected payloads: %+v", payloads)
+	}
+
+	rev, err := c.CommitLease(ctx, lease.Token, "old_hash", "new_hash", Tag{Name: "tag"})
+	if err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+	if rev != 1 {
+		t.Errorf("unexpected final revision: %v", rev)
+	}
+
+	if _, err := c.GetLease(ctx, lease.Token); err == nil {
+		t.Errorf("lease should have been released by the commit")
+	}
+	if err := c.CancelLease(ctx, lease.Token); !IsCode(err, "invalid_lease") {
+		t.Errorf("expected invalid_lease error, got: %v", err)
+	}
+
+	pub, err := c.GetRepoStatistics(ctx, "test2.repo.org", "", "")
+	if err != nil {
+		t.Fatalf("could not query publication statistics: %v", err)
+	}
+	if len(pub) != 1 || pub[0].KeyID != "keyid2" || pub[0].Commits != 1 || pub[0].ChunksAdded != 2 {
+		t.Errorf("unexpected publication statistics: %+v", pub)
+	}
+}
+
+func TestClientCompressedPayloads(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	ctx := context.Background()
+	c := New(url+APIRoot, "keyid2", "secret2")
+	lease, err := c.NewLease(ctx, "test2.repo.org/restricted/to/subdir", "")
+	if err != nil {
+		t.Fatalf("could not obtain lease: %v", err)
+	}
+	defer c.CancelLease(ctx, lease.Token)
+
+	payload := strings.Repeat("object pack ", 1000)
+	for _, encoding := range []string{"gzip", "zstd"} {
+		c.PayloadEncoding = encoding
+		if err := c.SubmitPayload(ctx, lease.Token, encoding, 3, strings.NewReader(payload), int64(len(payload))); err != nil {
+			t.Fatalf("could not submit %v payload: %v", encoding, err)
+		}
+	}
+	c.PayloadEncoding = "br"
+	if err := c.SubmitPayload(ctx, lease.Token, "br", 3, strings.NewReader(payload), -1); err == nil {
+		t.Errorf("unsupported encoding should have been rejected")
+	}
+
+	payloads, err := c.GetLeasePayloads(ctx, lease.Token)
+	if err != nil {
+		t.Fatalf("could not list payloads: %v", err)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("unexpected payloads: %+v", payloads)
+	}
+	for _, p := range payloads {
+		if p.Size != int64(len(payload)) || p.Encoding != p.Digest || p.TransferredSize >= p.Size {
+			t.Errorf("unexpected payload: %+v", p)
+		}
+	}
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	c := New(url, "keyid2", "wrong_secret")
+	_, err := c.NewLease(context.Background(), "test2.repo.org/restricted/to/subdir", "")
+	if !IsCode(err, "unauthorized") {
+		t.Errorf("expected unauthorized error, got: %v", err)
+	}
+	if e, ok := err.(*Error); !ok || e.StatusCode != 403 {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestClientAdmin(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	ctx := context.Background()
+	admin := New(url, "admin0", "big_secret")
+	c := New(url, "keyid2", "secret2")
+	leasePath := "test2.repo.org/restricted/to/subdir"
+
+	if err := c.SetRepoEnabled(ctx, "test2.repo.org", false); !IsCode(err, "unauthorized") {
+		t.Errorf("non-admin key should not be able to disable a repository: %v", err)
+	}
+
+	if err := admin.SetRepoEnabled(ctx, "test2.repo.org", false); err != nil {
+		t.Fatalf("could not disable repository: %v", err)
+	}
+	repo, err := admin.GetRepo(ctx, "test2.repo.org")
+	if err != nil {
+		t.Fatalf("could not query repository: %v", err)
+	}
+	if repo.Enabled {
+		t.Errorf("repository should be disabled")
+	}
+	if _, err := c.NewLease(ctx, leasePath, ""); !IsCode(err, "repo_disabled") {
+		t.Errorf("expected repo_disabled error, got: %v", err)
+	}
+	if err := admin.SetRepoEnabled(ctx, "test2.repo.org", true); err != nil {
+		t.Fatalf("could not enable repository: %v", err)
+	}
+
+	if _, err := c.NewLease(ctx, leasePath, ""); err != nil {
+		t.Fatalf("could not obtain lease: %v", err)
+	}
+	if err := admin.CancelLeasesByPath(ctx, "test2.repo.org/restricted"); err != nil {
+		t.Fatalf("could not cancel leases: %v", err)
+	}
+	leases, err := c.GetLeases(ctx)
+	if err != nil {
+		t.Fatalf("could not list leases: %v", err)
+	}
+	if len(leases) != 0 {
+		t.Errorf("leases should have been cancelled: %v", leases)
+	}
+
+	st, err := c.GetReceiverStatistics(ctx)
+	if err != nil {
+		t.Fatalf("could not query receiver statistics: %v", err)
+	}
+	if st.Workers != 1 || st.Busy != 0 {
+		t.Errorf("invalid receiver statistics: %+v", st)
+	}
+}
+
+func TestClientKeys(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	ctx := context.Background()
+	admin := New(url, "admin0", "big_secret")
+
+	if _, err := New(url, "keyid2", "secret2").GetKeys(ctx); !IsCode(err, "unauthorized") {
+		t.Errorf("non-admin key should not be able to list keys: %v", err)
+	}
+
+	id, secret, err := admin.NewKey(ctx, NewKeyOptions{
+		Bindings: []KeyBinding{{Repository: "test1.repo.org", Path: "/"}},
+	})
+	if err != nil {
+		t.Fatalf("could not create key: %v", err)
+	}
+	c := New(url, id, secret)
+	if _, err := c.NewLease(ctx, "test1.repo.org/some/path", ""); err != nil {
+		t.Fatalf("new key should be usable immediately: %v", err)
+	}
+
+	newSecret, err := admin.RotateKey(ctx, id, 60)
+	if err != nil {
+		t.Fatalf("could not rotate key: %v", err)
+	}
+	if _, err := c.NewLease(ctx, "test1.repo.org/other/path", ""); err != nil {
+		t.Errorf("previous secret should be valid during the overlap: %v", err)
+	}
+
+	key, err := admin.GetKey(ctx, id)
+	if err != nil || key.Source != "api" || key.PreviousExpires == "" {
+		t.Errorf("unexpected key information: %+v %v", key, err)
+	}
+
+	if err := admin.RevokeKey(ctx, id); err != nil {
+		t.Fatalf("could not revoke key: %v", err)
+	}
+	if _, err := New(url, id, newSecret).NewLease(ctx, "test1.repo.org/third", ""); !IsCode(err, "unauthorized") {
+		t.Errorf("revoked key should be rejected: %v", err)
+	}
+	if _, err := admin.GetKey(ctx, "missing"); !IsCode(err, "not_found") {
+		t.Errorf("expected not_found error, got: %v", err)
+	}
+}
+
+func TestClientNotifications(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := New(url, "keyid2", "secret2")
+
+	manifest := []byte("Cabcdef\nNtest2.repo.org\nS5\nT1700000000\n")
+	if err := New(url, "admin0", "big_secret").PublishManifest(ctx, "test2.repo.org", manifest); !IsCode(err, "unauthorized") {
+		t.Errorf("key not bound to the repository should not be able to publish: %v", err)
+	}
+	if err := c.PublishManifest(ctx, "test2.repo.org", manifest); err != nil {
+		t.Fatalf("could not publish manifest: %v", err)
+	}
+
+	messages, err := c.Subscribe(ctx, "test2.repo.org")
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	select {
+	case m := <-messages:
+		var msg struct {
+			Revision uint64 `json:"revision"`
+			RootHash string `json:"root_hash"`
+			Manifest []byte `json:"manifest"`
+		}
+		if err := json.Unmarshal([]byte(m), &msg); err != nil {
+			t.Fatalf("invalid message: %v", err)
+		}
+		if msg.Revision != 5 || msg.RootHash != "abcdef" || !bytes.Equal(msg.Manifest, manifest) {
+			t.Errorf("unexpected message: %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no notification received")
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	url, stop := startGateway(t)
+	defer stop()
+
+	ctx := context.Background()
+	if _, err := New(url, "keyid2", "secret2").GetConfig(ctx); !IsCode(err, "unauthorized") {
+		t.Errorf("non-admin key should not be able to read the configuration: %v", err)
+	}
+
+	cfg, err := New(url, "admin0", "big_secret").GetConfig(ctx)
+	if err != nil {
+		t.Fatalf("could not read configuration: %v", err)
+	}
+	if cfg.Gateway["num_receivers"] != float64(1) {
+		t.Errorf("unexpected gateway configuration: %v", cfg.Gateway)
+	}
+	if _, present := cfg.Repositories["test2.repo.org"]; !present {
+		t.Errorf("unexpected repositories: %v", cfg.Repositories)
+	}
+	if key := cfg.Keys["admin0"]; key.Secret != "xxxxx" || !key.Admin {
+		t.Errorf("key secret was not redacted: %+v", key)
+	}
+	// The test gateway has no access configuration file
+	if len(cfg.Problems) != 1 {
+		t.Errorf("unexpected problems: %v", cfg.Problems)
+	}
+}
+
+func TestClientRelays(t *testing.T) {
+	upstreamURL, stopUpstream := startGateway(t)
+	defer stopUpstream()
+	url, stop := startGateway(t, func(cfg *gw.Config) {
+		cfg.GatewayID = "downstream"
+		cfg.Relays = []gw.RelayConfig{{URL: upstreamURL + APIRoot, Repositories: []string{"test2.repo.org"}}}
+	})
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := New(url, "", "").Subscribe(ctx, "test2.repo.org")
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+
+	// The notification is relayed even if the downstream gateway subscribes
+	// to the upstream after the publication
+	manifest := []byte("Cabcdef\nNtest2.repo.org\nS7\nT1700000000\n")
+	if err := New(upstreamURL, "keyid2", "secret2").PublishManifest(ctx, "test2.repo.org", manifest); err != nil {
+		t.Fatalf("could not publish manifest: %v", err)
+	}
+	select {
+	case m := <-messages:
+		var msg struct {
+			Revision uint64   `json:"revision"`
+			Via      []string `json:"via"`
+		}
+		if err := json.Unmarshal([]byte(m), &msg); err != nil {
+			t.Fatalf("invalid message: %v", err)
+		}
+		if msg.Revision != 7 || len(msg.Via) != 2 || msg.Via[1] != "downstream" {
+			t.Errorf("unexpected message: %v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("notification was not relayed")
+	}
+
+	relays, err := New(url, "admin0", "big_secret").GetRelays(ctx)
+	if err != nil {
+		t.Fatalf("could not query relays: %v", err)
+	}
+	if len(relays) != 1 || !relays[0].Connected || relays[0].Repository != "test2.repo.org" {
+		t.Errorf("unexpected relays: %+v", relays)
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// KeyBinding is a repository subpath where a key is valid
+type KeyBinding struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+}
+
+// Key is the information about a gateway key. Secrets are never returned,
+// except when a key is created or rotated.
+type Key struct {
+	ID string `json:"id"`
+	// Source is "config" for the keys of the access configuration file and
+	// "api" for the keys managed through the API
+	Source          string       `json:"source"`
+	Admin           bool         `json:"admin"`
+	Status          string       `json:"status"`
+	Created         string       `json:"created,omitempty"`
+	Rotated         string       `json:"rotated,omitempty"`
+	PreviousExpires string       `json:"previous_secret_expires,omitempty"`
+	Bindings        []KeyBinding `json:"bindings"`
+}
+
+// NewKeyOptions are the parameters of a new key. The ID is generated by the
+// gateway if empty.
+type NewKeyOptions struct {
+	ID       string       `json:"id,omitempty"`
+	Admin    bool         `json:"admin"`
+	Bindings []KeyBinding `json:"bindings"`
+}
+
+// signedGet prepares a GET request of an admin endpoint, signed with the path
+// of the URL
+func signedGet(path string) request {
+	return request{method: http.MethodGet, path: path, hmacInput: []byte(APIRoot + path)}
+}
+
+// GetKeys returns all the keys known to the gateway (requires an admin key)
+func (c *Client) GetKeys(ctx context.Context) ([]Key, error) {
+	var reply struct {
+		Data []Key `json:"data"`
+	}
+	if err := c.call(ctx, signedGet("/keys"), &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// GetKey returns the information about a key (requires an admin key)
+func (c *Client) GetKey(ctx context.Context, id string) (*Key, error) {
+	var reply struct {
+		Data Key `json:"data"`
+	}
+	if err := c.call(ctx, signedGet("/keys/"+id), &reply); err != nil {
+		return nil, err
+	}
+	return &reply.Data, nil
+}
+
+// NewKey creates a key and returns its ID and secret (requires an admin key)
+func (c *Client) NewKey(ctx context.Context, options NewKeyOptions) (string, string, error) {
+	r, err := jsonRequest(http.MethodPost, "/keys", options, true)
+	if err != nil {
+		return "", "", err
+	}
+	var reply struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return "", "", err
+	}
+	return reply.ID, reply.Secret, nil
+}
+
+// RotateKey replaces the secret of a key and returns the new one. The previous
+// secret stays valid for overlapSeconds (requires an admin key).
+func (c *Client) RotateKey(ctx context.Context, id string, overlapSeconds int64) (string, error) {
+	msg := map[string]int64{"overlap_seconds": overlapSeconds}
+	r, err := jsonRequest(http.MethodPost, "/keys/"+id+"/rotate", msg, true)
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		Secret string `json:"secret"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return "", err
+	}
+	return reply.Secret, nil
+}
+
+// SetKeyEnabled enables or disables a key (requires an admin key)
+func (c *Client) SetKeyEnabled(ctx context.Context, id string, enable bool) error {
+	msg := map[string]bool{"enable": enable}
+	r, err := jsonRequest(http.MethodPost, "/keys/"+id, msg, true)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, r, nil)
+}
+
+// RevokeKey permanently revokes a key (requires an admin key)
+func (c *Client) RevokeKey(ctx context.Context, id string) error {
+	path := "/keys/" + id
+	r := request{method: http.MethodDelete, path: path, hmacInput: []byte(APIRoot + path)}
+	return c.call(ctx, r, nil)
+}
+
+// BindKey grants a key access to a repository subpath (requires an admin key)
+func (c *Client) BindKey(ctx context.Context, id, repository, path string) error {
+	msg := KeyBinding{Repository: repository, Path: path}
+	r, err := jsonRequest(http.MethodPost, "/keys/"+id+"/bindings", msg, true)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, r, nil)
+}
+
+// UnbindKey removes the access of a key to a repository (requires an admin key)
+func (c *Client) UnbindKey(ctx context.Context, id, repository string) error {
+	path := "/keys/" + id + "/bindings/" + repository
+	r := request{method: http.MethodDelete, path: path, hmacInput: []byte(APIRoot + path)}
+	return c.call(ctx, r, nil)
+}
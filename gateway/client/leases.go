@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Lease is the information about an active lease returned by the gateway
+type Lease struct {
+	KeyID     string `json:"key_id,omitempty"`
+	LeasePath string `json:"path,omitempty"`
+	Expires   string `json:"expires,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	// Paths are the leased paths, when the lease is on several subpaths
+	Paths []string `json:"paths,omitempty"`
+	// Quota is the content published so far by the lease, with its limits
+	Quota *LeaseQuota `json:"quota,omitempty"`
+}
+
+// LeaseQuota is the usage of the quota of a lease. Zero limits are disabled.
+type LeaseQuota struct {
+	MaxUploadedBytes int64 `json:"max_uploaded_bytes,omitempty"`
+	MaxPayloads      int64 `json:"max_payloads,omitempty"`
+	MaxCatalogs      int64 `json:"max_catalogs,omitempty"`
+	UploadedBytes    int64 `json:"uploaded_bytes"`
+	Payloads         int64 `json:"payloads"`
+	Catalogs         int64 `json:"catalogs"`
+	// Exceeded explains which limit was exceeded, empty within the quota
+	Exceeded string `json:"exceeded,omitempty"`
+}
+
+// NewLeaseReply is returned by the gateway when a lease is granted
+type NewLeaseReply struct {
+	Token         string `json:"session_token"`
+	MaxAPIVersion int    `json:"max_api_version"`
+}
+
+// Tag is a repository tag created when committing a lease
+type Tag struct {
+	Name        string `json:"tag_name"`
+	Description string `json:"tag_description"`
+}
+
+// Payload is the record of a payload submitted for a lease
+type Payload struct {
+	Digest     string `json:"digest"`
+	HeaderSize int    `json:"header_size"`
+	Size       int64  `json:"size"`
+	Received   string `json:"received"`
+	DurationMs int64  `json:"duration_ms"`
+	Result     string `json:"result"`
+	Encoding   string `json:"encoding,omitempty"`
+	// TransferredSize is the size of the payload as received by the gateway
+	TransferredSize int64 `json:"transferred_size"`
+}
+
+// NewLease requests a lease on leasePath ("<REPO_NAME>/<SUBPATH>"). If the
+// path is busy, the returned *Error has the "path_busy" code and the remaining
+// lease time in Fields["time_remaining"].
+func (c *Client) NewLease(ctx context.Context, leasePath, hostname string) (*NewLeaseReply, error) {
+	msg := map[string]string{
+		"path":        leasePath,
+		"api_version": strconv.Itoa(APIProtocolVersion),
+		"hostname":    hostname,
+	}
+	r, err := jsonRequest(http.MethodPost, "/leases", msg, true)
+	if err != nil {
+		return nil, err
+	}
+	var reply NewLeaseReply
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// NewMultiPathLease requests a single lease on several disjoint subpaths of a
+// repository ("<REPO_NAME>/<SUBPATH>"). Either all the paths are leased or
+// none of them, and the lease is committed as a single transaction.
+func (c *Client) NewMultiPathLease(ctx context.Context, leasePaths []string, hostname string) (*NewLeaseReply, error) {
+	msg := map[string]interface{}{
+		"paths":       leasePaths,
+		"api_version": strconv.Itoa(APIProtocolVersion),
+		"hostname":    hostname,
+	}
+	r, err := jsonRequest(http.MethodPost, "/leases", msg, true)
+	if err != nil {
+		return nil, err
+	}
+	var reply NewLeaseReply
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// GetLeases returns the active leases, indexed by lease path
+func (c *Client) GetLeases(ctx context.Context) (map[string]Lease, error) {
+	var reply struct {
+		Data map[string]Lease `json:"data"`
+	}
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/leases"}, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// GetLease returns the lease associated with a token
+func (c *Client) GetLease(ctx context.Context, token string) (*Lease, error) {
+	var reply struct {
+		Data Lease `json:"data"`
+	}
+	r := request{method: http.MethodGet, path: "/leases/" + escapePath(token)}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return &reply.Data, nil
+}
+
+// GetLeasePayloads returns the payloads submitted for a lease
+func (c *Client) GetLeasePayloads(ctx context.Context, token string) ([]Payload, error) {
+	var reply struct {
+		Data []Payload `json:"data"`
+	}
+	r := request{method: http.MethodGet, path: "/leases/" + escapePath(token) + "/payloads"}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// CancelLease drops a lease without committing it
+func (c *Client) CancelLease(ctx context.Context, token string) error {
+	r := request{method: http.MethodDelete, path: "/leases/" + escapePath(token), hmacInput: []byte(token)}
+	return c.call(ctx, r, nil)
+}
+
+// CommitLease commits the changes made under a lease and returns the final
+// revision of the repository
+func (c *Client) CommitLease(ctx context.Context, token, oldRootHash, newRootHash string, tag Tag) (uint64, error) {
+	rev, _, err := c.commitLease(ctx, token, oldRootHash, newRootHash, tag, 0, 0)
+	return rev, err
+}
+
+// CommitLeaseAndWait commits the changes made under a lease and waits until
+// the final revision is found on the given number of stratum 1 replicas, or
+// until the timeout expires. It returns the final revision and the number of
+// replicas which have it; the commit succeeded even if that number is lower
+// than requested.
+func (c *Client) CommitLeaseAndWait(
+	ctx context.Context, token, oldRootHash, newRootHash string, tag Tag,
+	replicas int, timeout time.Duration) (uint64, int, error) {
+	return c.commitLease(ctx, token, oldRootHash, newRootHash, tag, replicas, timeout)
+}
+
+func (c *Client) commitLease(
+	ctx context.Context, token, oldRootHash, newRootHash string, tag Tag,
+	replicas int, timeout time.Duration) (uint64, int, error) {
+	msg := map[string]interface{}{
+		"old_root_hash":   oldRootHash,
+		"new_root_hash":   newRootHash,
+		"tag_name":        tag.Name,
+		"tag_description": tag.Description,
+	}
+	if replicas > 0 {
+		msg["wait_replicas"] = replicas
+		msg["wait_timeout"] = int(timeout.Seconds())
+	}
+	r, err := jsonRequest(http.MethodPost, "/leases/"+escapePath(token), msg, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	r.hmacInput = []byte(token)
+
+	var reply struct {
+		FinalRevision uint64 `json:"final_revision"`
+		Replicated    int    `json:"replicated"`
+	}
+	if err := c.call(ctx, r, &reply); err != nil {
+		return 0, 0, err
+	}
+	return reply.FinalRevision, reply.Replicated, nil
+}
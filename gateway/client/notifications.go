@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PublishManifest publishes the manifest (.cvmfspublished) of a new revision
+// of a repository to the notification subscribers. The key must be bound to
+// the repository.
+func (c *Client) PublishManifest(ctx context.Context, repository string, manifest []byte) error {
+	msg := map[string]interface{}{
+		"version":    1,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		"type":       "activity",
+		"repository": repository,
+		"manifest":   manifest,
+	}
+	r, err := jsonRequest(http.MethodPost, "/notifications/publish", msg, true)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, r, nil)
+}
+
+// Subscribe to the notifications of a repository. The messages are delivered
+// on the returned channel, which is closed when the stream ends or ctx is
+// cancelled.
+func (c *Client) Subscribe(ctx context.Context, repository string) (<-chan string, error) {
+	msg := map[string]interface{}{"version": 1, "repository": repository}
+	r, err := jsonRequest(http.MethodGet, "/notifications/subscribe", msg, false)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &Error{
+			StatusCode: resp.StatusCode,
+			Status:     "error",
+			Reason:     fmt.Sprintf("subscription failed: %v", resp.Status),
+		}
+	}
+
+	messages := make(chan string)
+	go func() {
+		defer close(messages)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			select {
+			case messages <- strings.TrimPrefix(line, "data: "):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages, nil
+}
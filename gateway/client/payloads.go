@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// payloadMessage builds the JSON message which precedes the payload in the
+// request body
+func payloadMessage(token, digest string, headerSize int) ([]byte, error) {
+	msg := map[string]string{
+		"payload_digest": digest,
+		"header_size":    strconv.Itoa(headerSize),
+		"api_version":    strconv.Itoa(APIProtocolVersion),
+	}
+	if token != "" {
+		msg["session_token"] = token
+	}
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode request: %w", err)
+	}
+	return buf, nil
+}
+
+func payloadRequest(path string, msg []byte, payload io.Reader, size int64) (request, error) {
+	// The gateway needs the content length to separate the message from the
+	// payload, buffer payloads of unknown size
+	if size < 0 {
+		buf, err := io.ReadAll(payload)
+		if err != nil {
+			return request{}, fmt.Errorf("could not read payload: %w", err)
+		}
+		payload = bytes.NewReader(buf)
+		size = int64(len(buf))
+	}
+
+	header := make(http.Header)
+	header.Set("Message-Size", strconv.Itoa(len(msg)))
+	r := request{
+		method: http.MethodPost,
+		path:   path,
+		body:   io.MultiReader(bytes.NewReader(msg), payload),
+		size:   int64(len(msg)) + size,
+		header: header,
+	}
+	return r, nil
+}
+
+// compressRequest compresses the body of a request while it is sent
+func compressRequest(r request, encoding string) (request, error) {
+	pr, pw := io.Pipe()
+	var zw io.WriteCloser
+	switch encoding {
+	case "gzip":
+		zw = gzip.NewWriter(pw)
+	case "zstd":
+		w, err := zstd.NewWriter(pw)
+		if err != nil {
+			return request{}, fmt.Errorf("could not create compressor: %w", err)
+		}
+		zw = w
+	default:
+		return request{}, fmt.Errorf("unsupported payload encoding: %v", encoding)
+	}
+	body := r.body
+	go func() {
+		_, err := io.Copy(zw, body)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	r.body = pr
+	// The compressed size is unknown, the body is sent in chunks
+	r.size = -1
+	r.header.Set("Content-Encoding", encoding)
+	return r, nil
+}
+
+// SubmitPayload uploads a payload (object pack) for a lease. The digest and
+// header size describe the object pack; size is the length of the payload,
+// or -1 if unknown.
+func (c *Client) SubmitPayload(ctx context.Context, token, digest string, headerSize int, payload io.Reader, size int64) error {
+	msg, err := payloadMessage("", digest, headerSize)
+	if err != nil {
+		return err
+	}
+	r, err := payloadRequest("/payloads/"+escapePath(token), msg, payload, size)
+	if err != nil {
+		return err
+	}
+	r.hmacInput = []byte(token)
+	if c.PayloadEncoding != "" {
+		if r, err = compressRequest(r, c.PayloadEncoding); err != nil {
+			return err
+		}
+	}
+	return c.call(ctx, r, nil)
+}
+
+// SubmitPayloadLegacy uploads a payload using the legacy endpoint, where the
+// token is part of the signed request message
+func (c *Client) SubmitPayloadLegacy(ctx context.Context, token, digest string, headerSize int, payload io.Reader, size int64) error {
+	msg, err := payloadMessage(token, digest, headerSize)
+	if err != nil {
+		return err
+	}
+	r, err := payloadRequest("/payloads", msg, payload, size)
+	if err != nil {
+		return err
+	}
+	r.hmacInput = msg
+	return c.call(ctx, r, nil)
+}
@@ -0,0 +1,404 @@
+// cvmfs-gw is a command line client of the repository gateway API
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cvmfs/gateway/client"
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/spf13/pflag"
+)
+
+var Version = "development"
+
+type command struct {
+	args  string
+	help  string
+	nargs int
+	run   func(ctx context.Context, c *client.Client, args []string) error
+}
+
+var (
+	gatewayURL     = pflag.StringP("url", "u", "http://localhost:4929", "gateway URL")
+	socketPath     = pflag.String("socket", "", "Unix socket of the gateway, used instead of the URL")
+	compress       = pflag.String("compress", "", "compression of the payloads submitted by submit (gzip|zstd)")
+	keyID          = pflag.String("key-id", "", "ID of the API key used to sign requests")
+	secret         = pflag.String("secret", "", "secret of the API key")
+	keyFile        = pflag.StringP("key-file", "k", "", "file containing the API key (\"plain_text <ID> <SECRET>\")")
+	hostname       = pflag.String("hostname", "", "hostname recorded with a new lease")
+	oldRootHash    = pflag.String("old-root-hash", "", "root hash of the repository before the commit")
+	newRootHash    = pflag.String("new-root-hash", "", "root hash of the repository after the commit")
+	tagName        = pflag.String("tag-name", "", "name of the tag created by the commit")
+	tagDescription = pflag.String("tag-description", "", "description of the tag created by the commit")
+	traceparent    = pflag.String("traceparent", os.Getenv("TRACEPARENT"), "W3C trace context of the requests (default: $TRACEPARENT)")
+	waitReplicas   = pflag.Int("wait-replicas", 0, "number of stratum 1 replicas which must have the revision committed by commit")
+	waitTimeout    = pflag.Duration("wait-timeout", 5*time.Minute, "maximum time commit waits for the stratum 1 replicas")
+	digest         = pflag.String("digest", "", "digest of the object pack")
+	headerSize     = pflag.Int("header-size", 0, "header size of the object pack")
+	legacy         = pflag.Bool("legacy", false, "use the legacy payload submission endpoint")
+	numRevisions   = pflag.Int("num-revisions", 0, "number of revisions preserved by garbage collection")
+	dryRun         = pflag.Bool("dry-run", false, "only report what garbage collection would remove")
+	verbose        = pflag.Bool("verbose", false, "verbose garbage collection output")
+	newKeyID       = pflag.String("new-key-id", "", "ID of the key created by key-new (generated if empty)")
+	adminKey       = pflag.Bool("admin", false, "give admin rights to the key created by key-new")
+	bindings       = pflag.StringSlice("bind", nil, "path (<REPO>/<SUBPATH>) where the key created by key-new is valid")
+	overlap        = pflag.Int64("overlap", 0, "seconds during which the previous secret stays valid after key-rotate")
+	repoKeys       = pflag.StringSlice("repo-key", nil, "key (<ID>=<SUBPATH>) allowed to publish to the repository added by register")
+	maxLeaseTime   = pflag.Int64("max-lease-time", 0, "maximum lease time in seconds of the repository added by register")
+	maxLeases      = pflag.Int("max-leases", 0, "maximum number of concurrent leases of the repository added by register")
+	statsFrom      = pflag.String("from", "", "first day (YYYY-MM-DD) of the statistics returned by stats")
+	statsTo        = pflag.String("to", "", "last day (YYYY-MM-DD) of the statistics returned by stats")
+)
+
+var commands = map[string]command{
+	"lease": {"PATH", "acquire a lease on PATH (<REPO>/<SUBPATH>), print the token", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			reply, err := c.NewLease(ctx, args[0], *hostname)
+			if err != nil {
+				return err
+			}
+			fmt.Println(reply.Token)
+			return nil
+		}},
+	"leases": {"", "list the active leases", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			leases, err := c.GetLeases(ctx)
+			if err != nil {
+				return err
+			}
+			return printJSON(leases)
+		}},
+	"lease-info": {"TOKEN", "show a lease", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			lease, err := c.GetLease(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return printJSON(lease)
+		}},
+	"payloads": {"TOKEN", "list the payloads submitted for a lease", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			payloads, err := c.GetLeasePayloads(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return printJSON(payloads)
+		}},
+	"submit": {"TOKEN FILE", "submit an object pack for a lease (FILE may be \"-\" for stdin)", 2,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			var rd io.Reader = os.Stdin
+			size := int64(-1)
+			if args[1] != "-" {
+				f, err := os.Open(args[1])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				st, err := f.Stat()
+				if err != nil {
+					return err
+				}
+				rd, size = f, st.Size()
+			}
+			if *legacy {
+				return c.SubmitPayloadLegacy(ctx, args[0], *digest, *headerSize, rd, size)
+			}
+			return c.SubmitPayload(ctx, args[0], *digest, *headerSize, rd, size)
+		}},
+	"commit": {"TOKEN", "commit a lease, print the final revision", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			tag := client.Tag{Name: *tagName, Description: *tagDescription}
+			if *waitReplicas > 0 {
+				rev, replicated, err := c.CommitLeaseAndWait(
+					ctx, args[0], *oldRootHash, *newRootHash, tag, *waitReplicas, *waitTimeout)
+				if err != nil {
+					return err
+				}
+				fmt.Println(rev)
+				if replicated < *waitReplicas {
+					return fmt.Errorf("revision %v found on %v of %v replicas", rev, replicated, *waitReplicas)
+				}
+				return nil
+			}
+			rev, err := c.CommitLease(ctx, args[0], *oldRootHash, *newRootHash, tag)
+			if err != nil {
+				return err
+			}
+			fmt.Println(rev)
+			return nil
+		}},
+	"cancel": {"TOKEN", "cancel a lease", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.CancelLease(ctx, args[0])
+		}},
+	"repos": {"", "list the repositories", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			repos, err := c.GetRepos(ctx)
+			if err != nil {
+				return err
+			}
+			return printJSON(repos)
+		}},
+	"receivers": {"", "show the receiver pool statistics", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			st, err := c.GetReceiverStatistics(ctx)
+			if err != nil {
+				return err
+			}
+			return printJSON(st)
+		}},
+	"repo": {"NAME", "show a repository", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			repo, err := c.GetRepo(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return printJSON(repo)
+		}},
+	"enable": {"NAME", "enable a repository (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.SetRepoEnabled(ctx, args[0], true)
+		}},
+	"disable": {"NAME", "disable a repository (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.SetRepoEnabled(ctx, args[0], false)
+		}},
+	"register": {"NAME", "register a repository served by the stratum 0 (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			reg := client.RepoRegistration{Name: args[0], Keys: make(map[string]string)}
+			reg.MaxLeaseTime = *maxLeaseTime
+			reg.MaxLeases = *maxLeases
+			for _, k := range *repoKeys {
+				tokens := strings.SplitN(k, "=", 2)
+				if len(tokens) == 1 {
+					tokens = append(tokens, "/")
+				}
+				reg.Keys[tokens[0]] = tokens[1]
+			}
+			return c.RegisterRepo(ctx, reg)
+		}},
+	"deregister": {"NAME", "remove a registered repository (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.DeregisterRepo(ctx, args[0])
+		}},
+	"stats": {"NAME", "show the publication statistics of a repository per key and day", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			st, err := c.GetRepoStatistics(ctx, args[0], *statsFrom, *statsTo)
+			if err != nil {
+				return err
+			}
+			return printJSON(st)
+		}},
+	"hooks": {"NAME", "list the recent commit hook runs of a repository (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			runs, err := c.GetHookRuns(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return printJSON(runs)
+		}},
+	"config": {"", "show the effective configuration and the configuration problems of the gateway (admin)", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			cfg, err := c.GetConfig(ctx)
+			if err != nil {
+				return err
+			}
+			return printJSON(cfg)
+		}},
+	"relays": {"", "show the state of the subscriptions to the relayed upstream gateways (admin)", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			relays, err := c.GetRelays(ctx)
+			if err != nil {
+				return err
+			}
+			return printJSON(relays)
+		}},
+	"cancel-path": {"PATH", "cancel all the leases below PATH (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.CancelLeasesByPath(ctx, args[0])
+		}},
+	"gc": {"NAME", "run garbage collection on a repository (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			output, err := c.RunGC(ctx, client.GCOptions{
+				Repository:   args[0],
+				NumRevisions: *numRevisions,
+				DryRun:       *dryRun,
+				Verbose:      *verbose,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Print(output)
+			return nil
+		}},
+	"keys": {"", "list the keys (admin)", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			keys, err := c.GetKeys(ctx)
+			if err != nil {
+				return err
+			}
+			return printJSON(keys)
+		}},
+	"key-info": {"ID", "show a key (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			key, err := c.GetKey(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			return printJSON(key)
+		}},
+	"key-new": {"", "create a key, print its ID and secret (admin)", 0,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			options := client.NewKeyOptions{ID: *newKeyID, Admin: *adminKey}
+			for _, b := range *bindings {
+				repo, path := splitRepoPath(b)
+				options.Bindings = append(options.Bindings, client.KeyBinding{Repository: repo, Path: path})
+			}
+			id, secret, err := c.NewKey(ctx, options)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("plain_text %v %v\n", id, secret)
+			return nil
+		}},
+	"key-rotate": {"ID", "replace the secret of a key, print the new secret (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			secret, err := c.RotateKey(ctx, args[0], *overlap)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("plain_text %v %v\n", args[0], secret)
+			return nil
+		}},
+	"key-enable": {"ID", "enable a key (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.SetKeyEnabled(ctx, args[0], true)
+		}},
+	"key-disable": {"ID", "disable a key (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.SetKeyEnabled(ctx, args[0], false)
+		}},
+	"key-revoke": {"ID", "permanently revoke a key (admin)", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.RevokeKey(ctx, args[0])
+		}},
+	"key-bind": {"ID PATH", "make a key valid below PATH (<REPO>/<SUBPATH>) (admin)", 2,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			repo, path := splitRepoPath(args[1])
+			return c.BindKey(ctx, args[0], repo, path)
+		}},
+	"key-unbind": {"ID NAME", "remove the access of a key to a repository (admin)", 2,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			return c.UnbindKey(ctx, args[0], args[1])
+		}},
+	"publish": {"NAME FILE", "publish the manifest of a repository read from FILE (\"-\" for stdin)", 2,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			var manifest []byte
+			var err error
+			if args[1] == "-" {
+				manifest, err = io.ReadAll(os.Stdin)
+			} else {
+				manifest, err = os.ReadFile(args[1])
+			}
+			if err != nil {
+				return err
+			}
+			return c.PublishManifest(ctx, args[0], manifest)
+		}},
+	"subscribe": {"NAME", "print the notifications of a repository", 1,
+		func(ctx context.Context, c *client.Client, args []string) error {
+			messages, err := c.Subscribe(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			for m := range messages {
+				fmt.Println(m)
+			}
+			return nil
+		}},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "cvmfs-gw %v - repository gateway client\n\n", Version)
+	fmt.Fprintf(os.Stderr, "Usage: cvmfs-gw [OPTIONS] COMMAND [ARGS]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(os.Stderr, "  %-28s %v\n", strings.TrimSpace(name+" "+cmd.args), cmd.help)
+	}
+	fmt.Fprintf(os.Stderr, "\nOptions:\n%v", pflag.CommandLine.FlagUsages())
+}
+
+// splitRepoPath splits "<REPO>/<SUBPATH>" into the repository name and the
+// subpath
+func splitRepoPath(p string) (string, string) {
+	tokens := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	if len(tokens) == 1 {
+		return tokens[0], "/"
+	}
+	return tokens[0], "/" + tokens[1]
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func main() {
+	pflag.Usage = usage
+	pflag.Parse()
+
+	args := pflag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || len(args)-1 != cmd.nargs {
+		usage()
+		os.Exit(2)
+	}
+
+	id, sec := *keyID, *secret
+	if *keyFile != "" {
+		var err error
+		id, sec, err = gw.LoadKey(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not load key: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	c := client.New(*gatewayURL, id, sec)
+	if *socketPath != "" {
+		c = client.NewUnix(*socketPath, id, sec)
+	}
+	c.PayloadEncoding = *compress
+	if *traceparent != "" {
+		ctx = client.WithTraceparent(ctx, *traceparent)
+	}
+	if err := cmd.run(ctx, c, args[1:]); err != nil {
+		if e, ok := err.(*client.Error); ok && e.RequestID != "" {
+			fmt.Fprintf(os.Stderr, "%v (request ID: %v)\n", err, e.RequestID)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		os.Exit(1)
+	}
+}
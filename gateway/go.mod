@@ -5,6 +5,7 @@ go 1.18
 require (
 	github.com/google/uuid v1.3.0
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/klauspost/compress v1.15.15
 	github.com/mattn/go-sqlite3 v1.14.10
 	github.com/rs/zerolog v1.26.1
 	github.com/spf13/pflag v1.0.5
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 )
@@ -17,18 +18,46 @@ type KeyPaths map[string]string
 type RepositoryConfig struct {
 	Keys    KeyPaths `json:"keys"`
 	Enabled bool     `json:"enabled"`
+	// Limits are only set for the repositories registered through the API
+	Limits *RepositoryLimits `json:"limits,omitempty"`
+	// Replication is only set for a single repository whose stratum 1
+	// replicas are tracked
+	Replication *ReplicationDTO `json:"replication,omitempty"`
+}
+
+// RepositoryLimits are the limits of a repository registered through the API.
+// Zero values mean the gateway defaults apply.
+type RepositoryLimits struct {
+	// MaxLeaseTime is the maximum lease duration in seconds
+	MaxLeaseTime int64 `json:"max_lease_time,omitempty"`
+	// MaxLeases is the maximum number of concurrent leases
+	MaxLeases int `json:"max_leases,omitempty"`
 }
 
 // KeyConfig contains the secret part and the enabled status of a key
 type KeyConfig struct {
 	Secret string `json:"secret"`
 	Admin  bool   `json:"admin"`
+	// PreviousSecret is still accepted during the overlap period following the
+	// rotation of a managed key
+	PreviousSecret string `json:"-"`
+}
+
+// Secrets returns the secrets which are currently accepted for the key
+func (k *KeyConfig) Secrets() []string {
+	if k.PreviousSecret != "" {
+		return []string{k.Secret, k.PreviousSecret}
+	}
+	return []string{k.Secret}
 }
 
 // AccessConfig is the configuration of a single repository
 type AccessConfig struct {
 	Repositories map[string]RepositoryConfig
 	Keys         map[string]KeyConfig
+	// Problems are the errors found in the configuration file which do not
+	// prevent loading it, like keys which could not be imported
+	Problems []string
 }
 
 // RepositorySpecV1 lists the keys associated with a repository in the configuration file
@@ -82,6 +111,14 @@ func (ae RepoBusyError) Error() string {
 
 type rawConfig map[string]json.RawMessage
 
+// The fields of the items of the access configuration file
+var (
+	accessConfigFields = []string{"version", "repos", "keys"}
+	repoSpecFields     = []string{"domain", "keys"}
+	keyGrantFields     = []string{"id", "admin", "path"}
+	keySpecFields      = []string{"type", "id", "secret", "file_name", "repo_subpath", "admin"}
+)
+
 // NewAccessConfig creates an new access configuration from the given file
 func NewAccessConfig(fileName string) (*AccessConfig, error) {
 	return newAccessConfigWithImporter(fileName, keyImporter)
@@ -124,16 +161,21 @@ func (c *AccessConfig) Check(keyID, leasePath, repoName string) *AuthError {
 		return &AuthError{"invalid_key"}
 	}
 
-	overlapping := gw.CheckPathOverlap(leasePath, keyPath)
-	isSubpath := len(leasePath) >= len(keyPath)
-
-	if !overlapping || !isSubpath {
+	if !pathAllowed(leasePath, keyPath) {
 		return &AuthError{"invalid_path"}
 	}
 
 	return nil
 }
 
+// pathAllowed returns true if a lease can be acquired on leasePath by a key
+// valid for keyPath
+func pathAllowed(leasePath, keyPath string) bool {
+	overlapping := gw.CheckPathOverlap(leasePath, keyPath)
+	isSubpath := len(leasePath) >= len(keyPath)
+	return overlapping && isSubpath
+}
+
 func newAccessConfigWithImporter(fileName string, importer KeyImportFun) (*AccessConfig, error) {
 	ac := emptyAccessConfig()
 
@@ -165,13 +207,105 @@ func (c *AccessConfig) load(rd io.Reader, importer KeyImportFun) error {
 		return fmt.Errorf("could not decode JSON input: %w", err)
 	}
 
+	c.checkFields(t, "access configuration", accessConfigFields)
+
 	version := getConfigVersion(t)
 
+	var err error
 	if version == 1 {
-		return c.loadV1(t, importer)
+		err = c.loadV1(t, importer)
+	} else {
+		err = c.loadV2(t, importer)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keys which are not defined can not be used
+	repoNames := make([]string, 0, len(c.Repositories))
+	for name := range c.Repositories {
+		repoNames = append(repoNames, name)
+	}
+	sort.Strings(repoNames)
+	for _, name := range repoNames {
+		keyIDs := make([]string, 0)
+		for keyID := range c.Repositories[name].Keys {
+			if _, present := c.Keys[keyID]; !present {
+				keyIDs = append(keyIDs, keyID)
+			}
+		}
+		sort.Strings(keyIDs)
+		for _, keyID := range keyIDs {
+			c.problem("repository %v references undefined key %v", name, keyID)
+		}
+	}
+
+	return nil
+}
+
+// problem records a problem of the configuration file
+func (c *AccessConfig) problem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// checkFields records the fields of an item of the configuration file which
+// are not known
+func (c *AccessConfig) checkFields(item rawConfig, what string, known []string) {
+	unknown := make([]string, 0)
+	for field := range item {
+		isKnown := false
+		for _, k := range known {
+			isKnown = isKnown || field == k
+		}
+		if !isKnown {
+			unknown = append(unknown, field)
+		}
+	}
+	sort.Strings(unknown)
+	for _, field := range unknown {
+		c.problem("unknown field in %v: %v", what, field)
+	}
+}
+
+// checkRawFields is checkFields for items which may not be JSON objects
+func (c *AccessConfig) checkRawFields(raw json.RawMessage, what string, known []string) {
+	var item rawConfig
+	if err := json.Unmarshal(raw, &item); err == nil {
+		c.checkFields(item, what, known)
+	}
+}
+
+// addKey imports a key of the configuration file. Keys which can not be
+// imported are recorded as problems and skipped.
+func (c *AccessConfig) addKey(spec KeySpec, importer KeyImportFun, defined map[string]bool) (string, string, bool) {
+	keyID, secret, repoPath, admin, err := importer(spec)
+	if err != nil {
+		c.problem("could not import key %v: %v", keyDescription(spec), err)
+		return "", "", false
+	}
+	if defined[keyID] {
+		c.problem("key %v is defined more than once", keyID)
 	}
+	defined[keyID] = true
+	c.Keys[keyID] = KeyConfig{Secret: secret, Admin: admin}
+	return keyID, repoPath, true
+}
 
-	return c.loadV2(t, importer)
+// keyDescription identifies a key spec in the problems reported
+func keyDescription(spec KeySpec) string {
+	if spec.KeyType == "file" {
+		return spec.FileName
+	}
+	return spec.ID
+}
+
+// addRepository records the repository, and the repositories defined more
+// than once
+func (c *AccessConfig) addRepository(name string, rc RepositoryConfig) {
+	if _, present := c.Repositories[name]; present {
+		c.problem("repository %v is defined more than once", name)
+	}
+	c.Repositories[name] = rc
 }
 
 func (c *AccessConfig) loadV1(cfg rawConfig, importer KeyImportFun) error {
@@ -180,33 +314,41 @@ func (c *AccessConfig) loadV1(cfg rawConfig, importer KeyImportFun) error {
 	// Load the keys from the config file and store the KeyID -> Secret mapping
 	// Save the KeyID -> Subpath mapping for later
 	if rawKeys, present := cfg["keys"]; present {
-		keys := make([]KeySpec, 0)
+		keys := make([]json.RawMessage, 0)
 		if err := json.Unmarshal(rawKeys, &keys); err != nil {
 			return fmt.Errorf("could not parse key specs: %w", err)
 		}
-		for _, spec := range keys {
-			keyID, secret, repoPath, admin, err := importer(spec)
-			if err != nil {
-				return fmt.Errorf("could not import key %v: %w", spec.ID, err)
+		defined := make(map[string]bool)
+		for _, rawKey := range keys {
+			var spec KeySpec
+			if err := json.Unmarshal(rawKey, &spec); err != nil {
+				return fmt.Errorf("could not parse key specs: %w", err)
+			}
+			c.checkRawFields(rawKey, "key "+keyDescription(spec), keySpecFields)
+			if keyID, repoPath, ok := c.addKey(spec, importer, defined); ok {
+				keyPaths[keyID] = repoPath
 			}
-			keyPaths[keyID] = repoPath
-			c.Keys[keyID] = KeyConfig{Secret: secret, Admin: admin}
 		}
 	}
 
 	// Load the repository specs from the config file and store the
 	// RepoID -> (KeyID -> Subpath) mapping.
 	if rawRepos, present := cfg["repos"]; present {
-		repos := make([]RepositorySpecV1, 0)
+		repos := make([]json.RawMessage, 0)
 		if err := json.Unmarshal(rawRepos, &repos); err != nil {
 			return fmt.Errorf("could not import repository specs: %w", err)
 		}
-		for _, spec := range repos {
+		for _, rawRepo := range repos {
+			var spec RepositorySpecV1
+			if err := json.Unmarshal(rawRepo, &spec); err != nil {
+				return fmt.Errorf("could not import repository specs: %w", err)
+			}
+			c.checkRawFields(rawRepo, "repository "+spec.Name, repoSpecFields)
 			keyIds := make(KeyPaths)
 			for _, k := range spec.Keys {
 				keyIds[k] = keyPaths[k]
 			}
-			c.Repositories[spec.Name] = RepositoryConfig{Keys: keyIds}
+			c.addRepository(spec.Name, RepositoryConfig{Keys: keyIds})
 		}
 	}
 
@@ -232,35 +374,56 @@ func (c *AccessConfig) loadV2(cfg rawConfig, importer KeyImportFun) error {
 				}
 				// Item is a string representing the repository name; default key
 				// from /etc/cvmfs/keys/<REPO_NAME>/ will be associated
-				c.Repositories[name] = RepositoryConfig{
+				c.addRepository(name, RepositoryConfig{
 					Keys: KeyPaths{"default": "default"},
-				}
+				})
 			} else {
 				// Item is a RepositorySpecV2; associate the key IDs and paths to the
 				// repository
+				var item struct {
+					Keys []json.RawMessage `json:"keys"`
+				}
+				json.Unmarshal(r, &item)
+				c.checkRawFields(r, "repository "+spec.Name, repoSpecFields)
+				for _, k := range item.Keys {
+					c.checkRawFields(k, "key grant of repository "+spec.Name, keyGrantFields)
+				}
 				ks := make(KeyPaths)
-				for _, k := range spec.Keys {
+				for i, k := range spec.Keys {
+					if path, present := ks[k.ID]; present {
+						c.problem("key %v is granted both %v and %v in repository %v, only %v is used",
+							k.ID, path, k.Path, spec.Name, k.Path)
+					}
 					ks[k.ID] = k.Path
+					// the grants of the same key are reported above
+					for _, other := range spec.Keys[:i] {
+						if other.ID != k.ID && gw.CheckPathOverlap(other.Path, k.Path) {
+							c.problem("paths %v of key %v and %v of key %v overlap in repository %v",
+								other.Path, other.ID, k.Path, k.ID, spec.Name)
+						}
+					}
 				}
-				c.Repositories[spec.Name] = RepositoryConfig{
+				c.addRepository(spec.Name, RepositoryConfig{
 					Keys: ks,
-				}
+				})
 			}
 		}
 	}
 
 	// Load the keys from the config file and store the KeyID -> Secret mapping
 	if rawKeys, present := cfg["keys"]; present {
-		keys := make([]KeySpec, 0)
+		keys := make([]json.RawMessage, 0)
 		if err := json.Unmarshal(rawKeys, &keys); err != nil {
 			return fmt.Errorf("could not import key specs: %w", err)
 		}
-		for _, spec := range keys {
-			keyID, secret, _, admin, err := importer(spec)
-			if err != nil {
-				return fmt.Errorf("could not import key %v: %w", spec.ID, err)
+		defined := make(map[string]bool)
+		for _, rawKey := range keys {
+			var spec KeySpec
+			if err := json.Unmarshal(rawKey, &spec); err != nil {
+				return fmt.Errorf("could not import key specs: %w", err)
 			}
-			c.Keys[keyID] = KeyConfig{Secret: secret, Admin: admin}
+			c.checkRawFields(rawKey, "key "+keyDescription(spec), keySpecFields)
+			c.addKey(spec, importer, defined)
 		}
 	}
 
@@ -272,7 +435,8 @@ func (c *AccessConfig) loadV2(cfg rawConfig, importer KeyImportFun) error {
 			spec := KeySpec{KeyType: "file", FileName: "/etc/cvmfs/keys/" + repoName + ".gw"}
 			keyID, secret, _, admin, err := importer(spec)
 			if err != nil {
-				return fmt.Errorf("could not import default key for repository: %v: %w", repoName, err)
+				c.problem("could not import default key of repository %v: %v", repoName, err)
+				continue
 			}
 			if _, present := c.Keys[keyID]; !present {
 				c.Keys[keyID] = KeyConfig{Secret: secret, Admin: admin}
@@ -291,7 +455,7 @@ func keyImporter(ks KeySpec) (string, string, string, bool, error) {
 	case "file":
 		id, sec, err := gw.LoadKey(ks.FileName)
 		if err != nil {
-			return "", "", "", false, fmt.Errorf("could not import key from file")
+			return "", "", "", false, fmt.Errorf("could not import key from file: %w", err)
 		}
 		return id, sec, ks.Path, ks.Admin, nil
 	default:
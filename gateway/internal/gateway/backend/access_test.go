@@ -108,3 +108,57 @@ func TestKeyCheck(t *testing.T) {
 		}
 	})
 }
+
+func TestAccessConfigProblems(t *testing.T) {
+	cfg := `
+{
+	"version": 2,
+	"max_leases": 3,
+	"repos": [
+		{
+			"domain": "test1.repo.org",
+			"keys": [
+				{"id": "keyid1", "path": "/"},
+				{"id": "keyid1", "path": "/sub"}
+			]
+		},
+		{"domain": "test1.repo.org", "keys": [{"id": "missing", "path": "/"}]},
+		{
+			"domain": "test2.repo.org",
+			"keys": [
+				{"id": "keyid1", "path": "/a"},
+				{"id": "keyid2", "path": "/c"},
+				{"id": "keyid2", "path": "/a/b"}
+			]
+		}
+	],
+	"keys": [
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1", "comment": "x"},
+		{"type": "plain_text", "id": "keyid1", "secret": "secret1"},
+		{"type": "plain_text", "id": "keyid2", "secret": "secret2"},
+		{"type": "unknown", "id": "broken"}
+	]
+}
+`
+	ac := emptyAccessConfig()
+	if err := ac.load(strings.NewReader(cfg), mockKeyImporter); err != nil {
+		t.Fatalf("access config loading failed: %v", err)
+	}
+	expected := []string{
+		"unknown field in access configuration: max_leases",
+		"key keyid1 is granted both / and /sub in repository test1.repo.org, only /sub is used",
+		"repository test1.repo.org is defined more than once",
+		"key keyid2 is granted both /c and /a/b in repository test2.repo.org, only /a/b is used",
+		"paths /a of key keyid1 and /a/b of key keyid2 overlap in repository test2.repo.org",
+		"unknown field in key keyid1: comment",
+		"key keyid1 is defined more than once",
+		"could not import key broken: unknown key type",
+		"repository test1.repo.org references undefined key missing",
+	}
+	if strings.Join(ac.Problems, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected problems:\n%v", strings.Join(ac.Problems, "\n"))
+	}
+	if ac.GetKeyConfig("keyid1") == nil || ac.GetKeyConfig("broken") != nil {
+		t.Errorf("valid key was not loaded")
+	}
+}
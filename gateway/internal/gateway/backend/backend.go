@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	"github.com/cvmfs/gateway/internal/gateway/receiver"
@@ -19,6 +20,32 @@ type Services struct {
 	Pool          *receiver.Pool
 	Notifications *NotificationSystem
 	StatsMgr      *stats.StatisticsMgr
+	// Secrets encrypts the secrets of the API keys managed through the API
+	Secrets *SecretBox
+	Hooks   *Hooks
+	// Relays re-publish the notifications of upstream gateways
+	Relays *Relays
+	// Replication tracks the revisions of the stratum 1 replicas
+	Replication *ReplicationTracker
+	// activity keeps the recent publications and GC runs
+	activity activityLog
+	// batches collects the commits merged by commit batching
+	batches commitBatches
+	// payloadBudgets is shared by the concurrent payload uploads of a lease
+	payloadBudgets payloadBudgets
+}
+
+// ReceiverError wraps the failures of tasks executed by the receiver workers
+type ReceiverError struct {
+	Err error
+}
+
+func (e ReceiverError) Error() string {
+	return e.Err.Error()
+}
+
+func (e ReceiverError) Unwrap() error {
+	return e.Err
 }
 
 // ActionController contains the various actions that can be performed with the backend
@@ -28,21 +55,56 @@ type ActionController interface {
 	GetRepos(ctx context.Context) (map[string]RepositoryConfig, error)
 	SetRepoEnabled(ctx context.Context, repository string, enabled bool) error
 	NewLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int) (string, error)
+	NewMultiPathLease(ctx context.Context, keyID string, leasePaths []string, hostname string, protocolVersion int) (string, error)
 	GetLeases(ctx context.Context) (map[string]LeaseDTO, error)
 	GetLease(ctx context.Context, tokenStr string) (*LeaseDTO, error)
 	CancelLeases(ctx context.Context, repoPath string) error
 	CancelLease(ctx context.Context, tokenStr string) error
 	CommitLease(ctx context.Context, tokenStr, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error)
 	SubmitPayload(ctx context.Context, token string, payload io.Reader, digest string, headerSize int) error
+	GetLeasePayloads(ctx context.Context, tokenStr string) ([]PayloadDTO, error)
 	RunGC(ctx context.Context, options GCOptions) (string, error)
-	PublishManifest(ctx context.Context, repository string, message NotificationMessage)
+	PublishManifest(ctx context.Context, keyID, repository string, manifest []byte) error
 	SubscribeToNotifications(ctx context.Context, repository string) SubscriberHandle
 	UnsubscribeFromNotifications(ctx context.Context, repository string, handle SubscriberHandle) error
+	NewKey(ctx context.Context, options NewKeyOptions) (string, string, error)
+	GetKeys(ctx context.Context) ([]KeyDTO, error)
+	GetKeyInfo(ctx context.Context, keyID string) (*KeyDTO, error)
+	RotateKey(ctx context.Context, keyID string, overlap time.Duration) (string, error)
+	SetKeyEnabled(ctx context.Context, keyID string, enable bool) error
+	RevokeKey(ctx context.Context, keyID string) error
+	BindKey(ctx context.Context, keyID, repository, path string) error
+	UnbindKey(ctx context.Context, keyID, repository string) error
+	GetHookRuns(ctx context.Context, repository string) ([]HookRunDTO, error)
+	RegisterRepo(ctx context.Context, options RepoRegistrationOptions) error
+	DeregisterRepo(ctx context.Context, repoName string) error
+	GetReceiverStatistics(ctx context.Context) receiver.PoolStatistics
+	GetStatus(ctx context.Context) (*StatusDTO, error)
+	GetRepoStatistics(ctx context.Context, repository string, query StatisticsQuery) ([]PublicationStatisticsDTO, error)
+	GetConfig(ctx context.Context) (*ConfigReport, error)
+	GetRelayStatus(ctx context.Context) ([]RelayStatusDTO, error)
+	GetReplication(ctx context.Context, repository string) *ReplicationDTO
+	WaitForReplication(ctx context.Context, repository string, revision uint64, replicas int, timeout time.Duration) (int, error)
 }
 
 // GetKey returns the key configuration associated with a key ID
 func (s *Services) GetKey(ctx context.Context, keyID string) *KeyConfig {
-	return s.Access.GetKeyConfig(keyID)
+	if cfg := s.Access.GetKeyConfig(keyID); cfg != nil {
+		return cfg
+	}
+	cfg, err := s.getManagedKeyConfig(ctx, keyID)
+	if err != nil {
+		gw.LogC(ctx, "actions", gw.LogError).
+			Err(err).
+			Msgf("could not retrieve key %v", keyID)
+		return nil
+	}
+	return cfg
+}
+
+// GetReceiverStatistics returns the task counters of the receiver pool
+func (s *Services) GetReceiverStatistics(ctx context.Context) receiver.PoolStatistics {
+	return s.Pool.Statistics()
 }
 
 // StartBackend initializes the various backend services
@@ -51,6 +113,10 @@ func StartBackend(cfg gw.Config) (*Services, error) {
 	if err != nil {
 		return nil, fmt.Errorf("loading repository access configuration failed: %w", err)
 	}
+	for _, problem := range ac.Problems {
+		gw.Log("backend", gw.LogWarn).
+			Msgf("repository access configuration: %v", problem)
+	}
 
 	db, err := OpenDB(cfg)
 	if err != nil {
@@ -59,7 +125,8 @@ func StartBackend(cfg gw.Config) (*Services, error) {
 
 	smgr := stats.NewStatisticsMgr()
 
-	pool, err := receiver.StartPool(cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr)
+	pool, err := receiver.StartPool(cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr,
+		receiver.PoolOptions{TaskTimeout: cfg.ReceiverTimeout, Limits: cfg.ReceiverLimits})
 	if err != nil {
 		return nil, fmt.Errorf("could not start receiver pool: %w", err)
 	}
@@ -69,17 +136,44 @@ func StartBackend(cfg gw.Config) (*Services, error) {
 		return nil, fmt.Errorf("could not initialize notification system: %w", err)
 	}
 
-	services := Services{Config: cfg, Access: *ac, DB: db, Pool: pool, Notifications: ns, StatsMgr: smgr}
+	secrets, err := LoadSecretBox(cfg.MasterKeyFile, cfg.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not load key encryption secret: %w", err)
+	}
+
+	hooks, err := NewHooks(cfg.Hooks, db)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hook configuration: %w", err)
+	}
+
+	services := Services{
+		Config: cfg, Access: *ac, DB: db, Pool: pool, Notifications: ns, StatsMgr: smgr,
+		Secrets: secrets, Hooks: hooks, Replication: NewReplicationTracker(cfg)}
 
 	if err := PopulateRepositories(&services); err != nil {
 		return nil, fmt.Errorf("could not populate repository table: %w", err)
 	}
 
+	if err := RecoverLeaseStatistics(&services); err != nil {
+		return nil, fmt.Errorf("could not recover lease statistics: %w", err)
+	}
+
+	services.Relays = services.StartRelays()
+	services.Replication.Start()
+
 	return &services, nil
 }
 
 // Stop all the backend services
 func (s *Services) Stop() error {
+	if s.Relays != nil {
+		s.Relays.Stop()
+	}
+	s.Replication.Stop()
+	s.Hooks.Stop()
+	if err := s.Pool.Stop(); err != nil {
+		return fmt.Errorf("could not stop receiver pool: %w", err)
+	}
 	if err := s.DB.Close(); err != nil {
 		return fmt.Errorf("could not close database: %w", err)
 	}
@@ -96,6 +190,52 @@ func PopulateRepositories(s *Services) error {
 			return err
 		}
 	}
+	if err := s.PopulateRegisteredRepositories(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RecoverLeaseStatistics recreates the statistics counters of the leases which
+// are still active when the gateway is (re)started, so that they can still be
+// committed. The quota usage of the leases is restored from the lease DB, the
+// other counters of the payloads submitted before the restart are lost.
+func RecoverLeaseStatistics(s *Services) error {
+	ctx := context.Background()
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	leases, err := FindAllActiveLeases(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, lease := range leases {
+		if err := s.StatsMgr.CreateLease(lease.CombinedLeasePath()); err != nil {
+			return err
+		}
+		// The limits of the configuration apply again to the leases taken
+		// before the restart
+		if err := s.StatsMgr.SetLeaseQuota(lease.CombinedLeasePath(), s.leaseQuota(lease.Repository, lease.KeyID)); err != nil {
+			return err
+		}
+		usage, err := FindLeaseUsageByToken(ctx, tx, lease.Token)
+		if err != nil {
+			return err
+		}
+		if usage != nil {
+			if err := s.StatsMgr.RestoreLeaseUsage(lease.CombinedLeasePath(), *usage); err != nil {
+				return err
+			}
+		}
+	}
+
+	gw.Log("backend", gw.LogInfo).
+		Int("num_leases", len(leases)).
+		Msg("lease statistics recovered")
 
 	return nil
 }
@@ -0,0 +1,239 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
+)
+
+// commitBatches collects the commits of the repositories with commit
+// batching. Each repository has at most one open batch, which new commits
+// join until its window expires.
+type commitBatches struct {
+	mu   sync.Mutex
+	open map[string]*commitBatch
+}
+
+type commitBatch struct {
+	repository string
+	members    []batchedCommit
+}
+
+type batchedCommit struct {
+	receiver.CommitRequest
+	// token of the lease, which may be cancelled while the batch is open
+	token string
+	// trace is the span of the commit request
+	trace tracing.SpanContext
+	done  chan<- batchedCommitResult
+}
+
+type batchedCommitResult struct {
+	finalRev uint64
+	duration time.Duration
+	err      error
+}
+
+// overlaps returns true if the lease path overlaps the path of a commit of the
+// batch
+func (b *commitBatch) overlaps(leasePath string) bool {
+	for _, m := range b.members {
+		if gw.CheckPathOverlap(leasePath, m.LeasePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// add a commit to the open batch of the repository. A new batch is opened if
+// there is none, or if the commit overlaps the open one; flush is called with
+// the new batch once the window expires.
+func (c *commitBatches) add(
+	ctx context.Context, repository, token string, req receiver.CommitRequest, window time.Duration,
+	flush func(*commitBatch)) <-chan batchedCommitResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open == nil {
+		c.open = make(map[string]*commitBatch)
+	}
+	b := c.open[repository]
+	if b == nil || b.overlaps(req.LeasePath) {
+		b = &commitBatch{repository: repository}
+		c.open[repository] = b
+		time.AfterFunc(window, func() {
+			c.close(b)
+			flush(b)
+		})
+	}
+	done := make(chan batchedCommitResult, 1)
+	b.members = append(b.members, batchedCommit{
+		CommitRequest: req, token: token, trace: tracing.SpanFromContext(ctx).Context(), done: done})
+	return done
+}
+
+// close the batch, no more commits can join it
+func (c *commitBatches) close(b *commitBatch) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.open[b.repository] == b {
+		delete(c.open, b.repository)
+	}
+}
+
+// batchWindow returns the commit batching window of the repository, or zero if
+// its commits are not batched
+func (s *Services) batchWindow(repository string) time.Duration {
+	for _, b := range s.Config.CommitBatching {
+		if b.Repository == repository {
+			return b.Window
+		}
+	}
+	return 0
+}
+
+// commitToReceiver commits a lease with the receiver, while holding the lock
+// of the repository. It returns the final revision and the duration of the
+// receiver commit.
+//
+// The commits of the repositories with commit batching wait for the concurrent
+// commits of disjoint leases, and are merged with them into a single revision.
+// The lease mutex, held by the caller, is released during the wait: the batch
+// takes it again and checks that the lease is still valid before committing.
+func (s *Services) commitToReceiver(
+	ctx context.Context, repository, token string, req receiver.CommitRequest) (uint64, time.Duration, error) {
+	window := s.batchWindow(repository)
+	if window <= 0 {
+		var finalRev uint64
+		var commitDuration time.Duration
+		err := s.DB.WithLock(ctx, repository, func() error {
+			var err error
+			t1 := time.Now()
+			finalRev, err = s.Pool.CommitLease(ctx, req.LeasePath, req.OldRootHash, req.NewRootHash, req.Tag)
+			commitDuration = time.Since(t1)
+			if err != nil {
+				return ReceiverError{err}
+			}
+			return nil
+		})
+		return finalRev, commitDuration, err
+	}
+
+	done := s.batches.add(ctx, repository, token, req, window, s.flushBatch)
+	leaseMutex.Unlock()
+	res := <-done
+	leaseMutex.Lock()
+	return res.finalRev, res.duration, res.err
+}
+
+// flushBatch commits the leases of a batch and replies to each of them. The
+// batch is not bound to the request of any of its commits, its span is part of
+// the trace of the first traced commit and records the traces of the others.
+//
+// The leases cancelled or expired while the batch was open are not committed.
+func (s *Services) flushBatch(b *commitBatch) {
+	ctx := context.Background()
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+
+	results := make([]batchedCommitResult, len(b.members))
+	// members are the indices of the valid leases, in the results
+	var members []int
+	var commits []receiver.CommitRequest
+	var traces []string
+	for i, m := range b.members {
+		if err := s.checkBatchedLease(ctx, m.token); err != nil {
+			results[i].err = err
+			continue
+		}
+		members = append(members, i)
+		commits = append(commits, m.CommitRequest)
+		if m.trace.Valid() {
+			if len(traces) == 0 {
+				ctx = tracing.ContextWithRemote(ctx, m.trace)
+			}
+			traces = append(traces, m.trace.TraceIDString())
+		}
+	}
+	if len(traces) > 0 {
+		var span *tracing.Span
+		ctx, span = tracing.Start(ctx, "commit_batch", tracing.KindInternal)
+		span.SetAttribute("repository", b.repository)
+		span.SetAttribute("commits", len(commits))
+		span.SetAttribute("traces", strings.Join(traces, ","))
+		defer span.End()
+	}
+	s.DB.WithLock(ctx, b.repository, func() error {
+		if len(commits) > 1 {
+			t1 := time.Now()
+			finalRev, errs, err := s.Pool.CommitBatch(ctx, commits)
+			commitDuration := time.Since(t1)
+			if err == nil {
+				committed := 0
+				for j, i := range members {
+					if j < len(errs) && errs[j] != nil {
+						results[i].err = ReceiverError{errs[j]}
+						continue
+					}
+					results[i] = batchedCommitResult{finalRev: finalRev, duration: commitDuration}
+					committed++
+				}
+				gw.Log("commit_batch", gw.LogInfo).
+					Str("repository", b.repository).
+					Int("commits", committed).
+					Int("failed", len(commits)-committed).
+					Uint64("final_revision", finalRev).
+					Dur("duration", commitDuration).
+					Msg("batch committed")
+				return nil
+			}
+			if !errors.Is(err, receiver.ErrBatchNotSupported) && !errors.Is(err, receiver.ErrBatchNotMerged) {
+				for _, i := range members {
+					results[i].err = ReceiverError{err}
+				}
+				return nil
+			}
+		}
+
+		// The leases are committed one after the other, so that only the
+		// leases which cannot be merged fail
+		for j, c := range commits {
+			i := members[j]
+			t1 := time.Now()
+			finalRev, err := s.Pool.CommitLease(ctx, c.LeasePath, c.OldRootHash, c.NewRootHash, c.Tag)
+			results[i] = batchedCommitResult{finalRev: finalRev, duration: time.Since(t1)}
+			if err != nil {
+				results[i].err = ReceiverError{err}
+			}
+		}
+		return nil
+	})
+
+	for i, m := range b.members {
+		m.done <- results[i]
+	}
+}
+
+// checkBatchedLease returns InvalidLeaseError if the lease of a batched commit
+// was cancelled or expired while waiting for the batch
+func (s *Services) checkBatchedLease(ctx context.Context, token string) error {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	lease, err := FindLeaseByToken(ctx, tx, token)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Expiration.Before(time.Now()) {
+		return InvalidLeaseError{}
+	}
+	return nil
+}
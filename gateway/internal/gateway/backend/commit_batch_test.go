@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+)
+
+// commitConcurrently commits leases on the given paths at the same time and
+// returns the final revision and error of each commit
+func commitConcurrently(t *testing.T, backend *Services, paths []string) ([]uint64, []error) {
+	tokens := make([]string, len(paths))
+	for i, path := range paths {
+		token, err := backend.NewLease(context.TODO(), "keyid1", path, "host", 3)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		tokens[i] = token
+	}
+
+	revisions := make([]uint64, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		wg.Add(1)
+		go func(i int, token string) {
+			defer wg.Done()
+			revisions[i], errs[i] = backend.CommitLease(
+				context.TODO(), token, "old_hash", "new_hash", gw.RepositoryTag{})
+		}(i, token)
+	}
+	wg.Wait()
+	return revisions, errs
+}
+
+func TestCommitBatching(t *testing.T) {
+	backend, tmp := StartTestBackend("commit_batch_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.CommitBatching = []gw.CommitBatchConfig{{Repository: "test2.repo.org", Window: 200 * time.Millisecond}}
+	script := backend.Pool.MockScript()
+
+	paths := []string{"test2.repo.org/a", "test2.repo.org/b", "test2.repo.org/c"}
+	revisions, errs := commitConcurrently(t, backend, paths)
+	for i := range paths {
+		if errs[i] != nil {
+			t.Fatalf("could not commit lease on %v: %v", paths[i], errs[i])
+		}
+		if revisions[i] != revisions[0] {
+			t.Errorf("batched commits have different revisions: %v", revisions)
+		}
+	}
+	if _, commits := script.Counts(); commits != 1 {
+		t.Errorf("leases committed in %v receiver commits", commits)
+	}
+	leases, _ := backend.GetLeases(context.TODO())
+	if len(leases) != 0 {
+		t.Errorf("leases not removed after the commit: %v", leases)
+	}
+
+	// A failed commit does not prevent the other leases of the batch from
+	// being committed
+	script.FailCommits(1)
+	revisions, errs = commitConcurrently(t, backend, paths)
+	failed := 0
+	for i := range paths {
+		if errs[i] != nil {
+			failed++
+			if _, ok := errs[i].(ReceiverError); !ok {
+				t.Errorf("unexpected error: %v", errs[i])
+			}
+		} else if revisions[i] != 2 {
+			t.Errorf("unexpected revision of batched commit: %v", revisions[i])
+		}
+	}
+	if failed != 1 {
+		t.Errorf("unexpected number of failed commits: %v", failed)
+	}
+	for _, token := range leaseTokens(t, backend) {
+		backend.CancelLease(context.TODO(), token)
+	}
+
+	// A lease cancelled while waiting for the batch is not committed
+	token, err := backend.NewLease(context.TODO(), "keyid1", "test2.repo.org/a", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	_, commitsBefore := script.Counts()
+	commitErr := make(chan error, 1)
+	go func() {
+		_, err := backend.CommitLease(context.TODO(), token, "old_hash", "new_hash", gw.RepositoryTag{})
+		commitErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := backend.CancelLease(context.TODO(), token); err != nil {
+		t.Fatalf("could not cancel lease: %v", err)
+	}
+	if err := <-commitErr; !errors.Is(err, InvalidLeaseError{}) {
+		t.Errorf("unexpected error of the cancelled lease: %v", err)
+	}
+	if _, commits := script.Counts(); commits != commitsBefore {
+		t.Errorf("cancelled lease committed")
+	}
+
+	// The commits of the other repositories are not batched
+	backend.Config.CommitBatching = nil
+	revisions, errs = commitConcurrently(t, backend, paths)
+	seen := make(map[uint64]bool)
+	for i := range paths {
+		if errs[i] != nil {
+			t.Fatalf("could not commit lease on %v: %v", paths[i], errs[i])
+		}
+		seen[revisions[i]] = true
+	}
+	if len(seen) != len(paths) {
+		t.Errorf("unbatched commits share revisions: %v", revisions)
+	}
+}
+
+// leaseTokens returns the tokens of the active leases
+func leaseTokens(t *testing.T, backend *Services) []string {
+	tx, err := backend.DB.SQL.BeginTx(context.TODO(), nil)
+	if err != nil {
+		t.Fatalf("could not begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	leases, err := FindAllActiveLeases(context.TODO(), tx)
+	if err != nil {
+		t.Fatalf("could not list leases: %v", err)
+	}
+	tokens := make([]string, 0, len(leases))
+	for _, l := range leases {
+		tokens = append(tokens, l.Token)
+	}
+	return tokens
+}
+
+func TestCommitBatchOverlaps(t *testing.T) {
+	b := commitBatch{members: []batchedCommit{
+		{CommitRequest: receiver.CommitRequest{LeasePath: "test2.repo.org/a/b"}},
+	}}
+	for path, expected := range map[string]bool{
+		"test2.repo.org/a/b":   true,
+		"test2.repo.org/a/b/c": true,
+		"test2.repo.org/a":     true,
+		"test2.repo.org/a/bc":  false,
+		"test2.repo.org/a/c":   false,
+	} {
+		if b.overlaps(path) != expected {
+			t.Errorf("unexpected overlap of %v with the batch: %v", path, !expected)
+		}
+	}
+}
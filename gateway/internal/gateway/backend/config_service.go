@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// redactedSecret replaces the key secrets in the configuration report
+const redactedSecret = "xxxxx"
+
+// ConfigReport is the effective configuration of the gateway, with the secrets
+// redacted, and the problems found in the configuration files
+type ConfigReport struct {
+	Gateway      map[string]interface{}      `json:"gateway"`
+	Repositories map[string]RepositoryConfig `json:"repositories"`
+	Keys         map[string]KeyConfig        `json:"keys"`
+	Problems     []string                    `json:"problems,omitempty"`
+}
+
+// CheckConfig validates the gateway configuration and the repository access
+// configuration file. The access configuration is read again from the file.
+func CheckConfig(cfg gw.Config) *ConfigReport {
+	report := &ConfigReport{
+		Gateway:      cfg.Redacted(),
+		Repositories: make(map[string]RepositoryConfig),
+		Keys:         make(map[string]KeyConfig),
+		Problems:     append([]string{}, gw.CheckConfig(&cfg)...),
+	}
+	if _, err := NewHooks(cfg.Hooks, nil); err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	}
+
+	ac, err := NewAccessConfig(cfg.AccessConfigFile)
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+		return report
+	}
+	report.Problems = append(report.Problems, ac.Problems...)
+	report.Repositories = ac.Repositories
+	for id, key := range ac.Keys {
+		key.Secret = redactedSecret
+		report.Keys[id] = key
+	}
+
+	return report
+}
+
+// GetConfig returns the configuration of the running gateway, and the problems
+// found in the configuration files as they are now
+func (s *Services) GetConfig(ctx context.Context) (*ConfigReport, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_config", &outcome, t0)
+
+	report := CheckConfig(s.Config)
+	report.Repositories = s.Access.GetRepos()
+	report.Keys = make(map[string]KeyConfig)
+	for id, key := range s.Access.Keys {
+		key.Secret = redactedSecret
+		report.Keys[id] = key
+	}
+
+	return report, nil
+}
@@ -7,13 +7,12 @@ import (
 	"os"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
 	// latestSchemaVersion represents the most recent lease DB schema version
 	// known to the application
-	latestSchemaVersion = 3
+	latestSchemaVersion = 12
 )
 
 // DB stores active leases
@@ -33,7 +32,7 @@ func OpenDB(config gw.Config) (*DB, error) {
 		createDB = true
 	}
 
-	sqlDB, err := sql.Open("sqlite3", "file:"+dbFile+"?mode=rwc")
+	sqlDB, err := sql.Open(tracedDriverName, "file:"+dbFile+"?mode=rwc&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("could not open DB: %w", err)
 	}
@@ -44,9 +43,26 @@ func OpenDB(config gw.Config) (*DB, error) {
 		}
 	}
 
-	if _, err := checkSchemaVersion(sqlDB); err != nil {
+	version, err := schemaVersion(sqlDB)
+	if err != nil {
 		return nil, fmt.Errorf("invalid schema version: %w", err)
 	}
+	if !knownSchemaVersion(version) {
+		return nil, fmt.Errorf("invalid schema version: %w", SchemaError{version})
+	}
+	if version < latestSchemaVersion {
+		if !createDB {
+			backupFile, err := backupDB(sqlDB, dbFile, version)
+			if err != nil {
+				return nil, err
+			}
+			gw.Log("leasedb", gw.LogInfo).
+				Msgf("database backed up to %v before migration", backupFile)
+		}
+		if _, err := migrateSchema(sqlDB, latestSchemaVersion); err != nil {
+			return nil, err
+		}
+	}
 
 	gw.Log("leasedb", gw.LogInfo).
 		Msgf("database opened (work dir: %v)", config.WorkDir)
@@ -57,6 +73,45 @@ func OpenDB(config gw.Config) (*DB, error) {
 	}, nil
 }
 
+// SchemaStatus describes the schema of an existing lease DB
+type SchemaStatus struct {
+	// Version is the schema version of the DB, 0 if the DB does not exist
+	Version int
+	Latest  int
+	// Pending lists the descriptions of the migrations which will be applied
+	// when the DB is opened
+	Pending []string
+}
+
+// CheckSchema inspects the lease DB in the working directory, without
+// creating or migrating it
+func CheckSchema(config gw.Config) (*SchemaStatus, error) {
+	status := &SchemaStatus{Latest: latestSchemaVersion}
+	dbFile := config.WorkDir + "/gw.db"
+	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+		return status, nil
+	}
+
+	sqlDB, err := sql.Open("sqlite3", "file:"+dbFile+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("could not open DB: %w", err)
+	}
+	defer sqlDB.Close()
+
+	version, err := schemaVersion(sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	status.Version = version
+	if !knownSchemaVersion(version) {
+		return status, SchemaError{version}
+	}
+	for _, m := range pendingMigrations(version) {
+		status.Pending = append(status.Pending, fmt.Sprintf("%v: %v", m.Version, m.Description))
+	}
+	return status, nil
+}
+
 // Close the lease database
 func (db *DB) Close() error {
 	errs := make(map[string]error)
@@ -74,64 +129,5 @@ func (db *DB) Close() error {
 
 // WithLock runs the given task while holding a commit lock for the repository
 func (db *DB) WithLock(ctx context.Context, repository string, task func() error) error {
-	return db.Locks.WithLock(repository, task)
-}
-
-func createSchema(db *sql.DB) error {
-	statement := fmt.Sprintf(`
-create table SchemaVersion (
-    VersionNumber integer not null unique primary key,
-    ValidFrom timestamp not null,
-    ValidTo timestamp
-);
-insert into SchemaVersion (VersionNumber, ValidFrom) values (%v, datetime('now'));
-create table if not exists Lease (
-	Token string not null unique primary key,
-	Repository string not null,
-	Path string not null,
-	KeyID string not null,
-	Expiration integer not null,
-	ProtocolVersion integer not null,
-	Hostname string
-);
-create index lease_repository_path_idx ON Lease(Repository,Path);
-create table if not exists Repository (
-	Name string not null unique primary key,
-	Manifest string,
-	Enabled bool not null
-);
-`,
-		latestSchemaVersion)
-	if _, err := db.Exec(statement); err != nil {
-		return fmt.Errorf("could not create table 'SchemaVersion': %w", err)
-	}
-	return nil
-}
-
-func checkSchemaVersion(db *sql.DB) (int, error) {
-	var version int
-	if err := db.QueryRow(
-		"select VersionNumber from SchemaVersion;").Scan(&version); err != nil {
-		return 0, fmt.Errorf("could not retrieve schema version: %w", err)
-	}
-
-	if version > latestSchemaVersion {
-		return 0, fmt.Errorf(
-			"unknown schema version: %v, latest known %v",
-			version, latestSchemaVersion)
-	}
-
-	if version == 2 {
-		statement := `
-alter table lease add column hostname string;
-update SchemaVersion set VersionNumber=3, ValidFrom=datetime('now');
-`
-		if _, err := db.Exec(statement); err != nil {
-			return 2, fmt.Errorf("could not migrate table schema (2->3): %w", err)
-		}
-
-		version = 3
-	}
-
-	return version, nil
+	return db.Locks.WithLock(ctx, repository, task)
 }
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
+	"github.com/mattn/go-sqlite3"
+)
+
+// tracedDriverName is the SQLite driver recording the transactions of the
+// traced requests in spans
+const tracedDriverName = "sqlite3_traced"
+
+func init() {
+	sql.Register(tracedDriverName, tracedDriver{&sqlite3.SQLiteDriver{}})
+}
+
+type tracedDriver struct {
+	*sqlite3.SQLiteDriver
+}
+
+func (d tracedDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// tracedConn only overrides BeginTx, the other methods of the SQLite
+// connection are used as is
+type tracedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	_, span := tracing.StartChild(ctx, "db.transaction")
+	if span == nil {
+		return c.SQLiteConn.BeginTx(ctx, opts)
+	}
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, span: span}, nil
+}
+
+// tracedTx ends the span of the transaction
+type tracedTx struct {
+	driver.Tx
+	span *tracing.Span
+}
+
+func (t *tracedTx) Commit() error {
+	err := t.Tx.Commit()
+	t.span.SetAttribute("db.outcome", "commit")
+	t.span.SetError(err)
+	t.span.End()
+	return err
+}
+
+func (t *tracedTx) Rollback() error {
+	err := t.Tx.Rollback()
+	t.span.SetAttribute("db.outcome", "rollback")
+	t.span.SetError(err)
+	t.span.End()
+	return err
+}
@@ -22,6 +22,15 @@ func (s *Services) RunGC(ctx context.Context, options GCOptions) (string, error)
 
 	outcome := "success"
 	defer logAction(ctx, "garbage_collection", &outcome, t0)
+	defer func() {
+		s.activity.addGCRun(GCRunDTO{
+			Repository: options.Repository,
+			DryRun:     options.DryRun,
+			Outcome:    outcome,
+			Time:       t0.UTC().Format(time.RFC3339),
+			DurationMs: time.Since(t0).Milliseconds(),
+		})
+	}()
 
 	baseArgs := []string{"gc", "-f"}
 	if options.NumRevisions != 0 {
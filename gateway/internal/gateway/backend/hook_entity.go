@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// Status of the hook runs
+const (
+	HookRunning = "running"
+	HookSuccess = "success"
+	HookFailed  = "failed"
+	HookVetoed  = "vetoed"
+)
+
+// HookRun is the record of a commit hook run for a publication
+type HookRun struct {
+	ID         int64
+	Hook       string
+	Stage      string
+	Repository string
+	LeasePath  string
+	// Revision is the revision created by the commit (0 for pre-commit hooks)
+	Revision uint64
+	Status   string
+	Attempts int
+	Output   string
+	Started  time.Time
+	Finished time.Time
+}
+
+func CreateHookRun(ctx context.Context, tx *sql.Tx, run *HookRun) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"insert into HookRun (Hook, Stage, Repository, LeasePath, Revision, Status, Attempts, Output, Started, Finished) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+		run.Hook, run.Stage, run.Repository, run.LeasePath, run.Revision, run.Status,
+		run.Attempts, run.Output, unixMilli(run.Started), unixMilli(run.Finished))
+	if err != nil {
+		return fmt.Errorf("could not insert new hook run: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("could not retrieve hook run ID: %w", err)
+	}
+	run.ID = id
+
+	gw.LogC(ctx, "hook_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("hook: %v, repository: %v, status: %v", run.Hook, run.Repository, run.Status)
+
+	return nil
+}
+
+func UpdateHookRun(ctx context.Context, tx *sql.Tx, run HookRun) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"update HookRun set Status = ?, Attempts = ?, Output = ?, Finished = ? where ID = ?;",
+		run.Status, run.Attempts, run.Output, unixMilli(run.Finished), run.ID)
+	if err != nil {
+		return fmt.Errorf("could not update hook run: %w", err)
+	}
+	numUpdates, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numUpdates != 1 {
+		return fmt.Errorf("hook run not updated")
+	}
+
+	gw.LogC(ctx, "hook_entity", gw.LogDebug).
+		Str("operation", "update").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("id: %v, status: %v", run.ID, run.Status)
+
+	return nil
+}
+
+// FindHookRunsByRepository returns the most recent hook runs of a repository
+func FindHookRunsByRepository(ctx context.Context, tx *sql.Tx, repository string, limit int) ([]HookRun, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx,
+		"select * from HookRun where Repository = ? order by ID desc limit ?;", repository, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	runs := make([]HookRun, 0)
+	for rows.Next() {
+		var run HookRun
+		if err := scanHookRun(rows, &run); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	gw.LogC(ctx, "hook_entity", gw.LogDebug).
+		Str("operation", "find_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v hook runs", len(runs))
+
+	return runs, nil
+}
+
+func scanHookRun(rows *sql.Rows, run *HookRun) error {
+	var startedMilli, finishedMilli int64
+	if err := rows.Scan(
+		&run.ID,
+		&run.Hook,
+		&run.Stage,
+		&run.Repository,
+		&run.LeasePath,
+		&run.Revision,
+		&run.Status,
+		&run.Attempts,
+		&run.Output,
+		&startedMilli,
+		&finishedMilli); err != nil {
+		return err
+	}
+
+	run.Started = fromUnixMilli(startedMilli)
+	run.Finished = fromUnixMilli(finishedMilli)
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxHookRuns is the number of hook runs returned by GetHookRuns
+const maxHookRuns = 100
+
+// HookRunDTO is the hook run information returned to the HTTP frontend
+type HookRunDTO struct {
+	Hook      string `json:"hook"`
+	Stage     string `json:"stage"`
+	LeasePath string `json:"lease_path"`
+	Revision  uint64 `json:"revision,omitempty"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	Output    string `json:"output,omitempty"`
+	Started   string `json:"started"`
+	Finished  string `json:"finished,omitempty"`
+}
+
+// GetHookRuns returns the most recent commit hook runs of a repository
+func (s *Services) GetHookRuns(ctx context.Context, repository string) ([]HookRunDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_hook_runs", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	runs, err := FindHookRunsByRepository(ctx, tx, repository, maxHookRuns)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := make([]HookRunDTO, 0, len(runs))
+	for _, r := range runs {
+		dto := HookRunDTO{
+			Hook:      r.Hook,
+			Stage:     r.Stage,
+			LeasePath: r.LeasePath,
+			Revision:  r.Revision,
+			Status:    r.Status,
+			Attempts:  r.Attempts,
+			Output:    r.Output,
+			Started:   r.Started.String(),
+		}
+		if !r.Finished.IsZero() {
+			dto.Finished = r.Finished.String()
+		}
+		ret = append(ret, dto)
+	}
+
+	return ret, nil
+}
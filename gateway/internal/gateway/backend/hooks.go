@@ -0,0 +1,311 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// defaultHookTimeout is used for the hooks configured without a timeout
+const defaultHookTimeout = 30 * time.Second
+
+// maxHookOutput is the maximum size of the hook output which is recorded and
+// returned to clients
+const maxHookOutput = 4096
+
+// hookRetryDelay is the delay before the first retry of a failed post-commit
+// hook, doubled after each attempt
+var hookRetryDelay = time.Second
+
+// HookEvent is the description of a commit sent to the hooks
+type HookEvent struct {
+	Stage       string           `json:"stage"`
+	Repository  string           `json:"repository"`
+	LeasePath   string           `json:"lease_path"`
+	KeyID       string           `json:"key_id"`
+	Hostname    string           `json:"hostname,omitempty"`
+	OldRootHash string           `json:"old_root_hash"`
+	NewRootHash string           `json:"new_root_hash"`
+	Tag         gw.RepositoryTag `json:"tag"`
+	Statistics  stats.Statistics `json:"statistics"`
+	// Revision is the revision created by the commit (post-commit hooks only)
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// HookVetoError is returned when a pre-commit hook rejects a commit
+type HookVetoError struct {
+	Hook   string
+	Reason string
+}
+
+func (e HookVetoError) Error() string {
+	return fmt.Sprintf("commit vetoed by hook %v: %v", e.Hook, e.Reason)
+}
+
+// Hooks runs the commit hooks and records their outcome in the lease DB
+type Hooks struct {
+	hooks   []gw.HookConfig
+	db      *DB
+	running sync.WaitGroup
+	// stop interrupts the retries of the post-commit hooks
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewHooks validates the hook configuration
+func NewHooks(hooks []gw.HookConfig, db *DB) (*Hooks, error) {
+	h := &Hooks{db: db, stop: make(chan struct{})}
+	for _, hook := range hooks {
+		if hook.Name == "" {
+			return nil, fmt.Errorf("hook without a name")
+		}
+		if hook.Stage != gw.PreCommit && hook.Stage != gw.PostCommit {
+			return nil, fmt.Errorf("invalid stage for hook %v: %q", hook.Name, hook.Stage)
+		}
+		if (hook.Command == "") == (hook.URL == "") {
+			return nil, fmt.Errorf("hook %v needs either a command or a URL", hook.Name)
+		}
+		if hook.Timeout <= 0 {
+			hook.Timeout = defaultHookTimeout
+		}
+		h.hooks = append(h.hooks, hook)
+	}
+
+	return h, nil
+}
+
+// RunPreCommit runs the pre-commit hooks of the repository, in order. The
+// first hook which fails vetoes the commit.
+func (h *Hooks) RunPreCommit(ctx context.Context, event HookEvent) error {
+	event.Stage = gw.PreCommit
+	for _, hook := range h.selectHooks(gw.PreCommit, event.Repository) {
+		run := HookRun{
+			Hook:       hook.Name,
+			Stage:      hook.Stage,
+			Repository: event.Repository,
+			LeasePath:  event.LeasePath,
+			Attempts:   1,
+			Started:    time.Now(),
+		}
+		output, err := runHook(ctx, hook, event)
+		run.Finished = time.Now()
+		run.Output = output
+		run.Status = HookSuccess
+		if err != nil {
+			run.Status = HookVetoed
+		}
+		h.record(ctx, &run)
+
+		if err != nil {
+			reason := output
+			if reason == "" {
+				reason = err.Error()
+			}
+			return HookVetoError{Hook: hook.Name, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// RunPostCommit starts the post-commit hooks of the repository in the
+// background. Failed hooks are retried.
+func (h *Hooks) RunPostCommit(event HookEvent) {
+	event.Stage = gw.PostCommit
+	for _, hook := range h.selectHooks(gw.PostCommit, event.Repository) {
+		h.running.Add(1)
+		go func(hook gw.HookConfig) {
+			defer h.running.Done()
+			h.runWithRetries(context.Background(), hook, event)
+		}(hook)
+	}
+}
+
+// Wait for the post-commit hooks which are still running
+func (h *Hooks) Wait() {
+	h.running.Wait()
+}
+
+// Stop the retries of the post-commit hooks and wait for the attempts which
+// are still running. The interrupted hooks are recorded as failed.
+func (h *Hooks) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+	h.running.Wait()
+}
+
+func (h *Hooks) runWithRetries(ctx context.Context, hook gw.HookConfig, event HookEvent) {
+	run := HookRun{
+		Hook:       hook.Name,
+		Stage:      hook.Stage,
+		Repository: event.Repository,
+		LeasePath:  event.LeasePath,
+		Revision:   event.Revision,
+		Status:     HookRunning,
+		Started:    time.Now(),
+	}
+	h.record(ctx, &run)
+
+	delay := hookRetryDelay
+	for {
+		output, err := runHook(ctx, hook, event)
+		run.Attempts++
+		run.Output = output
+		run.Finished = time.Now()
+		if err == nil {
+			run.Status = HookSuccess
+			break
+		}
+		if output == "" {
+			run.Output = err.Error()
+		}
+		gw.Log("hooks", gw.LogError).
+			Err(err).
+			Str("hook", hook.Name).
+			Str("repository", event.Repository).
+			Int("attempt", run.Attempts).
+			Msg("post-commit hook failed")
+		if run.Attempts > hook.Retries {
+			run.Status = HookFailed
+			break
+		}
+		h.record(ctx, &run)
+		if !h.backoff(ctx, delay) {
+			run.Status = HookFailed
+			run.Output = "retries interrupted: " + run.Output
+			break
+		}
+		delay *= 2
+	}
+	h.record(ctx, &run)
+}
+
+// backoff waits before the next attempt of a hook. It returns false if the
+// hooks are stopped or the context is done in the meantime.
+func (h *Hooks) backoff(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-h.stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (h *Hooks) selectHooks(stage, repository string) []gw.HookConfig {
+	selected := make([]gw.HookConfig, 0)
+	for _, hook := range h.hooks {
+		if hook.Stage != stage {
+			continue
+		}
+		if len(hook.Repositories) == 0 {
+			selected = append(selected, hook)
+			continue
+		}
+		for _, r := range hook.Repositories {
+			if r == repository {
+				selected = append(selected, hook)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// record creates or updates the record of a hook run. Failures are only
+// logged, since they must not affect the publication.
+func (h *Hooks) record(ctx context.Context, run *HookRun) {
+	err := func() error {
+		tx, err := h.db.SQL.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("could not begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if run.ID == 0 {
+			err = CreateHookRun(ctx, tx, run)
+		} else {
+			err = UpdateHookRun(ctx, tx, *run)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		gw.LogC(ctx, "hooks", gw.LogError).
+			Err(err).
+			Str("hook", run.Hook).
+			Msg("could not record hook run")
+	}
+}
+
+// runHook runs a hook once and returns its output
+func runHook(ctx context.Context, hook gw.HookConfig, event HookEvent) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+
+	input, err := json.Marshal(event)
+	if err != nil {
+		return "", fmt.Errorf("could not encode hook event: %w", err)
+	}
+
+	if hook.URL != "" {
+		return runHTTPHook(ctx, hook.URL, input)
+	}
+
+	cmd := exec.CommandContext(ctx, hook.Command, event.Repository)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return truncateOutput(output), fmt.Errorf("hook timed out after %v", hook.Timeout)
+	}
+	return truncateOutput(output), err
+}
+
+func runHTTPHook(ctx context.Context, url string, input []byte) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(input))
+	if err != nil {
+		return "", fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("hook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	output := truncateOutput(body)
+	// The reason can be given in the "reason" field of a JSON reply
+	var reply struct {
+		Reason string `json:"reason"`
+	}
+	if json.Unmarshal(body, &reply) == nil && reply.Reason != "" {
+		output = reply.Reason
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return output, fmt.Errorf("hook replied with: %v", resp.Status)
+	}
+	return output, nil
+}
+
+func truncateOutput(output []byte) string {
+	if len(output) > maxHookOutput {
+		output = output[:maxHookOutput]
+	}
+	return strings.TrimSpace(string(output))
+}
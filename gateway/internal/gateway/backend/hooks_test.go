@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// vetoScript rejects the commits of the leases below "/forbidden"
+const vetoScript = `#!/bin/sh
+if grep -q '"lease_path":"test2.repo.org/forbidden' ; then
+	echo "publishing to /forbidden is not allowed for $1"
+	exit 1
+fi
+`
+
+func TestHooksConfiguration(t *testing.T) {
+	invalid := [][]gw.HookConfig{
+		{{Name: "", Stage: gw.PreCommit, Command: "/bin/true"}},
+		{{Name: "h", Stage: "during_commit", Command: "/bin/true"}},
+		{{Name: "h", Stage: gw.PreCommit}},
+		{{Name: "h", Stage: gw.PreCommit, Command: "/bin/true", URL: "http://localhost"}},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewHooks(cfg, nil); err == nil {
+			t.Errorf("invalid hook configuration accepted: %+v", cfg)
+		}
+	}
+}
+
+func TestHooksCommit(t *testing.T) {
+	backend, tmp := StartTestBackend("hooks_commit_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	hookRetryDelay = 10 * time.Millisecond
+
+	scriptPath := path.Join(tmp, "veto.sh")
+	if err := os.WriteFile(scriptPath, []byte(vetoScript), 0755); err != nil {
+		t.Fatalf("could not write hook script: %v", err)
+	}
+
+	// The post-commit endpoint fails on the first request
+	var mu sync.Mutex
+	var events []HookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var event HookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		if len(events) == 1 {
+			http.Error(w, "snapshot failed", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	hooks, err := NewHooks([]gw.HookConfig{
+		{Name: "veto", Stage: gw.PreCommit, Command: scriptPath},
+		{Name: "other_repo", Stage: gw.PreCommit, Command: "/bin/false", Repositories: []string{"test1.repo.org"}},
+		{Name: "snapshot", Stage: gw.PostCommit, URL: server.URL, Retries: 2},
+	}, backend.DB)
+	if err != nil {
+		t.Fatalf("could not configure hooks: %v", err)
+	}
+	backend.Hooks = hooks
+
+	ctx := context.TODO()
+
+	t.Run("veto", func(t *testing.T) {
+		token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/forbidden", "host", 3)
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
+		}
+		defer backend.CancelLease(ctx, token)
+
+		_, err = backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{})
+		var veto HookVetoError
+		if !errors.As(err, &veto) || veto.Hook != "veto" ||
+			veto.Reason != "publishing to /forbidden is not allowed for test2.repo.org" {
+			t.Fatalf("commit should have been vetoed: %v", err)
+		}
+		if _, err := backend.GetLease(ctx, token); err != nil {
+			t.Errorf("lease should survive a vetoed commit: %v", err)
+		}
+	})
+
+	t.Run("post-commit retries", func(t *testing.T) {
+		token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/allowed", "host", 3)
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
+		}
+		rev, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{Name: "v1"})
+		if err != nil {
+			t.Fatalf("could not commit lease: %v", err)
+		}
+		backend.Hooks.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(events) != 2 {
+			t.Fatalf("post-commit hook should have been retried once: %v", len(events))
+		}
+		if e := events[1]; e.Stage != gw.PostCommit || e.Revision != rev ||
+			e.KeyID != "keyid1" || e.Hostname != "host" || e.NewRootHash != "new_hash" || e.Tag.Name != "v1" {
+			t.Errorf("unexpected hook event: %+v", e)
+		}
+	})
+
+	t.Run("runs recorded", func(t *testing.T) {
+		runs, err := backend.GetHookRuns(ctx, "test2.repo.org")
+		if err != nil {
+			t.Fatalf("could not retrieve hook runs: %v", err)
+		}
+		status := make(map[string]HookRunDTO)
+		for _, r := range runs {
+			if _, present := status[r.Hook]; !present {
+				status[r.Hook] = r
+			}
+		}
+		if r := status["snapshot"]; r.Status != HookSuccess || r.Attempts != 2 || r.Revision == 0 {
+			t.Errorf("unexpected post-commit hook run: %+v", r)
+		}
+		if r := status["veto"]; r.Status != HookSuccess {
+			t.Errorf("unexpected pre-commit hook run: %+v", r)
+		}
+		if _, present := status["other_repo"]; present {
+			t.Errorf("hook of another repository should not run")
+		}
+		if len(runs) != 3 {
+			t.Errorf("unexpected hook runs: %+v", runs)
+		}
+	})
+}
+
+func TestHooksStop(t *testing.T) {
+	backend, tmp := StartTestBackend("hooks_stop_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	defer func(delay time.Duration) { hookRetryDelay = delay }(hookRetryDelay)
+	hookRetryDelay = time.Hour
+
+	attempted := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempted <- struct{}{}
+		http.Error(w, "snapshot failed", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	hooks, err := NewHooks([]gw.HookConfig{
+		{Name: "snapshot", Stage: gw.PostCommit, URL: server.URL, Retries: 3},
+	}, backend.DB)
+	if err != nil {
+		t.Fatalf("could not configure hooks: %v", err)
+	}
+	backend.Hooks = hooks
+
+	hooks.RunPostCommit(HookEvent{Repository: "test2.repo.org", LeasePath: "test2.repo.org/some/path", Revision: 2})
+	<-attempted
+
+	stopped := make(chan struct{})
+	go func() {
+		hooks.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stopping the hooks should interrupt the retry backoff")
+	}
+
+	runs, err := backend.GetHookRuns(context.TODO(), "test2.repo.org")
+	if err != nil {
+		t.Fatalf("could not retrieve hook runs: %v", err)
+	}
+	if len(runs) != 1 || runs[0].Status != HookFailed || runs[0].Attempts != 1 {
+		t.Errorf("unexpected interrupted hook run: %+v", runs)
+	}
+}
@@ -0,0 +1,286 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// Status of the API keys managed through the gateway API
+const (
+	KeyActive   = "active"
+	KeyDisabled = "disabled"
+	KeyRevoked  = "revoked"
+)
+
+// ErrKeyNotFound is returned when a managed key does not exist
+var ErrKeyNotFound = fmt.Errorf("key_not_found")
+
+// ErrKeyExists is returned when creating a key with an ID already in use
+var ErrKeyExists = fmt.Errorf("key_exists")
+
+// ErrInvalidKeyID is returned when creating a key with a malformed ID
+var ErrInvalidKeyID = fmt.Errorf("invalid_key_id")
+
+// ErrStaticKey is returned when modifying a key from the access configuration
+// file, which can only be changed by editing the file
+var ErrStaticKey = fmt.Errorf("static_key")
+
+// ErrKeyRevoked is returned when modifying a revoked key
+var ErrKeyRevoked = fmt.Errorf("key_revoked")
+
+// APIKey is an API key managed through the gateway API. The secrets are
+// stored encrypted with the SecretBox of the services.
+type APIKey struct {
+	ID     string
+	Secret string
+	// PreviousSecret is the secret replaced by the last rotation, which is
+	// still accepted until PreviousExpiration
+	PreviousSecret     string
+	PreviousExpiration time.Time
+	Admin              bool
+	Status             string
+	Created            time.Time
+	Rotated            time.Time
+}
+
+// KeyBinding grants a key access to a repository subpath
+type KeyBinding struct {
+	KeyID      string
+	Repository string
+	Path       string
+}
+
+func CreateAPIKey(ctx context.Context, tx *sql.Tx, key APIKey) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"insert into ApiKey (ID, Secret, PreviousSecret, PreviousExpiration, Admin, Status, Created, Rotated) values (?, ?, ?, ?, ?, ?, ?, ?);",
+		key.ID, key.Secret, key.PreviousSecret, unixMilli(key.PreviousExpiration),
+		key.Admin, key.Status, unixMilli(key.Created), unixMilli(key.Rotated))
+	if err != nil {
+		return fmt.Errorf("could not insert new key: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numInserts == 0 {
+		return fmt.Errorf("new key not inserted")
+	}
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("id: %v, admin: %v", key.ID, key.Admin)
+
+	return nil
+}
+
+func UpdateAPIKey(ctx context.Context, tx *sql.Tx, key APIKey) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"update ApiKey set Secret = ?, PreviousSecret = ?, PreviousExpiration = ?, Admin = ?, Status = ?, Rotated = ? where ID = ?;",
+		key.Secret, key.PreviousSecret, unixMilli(key.PreviousExpiration),
+		key.Admin, key.Status, unixMilli(key.Rotated), key.ID)
+	if err != nil {
+		return fmt.Errorf("could not update key: %w", err)
+	}
+	numUpdates, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numUpdates != 1 {
+		return fmt.Errorf("key not updated")
+	}
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "update").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("id: %v, status: %v", key.ID, key.Status)
+
+	return nil
+}
+
+func FindAPIKeyByID(ctx context.Context, tx *sql.Tx, id string) (*APIKey, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from ApiKey where ID = ?;", id)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var key *APIKey
+	if rows.Next() {
+		key = &APIKey{}
+		if err := scanAPIKey(rows, key); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	}
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "find_by_id").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("id: %v, found: %v", id, key != nil)
+
+	return key, nil
+}
+
+func FindAllAPIKeys(ctx context.Context, tx *sql.Tx) ([]APIKey, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from ApiKey order by ID;")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		var key APIKey
+		if err := scanAPIKey(rows, &key); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "find_all").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v keys", len(keys))
+
+	return keys, nil
+}
+
+// CreateKeyBinding binds a key to a repository subpath, replacing an existing
+// binding of the key to the same repository
+func CreateKeyBinding(ctx context.Context, tx *sql.Tx, binding KeyBinding) error {
+	t0 := time.Now()
+
+	if _, err := tx.ExecContext(ctx,
+		"insert or replace into KeyBinding (KeyID, Repository, Path) values (?, ?, ?);",
+		binding.KeyID, binding.Repository, binding.Path); err != nil {
+		return fmt.Errorf("could not insert key binding: %w", err)
+	}
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "create_binding").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, repository: %v, path: %v", binding.KeyID, binding.Repository, binding.Path)
+
+	return nil
+}
+
+func FindAllKeyBindings(ctx context.Context, tx *sql.Tx) ([]KeyBinding, error) {
+	return findKeyBindings(ctx, tx, "select * from KeyBinding order by KeyID, Repository;")
+}
+
+func FindAllKeyBindingsByKey(ctx context.Context, tx *sql.Tx, keyID string) ([]KeyBinding, error) {
+	return findKeyBindings(ctx, tx,
+		"select * from KeyBinding where KeyID = ? order by Repository;", keyID)
+}
+
+func FindAllKeyBindingsByRepository(ctx context.Context, tx *sql.Tx, repository string) ([]KeyBinding, error) {
+	return findKeyBindings(ctx, tx,
+		"select * from KeyBinding where Repository = ? order by KeyID;", repository)
+}
+
+func DeleteKeyBinding(ctx context.Context, tx *sql.Tx, keyID, repository string) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"delete from KeyBinding where KeyID = ? and Repository = ?;", keyID, repository)
+	if err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "delete_binding").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, repository: %v, deleted: %v", keyID, repository, numDeleted)
+
+	return nil
+}
+
+func DeleteAllKeyBindingsByKey(ctx context.Context, tx *sql.Tx, keyID string) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx, "delete from KeyBinding where KeyID = ?;", keyID)
+	if err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "delete_all_bindings_by_key").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("key: %v, deleted: %v", keyID, numDeleted)
+
+	return nil
+}
+
+func DeleteAllKeyBindingsByRepository(ctx context.Context, tx *sql.Tx, repository string) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx, "delete from KeyBinding where Repository = ?;", repository)
+	if err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "delete_all_bindings_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repository: %v, deleted: %v", repository, numDeleted)
+
+	return nil
+}
+
+func findKeyBindings(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]KeyBinding, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	bindings := make([]KeyBinding, 0)
+	for rows.Next() {
+		var b KeyBinding
+		if err := rows.Scan(&b.KeyID, &b.Repository, &b.Path); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		bindings = append(bindings, b)
+	}
+
+	gw.LogC(ctx, "key_entity", gw.LogDebug).
+		Str("operation", "find_bindings").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v bindings", len(bindings))
+
+	return bindings, nil
+}
+
+func scanAPIKey(rows *sql.Rows, key *APIKey) error {
+	var prevExpMilli, createdMilli, rotatedMilli int64
+	if err := rows.Scan(
+		&key.ID,
+		&key.Secret,
+		&key.PreviousSecret,
+		&prevExpMilli,
+		&key.Admin,
+		&key.Status,
+		&createdMilli,
+		&rotatedMilli); err != nil {
+		return err
+	}
+
+	key.PreviousExpiration = fromUnixMilli(prevExpMilli)
+	key.Created = fromUnixMilli(createdMilli)
+	key.Rotated = fromUnixMilli(rotatedMilli)
+
+	return nil
+}
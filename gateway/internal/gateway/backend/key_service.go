@@ -0,0 +1,573 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Sources of the API keys
+const (
+	KeySourceConfig = "config"
+	KeySourceAPI    = "api"
+)
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// KeyBindingDTO is a repository subpath where a key is valid
+type KeyBindingDTO struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+}
+
+// KeyDTO is the key information returned to the HTTP frontend. Secrets are
+// never included.
+type KeyDTO struct {
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Admin           bool            `json:"admin"`
+	Status          string          `json:"status"`
+	Created         string          `json:"created,omitempty"`
+	Rotated         string          `json:"rotated,omitempty"`
+	PreviousExpires string          `json:"previous_secret_expires,omitempty"`
+	Bindings        []KeyBindingDTO `json:"bindings"`
+}
+
+// NewKeyOptions are the parameters of a key created through the API
+type NewKeyOptions struct {
+	// ID of the key, generated if empty
+	ID       string          `json:"id"`
+	Admin    bool            `json:"admin"`
+	Bindings []KeyBindingDTO `json:"bindings"`
+}
+
+// NewKey creates a managed API key and returns its ID and secret. The secret
+// can not be retrieved later.
+func (s *Services) NewKey(ctx context.Context, options NewKeyOptions) (string, string, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "new_key", &outcome, t0)
+
+	id := options.ID
+	if id == "" {
+		var err error
+		if id, err = newKeyID(); err != nil {
+			outcome = err.Error()
+			return "", "", err
+		}
+	}
+	if !keyIDPattern.MatchString(id) {
+		err := fmt.Errorf("%w: %v", ErrInvalidKeyID, id)
+		outcome = err.Error()
+		return "", "", err
+	}
+	if s.Access.GetKeyConfig(id) != nil {
+		outcome = ErrKeyExists.Error()
+		return "", "", ErrKeyExists
+	}
+
+	secret, err := newKeySecret()
+	if err != nil {
+		outcome = err.Error()
+		return "", "", err
+	}
+	sealed, err := s.Secrets.Seal(secret)
+	if err != nil {
+		outcome = err.Error()
+		return "", "", err
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := FindAPIKeyByID(ctx, tx, id)
+	if err != nil {
+		outcome = err.Error()
+		return "", "", err
+	}
+	if existing != nil {
+		outcome = ErrKeyExists.Error()
+		return "", "", ErrKeyExists
+	}
+
+	key := APIKey{
+		ID:      id,
+		Secret:  sealed,
+		Admin:   options.Admin,
+		Status:  KeyActive,
+		Created: t0,
+	}
+	if err := CreateAPIKey(ctx, tx, key); err != nil {
+		outcome = err.Error()
+		return "", "", err
+	}
+
+	for _, b := range options.Bindings {
+		if err := s.bindKey(ctx, tx, id, b.Repository, b.Path); err != nil {
+			outcome = err.Error()
+			return "", "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", "", fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	outcome = fmt.Sprintf("success: %v", id)
+	return id, secret, nil
+}
+
+// GetKeys returns all the keys known to the gateway, from the access
+// configuration file and managed through the API
+func (s *Services) GetKeys(ctx context.Context) ([]KeyDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_keys", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	keys, err := FindAllAPIKeys(ctx, tx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+	bindings, err := FindAllKeyBindings(ctx, tx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := s.staticKeys(bindings)
+	for _, key := range keys {
+		ret = append(ret, newKeyDTO(key, bindings))
+	}
+
+	return ret, nil
+}
+
+// GetKeyInfo returns the information about a key
+func (s *Services) GetKeyInfo(ctx context.Context, keyID string) (*KeyDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_key_info", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	bindings, err := FindAllKeyBindingsByKey(ctx, tx, keyID)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if s.Access.GetKeyConfig(keyID) != nil {
+		for _, k := range s.staticKeys(bindings) {
+			if k.ID == keyID {
+				return &k, nil
+			}
+		}
+	}
+
+	key, err := FindAPIKeyByID(ctx, tx, keyID)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+	if key == nil {
+		outcome = ErrKeyNotFound.Error()
+		return nil, ErrKeyNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	dto := newKeyDTO(*key, bindings)
+	return &dto, nil
+}
+
+// RotateKey replaces the secret of a managed key and returns the new secret.
+// The previous secret is still accepted during the overlap period.
+func (s *Services) RotateKey(ctx context.Context, keyID string, overlap time.Duration) (string, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "rotate_key", &outcome, t0)
+
+	secret, err := newKeySecret()
+	if err != nil {
+		outcome = err.Error()
+		return "", err
+	}
+	sealed, err := s.Secrets.Seal(secret)
+	if err != nil {
+		outcome = err.Error()
+		return "", err
+	}
+
+	if err := s.updateKey(ctx, keyID, func(key *APIKey) {
+		if overlap > 0 {
+			key.PreviousSecret = key.Secret
+			key.PreviousExpiration = t0.Add(overlap)
+		} else {
+			key.PreviousSecret = ""
+			key.PreviousExpiration = time.Time{}
+		}
+		key.Secret = sealed
+		key.Rotated = t0
+	}); err != nil {
+		outcome = err.Error()
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// SetKeyEnabled enables or disables a managed key. Disabled keys are rejected
+// but keep their secret and bindings.
+func (s *Services) SetKeyEnabled(ctx context.Context, keyID string, enable bool) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "set_key_enabled", &outcome, t0)
+
+	if err := s.updateKey(ctx, keyID, func(key *APIKey) {
+		key.Status = KeyDisabled
+		if enable {
+			key.Status = KeyActive
+		}
+	}); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+// RevokeKey permanently revokes a managed key: its secrets and bindings are
+// deleted, and the key ID can not be reused
+func (s *Services) RevokeKey(ctx context.Context, keyID string) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "revoke_key", &outcome, t0)
+
+	if err := s.updateKey(ctx, keyID, func(key *APIKey) {
+		key.Status = KeyRevoked
+		key.Secret = ""
+		key.PreviousSecret = ""
+		key.PreviousExpiration = time.Time{}
+	}); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+// BindKey grants a managed key access to a repository subpath, replacing the
+// existing binding to the repository
+func (s *Services) BindKey(ctx context.Context, keyID, repository, path string) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "bind_key", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.findManagedKey(ctx, tx, keyID); err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if err := s.bindKey(ctx, tx, keyID, repository, path); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UnbindKey removes the access of a managed key to a repository
+func (s *Services) UnbindKey(ctx context.Context, keyID, repository string) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "unbind_key", &outcome, t0)
+
+	if s.Access.GetKeyConfig(keyID) != nil {
+		outcome = ErrStaticKey.Error()
+		return ErrStaticKey
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := DeleteKeyBinding(ctx, tx, keyID, repository); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// getManagedKeyConfig returns the configuration of an active managed key, or
+// nil if the key does not exist or is not active
+func (s *Services) getManagedKeyConfig(ctx context.Context, keyID string) (*KeyConfig, error) {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	key, err := FindAPIKeyByID(ctx, tx, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	if key == nil || key.Status != KeyActive {
+		return nil, nil
+	}
+
+	secret, err := s.Secrets.Open(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &KeyConfig{Secret: secret, Admin: key.Admin}
+	if key.PreviousSecret != "" && time.Now().Before(key.PreviousExpiration) {
+		if cfg.PreviousSecret, err = s.Secrets.Open(key.PreviousSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// checkAccess verifies that a key can acquire a lease on the subpath of a
+// repository, according to the access configuration file or to the key
+// bindings stored in the lease DB
+func (s *Services) checkAccess(ctx context.Context, tx *sql.Tx, keyID, path, repo string) error {
+	authErr := s.Access.Check(keyID, path, repo)
+	if authErr == nil {
+		return nil
+	}
+	if s.Access.GetKeyConfig(keyID) == nil {
+		key, err := FindAPIKeyByID(ctx, tx, keyID)
+		if err != nil {
+			return err
+		}
+		if key == nil || key.Status != KeyActive {
+			return authErr
+		}
+	}
+
+	bindings, err := FindAllKeyBindingsByKey(ctx, tx, keyID)
+	if err != nil {
+		return err
+	}
+	for _, b := range bindings {
+		if b.Repository != repo {
+			continue
+		}
+		if pathAllowed(path, b.Path) {
+			return nil
+		}
+		return &AuthError{"invalid_path"}
+	}
+
+	return authErr
+}
+
+// checkRepoAccess verifies that a key is bound to a repository, on any of its
+// subpaths
+func (s *Services) checkRepoAccess(ctx context.Context, tx *sql.Tx, keyID, repo string) error {
+	err := s.checkAccess(ctx, tx, keyID, "/", repo)
+	var authErr *AuthError
+	if errors.As(err, &authErr) && authErr.Reason == "invalid_path" {
+		return nil
+	}
+	return err
+}
+
+// findManagedKey returns a managed key which can be modified
+func (s *Services) findManagedKey(ctx context.Context, tx *sql.Tx, keyID string) (*APIKey, error) {
+	if s.Access.GetKeyConfig(keyID) != nil {
+		return nil, ErrStaticKey
+	}
+	key, err := FindAPIKeyByID(ctx, tx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrKeyNotFound
+	}
+	if key.Status == KeyRevoked {
+		return nil, ErrKeyRevoked
+	}
+	return key, nil
+}
+
+// updateKey applies a modification to a managed key
+func (s *Services) updateKey(ctx context.Context, keyID string, update func(key *APIKey)) error {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	key, err := s.findManagedKey(ctx, tx, keyID)
+	if err != nil {
+		return err
+	}
+
+	update(key)
+	if err := UpdateAPIKey(ctx, tx, *key); err != nil {
+		return err
+	}
+	if key.Status == KeyRevoked {
+		if err := DeleteAllKeyBindingsByKey(ctx, tx, keyID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Services) bindKey(ctx context.Context, tx *sql.Tx, keyID, repository, path string) error {
+	repo, err := FindRepositoryByName(ctx, tx, repository)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		return fmt.Errorf("%w: %v", ErrRepoNotFound, repository)
+	}
+	if path == "" {
+		path = "/"
+	}
+	if path[0] != '/' {
+		path = "/" + path
+	}
+	return CreateKeyBinding(ctx, tx, KeyBinding{KeyID: keyID, Repository: repository, Path: path})
+}
+
+// staticKeys returns the keys of the access configuration file, with their
+// bindings from the file and from the lease DB
+func (s *Services) staticKeys(bindings []KeyBinding) []KeyDTO {
+	keys := make([]KeyDTO, 0, len(s.Access.Keys))
+	for id, cfg := range s.Access.Keys {
+		dto := KeyDTO{ID: id, Source: KeySourceConfig, Admin: cfg.Admin, Status: KeyActive}
+		dto.Bindings = make([]KeyBindingDTO, 0)
+		for repo, rc := range s.Access.Repositories {
+			if path, present := rc.Keys[id]; present {
+				dto.Bindings = append(dto.Bindings, KeyBindingDTO{Repository: repo, Path: path})
+			}
+		}
+		for _, b := range bindings {
+			if b.KeyID == id {
+				dto.Bindings = append(dto.Bindings, KeyBindingDTO{Repository: b.Repository, Path: b.Path})
+			}
+		}
+		sort.Slice(dto.Bindings, func(i, j int) bool {
+			return dto.Bindings[i].Repository < dto.Bindings[j].Repository
+		})
+		keys = append(keys, dto)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+func newKeyDTO(key APIKey, bindings []KeyBinding) KeyDTO {
+	dto := KeyDTO{
+		ID:       key.ID,
+		Source:   KeySourceAPI,
+		Admin:    key.Admin,
+		Status:   key.Status,
+		Created:  key.Created.String(),
+		Bindings: make([]KeyBindingDTO, 0),
+	}
+	if key.PreviousSecret != "" && time.Now().Before(key.PreviousExpiration) {
+		dto.PreviousExpires = key.PreviousExpiration.String()
+	}
+	if !key.Rotated.IsZero() {
+		dto.Rotated = key.Rotated.String()
+	}
+	for _, b := range bindings {
+		if b.KeyID == key.ID {
+			dto.Bindings = append(dto.Bindings, KeyBindingDTO{Repository: b.Repository, Path: b.Path})
+		}
+	}
+	return dto
+}
+
+// managedKeyPaths returns the subpaths of the keys bound to each repository
+// in the lease DB. Managed keys are only included while they are active.
+func (s *Services) managedKeyPaths(ctx context.Context, tx *sql.Tx) (map[string]KeyPaths, error) {
+	keys, err := FindAllAPIKeys(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]bool)
+	for _, k := range keys {
+		active[k.ID] = k.Status == KeyActive
+	}
+
+	bindings, err := FindAllKeyBindings(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]KeyPaths)
+	for _, b := range bindings {
+		if !active[b.KeyID] && s.Access.GetKeyConfig(b.KeyID) == nil {
+			continue
+		}
+		if _, present := ret[b.Repository]; !present {
+			ret[b.Repository] = make(KeyPaths)
+		}
+		ret[b.Repository][b.KeyID] = b.Path
+	}
+
+	return ret, nil
+}
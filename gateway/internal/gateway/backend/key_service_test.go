@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyServiceLifecycle(t *testing.T) {
+	backend, tmp := StartTestBackend("key_service_lifecycle_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+
+	id, secret, err := backend.NewKey(ctx, NewKeyOptions{
+		Bindings: []KeyBindingDTO{{Repository: "test1.repo.org", Path: "some/path"}},
+	})
+	if err != nil {
+		t.Fatalf("could not create key: %v", err)
+	}
+
+	t.Run("secret encrypted at rest", func(t *testing.T) {
+		withTx(ctx, backend.DB.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+			key, err := FindAPIKeyByID(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if key == nil || key.Secret == "" || strings.Contains(key.Secret, secret) {
+				t.Errorf("key secret should be stored encrypted: %+v", key)
+			}
+			return nil
+		})
+	})
+	t.Run("honoured by GetKey", func(t *testing.T) {
+		cfg := backend.GetKey(ctx, id)
+		if cfg == nil || cfg.Secret != secret || cfg.Admin {
+			t.Fatalf("invalid key configuration: %+v", cfg)
+		}
+		repo, err := backend.GetRepo(ctx, "test1.repo.org")
+		if err != nil || repo.Keys[id] != "/some/path" {
+			t.Errorf("binding missing from repository: %+v %v", repo, err)
+		}
+		if backend.Access.GetRepo("test1.repo.org").Keys[id] != "" {
+			t.Errorf("binding should not be added to the access configuration")
+		}
+	})
+	t.Run("bindings used for new leases", func(t *testing.T) {
+		token, err := backend.NewLease(ctx, id, "test1.repo.org/some/path/below", "", 3)
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
+		}
+		backend.CancelLease(ctx, token)
+		if _, err := backend.NewLease(ctx, id, "test1.repo.org/other", "", 3); err == nil {
+			t.Errorf("lease outside of the bound path should be rejected")
+		}
+		if _, err := backend.NewLease(ctx, id, "test2.repo.org/some/path", "", 3); err == nil {
+			t.Errorf("lease on an unbound repository should be rejected")
+		}
+	})
+	t.Run("rotation with overlap", func(t *testing.T) {
+		newSecret, err := backend.RotateKey(ctx, id, time.Hour)
+		if err != nil {
+			t.Fatalf("could not rotate key: %v", err)
+		}
+		cfg := backend.GetKey(ctx, id)
+		if cfg.Secret != newSecret || cfg.PreviousSecret != secret {
+			t.Errorf("both secrets should be valid during the overlap: %+v", cfg)
+		}
+		if _, err := backend.RotateKey(ctx, id, 0); err != nil {
+			t.Fatalf("could not rotate key: %v", err)
+		}
+		if cfg := backend.GetKey(ctx, id); cfg.PreviousSecret != "" {
+			t.Errorf("previous secret should not be valid without overlap")
+		}
+	})
+	t.Run("disable and enable", func(t *testing.T) {
+		if err := backend.SetKeyEnabled(ctx, id, false); err != nil {
+			t.Fatalf("could not disable key: %v", err)
+		}
+		if backend.GetKey(ctx, id) != nil {
+			t.Errorf("disabled key should be rejected")
+		}
+		if err := backend.SetKeyEnabled(ctx, id, true); err != nil {
+			t.Fatalf("could not enable key: %v", err)
+		}
+		if backend.GetKey(ctx, id) == nil {
+			t.Errorf("enabled key should be accepted")
+		}
+	})
+	t.Run("revocation", func(t *testing.T) {
+		if err := backend.RevokeKey(ctx, id); err != nil {
+			t.Fatalf("could not revoke key: %v", err)
+		}
+		if backend.GetKey(ctx, id) != nil {
+			t.Errorf("revoked key should be rejected")
+		}
+		if err := backend.SetKeyEnabled(ctx, id, true); !errors.Is(err, ErrKeyRevoked) {
+			t.Errorf("revoked key should not be reenabled: %v", err)
+		}
+		info, err := backend.GetKeyInfo(ctx, id)
+		if err != nil || info.Status != KeyRevoked || len(info.Bindings) != 0 {
+			t.Errorf("unexpected key information: %+v %v", info, err)
+		}
+		if _, _, err := backend.NewKey(ctx, NewKeyOptions{ID: id}); !errors.Is(err, ErrKeyExists) {
+			t.Errorf("revoked key ID should not be reused: %v", err)
+		}
+	})
+}
+
+func TestKeyServiceInvalidRequests(t *testing.T) {
+	backend, tmp := StartTestBackend("key_service_invalid_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+
+	if _, _, err := backend.NewKey(ctx, NewKeyOptions{ID: "keyid2"}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("key from the access configuration should not be shadowed: %v", err)
+	}
+	if _, _, err := backend.NewKey(ctx, NewKeyOptions{ID: "bad/id"}); !errors.Is(err, ErrInvalidKeyID) {
+		t.Errorf("malformed key ID should be rejected: %v", err)
+	}
+	if _, _, err := backend.NewKey(ctx, NewKeyOptions{
+		Bindings: []KeyBindingDTO{{Repository: "unknown.repo.org", Path: "/"}},
+	}); !errors.Is(err, ErrRepoNotFound) {
+		t.Errorf("binding to an unknown repository should be rejected: %v", err)
+	}
+	if _, err := backend.RotateKey(ctx, "keyid2", 0); !errors.Is(err, ErrStaticKey) {
+		t.Errorf("key from the access configuration should not be rotated: %v", err)
+	}
+	if err := backend.RevokeKey(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown key should be reported: %v", err)
+	}
+
+	keys, err := backend.GetKeys(ctx)
+	if err != nil {
+		t.Fatalf("could not list keys: %v", err)
+	}
+	for _, k := range keys {
+		if k.Source != KeySourceConfig {
+			t.Errorf("no managed key should have been created: %+v", k)
+		}
+	}
+}
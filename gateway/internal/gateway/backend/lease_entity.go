@@ -8,6 +8,7 @@ import (
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
 )
 
 // PathBusyError is returned as error value for new lease requests on
@@ -37,13 +38,19 @@ func (e InvalidLeaseError) Error() string {
 // Lease describes an exclusive lease to a subpath inside the repository:
 // keyID and token ()
 type Lease struct {
-	Token           string
-	Repository      string
+	Token      string
+	Repository string
+	// Path is the subpath committed by the receiver. For leases on several
+	// subpaths, it is their closest common parent.
 	Path            string
 	KeyID           string
 	Expiration      time.Time
 	ProtocolVersion int
 	Hostname        string
+	// Paths are the leased subpaths, which must not overlap. They are stored
+	// separately and only set when creating a lease; the lease is on Path
+	// alone when empty.
+	Paths []string
 }
 
 func (l Lease) CombinedLeasePath() string {
@@ -65,6 +72,18 @@ func CreateLease(ctx context.Context, tx *sql.Tx, lease Lease) error {
 		return fmt.Errorf("new lease not inserted")
 	}
 
+	paths := lease.Paths
+	if len(paths) == 0 {
+		paths = []string{lease.Path}
+	}
+	for _, path := range paths {
+		if _, err := tx.ExecContext(ctx,
+			"insert into LeasePath (Token, Repository, Path) values (?, ?, ?);",
+			lease.Token, lease.Repository, path); err != nil {
+			return fmt.Errorf("could not insert lease path: %w", err)
+		}
+	}
+
 	gw.LogC(ctx, "lease_entity", gw.LogDebug).
 		Str("operation", "create").
 		Dur("task_dt", time.Since(t0)).
@@ -125,12 +144,44 @@ func FindAllActiveLeases(ctx context.Context, tx *sql.Tx) ([]Lease, error) {
 	return leases, nil
 }
 
+func FindAllActiveLeasesByRepository(ctx context.Context, tx *sql.Tx, repository string) ([]Lease, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx,
+		"select * from Lease where Repository = ? and Expiration >= ?;", repository, t0.UnixMilli())
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	leases := make([]Lease, 0)
+	for rows.Next() {
+		var lease Lease
+		if err := scanLease(rows, &lease); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		leases = append(leases, lease)
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "find_all_active_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v leases", len(leases))
+
+	return leases, nil
+}
+
+// FindAllLeasesByRepositoryAndOverlappingPath returns the leases with a leased
+// path or a committed path overlapping path
 func FindAllLeasesByRepositoryAndOverlappingPath(ctx context.Context, tx *sql.Tx, repository, path string) ([]Lease, error) {
 	t0 := time.Now()
 
 	rows, err := tx.QueryContext(
 		ctx,
-		"select * from Lease where Repository = ? and (? like Path || '%' or Path like ? || '%');", repository, path, path)
+		`select * from Lease where Token in (
+			select Token from LeasePath where Repository = ? and (? like Path || '%' or Path like ? || '%'))
+			or (Repository = ? and (? like Path || '%' or Path like ? || '%'));`,
+		repository, path, path, repository, path, path)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -181,6 +232,33 @@ func FindLeaseByToken(ctx context.Context, tx *sql.Tx, token string) (*Lease, er
 	return &lease, nil
 }
 
+// FindLeasePathsByToken returns the subpaths of a lease
+func FindLeasePathsByToken(ctx context.Context, tx *sql.Tx, token string) ([]string, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select Path from LeasePath where Token = ? order by Path;", token)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	paths := make([]string, 0)
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		paths = append(paths, path)
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "find_paths_by_token").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v paths", len(paths))
+
+	return paths, nil
+}
+
 func DeleteAllExpiredLeases(ctx context.Context, tx *sql.Tx) error {
 	t0 := time.Now()
 
@@ -195,13 +273,15 @@ func DeleteAllExpiredLeases(ctx context.Context, tx *sql.Tx) error {
 		Dur("task_dt", time.Since(t0)).
 		Msgf("deleted %v leases", numDeleted)
 
-	return nil
+	return deleteOrphanedLeaseRows(ctx, tx)
 }
 
 func DeleteAllLeasesByRepositoryAndPathPrefix(ctx context.Context, tx *sql.Tx, repo, path string) error {
 	t0 := time.Now()
 
-	res, err := tx.ExecContext(ctx, "delete from Lease where Repository = ? and Path like ? || '%'", repo, path)
+	res, err := tx.ExecContext(ctx,
+		"delete from Lease where Token in (select Token from LeasePath where Repository = ? and Path like ? || '%')",
+		repo, path)
 	if err != nil {
 		return fmt.Errorf("delete statement failed: %w", err)
 	}
@@ -212,7 +292,7 @@ func DeleteAllLeasesByRepositoryAndPathPrefix(ctx context.Context, tx *sql.Tx, r
 		Dur("task_dt", time.Since(t0)).
 		Msgf("deleted %v leases", numDeleted)
 
-	return nil
+	return deleteOrphanedLeaseRows(ctx, tx)
 }
 
 func DeleteAllLeasesByRepository(ctx context.Context, tx *sql.Tx, repo string) error {
@@ -229,7 +309,7 @@ func DeleteAllLeasesByRepository(ctx context.Context, tx *sql.Tx, repo string) e
 		Dur("task_dt", time.Since(t0)).
 		Msgf("deleted %v leases", numDeleted)
 
-	return nil
+	return deleteOrphanedLeaseRows(ctx, tx)
 }
 
 func DeleteLeaseByToken(ctx context.Context, tx *sql.Tx, token string) error {
@@ -246,11 +326,64 @@ func DeleteLeaseByToken(ctx context.Context, tx *sql.Tx, token string) error {
 		Dur("task_dt", time.Since(t0)).
 		Msgf("deleted %v leases", numDeleted)
 
+	return deleteOrphanedLeaseRows(ctx, tx)
+}
+
+// deleteOrphanedLeaseRows removes the subpaths and the quota usage of the
+// deleted leases
+func deleteOrphanedLeaseRows(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "delete from LeasePath where Token not in (select Token from Lease)"); err != nil {
+		return fmt.Errorf("could not delete lease paths: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "delete from LeaseUsage where Token not in (select Token from Lease)"); err != nil {
+		return fmt.Errorf("could not delete lease usage: %w", err)
+	}
+	return nil
+}
+
+// SaveLeaseUsage records the quota usage of a lease. The counters only grow,
+// the highest of the recorded and the given values is kept.
+func SaveLeaseUsage(ctx context.Context, tx *sql.Tx, token string, usage stats.QuotaUsage) error {
+	t0 := time.Now()
+
+	if _, err := tx.ExecContext(ctx,
+		`insert into LeaseUsage (Token, UploadedBytes, Payloads, Catalogs) values (?, ?, ?, ?)
+		on conflict (Token) do update set
+			UploadedBytes = max(UploadedBytes, excluded.UploadedBytes),
+			Payloads = max(Payloads, excluded.Payloads),
+			Catalogs = max(Catalogs, excluded.Catalogs);`,
+		token, usage.UploadedBytes, usage.Payloads, usage.Catalogs); err != nil {
+		return fmt.Errorf("could not save lease usage: %w", err)
+	}
+
+	gw.LogC(ctx, "lease_entity", gw.LogDebug).
+		Str("operation", "save_usage").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("payloads: %v, uploaded bytes: %v, catalogs: %v", usage.Payloads, usage.UploadedBytes, usage.Catalogs)
+
 	return nil
 }
 
+// FindLeaseUsageByToken returns the recorded quota usage of a lease, without
+// its limits, or nil if the lease has no payload
+func FindLeaseUsageByToken(ctx context.Context, tx *sql.Tx, token string) (*stats.QuotaUsage, error) {
+	var usage stats.QuotaUsage
+	err := tx.QueryRowContext(ctx,
+		"select UploadedBytes, Payloads, Catalogs from LeaseUsage where Token = ?;", token).
+		Scan(&usage.UploadedBytes, &usage.Payloads, &usage.Catalogs)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return &usage, nil
+}
+
 func scanLease(rows *sql.Rows, lease *Lease) error {
 	var expMilli int64
+	// Leases created before the schema migration to version 3 have no hostname
+	var hostname sql.NullString
 	if err := rows.Scan(
 		&lease.Token,
 		&lease.Repository,
@@ -258,11 +391,12 @@ func scanLease(rows *sql.Rows, lease *Lease) error {
 		&lease.KeyID,
 		&expMilli,
 		&lease.ProtocolVersion,
-		&lease.Hostname); err != nil {
+		&hostname); err != nil {
 		return err
 	}
 
 	lease.Expiration = time.UnixMilli(expMilli)
+	lease.Hostname = hostname.String
 
 	return nil
 }
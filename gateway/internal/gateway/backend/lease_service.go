@@ -2,25 +2,53 @@ package backend
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
 )
 
 var leaseMutex sync.Mutex
 
+// ErrLeaseLimit is returned when the maximum number of concurrent leases of a
+// repository is reached
+var ErrLeaseLimit = fmt.Errorf("lease_limit_reached")
+
+// ErrOverlappingPaths is returned when the subpaths of a lease request overlap
+var ErrOverlappingPaths = fmt.Errorf("overlapping_paths")
+
+// ErrMultipleRepositories is returned when the subpaths of a lease request are
+// not in the same repository
+var ErrMultipleRepositories = fmt.Errorf("multiple_repositories")
+
 // LeaseDTO is the lease information returned to the HTTP frontend
 type LeaseDTO struct {
 	KeyID     string `json:"key_id,omitempty"`
 	LeasePath string `json:"path,omitempty"`
 	Expires   string `json:"expires,omitempty"`
 	Hostname  string `json:"hostname,omitempty"`
+	// Paths are the leased paths, when the lease is on several subpaths
+	Paths []string `json:"paths,omitempty"`
+	// Quota is the content published so far by the lease, with its limits
+	Quota *stats.QuotaUsage `json:"quota,omitempty"`
 }
 
 // NewLease for the specified path, using keyID
 func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int) (string, error) {
+	return s.NewMultiPathLease(ctx, keyID, []string{leasePath}, hostname, protocolVersion)
+}
+
+// NewMultiPathLease acquires a single lease on several disjoint subpaths of a
+// repository, or fails if any of them is busy. The subpaths are committed
+// together, as their closest common parent: the key must be allowed to publish
+// in the common parent, which is locked as a whole by the lease.
+func (s *Services) NewMultiPathLease(ctx context.Context, keyID string, leasePaths []string, hostname string, protocolVersion int) (string, error) {
 	leaseMutex.Lock()
 	defer leaseMutex.Unlock()
 
@@ -29,7 +57,7 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 	outcome := "success"
 	defer logAction(ctx, "new_lease", &outcome, t0)
 
-	repo, path, err := gw.SplitLeasePath(leasePath)
+	repo, paths, commitPath, err := splitLeasePaths(leasePaths)
 	if err != nil {
 		outcome = err.Error()
 		return "", err
@@ -52,18 +80,25 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		return "", ErrRepoDisabled
 	}
 
-	// Check if keyID is allowed to request a lease in the repository
-	// at the specified subpath
-	if err := s.Access.Check(keyID, path, repo); err != nil {
-		outcome = err.Error()
-		return "", err
+	// Check if keyID is allowed to request a lease in the repository at the
+	// specified subpaths, and at the committed path which contains them
+	checked := paths
+	if len(paths) > 1 {
+		checked = append([]string{commitPath}, paths...)
+	}
+	for _, path := range checked {
+		if err := s.checkAccess(ctx, tx, keyID, path, repo); err != nil {
+			outcome = err.Error()
+			return "", err
+		}
 	}
 
-	leases, err := FindAllLeasesByRepositoryAndOverlappingPath(ctx, tx, repo, path)
+	// The changes of the lease are merged at the committed path, no other
+	// lease may overlap it
+	leases, err := FindAllLeasesByRepositoryAndOverlappingPath(ctx, tx, repo, commitPath)
 	if err != nil {
 		return "", err
 	}
-
 	for _, lease := range leases {
 		timeLeft := time.Until(lease.Expiration)
 		if timeLeft > 0 {
@@ -73,21 +108,44 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		}
 	}
 
+	maxLeaseTime := s.Config.MaxLeaseTime
+	if limits := repoConfig.Limits; limits != nil {
+		if limits.MaxLeaseTime > 0 {
+			maxLeaseTime = time.Duration(limits.MaxLeaseTime) * time.Second
+		}
+		if limits.MaxLeases > 0 {
+			active, err := FindAllActiveLeasesByRepository(ctx, tx, repo)
+			if err != nil {
+				outcome = err.Error()
+				return "", err
+			}
+			if len(active) >= limits.MaxLeases {
+				outcome = ErrLeaseLimit.Error()
+				return "", ErrLeaseLimit
+			}
+		}
+	}
+
 	// Delete expired leases
 	if err := DeleteAllExpiredLeases(ctx, tx); err != nil {
 		outcome = err.Error()
 		return "", err
 	}
+	if err := DeleteAllOrphanedPayloads(ctx, tx); err != nil {
+		outcome = err.Error()
+		return "", err
+	}
 
 	// Generate a new token for the lease
 	lease := Lease{
 		Token:           NewLeaseToken(),
 		Repository:      repo,
-		Path:            path,
+		Path:            commitPath,
 		KeyID:           keyID,
-		Expiration:      time.Now().Add(s.Config.MaxLeaseTime),
+		Expiration:      time.Now().Add(maxLeaseTime),
 		ProtocolVersion: protocolVersion,
 		Hostname:        hostname,
+		Paths:           paths,
 	}
 
 	if err := CreateLease(ctx, tx, lease); err != nil {
@@ -107,6 +165,10 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 		outcome = err.Error()
 		return "", err
 	}
+	if err := s.StatsMgr.SetLeaseQuota(lease.CombinedLeasePath(), s.leaseQuota(repo, keyID)); err != nil {
+		outcome = err.Error()
+		return "", err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return "", fmt.Errorf("could not commit transaction: %w", err)
@@ -116,6 +178,69 @@ func (s *Services) NewLease(ctx context.Context, keyID, leasePath, hostname stri
 	return lease.Token, nil
 }
 
+// splitLeasePaths returns the repository and subpaths of the full lease paths
+// of a lease request, and the path committed for the lease
+func splitLeasePaths(leasePaths []string) (string, []string, string, error) {
+	if len(leasePaths) == 0 {
+		return "", nil, "", fmt.Errorf("missing lease path")
+	}
+	var repo string
+	paths := make([]string, 0, len(leasePaths))
+	for _, leasePath := range leasePaths {
+		r, path, err := gw.SplitLeasePath(leasePath)
+		if err != nil {
+			return "", nil, "", err
+		}
+		if repo != "" && r != repo {
+			return "", nil, "", fmt.Errorf("%w: %v and %v", ErrMultipleRepositories, repo, r)
+		}
+		repo = r
+		for _, other := range paths {
+			if gw.CheckPathOverlap(path, other) {
+				return "", nil, "", fmt.Errorf("%w: %v and %v", ErrOverlappingPaths, other, path)
+			}
+		}
+		paths = append(paths, path)
+	}
+	if len(paths) == 1 {
+		return repo, paths, paths[0], nil
+	}
+
+	// The closest common parent directory of the subpaths
+	common := strings.Split(paths[0], "/")
+	for _, path := range paths[1:] {
+		components := strings.Split(path, "/")
+		n := 0
+		for n < len(common) && n < len(components) && common[n] == components[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	commitPath := strings.Join(common, "/")
+	if commitPath == "" {
+		commitPath = "/"
+	}
+	sort.Strings(paths)
+
+	return repo, paths, commitPath, nil
+}
+
+// multiLeasePaths returns the full paths of a lease on several subpaths, or nil
+// for a lease on a single path
+func multiLeasePaths(ctx context.Context, tx *sql.Tx, lease Lease) ([]string, error) {
+	paths, err := FindLeasePathsByToken(ctx, tx, lease.Token)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) < 2 {
+		return nil, nil
+	}
+	for i, path := range paths {
+		paths[i] = Lease{Repository: lease.Repository, Path: path}.CombinedLeasePath()
+	}
+	return paths, nil
+}
+
 // GetLeases returns all active and valid leases
 func (s *Services) GetLeases(ctx context.Context) (map[string]LeaseDTO, error) {
 	leaseMutex.Lock()
@@ -138,8 +263,15 @@ func (s *Services) GetLeases(ctx context.Context) (map[string]LeaseDTO, error) {
 	}
 	ret := make(map[string]LeaseDTO)
 	for _, l := range leases {
+		paths, err := multiLeasePaths(ctx, tx, l)
+		if err != nil {
+			outcome = err.Error()
+			return nil, err
+		}
 		leasePath := l.Repository + l.Path
-		ret[leasePath] = LeaseDTO{KeyID: l.KeyID, LeasePath: leasePath, Expires: l.Expiration.String(), Hostname: l.Hostname}
+		ret[leasePath] = LeaseDTO{
+			KeyID: l.KeyID, LeasePath: leasePath, Expires: l.Expiration.String(), Hostname: l.Hostname,
+			Paths: paths}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -176,6 +308,12 @@ func (s *Services) GetLease(ctx context.Context, token string) (*LeaseDTO, error
 		return nil, err
 	}
 
+	paths, err := multiLeasePaths(ctx, tx, *lease)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("could not commit transaction: %w", err)
 	}
@@ -185,6 +323,10 @@ func (s *Services) GetLease(ctx context.Context, token string) (*LeaseDTO, error
 		LeasePath: lease.CombinedLeasePath(),
 		Expires:   lease.Expiration.String(),
 		Hostname:  lease.Hostname,
+		Paths:     paths,
+	}
+	if usage, err := s.StatsMgr.GetLeaseQuota(ret.LeasePath); err == nil {
+		ret.Quota = &usage
 	}
 	return ret, nil
 }
@@ -214,6 +356,10 @@ func (s *Services) CancelLeases(ctx context.Context, repoPath string) error {
 		outcome = err.Error()
 		return err
 	}
+	if err := DeleteAllOrphanedPayloads(ctx, tx); err != nil {
+		outcome = err.Error()
+		return err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("could not commit transaction: %w", err)
@@ -253,6 +399,10 @@ func (s *Services) CancelLease(ctx context.Context, token string) error {
 		outcome = err.Error()
 		return err
 	}
+	if err := DeleteAllPayloadsByToken(ctx, tx, token); err != nil {
+		outcome = err.Error()
+		return err
+	}
 
 	// We don't check the error - if the statistics are missing, the lease
 	// should still be cancelable
@@ -292,32 +442,78 @@ func (s *Services) CommitLease(ctx context.Context, token, oldRootHash, newRootH
 		return 0, err
 	}
 
-	var finalRev uint64
-	if err := s.DB.WithLock(ctx, lease.Repository, func() error {
-		var err error
-		leasePath := lease.CombinedLeasePath()
-		finalRev, err = s.Pool.CommitLease(ctx, leasePath, oldRootHash, newRootHash, tag)
-		return err
-	}); err != nil {
+	// The database must not stay locked while the receiver commits, since the
+	// payloads of other leases are recorded in the meantime
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	leasePath := lease.CombinedLeasePath()
+	if err := s.StatsMgr.CheckLeaseQuota(leasePath); err != nil {
+		outcome = err.Error()
+		return 0, err
+	}
+	statistics, _ := s.StatsMgr.GetLeaseStatistics(leasePath)
+	event := HookEvent{
+		Repository:  lease.Repository,
+		LeasePath:   leasePath,
+		KeyID:       lease.KeyID,
+		Hostname:    lease.Hostname,
+		OldRootHash: oldRootHash,
+		NewRootHash: newRootHash,
+		Tag:         tag,
+		Statistics:  statistics,
+	}
+	if err := s.Hooks.RunPreCommit(ctx, event); err != nil {
 		outcome = err.Error()
 		return 0, err
 	}
 
-	go func() {
-		plotsErr := s.StatsMgr.UploadStatsPlots(lease.Repository)
-		if plotsErr != nil {
-			gw.LogC(ctx, "actions", gw.LogError).Msgf(plotsErr.Error())
-		}
-	}()
+	finalRev, commitDuration, err := s.commitToReceiver(ctx, lease.Repository, token, receiver.CommitRequest{
+		LeasePath: leasePath, OldRootHash: oldRootHash, NewRootHash: newRootHash, Tag: tag})
+	if err != nil {
+		outcome = err.Error()
+		return 0, err
+	}
+
+	event.Revision = finalRev
+	s.Hooks.RunPostCommit(event)
+
+	tx, err = s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return finalRev, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
 	if err := DeleteLeaseByToken(ctx, tx, token); err != nil {
 		outcome = err.Error()
 		return finalRev, err
 	}
+	if err := DeleteAllPayloadsByToken(ctx, tx, token); err != nil {
+		outcome = err.Error()
+		return finalRev, err
+	}
+	if err := AddPublicationStatistics(
+		ctx, tx, lease.Repository, lease.KeyID, time.Now(), statistics.Publish, commitDuration); err != nil {
+		outcome = err.Error()
+		return finalRev, err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return 0, fmt.Errorf("could not commit transaction: %w", err)
 	}
 
+	s.Replication.recordCommit(lease.Repository, finalRev, time.Now())
+
+	s.activity.addPublication(PublicationDTO{
+		Repository: lease.Repository,
+		LeasePath:  leasePath,
+		KeyID:      lease.KeyID,
+		Hostname:   lease.Hostname,
+		Revision:   finalRev,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		DurationMs: commitDuration.Milliseconds(),
+	})
+
 	return finalRev, nil
 }
@@ -281,3 +281,116 @@ func TestLeaseServiceCommitLease(t *testing.T) {
 		}
 	})
 }
+
+func TestLeaseServiceNewMultiPathLease(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("lease_actions_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	backend.Config.MaxLeaseTime = 1 * time.Second
+	keyID := "keyid1"
+	leasePaths := []string{"test2.repo.org/some/b", "test2.repo.org/some/a"}
+
+	t.Run("invalid paths", func(t *testing.T) {
+		overlapping := []string{"test2.repo.org/some/a", "test2.repo.org/some/a/below"}
+		if _, err := backend.NewMultiPathLease(context.TODO(), keyID, overlapping, "host", lastProtocolVersion); !errors.Is(err, ErrOverlappingPaths) {
+			t.Errorf("overlapping paths should have been rejected: %v", err)
+		}
+		repos := []string{"test2.repo.org/some/a", "test1.repo.org/some/b"}
+		if _, err := backend.NewMultiPathLease(context.TODO(), keyID, repos, "host", lastProtocolVersion); !errors.Is(err, ErrMultipleRepositories) {
+			t.Errorf("paths in several repositories should have been rejected: %v", err)
+		}
+	})
+	t.Run("all or none", func(t *testing.T) {
+		token1, err := backend.NewLease(context.TODO(), keyID, "test2.repo.org/some/b/below", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token1)
+		token2, err := backend.NewMultiPathLease(context.TODO(), keyID, leasePaths, "host", lastProtocolVersion)
+		if err == nil {
+			backend.CancelLease(context.TODO(), token2)
+			t.Fatalf("new lease should not have been granted for busy path")
+		}
+		// The free path must not have been leased
+		token3, err := backend.NewLease(context.TODO(), keyID, "test2.repo.org/some/a", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		backend.CancelLease(context.TODO(), token3)
+	})
+	t.Run("lease and commit", func(t *testing.T) {
+		token, err := backend.NewMultiPathLease(context.TODO(), keyID, leasePaths, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		lease, err := backend.GetLease(context.TODO(), token)
+		if err != nil {
+			t.Fatalf("could not query existing lease: %v", err)
+		}
+		if lease.LeasePath != "test2.repo.org/some" ||
+			len(lease.Paths) != 2 || lease.Paths[0] != "test2.repo.org/some/a" {
+			t.Errorf("lease query result is invalid: %+v", lease)
+		}
+
+		// The lease is committed as the common parent, which is busy with the
+		// subpaths and their siblings, but not the paths outside of it
+		for _, busy := range []string{
+			"test2.repo.org/some/a", "test2.repo.org/some/b/below", "test2.repo.org/some",
+			"test2.repo.org/some/c"} {
+			if token2, err := backend.NewLease(context.TODO(), keyID, busy, "host", lastProtocolVersion); err == nil {
+				backend.CancelLease(context.TODO(), token2)
+				t.Errorf("new lease should not have been granted for busy path %v", busy)
+			}
+		}
+		sibling := []string{"test2.repo.org/some/c", "test2.repo.org/some/d"}
+		if token2, err := backend.NewMultiPathLease(context.TODO(), keyID, sibling, "host", lastProtocolVersion); err == nil {
+			backend.CancelLease(context.TODO(), token2)
+			t.Errorf("new lease should not have been granted for the siblings %v", sibling)
+		}
+		token2, err := backend.NewLease(context.TODO(), keyID, "test2.repo.org/other", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease outside of the common parent: %v", err)
+		}
+		defer backend.CancelLease(context.TODO(), token2)
+
+		if _, err := backend.CommitLease(context.TODO(), token, "old_hash", "new_hash", gw.RepositoryTag{}); err != nil {
+			t.Fatalf("could not commit existing lease: %v", err)
+		}
+		token3, err := backend.NewLease(context.TODO(), keyID, "test2.repo.org/some/a", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("subpath still busy after commit: %v", err)
+		}
+		backend.CancelLease(context.TODO(), token3)
+	})
+	t.Run("common parent outside of the key path", func(t *testing.T) {
+		allowed := []string{"test2.repo.org/restricted/to/subdir/a", "test2.repo.org/restricted/to/subdir/b"}
+		token, err := backend.NewMultiPathLease(context.TODO(), "keyid2", allowed, "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		backend.CancelLease(context.TODO(), token)
+
+		denied := []string{"test2.repo.org/restricted/to/subdir/a", "test2.repo.org/restricted/to/subdirectory"}
+		if token, err := backend.NewMultiPathLease(context.TODO(), "keyid2", denied, "host", lastProtocolVersion); err == nil {
+			backend.CancelLease(context.TODO(), token)
+			t.Errorf("new lease should not have been granted outside of the key path")
+		}
+	})
+	t.Run("cancel by path", func(t *testing.T) {
+		if _, err := backend.NewMultiPathLease(context.TODO(), keyID, leasePaths, "host", lastProtocolVersion); err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		if err := backend.CancelLeases(context.TODO(), "test2.repo.org/some/b"); err != nil {
+			t.Fatalf("could not cancel existing lease: %v", err)
+		}
+		token, err := backend.NewLease(context.TODO(), keyID, "test2.repo.org/some/a", "host", lastProtocolVersion)
+		if err != nil {
+			t.Fatalf("subpath still busy after cancellation: %v", err)
+		}
+		backend.CancelLease(context.TODO(), token)
+	})
+}
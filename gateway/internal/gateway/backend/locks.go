@@ -1,6 +1,11 @@
 package backend
 
-import "sync"
+import (
+	"context"
+	"sync"
+
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
+)
 
 // NamedLocks provides a thread-safe map of named locks, used for locking
 // repositories during critical operations (commits, GC, etc.)
@@ -9,11 +14,15 @@ type NamedLocks struct {
 }
 
 // WithLock runs the given task, locking the "name" mutex for the
-// duration of the task
-func (l *NamedLocks) WithLock(name string, task func() error) error {
+// duration of the task. The wait for the lock is recorded in a span, if the
+// context is traced.
+func (l *NamedLocks) WithLock(ctx context.Context, name string, task func() error) error {
 	m, _ := l.locks.LoadOrStore(name, &sync.Mutex{})
 	mtx := m.(*sync.Mutex)
+	_, span := tracing.StartChild(ctx, "lock_wait")
+	span.SetAttribute("lock", name)
 	mtx.Lock()
+	span.End()
 	defer mtx.Unlock()
 
 	return task()
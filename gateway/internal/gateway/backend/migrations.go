@@ -0,0 +1,337 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// baseSchemaVersion is the version of the schema created by baseSchema. New
+// databases are brought to latestSchemaVersion by the regular migrations.
+const baseSchemaVersion = 2
+
+const baseSchema = `
+create table SchemaVersion (
+	VersionNumber integer not null unique primary key,
+	ValidFrom timestamp not null,
+	ValidTo timestamp
+);
+insert into SchemaVersion (VersionNumber, ValidFrom) values (2, datetime('now'));
+create table if not exists Lease (
+	Token string not null unique primary key,
+	Repository string not null,
+	Path string not null,
+	KeyID string not null,
+	Expiration integer not null,
+	ProtocolVersion integer not null
+);
+create index lease_repository_path_idx ON Lease(Repository,Path);
+create table if not exists Repository (
+	Name string not null unique primary key,
+	Manifest string,
+	Enabled bool not null
+);
+`
+
+// migration is a step between two consecutive schema versions. Up brings a
+// database from Version-1 to Version, Down reverts it.
+type migration struct {
+	Version     int
+	Description string
+	Up          string
+	Down        string
+}
+
+// migrations is the ordered list of schema migrations. The last one must
+// reach latestSchemaVersion.
+var migrations = []migration{
+	{
+		Version:     3,
+		Description: "record the hostname of the lease holder",
+		Up:          `alter table Lease add column Hostname string;`,
+		Down:        `alter table Lease drop column Hostname;`,
+	},
+	{
+		Version:     4,
+		Description: "record the payloads submitted for each lease",
+		Up: `
+create table if not exists Payload (
+	ID integer primary key autoincrement,
+	Token string not null,
+	Digest string not null,
+	HeaderSize integer not null,
+	Size integer not null,
+	Received integer not null,
+	Duration integer not null,
+	Result string not null
+);
+create index payload_token_digest_idx ON Payload(Token,Digest);
+`,
+		Down: `
+drop index payload_token_digest_idx;
+drop table Payload;
+`,
+	},
+	{
+		Version:     5,
+		Description: "store the API keys managed through the gateway API",
+		Up: `
+create table if not exists ApiKey (
+	ID string not null unique primary key,
+	Secret string not null,
+	PreviousSecret string not null,
+	PreviousExpiration integer not null,
+	Admin bool not null,
+	Status string not null,
+	Created integer not null,
+	Rotated integer not null
+);
+create table if not exists KeyBinding (
+	KeyID string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (KeyID, Repository)
+);
+`,
+		Down: `
+drop table KeyBinding;
+drop table ApiKey;
+`,
+	},
+	{
+		Version:     6,
+		Description: "store the repositories registered through the gateway API",
+		Up: `
+create table if not exists RepositoryRegistration (
+	Name string not null unique primary key,
+	MaxLeaseTime integer not null,
+	MaxLeases integer not null,
+	Registered integer not null
+);
+`,
+		Down: `drop table RepositoryRegistration;`,
+	},
+	{
+		Version:     7,
+		Description: "record the runs of the commit hooks",
+		Up: `
+create table if not exists HookRun (
+	ID integer primary key autoincrement,
+	Hook string not null,
+	Stage string not null,
+	Repository string not null,
+	LeasePath string not null,
+	Revision integer not null,
+	Status string not null,
+	Attempts integer not null,
+	Output string not null,
+	Started integer not null,
+	Finished integer not null
+);
+create index hookrun_repository_idx ON HookRun(Repository,Revision);
+`,
+		Down: `
+drop index hookrun_repository_idx;
+drop table HookRun;
+`,
+	},
+	{
+		Version:     8,
+		Description: "aggregate the publication statistics per repository, key and day",
+		Up: `
+create table if not exists PublicationStatistics (
+	Repository string not null,
+	KeyID string not null,
+	Day string not null,
+	Commits integer not null,
+	ChunksAdded integer not null,
+	ChunksDuplicated integer not null,
+	CatalogsAdded integer not null,
+	UploadedBytes integer not null,
+	UploadedCatalogBytes integer not null,
+	CommitDuration integer not null,
+	MaxCommitDuration integer not null,
+	primary key (Repository, Day, KeyID)
+);
+`,
+		Down: `
+drop table PublicationStatistics;
+`,
+	},
+	{
+		Version:     9,
+		Description: "allow leases on several subpaths of a repository",
+		Up: `
+create table if not exists LeasePath (
+	Token string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (Token, Path)
+);
+create index leasepath_repository_path_idx ON LeasePath(Repository,Path);
+insert into LeasePath select Token, Repository, Path from Lease;
+`,
+		Down: `
+drop index leasepath_repository_path_idx;
+drop table LeasePath;
+`,
+	},
+	{
+		Version:     10,
+		Description: "record the content encoding and transferred size of the payloads",
+		Up: `
+alter table Payload add column Encoding string not null default '';
+alter table Payload add column TransferredSize integer not null default 0;
+update Payload set TransferredSize = Size;
+`,
+		Down: `
+alter table Payload drop column TransferredSize;
+alter table Payload drop column Encoding;
+`,
+	},
+	{
+		Version:     11,
+		Description: "keep the quota usage of the leases across restarts",
+		Up: `
+create table if not exists LeaseUsage (
+	Token string not null unique primary key,
+	UploadedBytes integer not null,
+	Payloads integer not null,
+	Catalogs integer not null
+);
+`,
+		Down: `drop table LeaseUsage;`,
+	},
+	{
+		Version:     12,
+		Description: "accept a payload digest only once for a lease",
+		Up: `
+delete from Payload where Result = 'ok' and ID not in (
+	select min(ID) from Payload where Result = 'ok' group by Token, Digest);
+create unique index payload_accepted_idx ON Payload(Token,Digest) where Result = 'ok';
+`,
+		Down: `drop index payload_accepted_idx;`,
+	},
+}
+
+// SchemaError is returned when the schema of the lease DB can not be used by
+// the application
+type SchemaError struct {
+	Version int
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf(
+		"unknown schema version: %v, known versions %v to %v",
+		e.Version, baseSchemaVersion, latestSchemaVersion)
+}
+
+// knownSchemaVersion returns true if the migrations can bring a database of
+// this schema version to any other known version
+func knownSchemaVersion(version int) bool {
+	return version >= baseSchemaVersion && version <= latestSchemaVersion
+}
+
+func createSchema(db *sql.DB) error {
+	if _, err := db.Exec(baseSchema); err != nil {
+		return fmt.Errorf("could not create base schema: %w", err)
+	}
+	return nil
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow(
+		"select VersionNumber from SchemaVersion;").Scan(&version); err != nil {
+		return 0, fmt.Errorf("could not retrieve schema version: %w", err)
+	}
+	return version, nil
+}
+
+// pendingMigrations returns the migrations needed to bring a database from
+// version to latestSchemaVersion
+func pendingMigrations(version int) []migration {
+	pending := []migration{}
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// migrateSchema applies the up or down steps needed to bring the database
+// from its current schema version to target. Each step runs in its own
+// transaction.
+func migrateSchema(db *sql.DB, target int) (int, error) {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+	if !knownSchemaVersion(version) {
+		return version, SchemaError{version}
+	}
+	if !knownSchemaVersion(target) {
+		return version, fmt.Errorf("invalid target schema version: %v", target)
+	}
+
+	for version < target {
+		m := migrations[version-baseSchemaVersion]
+		if err := applyMigration(db, m.Up, m.Version); err != nil {
+			return version, fmt.Errorf(
+				"could not migrate table schema (%v->%v): %w", version, m.Version, err)
+		}
+		gw.Log("leasedb", gw.LogInfo).
+			Msgf("schema migrated to version %v: %v", m.Version, m.Description)
+		version = m.Version
+	}
+
+	for version > target {
+		m := migrations[version-baseSchemaVersion-1]
+		if err := applyMigration(db, m.Down, m.Version-1); err != nil {
+			return version, fmt.Errorf(
+				"could not migrate table schema (%v->%v): %w", version, m.Version-1, err)
+		}
+		gw.Log("leasedb", gw.LogInfo).
+			Msgf("schema reverted to version %v", m.Version-1)
+		version = m.Version - 1
+	}
+
+	return version, nil
+}
+
+func applyMigration(db *sql.DB, statement string, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(statement); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"update SchemaVersion set VersionNumber = ?, ValidFrom = datetime('now');",
+		version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// backupDB makes an online copy of the database next to dbFile, tagged with
+// the schema version, and returns the name of the copy
+func backupDB(db *sql.DB, dbFile string, version int) (string, error) {
+	backupFile := fmt.Sprintf(
+		"%v.v%v.%v.bak", dbFile, version, time.Now().Format("20060102T150405"))
+	if _, err := os.Stat(backupFile); err == nil {
+		return "", fmt.Errorf("backup file %v already exists", backupFile)
+	}
+	if _, err := db.Exec("vacuum into ?;", backupFile); err != nil {
+		return "", fmt.Errorf("could not back up database: %w", err)
+	}
+	return backupFile, nil
+}
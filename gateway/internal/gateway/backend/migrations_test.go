@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cvmfs/gateway/internal/gateway"
+)
+
+// openFixture creates a lease DB in a temporary working directory from one of
+// the SQL fixtures in testdata
+func openFixture(t *testing.T, version int) (gateway.Config, string) {
+	tmp, err := ioutil.TempDir("", "test_migrations")
+	if err != nil {
+		t.Fatalf("could not create temp dir for test case")
+	}
+	statement, err := os.ReadFile(fmt.Sprintf("testdata/schema_v%v.sql", version))
+	if err != nil {
+		t.Fatalf("could not read fixture: %v", err)
+	}
+	sqlDB, err := sql.Open("sqlite3", "file:"+tmp+"/gw.db?mode=rwc")
+	if err != nil {
+		t.Fatalf("could not create fixture DB: %v", err)
+	}
+	defer sqlDB.Close()
+	if _, err := sqlDB.Exec(string(statement)); err != nil {
+		t.Fatalf("could not load fixture: %v", err)
+	}
+	return gateway.Config{WorkDir: tmp}, tmp
+}
+
+func TestMigrationsAreContiguous(t *testing.T) {
+	version := baseSchemaVersion
+	for _, m := range migrations {
+		if m.Version != version+1 {
+			t.Errorf("migration to version %v follows version %v", m.Version, version)
+		}
+		version = m.Version
+	}
+	if version != latestSchemaVersion {
+		t.Errorf("migrations end at version %v instead of %v", version, latestSchemaVersion)
+	}
+}
+
+func TestOpenHistoricSchemas(t *testing.T) {
+	for version := baseSchemaVersion; version <= latestSchemaVersion; version++ {
+		t.Run(fmt.Sprintf("version %v", version), func(t *testing.T) {
+			cfg, tmp := openFixture(t, version)
+			defer os.RemoveAll(tmp)
+
+			status, err := CheckSchema(cfg)
+			if err != nil {
+				t.Fatalf("could not check schema: %v", err)
+			}
+			if status.Version != version || len(status.Pending) != latestSchemaVersion-version {
+				t.Errorf("unexpected schema status: %+v", status)
+			}
+
+			db, err := OpenDB(cfg)
+			if err != nil {
+				t.Fatalf("could not open database: %v", err)
+			}
+			defer db.Close()
+
+			if v, err := schemaVersion(db.SQL); err != nil || v != latestSchemaVersion {
+				t.Errorf("database not migrated: %v %v", v, err)
+			}
+
+			withTx(context.TODO(), db.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+				lease, err := FindLeaseByToken(ctx, tx, "fixture_token")
+				if err != nil {
+					return err
+				}
+				if lease == nil || lease.Path != "some/path" {
+					return fmt.Errorf("lease not preserved: %+v", lease)
+				}
+				paths, err := FindLeasePathsByToken(ctx, tx, "fixture_token")
+				if err != nil {
+					return err
+				}
+				if len(paths) != 1 || paths[0] != "some/path" {
+					return fmt.Errorf("lease path not preserved: %v", paths)
+				}
+				return nil
+			})
+
+			backups, _ := filepath.Glob(tmp + "/gw.db.v*.bak")
+			if version < latestSchemaVersion && len(backups) != 1 {
+				t.Errorf("expected a backup before migrating, found: %v", backups)
+			}
+			if version == latestSchemaVersion && len(backups) != 0 {
+				t.Errorf("no backup expected without migration, found: %v", backups)
+			}
+		})
+	}
+}
+
+func TestMigrationBackupIsUsable(t *testing.T) {
+	cfg, tmp := openFixture(t, baseSchemaVersion)
+	defer os.RemoveAll(tmp)
+
+	db, err := OpenDB(cfg)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	db.Close()
+
+	backups, _ := filepath.Glob(tmp + "/gw.db.v*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("expected a backup, found: %v", backups)
+	}
+	backup, err := sql.Open("sqlite3", "file:"+backups[0]+"?mode=ro")
+	if err != nil {
+		t.Fatalf("could not open backup: %v", err)
+	}
+	defer backup.Close()
+	if v, err := schemaVersion(backup); err != nil || v != baseSchemaVersion {
+		t.Errorf("backup should keep the original schema: %v %v", v, err)
+	}
+}
+
+func TestMigrationsDownAndUp(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_migrations")
+	if err != nil {
+		t.Fatalf("could not create temp dir for test case")
+	}
+	defer os.RemoveAll(tmp)
+
+	db, err := OpenDB(gateway.Config{WorkDir: tmp})
+	if err != nil {
+		t.Fatalf("could not create database: %v", err)
+	}
+	defer db.Close()
+
+	if v, err := migrateSchema(db.SQL, baseSchemaVersion); err != nil || v != baseSchemaVersion {
+		t.Fatalf("could not revert migrations: %v %v", v, err)
+	}
+	if _, err := db.SQL.Exec("select * from Payload;"); err == nil {
+		t.Errorf("Payload table should have been dropped")
+	}
+	if v, err := migrateSchema(db.SQL, latestSchemaVersion); err != nil || v != latestSchemaVersion {
+		t.Fatalf("could not reapply migrations: %v %v", v, err)
+	}
+	if _, err := migrateSchema(db.SQL, latestSchemaVersion+1); err == nil {
+		t.Errorf("migration to an unknown version should fail")
+	}
+}
+
+func TestOpenUnknownSchemaFails(t *testing.T) {
+	for _, version := range []int{latestSchemaVersion + 1, baseSchemaVersion - 1, 0} {
+		t.Run(fmt.Sprintf("version %v", version), func(t *testing.T) {
+			cfg, tmp := openFixture(t, latestSchemaVersion)
+			defer os.RemoveAll(tmp)
+
+			sqlDB, _ := sql.Open("sqlite3", "file:"+tmp+"/gw.db?mode=rw")
+			sqlDB.Exec("update SchemaVersion set VersionNumber = ?;", version)
+			defer sqlDB.Close()
+
+			var schemaErr SchemaError
+			if _, err := OpenDB(cfg); !errors.As(err, &schemaErr) || schemaErr.Version != version {
+				t.Errorf("database with an unknown schema should not be opened: %v", err)
+			}
+			if _, err := CheckSchema(cfg); !errors.As(err, &schemaErr) {
+				t.Errorf("database with an unknown schema should be reported: %v", err)
+			}
+			if _, err := migrateSchema(sqlDB, latestSchemaVersion); !errors.As(err, &schemaErr) {
+				t.Errorf("database with an unknown schema should not be migrated: %v", err)
+			}
+		})
+	}
+}
@@ -1,18 +1,166 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"time"
 )
 
-// PublishManifest publishes a repository manifest to the notification system
-func (s *Services) PublishManifest(ctx context.Context, repository string, message NotificationMessage) {
+// ErrInvalidManifest is returned when publishing a manifest which can not be
+// parsed or does not belong to the repository
+var ErrInvalidManifest = fmt.Errorf("invalid_manifest")
+
+// ErrInvalidSignature is returned when publishing a manifest whose signature
+// does not match the public key of the repository
+var ErrInvalidSignature = fmt.Errorf("invalid_manifest_signature")
+
+// NewNotificationMessage builds the notification of a new revision of a
+// repository from its manifest
+func NewNotificationMessage(repository string, manifest []byte) (NotificationMessage, error) {
+	fields := parseManifest(manifest)
+	if fields['N'] != repository {
+		return NotificationMessage{}, fmt.Errorf(
+			"%w: manifest belongs to %q", ErrInvalidManifest, fields['N'])
+	}
+	revision, err := strconv.ParseUint(fields['S'], 10, 64)
+	if err != nil {
+		return NotificationMessage{}, fmt.Errorf("%w: invalid revision %q", ErrInvalidManifest, fields['S'])
+	}
+	if fields['C'] == "" {
+		return NotificationMessage{}, fmt.Errorf("%w: missing root hash", ErrInvalidManifest)
+	}
+	timestamp := time.Now().UTC()
+	if t, err := strconv.ParseInt(fields['T'], 10, 64); err == nil {
+		timestamp = time.Unix(t, 0).UTC()
+	}
+
+	return NotificationMessage{
+		Version:    1,
+		Type:       "activity",
+		Repository: repository,
+		Revision:   revision,
+		RootHash:   fields['C'],
+		Timestamp:  timestamp,
+		Manifest:   manifest,
+	}, nil
+}
+
+// PublishManifest publishes a repository manifest to the notification system.
+// The key must be bound to the repository. The signature of the manifest is
+// verified if the certificates of the repositories are configured.
+func (s *Services) PublishManifest(ctx context.Context, keyID, repository string, manifest []byte) error {
 	t0 := time.Now()
 
 	outcome := "success"
 	defer logAction(ctx, "publish_manifest", &outcome, t0)
 
-	s.Notifications.Publish(ctx, repository, message)
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := s.checkRepoAccess(ctx, tx, keyID, repository); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	message, err := NewNotificationMessage(repository, manifest)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if err := s.checkManifestSignature(repository, manifest); err != nil {
+		outcome = err.Error()
+		return err
+	}
+	message.Via = []string{s.gatewayID()}
+
+	if err := s.Notifications.Publish(ctx, message); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+// checkManifestSignature verifies the signature of a manifest, if the
+// certificates of the repositories are configured
+func (s *Services) checkManifestSignature(repository string, manifest []byte) error {
+	if s.Config.ManifestKeyDir == "" {
+		return nil
+	}
+	pub, err := loadRepositoryPublicKey(s.Config.ManifestKeyDir, repository)
+	if err != nil {
+		return err
+	}
+	return verifyManifest(manifest, pub)
+}
+
+// loadRepositoryPublicKey reads the public key of the certificate of a
+// repository (<REPO>.crt) signing its manifests
+func loadRepositoryPublicKey(dir, repository string) (*rsa.PublicKey, error) {
+	fileName := filepath.Join(dir, repository+".crt")
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate of repository %v: %w", repository, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid certificate file %v", fileName)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate file %v: %w", fileName, err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("certificate %v does not contain an RSA public key", fileName)
+	}
+	return pub, nil
+}
+
+// verifyManifest checks the signature of a repository manifest. A signed
+// manifest is followed by a "--" line, the hexadecimal SHA-1 hash of the
+// manifest and the RSA signature of the hash.
+func verifyManifest(manifest []byte, pub *rsa.PublicKey) error {
+	separator := []byte("\n--\n")
+	pos := bytes.Index(manifest, separator)
+	if pos < 0 {
+		return fmt.Errorf("%w: manifest is not signed", ErrInvalidSignature)
+	}
+	letter := manifest[:pos+1]
+	tail := manifest[pos+len(separator):]
+	end := bytes.IndexByte(tail, '\n')
+	if end < 0 {
+		return fmt.Errorf("%w: missing manifest hash", ErrInvalidSignature)
+	}
+	hash, signature := tail[:end], tail[end+1:]
+
+	sum := sha1.Sum(letter)
+	if string(hash) != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("%w: manifest hash mismatch", ErrInvalidSignature)
+	}
+	digest := sha1.Sum(hash)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA1, digest[:], signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
 }
 
 // SubscribeToNotifications for a repository
@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testManifest returns an unsigned manifest of a repository
+func testManifest(repository string, revision uint64) []byte {
+	return []byte(fmt.Sprintf("Cabcdef\nB1234\nT1700000000\nN%v\nS%v\n", repository, revision))
+}
+
+// signManifest appends the hash of the manifest and its signature
+func signManifest(t *testing.T, manifest []byte, key *rsa.PrivateKey) []byte {
+	sum := sha1.Sum(manifest)
+	hash := hex.EncodeToString(sum[:])
+	digest := sha1.Sum([]byte(hash))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, digest[:])
+	if err != nil {
+		t.Fatalf("could not sign manifest: %v", err)
+	}
+	signed := append([]byte{}, manifest...)
+	signed = append(signed, []byte("--\n"+hash+"\n")...)
+	return append(signed, signature...)
+}
+
+// writeCertificate writes a self-signed certificate of the repository key
+func writeCertificate(t *testing.T, dir, repository string, key *rsa.PrivateKey) {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: repository},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, repository+".crt"), data, 0644); err != nil {
+		t.Fatalf("could not write certificate: %v", err)
+	}
+}
+
+func TestNotificationServicePublish(t *testing.T) {
+	backend, tmp := StartTestBackend("notification_service_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	repo := "test2.repo.org"
+	handle := backend.SubscribeToNotifications(ctx, repo)
+	defer backend.UnsubscribeFromNotifications(ctx, repo, handle)
+
+	t.Run("key not bound", func(t *testing.T) {
+		err := backend.PublishManifest(ctx, "admin0", repo, testManifest(repo, 1))
+		var authErr *AuthError
+		if !errors.As(err, &authErr) {
+			t.Errorf("expected authorization error, got: %v", err)
+		}
+	})
+	t.Run("other repository", func(t *testing.T) {
+		err := backend.PublishManifest(ctx, "keyid1", repo, testManifest("test1.repo.org", 1))
+		if !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("expected invalid manifest error, got: %v", err)
+		}
+	})
+	t.Run("key bound to a subpath", func(t *testing.T) {
+		if err := backend.PublishManifest(ctx, "keyid2", repo, testManifest(repo, 2)); err != nil {
+			t.Fatalf("could not publish manifest: %v", err)
+		}
+		msg := <-handle
+		if msg.Revision != 2 || msg.RootHash != "abcdef" || msg.Timestamp.Unix() != 1700000000 {
+			t.Errorf("unexpected notification: %+v", msg)
+		}
+	})
+	t.Run("outdated", func(t *testing.T) {
+		err := backend.PublishManifest(ctx, "keyid1", repo, testManifest(repo, 1))
+		if !errors.Is(err, ErrOutdatedManifest) {
+			t.Errorf("expected outdated manifest error, got: %v", err)
+		}
+	})
+	t.Run("signature", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatalf("could not generate key: %v", err)
+		}
+		backend.Config.ManifestKeyDir = t.TempDir()
+		defer func() { backend.Config.ManifestKeyDir = "" }()
+		writeCertificate(t, backend.Config.ManifestKeyDir, repo, key)
+
+		err = backend.PublishManifest(ctx, "keyid1", repo, testManifest(repo, 3))
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("unsigned manifest was accepted: %v", err)
+		}
+		signed := signManifest(t, testManifest(repo, 3), key)
+		tampered := append([]byte{}, signed...)
+		tampered[1] = 'x'
+		if err := backend.PublishManifest(ctx, "keyid1", repo, tampered); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("tampered manifest was accepted: %v", err)
+		}
+		if err := backend.PublishManifest(ctx, "keyid1", repo, signed); err != nil {
+			t.Fatalf("could not publish signed manifest: %v", err)
+		}
+		if msg := <-handle; msg.Revision != 3 {
+			t.Errorf("unexpected notification: %+v", msg)
+		}
+	})
+}
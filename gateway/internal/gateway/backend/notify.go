@@ -1,15 +1,39 @@
 package backend
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 )
 
-// NotificationMessage is an alias for a UTF-8 string
-type NotificationMessage string
+// ErrOutdatedManifest is returned when publishing a manifest older than the
+// last one published for the repository
+var ErrOutdatedManifest = fmt.Errorf("outdated_manifest")
+
+// NotificationMessage announces a new revision of a repository. It is relayed
+// to the subscribers as a JSON document.
+type NotificationMessage struct {
+	Version    int       `json:"version"`
+	Type       string    `json:"type"`
+	Repository string    `json:"repository"`
+	Revision   uint64    `json:"revision"`
+	RootHash   string    `json:"root_hash"`
+	Timestamp  time.Time `json:"timestamp"`
+	// Manifest is the signed repository manifest (.cvmfspublished), encoded
+	// in base64 in JSON
+	Manifest []byte `json:"manifest"`
+	// Via are the IDs of the gateways the notification went through, starting
+	// with the one it was published to
+	Via []string `json:"via,omitempty"`
+}
 
 // SubscriberHandle is the writable end of a channel of notification messages
 type SubscriberHandle chan NotificationMessage
@@ -20,6 +44,7 @@ type SubscriberSet map[SubscriberHandle]struct{}
 // SubscriberMap holds a set of subscriber handles for each repository
 type SubscriberMap map[string]SubscriberSet
 
+// NotificationStore holds the last message published for each repository
 type NotificationStore map[string]NotificationMessage
 
 // NotificationSystem encapsulates the functionality of the repository
@@ -28,35 +53,125 @@ type NotificationSystem struct {
 	Subscribers    SubscriberMap
 	SubscriberLock sync.RWMutex
 	Store          NotificationStore
+	StoreLock      sync.Mutex
+	// storeDir keeps a copy of the store (<REPO>.json), so that the outdated
+	// manifests are still rejected after a restart
+	storeDir string
 }
 
-// NewNotificationSystem is a constructor function for the NotificationSystem type
+// NewNotificationSystem is a constructor function for the NotificationSystem
+// type. The store is loaded from the notifications directory of workDir.
 func NewNotificationSystem(workDir string) (*NotificationSystem, error) {
+	storeDir := filepath.Join(workDir, "notifications")
+	if err := os.MkdirAll(storeDir, 0777); err != nil {
+		return nil, fmt.Errorf("could not create notification store: %w", err)
+	}
+	store, err := loadNotificationStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+
 	gw.Log("notify", gw.LogInfo).
-		Msgf("database opened (work dir: %v)", workDir)
+		Msgf("database opened (work dir: %v, repositories: %v)", workDir, len(store))
 
 	ns := &NotificationSystem{
 		Subscribers:    make(SubscriberMap),
 		SubscriberLock: sync.RWMutex{},
-		Store:          make(NotificationStore),
+		Store:          store,
+		storeDir:       storeDir,
 	}
 
 	return ns, nil
 }
 
-// Publish a new repository manifest to the notification system
-func (ns *NotificationSystem) Publish(
-	ctx context.Context, repository string, message NotificationMessage) {
+// loadNotificationStore reads the last messages saved in the store directory
+func loadNotificationStore(storeDir string) (NotificationStore, error) {
+	entries, err := os.ReadDir(storeDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read notification store: %w", err)
+	}
+	store := make(NotificationStore)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(storeDir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read notification store: %w", err)
+		}
+		var message NotificationMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, fmt.Errorf("invalid notification store entry %v: %w", e.Name(), err)
+		}
+		store[message.Repository] = message
+	}
+	return store, nil
+}
+
+// save writes the last message of a repository to the store directory. The
+// message replaces the previous one atomically.
+func (ns *NotificationSystem) save(message NotificationMessage) error {
+	if message.Repository == "" || strings.ContainsAny(message.Repository, "/\\") || strings.HasPrefix(message.Repository, ".") {
+		return fmt.Errorf("invalid repository name %q", message.Repository)
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	fileName := filepath.Join(ns.storeDir, message.Repository+".json")
+	tmp, err := os.CreateTemp(ns.storeDir, message.Repository+".json.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// Publish a new repository manifest to the notification system. Manifests
+// older than the last one published for the repository are rejected.
+func (ns *NotificationSystem) Publish(ctx context.Context, message NotificationMessage) error {
+	repository := message.Repository
 
-	existing := ns.getMessage(repository)
-	if existing == "" || message != existing {
-		ns.setMessage(repository, message)
+	changed, err := func() (bool, error) {
+		ns.StoreLock.Lock()
+		defer ns.StoreLock.Unlock()
+		existing, present := ns.Store[repository]
+		if present {
+			if message.Revision < existing.Revision {
+				return false, fmt.Errorf(
+					"%w: revision %v is older than revision %v",
+					ErrOutdatedManifest, message.Revision, existing.Revision)
+			}
+			if message.Revision == existing.Revision && bytes.Equal(message.Manifest, existing.Manifest) {
+				return false, nil
+			}
+		}
+		if err := ns.save(message); err != nil {
+			return false, fmt.Errorf("could not save notification: %w", err)
+		}
+		ns.Store[repository] = message
+		return true, nil
+	}()
+	if err != nil {
+		return err
+	}
+	if changed {
 		ns.notify(repository, message)
 	}
 
 	gw.LogC(ctx, "notify", gw.LogDebug).
 		Str("repository", repository).
+		Uint64("revision", message.Revision).
 		Msg("manifest published")
+
+	return nil
 }
 
 // Subscribe the handle to messages for the given repository
@@ -83,9 +198,7 @@ func (ns *NotificationSystem) Subscribe(
 	}()
 
 	if added {
-		message := ns.getMessage(repository)
-
-		if message != "" {
+		if message, present := ns.getMessage(repository); present {
 			ns.notify(repository, message)
 		}
 
@@ -121,6 +234,17 @@ func (ns *NotificationSystem) Unsubscribe(
 	return nil
 }
 
+// SubscriberCounts returns the number of subscribers of each repository
+func (ns *NotificationSystem) SubscriberCounts() map[string]int {
+	ns.SubscriberLock.RLock()
+	defer ns.SubscriberLock.RUnlock()
+	counts := make(map[string]int)
+	for repository, subs := range ns.Subscribers {
+		counts[repository] = len(subs)
+	}
+	return counts
+}
+
 func (ns *NotificationSystem) notify(repository string, message NotificationMessage) {
 	ns.SubscriberLock.RLock()
 	defer ns.SubscriberLock.RUnlock()
@@ -132,10 +256,9 @@ func (ns *NotificationSystem) notify(repository string, message NotificationMess
 	}
 }
 
-func (ns *NotificationSystem) getMessage(repository string) NotificationMessage {
-	return ns.Store[repository]
-}
-
-func (ns *NotificationSystem) setMessage(repository string, message NotificationMessage) {
-	ns.Store[repository] = message
+func (ns *NotificationSystem) getMessage(repository string) (NotificationMessage, bool) {
+	ns.StoreLock.Lock()
+	defer ns.StoreLock.Unlock()
+	message, present := ns.Store[repository]
+	return message, present
 }
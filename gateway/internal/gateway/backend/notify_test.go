@@ -2,11 +2,16 @@ package backend
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
 )
 
+func testNotification(revision uint64) NotificationMessage {
+	return NotificationMessage{Repository: "test.repo.org", Revision: revision, RootHash: "abc"}
+}
+
 func TestNotificationSystem(t *testing.T) {
 	tmp, err := ioutil.TempDir("", "test_notifications")
 	if err != nil {
@@ -26,19 +31,19 @@ func TestNotificationSystem(t *testing.T) {
 
 	ns.Subscribe(ctx, repo, hd)
 
-	ns.Publish(ctx, repo, NotificationMessage("msg1"))
-	ns.Publish(ctx, repo, NotificationMessage("msg2"))
+	ns.Publish(ctx, testNotification(1))
+	ns.Publish(ctx, testNotification(2))
 
 	ns.Unsubscribe(ctx, repo, hd)
 
-	ns.Publish(ctx, repo, NotificationMessage("msg3"))
+	ns.Publish(ctx, testNotification(3))
 
 	messages := make([]NotificationMessage, 0)
 	for m := range hd {
 		messages = append(messages, m)
 	}
 
-	if len(messages) != 2 || messages[0] != "msg1" || messages[1] != "msg2" {
+	if len(messages) != 2 || messages[0].Revision != 1 || messages[1].Revision != 2 {
 		t.Fatalf("Unexpected received message pattern: %v", messages)
 	}
 }
@@ -60,8 +65,8 @@ func TestNotificationSystemLateSubscription(t *testing.T) {
 	ctx := context.TODO()
 	repo := "test.repo.org"
 
-	ns.Publish(ctx, repo, NotificationMessage("msg1"))
-	ns.Publish(ctx, repo, NotificationMessage("msg2"))
+	ns.Publish(ctx, testNotification(1))
+	ns.Publish(ctx, testNotification(2))
 
 	ns.Subscribe(ctx, repo, hd)
 	ns.Unsubscribe(ctx, repo, hd)
@@ -71,7 +76,83 @@ func TestNotificationSystemLateSubscription(t *testing.T) {
 		messages = append(messages, m)
 	}
 
-	if len(messages) != 1 || messages[0] != "msg2" {
+	if len(messages) != 1 || messages[0].Revision != 2 {
 		t.Fatalf("Unexpected received message pattern: %v", messages)
 	}
 }
+
+func TestNotificationSystemOutdatedManifest(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_notifications")
+	if err != nil {
+		t.Fatalf("could not create temp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	ns, err := NewNotificationSystem(tmp)
+	if err != nil {
+		t.Fatalf("could not create notification system")
+	}
+
+	hd := make(chan NotificationMessage, 1000)
+
+	ctx := context.TODO()
+	repo := "test.repo.org"
+
+	ns.Subscribe(ctx, repo, hd)
+
+	if err := ns.Publish(ctx, testNotification(2)); err != nil {
+		t.Fatalf("could not publish manifest: %v", err)
+	}
+	if err := ns.Publish(ctx, testNotification(1)); !errors.Is(err, ErrOutdatedManifest) {
+		t.Errorf("older manifest was not rejected: %v", err)
+	}
+	// Republishing the same manifest does not notify the subscribers again
+	if err := ns.Publish(ctx, testNotification(2)); err != nil {
+		t.Errorf("could not republish manifest: %v", err)
+	}
+
+	ns.Unsubscribe(ctx, repo, hd)
+
+	messages := make([]NotificationMessage, 0)
+	for m := range hd {
+		messages = append(messages, m)
+	}
+
+	if len(messages) != 1 || messages[0].Revision != 2 {
+		t.Fatalf("Unexpected received message pattern: %v", messages)
+	}
+}
+
+func TestNotificationSystemRestart(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "test_notifications")
+	if err != nil {
+		t.Fatalf("could not create temp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	ns, err := NewNotificationSystem(tmp)
+	if err != nil {
+		t.Fatalf("could not create notification system")
+	}
+	ctx := context.TODO()
+	if err := ns.Publish(ctx, testNotification(3)); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	// The last manifest of the repository is still known after a restart
+	ns, err = NewNotificationSystem(tmp)
+	if err != nil {
+		t.Fatalf("could not create notification system: %v", err)
+	}
+	if err := ns.Publish(ctx, testNotification(2)); !errors.Is(err, ErrOutdatedManifest) {
+		t.Errorf("outdated manifest accepted after a restart: %v", err)
+	}
+	hd := make(chan NotificationMessage, 1)
+	ns.Subscribe(ctx, "test.repo.org", hd)
+	if m := <-hd; m.Revision != 3 {
+		t.Errorf("unexpected message after a restart: %+v", m)
+	}
+	if err := ns.Publish(ctx, testNotification(4)); err != nil {
+		t.Errorf("could not publish after a restart: %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// PayloadAccepted is the result recorded for payloads which were successfully
+// processed by the receiver
+const PayloadAccepted = "ok"
+
+// ErrPayloadAlreadyAccepted is returned when a payload digest was already
+// accepted for the lease, by a concurrent submission of the same payload
+var ErrPayloadAlreadyAccepted = fmt.Errorf("payload already accepted")
+
+// Payload is a record of a payload submission made against a lease
+type Payload struct {
+	ID         int64
+	Token      string
+	Digest     string
+	HeaderSize int
+	Size       int64
+	Received   time.Time
+	Duration   time.Duration
+	Result     string
+	// Encoding is the content encoding of the payload upload, empty if the
+	// payload was not compressed
+	Encoding string
+	// TransferredSize is the size of the payload as received, Size the size
+	// after decompression
+	TransferredSize int64
+}
+
+func CreatePayload(ctx context.Context, tx *sql.Tx, payload Payload) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"insert into Payload (Token, Digest, HeaderSize, Size, Received, Duration, Result, Encoding, TransferredSize) values (?, ?, ?, ?, ?, ?, ?, ?, ?) on conflict do nothing;",
+		payload.Token, payload.Digest, payload.HeaderSize, payload.Size,
+		payload.Received.UnixMilli(), payload.Duration.Milliseconds(), payload.Result,
+		payload.Encoding, payload.TransferredSize)
+	if err != nil {
+		return fmt.Errorf("could not insert new payload: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	// Only the accepted payloads are unique for a lease
+	if err == nil && numInserts == 0 {
+		return ErrPayloadAlreadyAccepted
+	}
+
+	gw.LogC(ctx, "payload_entity", gw.LogDebug).
+		Str("operation", "create").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("digest: %v, size: %v, result: %v", payload.Digest, payload.Size, payload.Result)
+
+	return nil
+}
+
+func FindAllPayloadsByToken(ctx context.Context, tx *sql.Tx, token string) ([]Payload, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"select * from Payload where Token = ? order by ID;", token)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	payloads := make([]Payload, 0)
+	for rows.Next() {
+		var payload Payload
+		if err := scanPayload(rows, &payload); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		payloads = append(payloads, payload)
+	}
+
+	gw.LogC(ctx, "payload_entity", gw.LogDebug).
+		Str("operation", "find_all_by_token").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v payloads", len(payloads))
+
+	return payloads, nil
+}
+
+func FindAcceptedPayloadByTokenAndDigest(ctx context.Context, tx *sql.Tx, token, digest string) (*Payload, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"select * from Payload where Token = ? and Digest = ? and Result = ?;", token, digest, PayloadAccepted)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var payload Payload
+	if rows.Next() {
+		if err := scanPayload(rows, &payload); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	} else {
+		return nil, nil
+	}
+
+	gw.LogC(ctx, "payload_entity", gw.LogDebug).
+		Str("operation", "find_accepted_by_token_and_digest").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("success")
+
+	return &payload, nil
+}
+
+// SumPayloadSizesByToken returns the total size of the payloads accepted for
+// a lease. The rejected and failed submissions, which are retried, are not
+// counted.
+func SumPayloadSizesByToken(ctx context.Context, tx *sql.Tx, token string) (int64, error) {
+	t0 := time.Now()
+
+	var total int64
+	if err := tx.QueryRowContext(
+		ctx,
+		"select coalesce(sum(Size), 0) from Payload where Token = ? and Result = ?;",
+		token, PayloadAccepted).Scan(&total); err != nil {
+		return 0, fmt.Errorf("query failed: %w", err)
+	}
+
+	gw.LogC(ctx, "payload_entity", gw.LogDebug).
+		Str("operation", "sum_sizes_by_token").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("total size: %v", total)
+
+	return total, nil
+}
+
+func DeleteAllPayloadsByToken(ctx context.Context, tx *sql.Tx, token string) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx, "delete from Payload where Token = ?", token)
+	if err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "payload_entity", gw.LogDebug).
+		Str("operation", "delete_all_by_token").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("deleted %v payloads", numDeleted)
+
+	return nil
+}
+
+// DeleteAllOrphanedPayloads removes the payload records of leases which no
+// longer exist (expired or cancelled)
+func DeleteAllOrphanedPayloads(ctx context.Context, tx *sql.Tx) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx, "delete from Payload where Token not in (select Token from Lease)")
+	if err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+	numDeleted, _ := res.RowsAffected()
+
+	gw.LogC(ctx, "payload_entity", gw.LogDebug).
+		Str("operation", "delete_all_orphaned").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("deleted %v payloads", numDeleted)
+
+	return nil
+}
+
+func scanPayload(rows *sql.Rows, payload *Payload) error {
+	var receivedMilli, durationMilli int64
+	if err := rows.Scan(
+		&payload.ID,
+		&payload.Token,
+		&payload.Digest,
+		&payload.HeaderSize,
+		&payload.Size,
+		&receivedMilli,
+		&durationMilli,
+		&payload.Result,
+		&payload.Encoding,
+		&payload.TransferredSize); err != nil {
+		return err
+	}
+
+	payload.Received = time.UnixMilli(receivedMilli)
+	payload.Duration = time.Duration(durationMilli) * time.Millisecond
+
+	return nil
+}
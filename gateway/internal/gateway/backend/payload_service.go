@@ -1,13 +1,204 @@
 package backend
 
 import (
+	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
+
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of the payload uploads
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
 )
 
-// SubmitPayload to be unpacked into the repository
+// ErrInvalidEncoding is returned for payloads with an unsupported content
+// encoding, or which can not be decompressed
+var ErrInvalidEncoding = fmt.Errorf("invalid_encoding")
+
+// ErrPayloadTooLarge is returned when the payloads of a lease exceed the
+// configured maximum size
+var ErrPayloadTooLarge = fmt.Errorf("payload_too_large")
+
+// PayloadDTO is the payload information returned to the HTTP frontend
+type PayloadDTO struct {
+	Digest     string `json:"digest"`
+	HeaderSize int    `json:"header_size"`
+	Size       int64  `json:"size"`
+	Received   string `json:"received"`
+	DurationMs int64  `json:"duration_ms"`
+	Result     string `json:"result"`
+	Encoding   string `json:"encoding,omitempty"`
+	// TransferredSize is the size of the payload as received, before
+	// decompression
+	TransferredSize int64 `json:"transferred_size"`
+}
+
+// countingReader counts the number of bytes read from the underlying reader
+type countingReader struct {
+	rd    io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// payloadBudgets keeps the payload size budgets of the leases with uploads in
+// progress. The concurrent uploads of a lease share its budget, the bytes are
+// reserved before they are passed to the receiver.
+type payloadBudgets struct {
+	mu     sync.Mutex
+	leases map[string]*payloadBudget
+}
+
+type payloadBudget struct {
+	// used is the size of the accepted payloads of the lease, including the
+	// bytes reserved by the uploads in progress
+	used    int64
+	uploads int
+}
+
+// acquire returns the budget of the lease, which is loaded with the size of
+// the accepted payloads when no other upload of the lease is in progress
+func (b *payloadBudgets) acquire(token string, load func() (int64, error)) (*payloadBudget, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	budget, ok := b.leases[token]
+	if !ok {
+		used, err := load()
+		if err != nil {
+			return nil, err
+		}
+		budget = &payloadBudget{used: used}
+		if b.leases == nil {
+			b.leases = make(map[string]*payloadBudget)
+		}
+		b.leases[token] = budget
+	}
+	budget.uploads++
+	return budget, nil
+}
+
+// reserve up to n bytes of the budget, below the limit
+func (b *payloadBudgets) reserve(budget *payloadBudget, n, limit int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > limit-budget.used {
+		n = limit - budget.used
+	}
+	if n < 0 {
+		n = 0
+	}
+	budget.used += n
+	return n
+}
+
+// release the budget of an upload, returning the bytes reserved by a payload
+// which was not accepted
+func (b *payloadBudgets) release(token string, budget *payloadBudget, unused int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	budget.used -= unused
+	budget.uploads--
+	if budget.uploads == 0 {
+		delete(b.leases, token)
+	}
+}
+
+// sizeLimitReader fails once the bytes read exceed the payload size budget of
+// the lease
+type sizeLimitReader struct {
+	rd       io.Reader
+	budgets  *payloadBudgets
+	budget   *payloadBudget
+	limit    int64
+	reserved int64
+	exceeded bool
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.exceeded {
+		return 0, ErrPayloadTooLarge
+	}
+	n, err := r.rd.Read(p)
+	granted := r.budgets.reserve(r.budget, int64(n), r.limit)
+	r.reserved += granted
+	if granted < int64(n) {
+		// The bytes above the limit are not passed on
+		r.exceeded = true
+		n = int(granted)
+		err = ErrPayloadTooLarge
+	}
+	return n, err
+}
+
+// EncodedPayload decompresses a payload upload while it is read
+type EncodedPayload struct {
+	// Encoding is the content encoding of the upload, empty if the payload
+	// is not compressed
+	Encoding string
+	wire     *countingReader
+	rd       io.Reader
+	close    func()
+}
+
+// DecodePayload returns a reader decompressing rd according to the content
+// encoding of the upload (EncodingGzip, EncodingZstd or no encoding)
+func DecodePayload(rd io.Reader, encoding string) (*EncodedPayload, error) {
+	wire := &countingReader{rd: rd}
+	p := &EncodedPayload{Encoding: encoding, wire: wire, rd: wire, close: func() {}}
+	switch encoding {
+	case "", EncodingIdentity:
+		p.Encoding = ""
+	case EncodingGzip:
+		zr, err := gzip.NewReader(wire)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+		}
+		p.rd = zr
+		p.close = func() { zr.Close() }
+	case EncodingZstd:
+		// A single goroutine is enough, since the receiver is the bottleneck
+		zr, err := zstd.NewReader(wire, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+		}
+		p.rd = zr
+		p.close = zr.Close
+	default:
+		return nil, fmt.Errorf("%w: unsupported content encoding %v", ErrInvalidEncoding, encoding)
+	}
+	return p, nil
+}
+
+func (p *EncodedPayload) Read(b []byte) (int, error) {
+	return p.rd.Read(b)
+}
+
+// TransferredBytes returns the number of bytes of the upload read so far
+func (p *EncodedPayload) TransferredBytes() int64 {
+	return p.wire.count
+}
+
+// Close releases the decompressor
+func (p *EncodedPayload) Close() error {
+	p.close()
+	return nil
+}
+
+// SubmitPayload to be unpacked into the repository. Payloads whose digest was
+// already accepted for the lease are not submitted again to the receiver.
 func (s *Services) SubmitPayload(ctx context.Context, token string, payload io.Reader, digest string, headerSize int) error {
 	t0 := time.Now()
 
@@ -32,17 +223,220 @@ func (s *Services) SubmitPayload(ctx context.Context, token string, payload io.R
 		return err
 	}
 
+	existing, err := FindAcceptedPayloadByTokenAndDigest(ctx, tx, token, digest)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("could not commit transaction: %w", err)
 	}
 
-	if lease == nil {
-		return fmt.Errorf("lease not found: %w", err)
+	if existing != nil {
+		outcome = fmt.Sprintf("success: payload %v already accepted", digest)
+		return nil
 	}
 
-	if err := s.Pool.SubmitPayload(ctx, lease.CombinedLeasePath(), payload, digest, headerSize); err != nil {
+	leasePath := lease.CombinedLeasePath()
+	if err := s.StatsMgr.AdmitPayload(leasePath); err != nil {
 		outcome = err.Error()
 		return err
 	}
+
+	// The size of compressed payloads is only known once they are
+	// decompressed, the limit is enforced while they are read
+	var limiter *sizeLimitReader
+	accepted := false
+	rd := payload
+	if s.Config.MaxLeasePayloadSize > 0 {
+		budget, err := s.payloadBudgets.acquire(token, func() (int64, error) {
+			return s.sumAcceptedPayloadSizes(ctx, token)
+		})
+		if err != nil {
+			outcome = err.Error()
+			return err
+		}
+		limiter = &sizeLimitReader{
+			rd:      payload,
+			budgets: &s.payloadBudgets,
+			budget:  budget,
+			limit:   s.Config.MaxLeasePayloadSize,
+		}
+		rd = limiter
+		// The budget is released once the payload is recorded, the bytes of an
+		// accepted payload are then counted by the lease DB
+		defer func() {
+			var unused int64
+			if !accepted {
+				unused = limiter.reserved
+			}
+			s.payloadBudgets.release(token, budget, unused)
+		}()
+	}
+	encoding := ""
+	encoded, isEncoded := payload.(*EncodedPayload)
+	if isEncoded {
+		encoding = encoded.Encoding
+	}
+
+	counter := &countingReader{rd: rd}
+	submitErr := s.Pool.SubmitPayload(ctx, leasePath, counter, digest, headerSize)
+
+	record := Payload{
+		Token:           token,
+		Digest:          digest,
+		HeaderSize:      headerSize,
+		Size:            counter.count,
+		Received:        t0,
+		Duration:        time.Since(t0),
+		Result:          PayloadAccepted,
+		Encoding:        encoding,
+		TransferredSize: counter.count,
+	}
+	if encoding != "" {
+		// The decompressor reads ahead, the transferred size of a compressed
+		// upload includes the request message which precedes the payload
+		record.TransferredSize = encoded.TransferredBytes()
+	}
+	// The quota usage of the lease, once the receiver accepted the payload,
+	// is kept for the recovery of the lease after a restart
+	var usage *stats.QuotaUsage
+	if limiter != nil && limiter.exceeded {
+		submitErr = fmt.Errorf(
+			"%w: more than %v bytes submitted for the lease", ErrPayloadTooLarge, s.Config.MaxLeasePayloadSize)
+		record.Result = submitErr.Error()
+	} else if submitErr != nil {
+		submitErr = ReceiverError{submitErr}
+		record.Result = submitErr.Error()
+	} else {
+		s.StatsMgr.AddTransfer(leasePath, record.Size, record.TransferredSize)
+		if u, err := s.StatsMgr.GetLeaseQuota(leasePath); err == nil {
+			usage = &u
+		}
+		// The content of a payload is only known once the receiver unpacked
+		// it, the payload exceeding the quota is reported as failed
+		if err := s.StatsMgr.CheckLeaseQuota(leasePath); err != nil {
+			submitErr = err
+			record.Result = submitErr.Error()
+		}
+	}
+
+	recorded, err := s.recordPayload(ctx, record, usage)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if !recorded {
+		outcome = fmt.Sprintf("success: payload %v already accepted", digest)
+		return nil
+	}
+	accepted = record.Result == PayloadAccepted
+
+	if submitErr != nil {
+		outcome = submitErr.Error()
+		return submitErr
+	}
 	return nil
 }
+
+// GetLeasePayloads returns the payloads which were submitted for a lease
+func (s *Services) GetLeasePayloads(ctx context.Context, token string) ([]PayloadDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_lease_payloads", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lease, err := FindLeaseByToken(ctx, tx, token)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if lease == nil || lease.Expiration.Before(time.Now()) {
+		err := InvalidLeaseError{}
+		outcome = err.Error()
+		return nil, err
+	}
+
+	payloads, err := FindAllPayloadsByToken(ctx, tx, token)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := make([]PayloadDTO, 0, len(payloads))
+	for _, p := range payloads {
+		ret = append(ret, PayloadDTO{
+			Digest:          p.Digest,
+			HeaderSize:      p.HeaderSize,
+			Size:            p.Size,
+			Received:        p.Received.String(),
+			DurationMs:      p.Duration.Milliseconds(),
+			Result:          p.Result,
+			Encoding:        p.Encoding,
+			TransferredSize: p.TransferredSize,
+		})
+	}
+
+	return ret, nil
+}
+
+// sumAcceptedPayloadSizes returns the total size of the accepted payloads of a
+// lease
+func (s *Services) sumAcceptedPayloadSizes(ctx context.Context, token string) (int64, error) {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	total, err := SumPayloadSizesByToken(ctx, tx, token)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return total, nil
+}
+
+// recordPayload records a payload and, if given, the quota usage of its lease.
+// The payload is not recorded if the same digest was accepted for the lease in
+// the meantime.
+func (s *Services) recordPayload(ctx context.Context, payload Payload, usage *stats.QuotaUsage) (bool, error) {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	recorded := true
+	if err := CreatePayload(ctx, tx, payload); errors.Is(err, ErrPayloadAlreadyAccepted) {
+		recorded = false
+	} else if err != nil {
+		return false, err
+	}
+	if usage != nil {
+		if err := SaveLeaseUsage(ctx, tx, payload.Token, *usage); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return recorded, nil
+}
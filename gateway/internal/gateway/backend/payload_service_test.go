@@ -0,0 +1,405 @@
+package backend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestPayloadServiceManifest(t *testing.T) {
+	lastProtocolVersion := 3
+	backend, tmp := StartTestBackend("payload_service_test", 1*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", lastProtocolVersion)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+
+	t.Run("submit and list", func(t *testing.T) {
+		if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5); err != nil {
+			t.Fatalf("could not submit payload: %v", err)
+		}
+		payloads, err := backend.GetLeasePayloads(ctx, token)
+		if err != nil {
+			t.Fatalf("could not list payloads: %v", err)
+		}
+		if len(payloads) != 1 {
+			t.Fatalf("expected 1 payload, found %v", len(payloads))
+		}
+		p := payloads[0]
+		if p.Digest != "abcdef" || p.HeaderSize != 5 || p.Size != int64(len("DUMMY PAYLOAD")) {
+			t.Fatalf("invalid payload record: %+v", p)
+		}
+		if p.Result != PayloadAccepted {
+			t.Fatalf("payload should have been accepted: %+v", p)
+		}
+	})
+	t.Run("resubmit accepted digest", func(t *testing.T) {
+		payload := strings.NewReader("DUMMY PAYLOAD")
+		if err := backend.SubmitPayload(ctx, token, payload, "abcdef", 5); err != nil {
+			t.Fatalf("resubmission of accepted payload failed: %v", err)
+		}
+		if payload.Len() == 0 {
+			t.Fatalf("accepted payload should not have been re-ingested")
+		}
+		payloads, _ := backend.GetLeasePayloads(ctx, token)
+		if len(payloads) != 1 {
+			t.Fatalf("expected 1 payload after resubmission, found %v", len(payloads))
+		}
+	})
+	t.Run("concurrent resubmission", func(t *testing.T) {
+		// A concurrent submission of the digest passed the lookup before the
+		// payload was recorded
+		record := Payload{Token: token, Digest: "abcdef", HeaderSize: 5, Received: time.Now(), Result: PayloadAccepted}
+		recorded, err := backend.recordPayload(ctx, record, nil)
+		if err != nil || recorded {
+			t.Fatalf("expected the payload to be already accepted, got %v: %v", recorded, err)
+		}
+		payloads, _ := backend.GetLeasePayloads(ctx, token)
+		if len(payloads) != 1 {
+			t.Fatalf("expected 1 payload after the concurrent resubmission, found %v", len(payloads))
+		}
+	})
+	t.Run("invalid lease", func(t *testing.T) {
+		_, err := backend.GetLeasePayloads(ctx, NewLeaseToken())
+		if !errors.As(err, &InvalidLeaseError{}) {
+			t.Fatalf("expected invalid lease error, got: %v", err)
+		}
+	})
+	t.Run("records dropped with lease", func(t *testing.T) {
+		if err := backend.CancelLease(ctx, token); err != nil {
+			t.Fatalf("could not cancel lease: %v", err)
+		}
+		tx, err := backend.DB.SQL.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("could not begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		payloads, err := FindAllPayloadsByToken(ctx, tx, token)
+		if err != nil {
+			t.Fatalf("could not query payloads: %v", err)
+		}
+		if len(payloads) != 0 {
+			t.Fatalf("payload records should have been deleted with the lease")
+		}
+	})
+}
+
+func TestPayloadServiceTaskTimeout(t *testing.T) {
+	backend, tmp := StartTestBackend("payload_timeout_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	// Replace the pool of the test backend with one enforcing a short timeout
+	backend.Pool.Stop()
+	pool, err := receiver.StartPool("", 1, true, backend.StatsMgr, receiver.PoolOptions{TaskTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("could not start receiver pool: %v", err)
+	}
+	backend.Pool = pool
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+
+	pool.MockScript().SetLatency(10 * time.Second)
+	t0 := time.Now()
+	err = backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5)
+	var killed receiver.TaskKilledError
+	if !errors.As(err, &killed) || !errors.As(err, &ReceiverError{}) {
+		t.Fatalf("expected the task to be killed, got: %v", err)
+	}
+	if killed.Reason != receiver.KilledTimeout || killed.Task != "payload" {
+		t.Fatalf("invalid kill error: %+v", killed)
+	}
+	if time.Since(t0) > 5*time.Second {
+		t.Fatalf("task was not interrupted at the timeout")
+	}
+
+	pool.MockScript().SetLatency(0)
+	if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "012345", 5); err != nil {
+		t.Fatalf("payload submission after the timeout failed: %v", err)
+	}
+
+	st := backend.GetReceiverStatistics(ctx)
+	if st.Tasks != 2 || st.Failed != 1 || st.TimedOut != 1 || st.OutOfMemory != 0 || st.Busy != 0 {
+		t.Fatalf("invalid receiver statistics: %+v", st)
+	}
+}
+
+func TestPayloadServiceCompressed(t *testing.T) {
+	backend, tmp := StartTestBackend("payload_compressed_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+
+	payload := strings.Repeat("DUMMY PAYLOAD ", 1000)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(payload))
+	zw.Close()
+	zstdWriter, _ := zstd.NewWriter(nil)
+	zst := zstdWriter.EncodeAll([]byte(payload), nil)
+
+	uploads := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(payload)},
+		{EncodingGzip, gz.Bytes()},
+		{EncodingZstd, zst},
+	}
+	for i, u := range uploads {
+		rd, err := DecodePayload(bytes.NewReader(u.body), u.encoding)
+		if err != nil {
+			t.Fatalf("could not decode %v payload: %v", u.encoding, err)
+		}
+		if err := backend.SubmitPayload(ctx, token, rd, fmt.Sprintf("digest%v", i), 5); err != nil {
+			t.Fatalf("could not submit %v payload: %v", u.encoding, err)
+		}
+		rd.Close()
+	}
+
+	payloads, err := backend.GetLeasePayloads(ctx, token)
+	if err != nil {
+		t.Fatalf("could not list payloads: %v", err)
+	}
+	for i, p := range payloads {
+		if p.Size != int64(len(payload)) || p.Encoding != uploads[i].encoding ||
+			p.TransferredSize != int64(len(uploads[i].body)) {
+			t.Errorf("invalid payload record: %+v", p)
+		}
+	}
+
+	st, _ := backend.StatsMgr.GetLeaseStatistics("test2.repo.org/some/path")
+	transferred := int64(len(payload) + gz.Len() + len(zst))
+	if st.Transfer.PayloadBytes != 3*int64(len(payload)) || st.Transfer.TransferredBytes != transferred ||
+		st.Transfer.CompressionRatio <= 1 {
+		t.Errorf("invalid transfer statistics: %+v", st.Transfer)
+	}
+
+	if _, err := DecodePayload(bytes.NewReader(nil), "br"); !errors.Is(err, ErrInvalidEncoding) {
+		t.Errorf("unsupported encoding should have been rejected: %v", err)
+	}
+}
+
+func TestPayloadServiceMaxLeasePayloadSize(t *testing.T) {
+	backend, tmp := StartTestBackend("payload_size_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.MaxLeasePayloadSize = 20
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+
+	if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5); err != nil {
+		t.Fatalf("could not submit payload: %v", err)
+	}
+	err = backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "012345", 5)
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected the payload to exceed the lease limit, got: %v", err)
+	}
+	payloads, _ := backend.GetLeasePayloads(ctx, token)
+	if len(payloads) != 2 || payloads[1].Result == PayloadAccepted {
+		t.Fatalf("invalid payload records: %+v", payloads)
+	}
+}
+
+func TestPayloadServiceMaxLeasePayloadSizeRetry(t *testing.T) {
+	backend, tmp := StartTestBackend("payload_size_retry_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.MaxLeasePayloadSize = 20
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+
+	// The failed submission does not count towards the limit of its retry
+	backend.Pool.MockScript().FailPayloads(1)
+	if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5); err == nil {
+		t.Fatalf("expected the payload submission to fail")
+	}
+	if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5); err != nil {
+		t.Fatalf("could not submit payload again: %v", err)
+	}
+	payloads, _ := backend.GetLeasePayloads(ctx, token)
+	if len(payloads) != 2 || payloads[0].Result == PayloadAccepted || payloads[1].Result != PayloadAccepted {
+		t.Fatalf("invalid payload records: %+v", payloads)
+	}
+}
+
+func TestPayloadServiceSharedPayloadBudget(t *testing.T) {
+	var budgets payloadBudgets
+	load := func() (int64, error) { return 5, nil }
+
+	// Two uploads of the lease in progress at the same time
+	first, _ := budgets.acquire("token", load)
+	second, _ := budgets.acquire("token", load)
+	if first != second {
+		t.Fatalf("expected the uploads to share the budget of the lease")
+	}
+	a := &sizeLimitReader{rd: strings.NewReader("0123456789"), budgets: &budgets, budget: first, limit: 20}
+	b := &sizeLimitReader{rd: strings.NewReader("0123456789"), budgets: &budgets, budget: second, limit: 20}
+
+	if n, err := io.Copy(io.Discard, a); n != 10 || err != nil {
+		t.Fatalf("expected the first upload to be read, got %v bytes: %v", n, err)
+	}
+	n, err := io.Copy(io.Discard, b)
+	if n != 5 || !errors.Is(err, ErrPayloadTooLarge) {
+		t.Fatalf("expected the second upload to exceed the limit after 5 bytes, got %v bytes: %v", n, err)
+	}
+
+	budgets.release("token", first, 0)
+	if first.used != 20 {
+		t.Fatalf("invalid budget after the first upload: %v", first.used)
+	}
+	budgets.release("token", second, b.reserved)
+	if first.used != 15 || len(budgets.leases) != 0 {
+		t.Fatalf("invalid budget after the second upload: %v, %v", first.used, budgets.leases)
+	}
+}
+
+func TestPayloadServiceQuota(t *testing.T) {
+	backend, tmp := StartTestBackend("payload_quota_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.Quotas = []gw.QuotaConfig{
+		{Repository: "test2.repo.org", MaxPayloads: 2},
+		{Repository: "test2.repo.org", KeyID: "keyid1", MaxUploadedBytes: 20},
+		{KeyID: "keyid1", MaxPayloads: 5},
+	}
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+
+	lease, err := backend.GetLease(ctx, token)
+	if err != nil || lease.Quota == nil {
+		t.Fatalf("could not obtain lease quota: %v", err)
+	}
+	if lease.Quota.MaxPayloads != 2 || lease.Quota.MaxUploadedBytes != 20 || lease.Quota.MaxCatalogs != 0 {
+		t.Errorf("unexpected lease quota: %+v", lease.Quota.Quota)
+	}
+
+	if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5); err != nil {
+		t.Fatalf("could not submit payload: %v", err)
+	}
+	// The second payload exceeds the uploaded bytes, the next ones are
+	// rejected and the lease can not be committed
+	err = backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "012345", 5)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected the payload to exceed the lease quota, got: %v", err)
+	}
+	err = backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "6789ab", 5)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the payload to be rejected, got: %v", err)
+	}
+	if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the commit to be refused, got: %v", err)
+	}
+
+	lease, _ = backend.GetLease(ctx, token)
+	if lease.Quota.Payloads != 2 || lease.Quota.UploadedBytes != 26 || lease.Quota.Exceeded == "" {
+		t.Errorf("unexpected lease quota usage: %+v", lease.Quota)
+	}
+
+	// Reaching the number of payloads rejects the next ones, but the lease
+	// is within its quota
+	token2, err := backend.NewLease(ctx, "keyid2", "test2.repo.org/restricted/to/subdir", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	for _, digest := range []string{"abcdef", "012345"} {
+		if err := backend.SubmitPayload(ctx, token2, strings.NewReader("DUMMY PAYLOAD"), digest, 5); err != nil {
+			t.Fatalf("could not submit payload: %v", err)
+		}
+	}
+	err = backend.SubmitPayload(ctx, token2, strings.NewReader("DUMMY PAYLOAD"), "6789ab", 5)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the payload to be rejected, got: %v", err)
+	}
+	if _, err := backend.CommitLease(ctx, token2, "old_hash", "new_hash", gw.RepositoryTag{}); err != nil {
+		t.Errorf("could not commit lease within its quota: %v", err)
+	}
+}
+
+func TestPayloadServiceQuotaRestart(t *testing.T) {
+	backend, tmp := StartTestBackend("payload_quota_restart_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.Quotas = []gw.QuotaConfig{{Repository: "test2.repo.org", MaxPayloads: 1}}
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	defer backend.CancelLease(ctx, token)
+	if err := backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "abcdef", 5); err != nil {
+		t.Fatalf("could not submit payload: %v", err)
+	}
+
+	// After a restart, the statistics of the active leases are recovered
+	// from the lease DB, with the quota of the configuration and the usage
+	// of the payloads submitted before the restart
+	backend.StatsMgr.PopLease("test2.repo.org/some/path")
+	if err := RecoverLeaseStatistics(backend); err != nil {
+		t.Fatalf("could not recover lease statistics: %v", err)
+	}
+	lease, err := backend.GetLease(ctx, token)
+	if err != nil || lease.Quota == nil || lease.Quota.MaxPayloads != 1 || lease.Quota.Payloads != 1 {
+		t.Fatalf("lease quota not recovered: %+v %v", lease, err)
+	}
+	err = backend.SubmitPayload(ctx, token, strings.NewReader("DUMMY PAYLOAD"), "012345", 5)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("expected the payload to exceed the recovered quota, got: %v", err)
+	}
+}
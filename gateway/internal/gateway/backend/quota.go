@@ -0,0 +1,32 @@
+package backend
+
+import (
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// ErrQuotaExceeded is returned when a lease exceeds its quota: its payloads are
+// rejected and it can not be committed
+var ErrQuotaExceeded = stats.ErrQuotaExceeded
+
+// leaseQuota returns the quota of the leases of a key in a repository, made of
+// the lowest of the configured limits matching the repository and the key
+func (s *Services) leaseQuota(repository, keyID string) stats.Quota {
+	var quota stats.Quota
+	for _, q := range s.Config.Quotas {
+		if (q.Repository != "" && q.Repository != repository) || (q.KeyID != "" && q.KeyID != keyID) {
+			continue
+		}
+		quota.MaxUploadedBytes = lowestLimit(quota.MaxUploadedBytes, q.MaxUploadedBytes)
+		quota.MaxPayloads = lowestLimit(quota.MaxPayloads, q.MaxPayloads)
+		quota.MaxCatalogs = lowestLimit(quota.MaxCatalogs, q.MaxCatalogs)
+	}
+	return quota
+}
+
+// lowestLimit returns the lowest of two limits, where zero means unlimited
+func lowestLimit(a, b int64) int64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
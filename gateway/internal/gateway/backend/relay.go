@@ -0,0 +1,262 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// ErrRelayLoop is returned when a notification relayed from an upstream
+// gateway was already relayed by this gateway
+var ErrRelayLoop = fmt.Errorf("relay_loop")
+
+// The delay before reconnecting to an upstream gateway doubles after each
+// failed attempt, from relayMinBackoff up to relayMaxBackoff
+var (
+	relayMinBackoff = time.Second
+	relayMaxBackoff = 5 * time.Minute
+)
+
+// RelayStatusDTO is the state of the subscription to the notifications of a
+// repository on an upstream gateway
+type RelayStatusDTO struct {
+	Upstream   string `json:"upstream"`
+	Repository string `json:"repository"`
+	Connected  bool   `json:"connected"`
+	// Received is the number of notifications received from the upstream
+	Received uint64 `json:"received"`
+	// Dropped is the number of received notifications which were not
+	// re-published: looping, outdated or invalid
+	Dropped uint64 `json:"dropped"`
+	// Reconnects is the number of times the subscription was re-established
+	Reconnects  int    `json:"reconnects"`
+	LastMessage string `json:"last_message,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	// NextAttempt is the time of the next connection attempt, while
+	// disconnected
+	NextAttempt string `json:"next_attempt,omitempty"`
+}
+
+// relaySubscription is the subscription to one repository of an upstream
+type relaySubscription struct {
+	mu     sync.Mutex
+	status RelayStatusDTO
+}
+
+func (r *relaySubscription) update(f func(st *RelayStatusDTO)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(&r.status)
+}
+
+// Relays re-publish the notifications of upstream gateways to the subscribers
+// of this gateway
+type Relays struct {
+	subscriptions []*relaySubscription
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+}
+
+// StartRelays subscribes to the configured upstream gateways
+func (s *Services) StartRelays() *Relays {
+	ctx, cancel := context.WithCancel(context.Background())
+	relays := &Relays{cancel: cancel}
+	for _, cfg := range s.Config.Relays {
+		upstream := strings.TrimSuffix(cfg.URL, "/")
+		for _, repository := range cfg.Repositories {
+			sub := &relaySubscription{
+				status: RelayStatusDTO{Upstream: upstream, Repository: repository},
+			}
+			relays.subscriptions = append(relays.subscriptions, sub)
+			relays.wg.Add(1)
+			go func() {
+				defer relays.wg.Done()
+				s.runRelay(ctx, sub)
+			}()
+		}
+	}
+	return relays
+}
+
+// Stop closes the subscriptions to the upstream gateways
+func (r *Relays) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// Status returns the state of the subscriptions to the upstream gateways
+func (r *Relays) Status() []RelayStatusDTO {
+	status := make([]RelayStatusDTO, 0, len(r.subscriptions))
+	for _, sub := range r.subscriptions {
+		sub.mu.Lock()
+		status = append(status, sub.status)
+		sub.mu.Unlock()
+	}
+	return status
+}
+
+// GetRelayStatus returns the state of the subscriptions to the upstream
+// gateways relayed by this gateway
+func (s *Services) GetRelayStatus(ctx context.Context) ([]RelayStatusDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_relay_status", &outcome, t0)
+
+	if s.Relays == nil {
+		return []RelayStatusDTO{}, nil
+	}
+	return s.Relays.Status(), nil
+}
+
+// gatewayID identifies this gateway in the relayed notifications
+func (s *Services) gatewayID() string {
+	if s.Config.GatewayID != "" {
+		return s.Config.GatewayID
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// runRelay keeps a subscription to an upstream gateway open until ctx is
+// cancelled, reconnecting with an exponential backoff
+func (s *Services) runRelay(ctx context.Context, sub *relaySubscription) {
+	backoff := relayMinBackoff
+	for {
+		connected, err := s.relayStream(ctx, sub)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = relayMinBackoff
+		}
+		sub.update(func(st *RelayStatusDTO) {
+			st.Connected = false
+			st.LastError = err.Error()
+			st.NextAttempt = time.Now().Add(backoff).UTC().Format(time.RFC3339)
+		})
+		gw.Log("relay", gw.LogWarn).
+			Err(err).
+			Str("upstream", sub.status.Upstream).
+			Str("repository", sub.status.Repository).
+			Msgf("subscription interrupted, reconnecting in %v", backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > relayMaxBackoff {
+			backoff = relayMaxBackoff
+		}
+	}
+}
+
+// relayStream subscribes to the notifications of a repository on an upstream
+// gateway and re-publishes them until the stream ends. It returns whether the
+// subscription was established.
+func (s *Services) relayStream(ctx context.Context, sub *relaySubscription) (bool, error) {
+	upstream, repository := sub.status.Upstream, sub.status.Repository
+
+	body, _ := json.Marshal(map[string]interface{}{"version": 1, "repository": repository})
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, upstream+"/notifications/subscribe", bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("could not create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("could not subscribe to %v: %w", upstream, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("could not subscribe to %v: %v", upstream, resp.Status)
+	}
+
+	sub.update(func(st *RelayStatusDTO) {
+		if st.LastError != "" {
+			st.Reconnects++
+		}
+		st.Connected = true
+		st.NextAttempt = ""
+	})
+	gw.Log("relay", gw.LogInfo).
+		Str("upstream", upstream).
+		Str("repository", repository).
+		Msg("subscribed to upstream gateway")
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		// Only the fields also sent by older gateways are used, the
+		// notification is rebuilt from the manifest
+		var message struct {
+			Repository string   `json:"repository"`
+			Manifest   []byte   `json:"manifest"`
+			Via        []string `json:"via"`
+		}
+		err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &message)
+		if err == nil && message.Repository != repository {
+			err = fmt.Errorf("%w: notification of repository %v", ErrInvalidManifest, message.Repository)
+		}
+		if err == nil {
+			err = s.relayManifest(ctx, message.Repository, message.Manifest, message.Via)
+		}
+		sub.update(func(st *RelayStatusDTO) {
+			st.Received++
+			st.LastMessage = time.Now().UTC().Format(time.RFC3339)
+			if err != nil {
+				st.Dropped++
+			}
+		})
+		if err != nil {
+			gw.Log("relay", gw.LogDebug).
+				Err(err).
+				Str("upstream", upstream).
+				Str("repository", repository).
+				Msg("notification dropped")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("could not read notifications from %v: %w", upstream, err)
+	}
+	return true, fmt.Errorf("notification stream of %v closed", upstream)
+}
+
+// relayManifest re-publishes the manifest of a notification received from an
+// upstream gateway. The gateways the notification went through are recorded,
+// so it is not relayed twice by the same gateway.
+func (s *Services) relayManifest(ctx context.Context, repository string, manifest []byte, via []string) error {
+	id := s.gatewayID()
+	for _, v := range via {
+		if v == id {
+			return ErrRelayLoop
+		}
+	}
+
+	message, err := NewNotificationMessage(repository, manifest)
+	if err != nil {
+		return err
+	}
+	if err := s.checkManifestSignature(repository, manifest); err != nil {
+		return err
+	}
+	message.Via = append(append([]string{}, via...), id)
+
+	return s.Notifications.Publish(ctx, message)
+}
@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestRelay(t *testing.T) {
+	defer func(d time.Duration) { relayMinBackoff = d }(relayMinBackoff)
+	relayMinBackoff = 10 * time.Millisecond
+
+	repo := "test2.repo.org"
+	send := func(w http.ResponseWriter, repository string, revision uint64, via ...string) {
+		data, _ := json.Marshal(map[string]interface{}{
+			"repository": repository, "manifest": testManifest(repository, revision), "via": via})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		w.(http.Flusher).Flush()
+	}
+	var connections int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		if atomic.AddInt32(&connections, 1) == 1 {
+			send(w, repo, 3, "upstream")
+			send(w, repo, 4, "upstream", "downstream")
+			send(w, "test1.repo.org", 4, "upstream")
+			// The stream is closed, the relay reconnects
+			return
+		}
+		send(w, repo, 5, "upstream")
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+
+	backend, tmp := StartTestBackend("relay_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.GatewayID = "downstream"
+	backend.Config.Relays = []gw.RelayConfig{{URL: upstream.URL + "/", Repositories: []string{repo}}}
+
+	ctx := context.TODO()
+	handle := backend.SubscribeToNotifications(ctx, repo)
+	defer backend.UnsubscribeFromNotifications(ctx, repo, handle)
+	backend.Relays = backend.StartRelays()
+
+	for _, revision := range []uint64{3, 5} {
+		select {
+		case msg := <-handle:
+			if msg.Revision != revision || len(msg.Via) != 2 || msg.Via[1] != "downstream" {
+				t.Errorf("unexpected notification: %+v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notification %v was not relayed", revision)
+		}
+	}
+
+	var status []RelayStatusDTO
+	for i := 0; i < 100; i++ {
+		status, _ = backend.GetRelayStatus(ctx)
+		if len(status) == 1 && status[0].Received == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(status) != 1 {
+		t.Fatalf("unexpected relay status: %+v", status)
+	}
+	st := status[0]
+	if st.Upstream != upstream.URL || !st.Connected || st.Received != 4 || st.Dropped != 2 || st.Reconnects != 1 {
+		t.Errorf("unexpected relay status: %+v", st)
+	}
+}
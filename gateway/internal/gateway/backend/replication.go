@@ -0,0 +1,287 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// ErrNotEnoughReplicas is returned when waiting for a revision to be
+// replicated on more stratum 1 servers than tracked for the repository
+var ErrNotEnoughReplicas = fmt.Errorf("not_enough_replicas")
+
+// defaultReplicationInterval is the interval between two checks of the
+// replicas, if not configured
+const defaultReplicationInterval = time.Minute
+
+// replicationWaitInterval is the interval between two checks of the replicas
+// while waiting for a revision to be replicated
+var replicationWaitInterval = 2 * time.Second
+
+// ReplicaStatusDTO is the state of a stratum 1 replica of a repository
+type ReplicaStatusDTO struct {
+	URL      string `json:"url"`
+	Revision uint64 `json:"revision"`
+	// RevisionsBehind is the number of revisions of the stratum 0 which are
+	// missing on the replica
+	RevisionsBehind uint64 `json:"revisions_behind"`
+	// Lag is the difference in seconds between the publication times of the
+	// revision on the stratum 0 and of the revision on the replica
+	Lag       float64 `json:"lag"`
+	LastCheck string  `json:"last_check,omitempty"`
+	LastError string  `json:"last_error,omitempty"`
+}
+
+// ReplicationDTO is the replication state of a repository
+type ReplicationDTO struct {
+	// Revision is the latest revision of the repository, on the stratum 0
+	Revision uint64             `json:"revision"`
+	Replicas []ReplicaStatusDTO `json:"replicas"`
+}
+
+// manifestRevision is the revision of a repository found on a server
+type manifestRevision struct {
+	revision  uint64
+	published time.Time
+}
+
+type replicaState struct {
+	url string
+	manifestRevision
+	lastCheck time.Time
+	lastError string
+}
+
+type repoReplication struct {
+	manifestRevision
+	replicas []*replicaState
+}
+
+// ReplicationTracker periodically records the revisions of the repositories
+// on the stratum 0 and on their stratum 1 replicas
+type ReplicationTracker struct {
+	stratum0URL string
+	interval    time.Duration
+
+	mu    sync.Mutex
+	repos map[string]*repoReplication
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewReplicationTracker creates the tracker of the configured replicas. The
+// replicas are only checked after Start.
+func NewReplicationTracker(cfg gw.Config) *ReplicationTracker {
+	t := &ReplicationTracker{
+		stratum0URL: cfg.Stratum0URL,
+		interval:    cfg.Replication.Interval,
+		repos:       make(map[string]*repoReplication),
+	}
+	if t.interval <= 0 {
+		t.interval = defaultReplicationInterval
+	}
+	for _, r := range cfg.Replication.Replicas {
+		repo := &repoReplication{}
+		for _, url := range r.URLs {
+			repo.replicas = append(repo.replicas, &replicaState{url: url})
+		}
+		t.repos[r.Repository] = repo
+	}
+	return t
+}
+
+// Start checking the replicas periodically
+func (t *ReplicationTracker) Start() {
+	if len(t.repos) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			for repo := range t.repos {
+				t.check(ctx, repo)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop checking the replicas
+func (t *ReplicationTracker) Stop() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.wg.Wait()
+}
+
+// check records the revisions of a repository on the stratum 0 and on its
+// replicas
+func (t *ReplicationTracker) check(ctx context.Context, repository string) {
+	repo, ok := t.repos[repository]
+	if !ok {
+		return
+	}
+
+	if t.stratum0URL != "" {
+		if rev, err := fetchRevision(ctx, t.stratum0URL, repository); err == nil {
+			t.mu.Lock()
+			if rev.revision > repo.revision {
+				repo.manifestRevision = rev
+			}
+			t.mu.Unlock()
+		} else {
+			gw.Log("replication", gw.LogDebug).
+				Err(err).
+				Str("repository", repository).
+				Msg("could not check the stratum 0")
+		}
+	}
+
+	for _, replica := range repo.replicas {
+		rev, err := fetchRevision(ctx, replica.url, repository)
+		if ctx.Err() != nil {
+			return
+		}
+		t.mu.Lock()
+		replica.lastCheck = time.Now()
+		if err != nil {
+			replica.lastError = err.Error()
+		} else {
+			replica.lastError = ""
+			replica.manifestRevision = rev
+			// The replicas may have a revision not seen yet on the stratum 0
+			if rev.revision > repo.revision {
+				repo.manifestRevision = rev
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// fetchRevision returns the revision of a repository on a server
+func fetchRevision(ctx context.Context, baseURL, repository string) (manifestRevision, error) {
+	manifest, err := fetchManifest(ctx, baseURL, repository)
+	if err != nil {
+		return manifestRevision{}, err
+	}
+	revision, err := strconv.ParseUint(manifest['S'], 10, 64)
+	if err != nil {
+		return manifestRevision{}, fmt.Errorf("invalid revision in manifest of %v: %q", repository, manifest['S'])
+	}
+	rev := manifestRevision{revision: revision}
+	if ts, err := strconv.ParseInt(manifest['T'], 10, 64); err == nil {
+		rev.published = time.Unix(ts, 0)
+	}
+	return rev, nil
+}
+
+// recordCommit records a new revision of a repository, published by the
+// gateway
+func (t *ReplicationTracker) recordCommit(repository string, revision uint64, published time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if repo, ok := t.repos[repository]; ok && revision > repo.revision {
+		repo.manifestRevision = manifestRevision{revision: revision, published: published}
+	}
+}
+
+// Status returns the replication state of a repository, or nil if its
+// replicas are not tracked
+func (t *ReplicationTracker) Status(repository string) *ReplicationDTO {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	repo, ok := t.repos[repository]
+	if !ok {
+		return nil
+	}
+	status := &ReplicationDTO{
+		Revision: repo.revision,
+		Replicas: make([]ReplicaStatusDTO, 0, len(repo.replicas)),
+	}
+	for _, replica := range repo.replicas {
+		st := ReplicaStatusDTO{URL: replica.url, Revision: replica.revision, LastError: replica.lastError}
+		if !replica.lastCheck.IsZero() {
+			st.LastCheck = replica.lastCheck.UTC().Format(time.RFC3339)
+		}
+		if replica.revision < repo.revision {
+			st.RevisionsBehind = repo.revision - replica.revision
+			if !repo.published.IsZero() && !replica.published.IsZero() {
+				st.Lag = repo.published.Sub(replica.published).Seconds()
+			}
+		}
+		status.Replicas = append(status.Replicas, st)
+	}
+	return status
+}
+
+// replicated returns the number of replicas of the repository with the
+// revision, or a later one
+func (t *ReplicationTracker) replicated(repository string, revision uint64) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	if repo, ok := t.repos[repository]; ok {
+		for _, replica := range repo.replicas {
+			if replica.revision >= revision {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// GetReplication returns the replication state of a repository, or nil if its
+// replicas are not tracked
+func (s *Services) GetReplication(ctx context.Context, repository string) *ReplicationDTO {
+	return s.Replication.Status(repository)
+}
+
+// WaitForReplication waits until a revision of a repository is found on at
+// least the given number of replicas, or until the timeout expires. It returns
+// the number of replicas with the revision.
+func (s *Services) WaitForReplication(
+	ctx context.Context, repository string, revision uint64, replicas int, timeout time.Duration) (int, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "wait_for_replication", &outcome, t0)
+
+	status := s.Replication.Status(repository)
+	if status == nil || len(status.Replicas) < replicas {
+		err := fmt.Errorf("%w: %v replicas requested", ErrNotEnoughReplicas, replicas)
+		outcome = err.Error()
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(replicationWaitInterval)
+	defer ticker.Stop()
+	for {
+		s.Replication.check(ctx, repository)
+		n := s.Replication.replicated(repository, revision)
+		if n >= replicas {
+			return n, nil
+		}
+		select {
+		case <-ctx.Done():
+			outcome = "timeout"
+			return n, nil
+		case <-ticker.C:
+		}
+	}
+}
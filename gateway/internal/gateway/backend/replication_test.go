@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// replicaServer serves the manifests of a repository under one prefix per
+// server: /s0 for the stratum 0 and /r1, /r2... for the replicas
+type replicaServer struct {
+	mu        sync.Mutex
+	revisions map[string]uint64
+}
+
+func (s *replicaServer) set(server string, revision uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revisions[server] = revision
+}
+
+func (s *replicaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) != 3 || parts[2] != ".cvmfspublished" {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	revision, ok := s.revisions[parts[0]]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	// Each revision is published one minute after the previous one
+	fmt.Fprintf(w, "Cabcdef\nN%v\nS%v\nT%v\n--\n0123456789\n", parts[1], revision, 1700000000+60*revision)
+}
+
+func TestReplicationTracker(t *testing.T) {
+	repo := "test2.repo.org"
+	servers := &replicaServer{revisions: map[string]uint64{"s0": 5, "r1": 5, "r2": 3}}
+	srv := httptest.NewServer(servers)
+	defer srv.Close()
+
+	var cfg gw.Config
+	cfg.Stratum0URL = srv.URL + "/s0"
+	cfg.Replication.Replicas = []gw.ReplicaConfig{
+		{Repository: repo, URLs: []string{srv.URL + "/r1", srv.URL + "/r2", srv.URL + "/r3"}},
+	}
+	tracker := NewReplicationTracker(cfg)
+
+	if st := tracker.Status("test1.repo.org"); st != nil {
+		t.Errorf("untracked repository has a replication status: %+v", st)
+	}
+
+	tracker.check(context.TODO(), repo)
+	st := tracker.Status(repo)
+	if st == nil || st.Revision != 5 || len(st.Replicas) != 3 {
+		t.Fatalf("unexpected replication status: %+v", st)
+	}
+	if r := st.Replicas[0]; r.Revision != 5 || r.RevisionsBehind != 0 || r.Lag != 0 || r.LastCheck == "" {
+		t.Errorf("unexpected status of up to date replica: %+v", r)
+	}
+	if r := st.Replicas[1]; r.Revision != 3 || r.RevisionsBehind != 2 || r.Lag != 120 {
+		t.Errorf("unexpected status of outdated replica: %+v", r)
+	}
+	if r := st.Replicas[2]; r.LastError == "" {
+		t.Errorf("unreachable replica has no error: %+v", r)
+	}
+
+	tracker.recordCommit(repo, 6, time.Unix(1700000000+60*6, 0))
+	st = tracker.Status(repo)
+	if st.Revision != 6 || st.Replicas[0].RevisionsBehind != 1 || st.Replicas[0].Lag != 60 {
+		t.Errorf("commit not recorded: %+v", st)
+	}
+	if n := tracker.replicated(repo, 5); n != 1 {
+		t.Errorf("unexpected number of replicas with revision 5: %v", n)
+	}
+}
+
+func TestWaitForReplication(t *testing.T) {
+	defer func(d time.Duration) { replicationWaitInterval = d }(replicationWaitInterval)
+	replicationWaitInterval = 10 * time.Millisecond
+
+	repo := "test2.repo.org"
+	servers := &replicaServer{revisions: map[string]uint64{"r1": 5, "r2": 4}}
+	srv := httptest.NewServer(servers)
+	defer srv.Close()
+
+	backend, tmp := StartTestBackend("replication_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+	backend.Config.Replication.Replicas = []gw.ReplicaConfig{
+		{Repository: repo, URLs: []string{srv.URL + "/r1", srv.URL + "/r2"}},
+	}
+	backend.Replication = NewReplicationTracker(backend.Config)
+
+	ctx := context.TODO()
+	if _, err := backend.WaitForReplication(ctx, repo, 5, 3, time.Second); !errors.Is(err, ErrNotEnoughReplicas) {
+		t.Errorf("waiting for more replicas than configured: %v", err)
+	}
+	if _, err := backend.WaitForReplication(ctx, "test1.repo.org", 5, 1, time.Second); !errors.Is(err, ErrNotEnoughReplicas) {
+		t.Errorf("waiting for an untracked repository: %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		servers.set("r2", 5)
+	}()
+	n, err := backend.WaitForReplication(ctx, repo, 5, 2, 5*time.Second)
+	if err != nil || n != 2 {
+		t.Errorf("revision not replicated: %v replicas, %v", n, err)
+	}
+
+	// The wait times out without error
+	n, err = backend.WaitForReplication(ctx, repo, 6, 1, 50*time.Millisecond)
+	if err != nil || n != 0 {
+		t.Errorf("unexpected result of timed out wait: %v replicas, %v", n, err)
+	}
+
+	if st := backend.GetReplication(ctx, repo); st == nil || st.Revision != 5 {
+		t.Errorf("unexpected replication status: %+v", st)
+	}
+}
@@ -4,8 +4,20 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
 )
 
+// RepoRegistrationOptions are the parameters of a repository registered
+// through the API
+type RepoRegistrationOptions struct {
+	Name string `json:"name"`
+	// Keys maps the IDs of the keys allowed to publish to the repository to
+	// the subpath where they are valid
+	Keys KeyPaths `json:"keys"`
+	RepositoryLimits
+}
+
 func (s *Services) NewRepo(ctx context.Context, name string, enabled bool) error {
 	t0 := time.Now()
 
@@ -53,16 +65,34 @@ func (s *Services) GetRepo(ctx context.Context, repoName string) (*RepositoryCon
 		return nil, err
 	}
 
+	reg, err := FindRepositoryRegistrationByName(ctx, tx, repoName)
+	if err != nil {
+		return nil, err
+	}
+
+	managed, err := s.managedKeyPaths(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := tx.Commit(); err != nil {
 		return nil, fmt.Errorf("could not commit transaction: %w", err)
 	}
 
-	repoConfig := s.Access.GetRepo(repoName)
-	if repo != nil && repoConfig != nil {
+	var repoConfig RepositoryConfig
+	if staticConfig := s.Access.GetRepo(repoName); staticConfig != nil {
+		repoConfig = mergeKeyPaths(*staticConfig, managed[repoName])
+	} else if reg != nil {
+		repoConfig = registeredRepoConfig(*reg, managed[repoName])
+	} else {
+		return nil, nil
+	}
+	if repo != nil {
 		repoConfig.Enabled = repo.Enabled
 	}
+	repoConfig.Replication = s.Replication.Status(repoName)
 
-	return repoConfig, nil
+	return &repoConfig, nil
 }
 
 // GetRepos returns a map with repository access configurations
@@ -83,7 +113,23 @@ func (s *Services) GetRepos(ctx context.Context) (map[string]RepositoryConfig, e
 		return nil, err
 	}
 
-	repoConfig := s.Access.GetRepos()
+	registrations, err := FindAllRepositoryRegistrations(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	managed, err := s.managedKeyPaths(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	repoConfig := make(map[string]RepositoryConfig)
+	for _, reg := range registrations {
+		repoConfig[reg.Name] = registeredRepoConfig(reg, managed[reg.Name])
+	}
+	for name, cfg := range s.Access.GetRepos() {
+		repoConfig[name] = mergeKeyPaths(cfg, managed[name])
+	}
 	for _, repo := range repos {
 		cfg := repoConfig[repo.Name]
 		cfg.Enabled = repo.Enabled
@@ -97,6 +143,176 @@ func (s *Services) GetRepos(ctx context.Context) (map[string]RepositoryConfig, e
 	return repoConfig, nil
 }
 
+// RegisterRepo registers a repository served by the stratum 0, with the keys
+// allowed to publish to it. The registration persists across restarts.
+func (s *Services) RegisterRepo(ctx context.Context, options RepoRegistrationOptions) error {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "register_repo", &outcome, t0)
+
+	if options.Name == "" || options.MaxLeaseTime < 0 || options.MaxLeases < 0 {
+		err := fmt.Errorf("%w: %+v", ErrInvalidRegistration, options)
+		outcome = err.Error()
+		return err
+	}
+	if s.Access.GetRepo(options.Name) != nil {
+		outcome = ErrRepoExists.Error()
+		return ErrRepoExists
+	}
+
+	if err := s.checkStratum0(ctx, options.Name); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	existing, err := FindRepositoryByName(ctx, tx, options.Name)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if existing != nil {
+		outcome = ErrRepoExists.Error()
+		return ErrRepoExists
+	}
+
+	reg := RepositoryRegistration{
+		Name:         options.Name,
+		MaxLeaseTime: time.Duration(options.MaxLeaseTime) * time.Second,
+		MaxLeases:    options.MaxLeases,
+		Registered:   t0,
+	}
+	if err := CreateRepositoryRegistration(ctx, tx, reg); err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if err := CreateRepository(ctx, tx, Repository{Name: options.Name, Enabled: true}); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	for keyID, path := range options.Keys {
+		if s.Access.GetKeyConfig(keyID) == nil {
+			if _, err := s.findManagedKey(ctx, tx, keyID); err != nil {
+				err = fmt.Errorf("%w: %v", err, keyID)
+				outcome = err.Error()
+				return err
+			}
+		}
+		if err := s.bindKey(ctx, tx, keyID, options.Name, path); err != nil {
+			outcome = err.Error()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeregisterRepo removes a repository registered through the API, with its key
+// bindings. Repositories with active leases are not deregistered.
+func (s *Services) DeregisterRepo(ctx context.Context, repoName string) error {
+	leaseMutex.Lock()
+	defer leaseMutex.Unlock()
+
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "deregister_repo", &outcome, t0)
+
+	if s.Access.GetRepo(repoName) != nil {
+		outcome = ErrStaticRepo.Error()
+		return ErrStaticRepo
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	reg, err := FindRepositoryRegistrationByName(ctx, tx, repoName)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if reg == nil {
+		err := fmt.Errorf("%w: %v", ErrRepoNotFound, repoName)
+		outcome = err.Error()
+		return err
+	}
+
+	leases, err := FindAllActiveLeasesByRepository(ctx, tx, repoName)
+	if err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if len(leases) > 0 {
+		outcome = RepoBusyError{}.Error()
+		return RepoBusyError{}
+	}
+
+	if err := DeleteAllLeasesByRepository(ctx, tx, repoName); err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if err := DeleteAllKeyBindingsByRepository(ctx, tx, repoName); err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if err := DeleteRepositoryRegistration(ctx, tx, repoName); err != nil {
+		outcome = err.Error()
+		return err
+	}
+	if err := DeleteRepository(ctx, tx, repoName); err != nil {
+		outcome = err.Error()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// PopulateRegisteredRepositories adds the repositories registered through the
+// API to the Repository table. Registrations shadowed by a repository of the
+// access configuration file are ignored.
+func (s *Services) PopulateRegisteredRepositories(ctx context.Context) error {
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	registrations, err := FindAllRepositoryRegistrations(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, reg := range registrations {
+		if s.Access.GetRepo(reg.Name) != nil {
+			gw.Log("backend", gw.LogWarn).
+				Msgf("registered repository %v is also in the access configuration, registration ignored", reg.Name)
+			continue
+		}
+		if err := CreateRepository(ctx, tx, Repository{Name: reg.Name, Enabled: true}); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // SetRepoEnabled enables or disables a repository. The change does not persist
 // across applications restarts
 func (s *Services) SetRepoEnabled(ctx context.Context, repoName string, enable bool) error {
@@ -151,3 +367,30 @@ func (s *Services) DeleteAllRepositories(ctx context.Context) error {
 
 	return nil
 }
+
+// mergeKeyPaths returns a copy of a repository configuration, with the
+// managed keys bound to the repository added to the keys from the access
+// configuration file
+func mergeKeyPaths(cfg RepositoryConfig, managed KeyPaths) RepositoryConfig {
+	keys := make(KeyPaths)
+	for id, path := range cfg.Keys {
+		keys[id] = path
+	}
+	for id, path := range managed {
+		keys[id] = path
+	}
+	cfg.Keys = keys
+	return cfg
+}
+
+// registeredRepoConfig returns the configuration of a repository registered
+// through the API
+func registeredRepoConfig(reg RepositoryRegistration, keys KeyPaths) RepositoryConfig {
+	cfg := RepositoryConfig{
+		Limits: &RepositoryLimits{
+			MaxLeaseTime: int64(reg.MaxLeaseTime / time.Second),
+			MaxLeases:    reg.MaxLeases,
+		},
+	}
+	return mergeKeyPaths(cfg, keys)
+}
@@ -2,6 +2,10 @@ package backend
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -35,3 +39,140 @@ func TestRepoServiceToggleRepo(t *testing.T) {
 		t.Fatalf("Repository %v should have been reenabled", repoName)
 	}
 }
+
+// startStratum0 serves the manifests of the given repositories
+func startStratum0(repos ...string) *httptest.Server {
+	mux := http.NewServeMux()
+	for _, repo := range repos {
+		manifest := "C0123456789abcdef\nN" + repo + "\nS42\n--\nsignature"
+		mux.HandleFunc("/cvmfs/"+repo+"/.cvmfspublished", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(manifest))
+		})
+	}
+	return httptest.NewServer(mux)
+}
+
+func TestRepoServiceRegisterRepo(t *testing.T) {
+	backend, tmp := StartTestBackend("repo_actions_register_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	stratum0 := startStratum0("new.repo.org")
+	defer stratum0.Close()
+	backend.Config.Stratum0URL = stratum0.URL + "/cvmfs"
+
+	ctx := context.TODO()
+	options := RepoRegistrationOptions{
+		Name:             "new.repo.org",
+		Keys:             KeyPaths{"keyid2": "/sub"},
+		RepositoryLimits: RepositoryLimits{MaxLeaseTime: 60, MaxLeases: 1},
+	}
+
+	t.Run("validation", func(t *testing.T) {
+		if err := backend.RegisterRepo(ctx, RepoRegistrationOptions{Name: "missing.repo.org"}); !errors.Is(err, ErrNotOnStratum0) {
+			t.Errorf("repository missing from the stratum 0 should be rejected: %v", err)
+		}
+		backend.Config.Stratum0URL = ""
+		if err := backend.RegisterRepo(ctx, options); !errors.Is(err, ErrNoStratum0URL) {
+			t.Errorf("registration without a stratum 0 URL should fail: %v", err)
+		}
+		backend.Config.Stratum0URL = stratum0.URL + "/cvmfs"
+		if err := backend.RegisterRepo(ctx, RepoRegistrationOptions{}); !errors.Is(err, ErrInvalidRegistration) {
+			t.Errorf("registration without a name should be rejected: %v", err)
+		}
+		negative := options
+		negative.MaxLeases = -1
+		if err := backend.RegisterRepo(ctx, negative); !errors.Is(err, ErrInvalidRegistration) {
+			t.Errorf("registration with negative limits should be rejected: %v", err)
+		}
+		if err := backend.RegisterRepo(ctx, RepoRegistrationOptions{Name: "test1.repo.org"}); !errors.Is(err, ErrRepoExists) {
+			t.Errorf("repository of the access configuration should be rejected: %v", err)
+		}
+		bad := options
+		bad.Keys = KeyPaths{"unknown_key": "/"}
+		if err := backend.RegisterRepo(ctx, bad); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("unknown key should be rejected: %v", err)
+		}
+		if repo, _ := backend.GetRepo(ctx, "new.repo.org"); repo != nil {
+			t.Errorf("failed registration should not be persisted: %+v", repo)
+		}
+	})
+
+	if err := backend.RegisterRepo(ctx, options); err != nil {
+		t.Fatalf("could not register repository: %v", err)
+	}
+	if err := backend.RegisterRepo(ctx, options); !errors.Is(err, ErrRepoExists) {
+		t.Errorf("repository should not be registered twice: %v", err)
+	}
+
+	t.Run("served", func(t *testing.T) {
+		repo, err := backend.GetRepo(ctx, "new.repo.org")
+		if err != nil || repo == nil {
+			t.Fatalf("could not query repository: %v", err)
+		}
+		if !repo.Enabled || repo.Keys["keyid2"] != "/sub" || repo.Limits.MaxLeases != 1 {
+			t.Errorf("unexpected repository configuration: %+v", repo)
+		}
+		repos, _ := backend.GetRepos(ctx)
+		if _, present := repos["new.repo.org"]; !present {
+			t.Errorf("registered repository not listed: %+v", repos)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		token, err := backend.NewLease(ctx, "keyid2", "new.repo.org/sub/a", "", 3)
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
+		}
+		withTx(ctx, backend.DB.SQL, t, func(ctx context.Context, tx *sql.Tx) error {
+			lease, err := FindLeaseByToken(ctx, tx, token)
+			if err != nil {
+				return err
+			}
+			if remaining := time.Until(lease.Expiration); remaining > time.Minute || remaining < 50*time.Second {
+				t.Errorf("lease should use the maximum lease time of the repository: %v", remaining)
+			}
+			return nil
+		})
+		if _, err := backend.NewLease(ctx, "keyid2", "new.repo.org/sub/b", "", 3); !errors.Is(err, ErrLeaseLimit) {
+			t.Errorf("lease limit should be enforced: %v", err)
+		}
+		if _, err := backend.NewLease(ctx, "keyid2", "new.repo.org/other", "", 3); err == nil {
+			t.Errorf("lease outside of the key path should be rejected")
+		}
+
+		if err := backend.DeregisterRepo(ctx, "new.repo.org"); !errors.As(err, &RepoBusyError{}) {
+			t.Errorf("repository with active leases should not be deregistered: %v", err)
+		}
+		backend.CancelLease(ctx, token)
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		if err := PopulateRepositories(backend); err != nil {
+			t.Fatalf("could not populate repositories: %v", err)
+		}
+		if repo, _ := backend.GetRepo(ctx, "new.repo.org"); repo == nil {
+			t.Errorf("registration should persist")
+		}
+	})
+
+	t.Run("deregistration", func(t *testing.T) {
+		if err := backend.DeregisterRepo(ctx, "test1.repo.org"); !errors.Is(err, ErrStaticRepo) {
+			t.Errorf("repository of the access configuration should not be deregistered: %v", err)
+		}
+		if err := backend.DeregisterRepo(ctx, "new.repo.org"); err != nil {
+			t.Fatalf("could not deregister repository: %v", err)
+		}
+		if repo, _ := backend.GetRepo(ctx, "new.repo.org"); repo != nil {
+			t.Errorf("repository should have been deregistered: %+v", repo)
+		}
+		if _, err := backend.NewLease(ctx, "keyid2", "new.repo.org/sub", "", 3); err == nil {
+			t.Errorf("lease on a deregistered repository should be rejected")
+		}
+		if err := backend.DeregisterRepo(ctx, "new.repo.org"); !errors.Is(err, ErrRepoNotFound) {
+			t.Errorf("unknown repository should be reported: %v", err)
+		}
+	})
+}
@@ -13,12 +13,36 @@ import (
 // being disabled
 var ErrRepoDisabled = fmt.Errorf("repo_disabled")
 
+// ErrRepoNotFound is returned when a repository is not served by the gateway
+var ErrRepoNotFound = fmt.Errorf("repo_not_found")
+
+// ErrRepoExists is returned when registering a repository which is already
+// served by the gateway
+var ErrRepoExists = fmt.Errorf("repo_exists")
+
+// ErrStaticRepo is returned when deregistering a repository from the access
+// configuration file, which can only be removed by editing the file
+var ErrStaticRepo = fmt.Errorf("static_repo")
+
+// ErrInvalidRegistration is returned when registering a repository without a
+// name or with negative limits
+var ErrInvalidRegistration = fmt.Errorf("invalid_registration")
+
 type Repository struct {
 	Name     string
 	Manifest string
 	Enabled  bool
 }
 
+// RepositoryRegistration is a repository registered through the gateway API,
+// with its limits. Zero limits mean the gateway defaults apply.
+type RepositoryRegistration struct {
+	Name         string
+	MaxLeaseTime time.Duration
+	MaxLeases    int
+	Registered   time.Time
+}
+
 func CreateRepository(ctx context.Context, tx *sql.Tx, repo Repository) error {
 	t0 := time.Now()
 
@@ -137,6 +161,127 @@ func DeleteAllRepositories(ctx context.Context, tx *sql.Tx) error {
 	return nil
 }
 
+func DeleteRepository(ctx context.Context, tx *sql.Tx, name string) error {
+	t0 := time.Now()
+
+	if _, err := tx.ExecContext(ctx, "delete from Repository where Name = ?;", name); err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+
+	gw.LogC(ctx, "repository_entity", gw.LogDebug).
+		Str("operation", "delete").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("name: %v", name)
+
+	return nil
+}
+
+func CreateRepositoryRegistration(ctx context.Context, tx *sql.Tx, reg RepositoryRegistration) error {
+	t0 := time.Now()
+
+	res, err := tx.ExecContext(ctx,
+		"insert into RepositoryRegistration (Name, MaxLeaseTime, MaxLeases, Registered) values (?, ?, ?, ?);",
+		reg.Name, reg.MaxLeaseTime.Milliseconds(), reg.MaxLeases, unixMilli(reg.Registered))
+	if err != nil {
+		return fmt.Errorf("could not insert new repository registration: %w", err)
+	}
+	numInserts, err := res.RowsAffected()
+	// err should be nil if DB driver returns the number of affected rows
+	if err == nil && numInserts == 0 {
+		return fmt.Errorf("new repository registration not inserted")
+	}
+
+	gw.LogC(ctx, "repository_entity", gw.LogDebug).
+		Str("operation", "create_registration").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("name: %v", reg.Name)
+
+	return nil
+}
+
+func FindAllRepositoryRegistrations(ctx context.Context, tx *sql.Tx) ([]RepositoryRegistration, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from RepositoryRegistration order by Name;")
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	registrations := make([]RepositoryRegistration, 0)
+	for rows.Next() {
+		var reg RepositoryRegistration
+		if err := scanRepositoryRegistration(rows, &reg); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		registrations = append(registrations, reg)
+	}
+
+	gw.LogC(ctx, "repository_entity", gw.LogDebug).
+		Str("operation", "find_all_registrations").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("num registrations: %v", len(registrations))
+
+	return registrations, nil
+}
+
+func FindRepositoryRegistrationByName(ctx context.Context, tx *sql.Tx, name string) (*RepositoryRegistration, error) {
+	t0 := time.Now()
+
+	rows, err := tx.QueryContext(ctx, "select * from RepositoryRegistration where Name = ?;", name)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var reg *RepositoryRegistration
+	if rows.Next() {
+		reg = &RepositoryRegistration{}
+		if err := scanRepositoryRegistration(rows, reg); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+	}
+
+	gw.LogC(ctx, "repository_entity", gw.LogDebug).
+		Str("operation", "find_registration_by_name").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("name: %v, found: %v", name, reg != nil)
+
+	return reg, nil
+}
+
+func DeleteRepositoryRegistration(ctx context.Context, tx *sql.Tx, name string) error {
+	t0 := time.Now()
+
+	if _, err := tx.ExecContext(ctx,
+		"delete from RepositoryRegistration where Name = ?;", name); err != nil {
+		return fmt.Errorf("delete statement failed: %w", err)
+	}
+
+	gw.LogC(ctx, "repository_entity", gw.LogDebug).
+		Str("operation", "delete_registration").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("name: %v", name)
+
+	return nil
+}
+
+func scanRepositoryRegistration(rows *sql.Rows, reg *RepositoryRegistration) error {
+	var maxLeaseTimeMilli, registeredMilli int64
+	if err := rows.Scan(
+		&reg.Name,
+		&maxLeaseTimeMilli,
+		&reg.MaxLeases,
+		&registeredMilli); err != nil {
+		return err
+	}
+
+	reg.MaxLeaseTime = time.Duration(maxLeaseTimeMilli) * time.Millisecond
+	reg.Registered = fromUnixMilli(registeredMilli)
+
+	return nil
+}
+
 func scanRepository(rows *sql.Rows, repo *Repository) error {
 	if err := rows.Scan(
 		&repo.Name,
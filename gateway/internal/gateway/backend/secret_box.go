@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+const masterKeySize = 32
+
+// SecretBox encrypts the secrets of the API keys stored in the lease DB with
+// AES-256-GCM, using a master key kept outside of the DB
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// LoadSecretBox reads the master key from keyFile, creating the file with a
+// random key if it does not exist. An empty keyFile selects
+// <workDir>/master.key.
+func LoadSecretBox(keyFile, workDir string) (*SecretBox, error) {
+	if keyFile == "" {
+		keyFile = path.Join(workDir, "master.key")
+	}
+
+	key, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		key = make([]byte, masterKeySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, fmt.Errorf("could not generate master key: %w", err)
+		}
+		if err := os.WriteFile(keyFile, key, 0600); err != nil {
+			return nil, fmt.Errorf("could not write master key: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read master key: %w", err)
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("invalid master key size in %v: %v", keyFile, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead}, nil
+}
+
+// Seal encrypts a secret, returning the base64 encoded nonce and ciphertext
+func (b *SecretBox) Seal(secret string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret encrypted by Seal
+func (b *SecretBox) Open(sealed string) (string, error) {
+	buf, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret: %w", err)
+	}
+	if len(buf) < b.aead.NonceSize() {
+		return "", fmt.Errorf("encrypted secret is too short")
+	}
+	nonce, ciphertext := buf[:b.aead.NonceSize()], buf[b.aead.NonceSize():]
+	secret, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret: %w", err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// statisticsDayFormat is the format of the days of the publication statistics
+const statisticsDayFormat = "2006-01-02"
+
+// PublicationStatistics are the publication counters of a repository, summed
+// over the commits of a key during a day (UTC)
+type PublicationStatistics struct {
+	Repository string
+	KeyID      string
+	Day        string
+	Commits    int64
+	stats.PublishCounters
+	// CommitDuration is the total time spent by the receiver committing
+	CommitDuration time.Duration
+	// MaxCommitDuration is the duration of the slowest commit
+	MaxCommitDuration time.Duration
+}
+
+// AddPublicationStatistics adds the counters of a commit to the statistics of
+// the repository, key and day
+func AddPublicationStatistics(ctx context.Context, tx *sql.Tx, repository, keyID string, day time.Time, counters stats.PublishCounters, commitDuration time.Duration) error {
+	t0 := time.Now()
+
+	dayStr := day.UTC().Format(statisticsDayFormat)
+	durationMilli := commitDuration.Milliseconds()
+	if _, err := tx.ExecContext(ctx,
+		`insert into PublicationStatistics values (?, ?, ?, 1, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (Repository, Day, KeyID) do update set
+			Commits = Commits + 1,
+			ChunksAdded = ChunksAdded + excluded.ChunksAdded,
+			ChunksDuplicated = ChunksDuplicated + excluded.ChunksDuplicated,
+			CatalogsAdded = CatalogsAdded + excluded.CatalogsAdded,
+			UploadedBytes = UploadedBytes + excluded.UploadedBytes,
+			UploadedCatalogBytes = UploadedCatalogBytes + excluded.UploadedCatalogBytes,
+			CommitDuration = CommitDuration + excluded.CommitDuration,
+			MaxCommitDuration = max(MaxCommitDuration, excluded.MaxCommitDuration);`,
+		repository, keyID, dayStr,
+		counters.ChunksAdded, counters.ChunksDuplicated, counters.CatalogsAdded,
+		counters.UploadedBytes, counters.UploadedCatalogBytes,
+		durationMilli, durationMilli); err != nil {
+		return fmt.Errorf("could not update publication statistics: %w", err)
+	}
+
+	gw.LogC(ctx, "statistics_entity", gw.LogDebug).
+		Str("operation", "add").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("repository: %v, key: %v, day: %v", repository, keyID, dayStr)
+
+	return nil
+}
+
+// FindPublicationStatisticsByRepository returns the statistics of a repository
+// between two days (included, in the statisticsDayFormat), ordered by day.
+// Empty bounds are ignored.
+func FindPublicationStatisticsByRepository(ctx context.Context, tx *sql.Tx, repository, from, to string) ([]PublicationStatistics, error) {
+	t0 := time.Now()
+
+	if to == "" {
+		to = "9999-12-31"
+	}
+	rows, err := tx.QueryContext(ctx,
+		"select * from PublicationStatistics where Repository = ? and Day >= ? and Day <= ? order by Day, KeyID;",
+		repository, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	ret := make([]PublicationStatistics, 0)
+	for rows.Next() {
+		var st PublicationStatistics
+		if err := scanPublicationStatistics(rows, &st); err != nil {
+			return nil, fmt.Errorf("scan failed: %w", err)
+		}
+		ret = append(ret, st)
+	}
+
+	gw.LogC(ctx, "statistics_entity", gw.LogDebug).
+		Str("operation", "find_by_repository").
+		Dur("task_dt", time.Since(t0)).
+		Msgf("found %v entries", len(ret))
+
+	return ret, nil
+}
+
+func scanPublicationStatistics(rows *sql.Rows, st *PublicationStatistics) error {
+	var durationMilli, maxDurationMilli int64
+	if err := rows.Scan(
+		&st.Repository,
+		&st.KeyID,
+		&st.Day,
+		&st.Commits,
+		&st.ChunksAdded,
+		&st.ChunksDuplicated,
+		&st.CatalogsAdded,
+		&st.UploadedBytes,
+		&st.UploadedCatalogBytes,
+		&durationMilli,
+		&maxDurationMilli); err != nil {
+		return err
+	}
+
+	st.CommitDuration = time.Duration(durationMilli) * time.Millisecond
+	st.MaxCommitDuration = time.Duration(maxDurationMilli) * time.Millisecond
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+)
+
+// ErrInvalidDate is returned for statistics queries with a malformed date
+var ErrInvalidDate = fmt.Errorf("invalid_date")
+
+// StatisticsQuery selects the publication statistics returned by
+// GetRepoStatistics. Empty fields are ignored.
+type StatisticsQuery struct {
+	// From and To are the first and last days (YYYY-MM-DD, UTC) of the period
+	From  string
+	To    string
+	KeyID string
+}
+
+// PublicationStatisticsDTO is the publication statistics information returned
+// to the HTTP frontend
+type PublicationStatisticsDTO struct {
+	Day     string `json:"day"`
+	KeyID   string `json:"key_id"`
+	Commits int64  `json:"commits"`
+	stats.PublishCounters
+	CommitDurationMs    int64 `json:"commit_duration_ms"`
+	MaxCommitDurationMs int64 `json:"max_commit_duration_ms"`
+}
+
+// GetRepoStatistics returns the publication statistics of a repository, per
+// key and day
+func (s *Services) GetRepoStatistics(ctx context.Context, repository string, query StatisticsQuery) ([]PublicationStatisticsDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_repo_statistics", &outcome, t0)
+
+	for _, day := range []string{query.From, query.To} {
+		if day == "" {
+			continue
+		}
+		if _, err := time.Parse(statisticsDayFormat, day); err != nil {
+			outcome = ErrInvalidDate.Error()
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDate, day)
+		}
+	}
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	repo, err := FindRepositoryByName(ctx, tx, repository)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+	if repo == nil {
+		outcome = ErrRepoNotFound.Error()
+		return nil, fmt.Errorf("%w: %v", ErrRepoNotFound, repository)
+	}
+
+	entries, err := FindPublicationStatisticsByRepository(ctx, tx, repository, query.From, query.To)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	ret := make([]PublicationStatisticsDTO, 0, len(entries))
+	for _, e := range entries {
+		if query.KeyID != "" && e.KeyID != query.KeyID {
+			continue
+		}
+		ret = append(ret, PublicationStatisticsDTO{
+			Day:                 e.Day,
+			KeyID:               e.KeyID,
+			Commits:             e.Commits,
+			PublishCounters:     e.PublishCounters,
+			CommitDurationMs:    e.CommitDuration.Milliseconds(),
+			MaxCommitDurationMs: e.MaxCommitDuration.Milliseconds(),
+		})
+	}
+
+	return ret, nil
+}
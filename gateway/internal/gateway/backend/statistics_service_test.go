@@ -0,0 +1,77 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestStatisticsServiceAggregation(t *testing.T) {
+	backend, tmp := StartTestBackend("statistics_service_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	publish := func(payloads ...string) {
+		token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "host", 3)
+		if err != nil {
+			t.Fatalf("could not obtain new lease: %v", err)
+		}
+		for i, p := range payloads {
+			digest := strings.Repeat(string(rune('a'+i)), 6)
+			if err := backend.SubmitPayload(ctx, token, strings.NewReader(p), digest, 1); err != nil {
+				t.Fatalf("could not submit payload: %v", err)
+			}
+		}
+		if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{}); err != nil {
+			t.Fatalf("could not commit lease: %v", err)
+		}
+	}
+	publish("DUMMY", "PAYLOAD")
+	publish("MORE")
+
+	today := time.Now().UTC().Format(statisticsDayFormat)
+	entries, err := backend.GetRepoStatistics(ctx, "test2.repo.org", StatisticsQuery{From: today})
+	if err != nil {
+		t.Fatalf("could not query statistics: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected a single entry, got: %+v", entries)
+	}
+	e := entries[0]
+	if e.Day != today || e.KeyID != "keyid1" || e.Commits != 2 {
+		t.Fatalf("invalid statistics entry: %+v", e)
+	}
+	if e.ChunksAdded != 3 || e.UploadedBytes != int64(len("DUMMYPAYLOADMORE")) {
+		t.Fatalf("publication counters not aggregated: %+v", e)
+	}
+	if e.MaxCommitDurationMs > e.CommitDurationMs {
+		t.Fatalf("invalid commit durations: %+v", e)
+	}
+
+	t.Run("filters", func(t *testing.T) {
+		entries, err := backend.GetRepoStatistics(ctx, "test2.repo.org", StatisticsQuery{To: "2000-01-01"})
+		if err != nil || len(entries) != 0 {
+			t.Fatalf("expected no statistics before 2000: %v %v", entries, err)
+		}
+		entries, err = backend.GetRepoStatistics(ctx, "test2.repo.org", StatisticsQuery{KeyID: "keyid2"})
+		if err != nil || len(entries) != 0 {
+			t.Fatalf("expected no statistics for keyid2: %v %v", entries, err)
+		}
+	})
+	t.Run("invalid queries", func(t *testing.T) {
+		if _, err := backend.GetRepoStatistics(ctx, "test2.repo.org", StatisticsQuery{From: "yesterday"}); !errors.Is(err, ErrInvalidDate) {
+			t.Fatalf("expected invalid date error, got: %v", err)
+		}
+		if _, err := backend.GetRepoStatistics(ctx, "unknown.repo.org", StatisticsQuery{}); !errors.Is(err, ErrRepoNotFound) {
+			t.Fatalf("expected repository not found error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+)
+
+// maxRecentEvents is the number of publications and GC runs kept in memory
+// for the status overview
+const maxRecentEvents = 50
+
+// PublicationDTO describes a recent commit
+type PublicationDTO struct {
+	Repository string `json:"repository"`
+	LeasePath  string `json:"lease_path"`
+	KeyID      string `json:"key_id"`
+	Hostname   string `json:"hostname"`
+	Revision   uint64 `json:"revision"`
+	Time       string `json:"time"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// GCRunDTO describes a recent garbage collection run
+type GCRunDTO struct {
+	Repository string `json:"repository"`
+	DryRun     bool   `json:"dry_run"`
+	Outcome    string `json:"outcome"`
+	Time       string `json:"time"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// RepoStatusDTO is the state of a repository in the status overview
+type RepoStatusDTO struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Subscribers int    `json:"subscribers"`
+	// Replication is set for the repositories whose stratum 1 replicas are
+	// tracked
+	Replication *ReplicationDTO `json:"replication,omitempty"`
+}
+
+// LeaseStatusDTO is an active lease in the status overview
+type LeaseStatusDTO struct {
+	LeasePath string `json:"lease_path"`
+	KeyID     string `json:"key_id"`
+	Hostname  string `json:"hostname"`
+	// Paths are the leased paths, when the lease is on several subpaths
+	Paths []string `json:"paths,omitempty"`
+	// TimeRemaining is the validity of the lease in seconds
+	TimeRemaining float64 `json:"time_remaining"`
+}
+
+// StatusDTO is an overview of the state of the gateway
+type StatusDTO struct {
+	Repositories []RepoStatusDTO         `json:"repositories"`
+	Leases       []LeaseStatusDTO        `json:"leases"`
+	Receivers    receiver.PoolStatistics `json:"receivers"`
+	Publications []PublicationDTO        `json:"publications"`
+	GCRuns       []GCRunDTO              `json:"gc_runs"`
+}
+
+// activityLog keeps the most recent publications and GC runs, newest first
+type activityLog struct {
+	mu           sync.Mutex
+	publications []PublicationDTO
+	gcRuns       []GCRunDTO
+}
+
+func (l *activityLog) addPublication(p PublicationDTO) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.publications = append([]PublicationDTO{p}, l.publications...)
+	if len(l.publications) > maxRecentEvents {
+		l.publications = l.publications[:maxRecentEvents]
+	}
+}
+
+func (l *activityLog) addGCRun(r GCRunDTO) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gcRuns = append([]GCRunDTO{r}, l.gcRuns...)
+	if len(l.gcRuns) > maxRecentEvents {
+		l.gcRuns = l.gcRuns[:maxRecentEvents]
+	}
+}
+
+func (l *activityLog) recent() ([]PublicationDTO, []GCRunDTO) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]PublicationDTO{}, l.publications...), append([]GCRunDTO{}, l.gcRuns...)
+}
+
+// GetStatus returns an overview of the repositories, active leases, receiver
+// pool and recent activity of the gateway
+func (s *Services) GetStatus(ctx context.Context) (*StatusDTO, error) {
+	t0 := time.Now()
+
+	outcome := "success"
+	defer logAction(ctx, "get_status", &outcome, t0)
+
+	tx, err := s.DB.SQL.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	repos, err := FindAllRepositories(ctx, tx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+	leases, err := FindAllActiveLeases(ctx, tx)
+	if err != nil {
+		outcome = err.Error()
+		return nil, err
+	}
+	leasePaths := make(map[string][]string)
+	for _, l := range leases {
+		paths, err := multiLeasePaths(ctx, tx, l)
+		if err != nil {
+			outcome = err.Error()
+			return nil, err
+		}
+		leasePaths[l.Token] = paths
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %w", err)
+	}
+
+	subscribers := s.Notifications.SubscriberCounts()
+	status := &StatusDTO{
+		Repositories: make([]RepoStatusDTO, 0, len(repos)),
+		Leases:       make([]LeaseStatusDTO, 0, len(leases)),
+		Receivers:    s.Pool.Statistics(),
+	}
+	for _, r := range repos {
+		status.Repositories = append(status.Repositories, RepoStatusDTO{
+			Name: r.Name, Enabled: r.Enabled, Subscribers: subscribers[r.Name],
+			Replication: s.Replication.Status(r.Name)})
+	}
+	sort.Slice(status.Repositories, func(i, j int) bool {
+		return status.Repositories[i].Name < status.Repositories[j].Name
+	})
+	for _, l := range leases {
+		status.Leases = append(status.Leases, LeaseStatusDTO{
+			LeasePath:     l.CombinedLeasePath(),
+			KeyID:         l.KeyID,
+			Hostname:      l.Hostname,
+			Paths:         leasePaths[l.Token],
+			TimeRemaining: time.Until(l.Expiration).Seconds(),
+		})
+	}
+	sort.Slice(status.Leases, func(i, j int) bool {
+		return status.Leases[i].LeasePath < status.Leases[j].LeasePath
+	})
+	status.Publications, status.GCRuns = s.activity.recent()
+
+	return status, nil
+}
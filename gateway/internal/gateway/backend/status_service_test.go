@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestStatusServiceOverview(t *testing.T) {
+	backend, tmp := StartTestBackend("status_service_test", 10*time.Second)
+	defer func() {
+		backend.Stop()
+		os.RemoveAll(tmp)
+	}()
+
+	ctx := context.TODO()
+	token, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/some/path", "publisher", 3)
+	if err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	if _, err := backend.CommitLease(ctx, token, "old_hash", "new_hash", gw.RepositoryTag{}); err != nil {
+		t.Fatalf("could not commit lease: %v", err)
+	}
+	if _, err := backend.NewLease(ctx, "keyid1", "test2.repo.org/other/path", "publisher", 3); err != nil {
+		t.Fatalf("could not obtain new lease: %v", err)
+	}
+	handle := backend.SubscribeToNotifications(ctx, "test2.repo.org")
+	defer backend.UnsubscribeFromNotifications(ctx, "test2.repo.org", handle)
+
+	status, err := backend.GetStatus(ctx)
+	if err != nil {
+		t.Fatalf("could not obtain status: %v", err)
+	}
+
+	subscribers := -1
+	for _, r := range status.Repositories {
+		if r.Name == "test2.repo.org" {
+			subscribers = r.Subscribers
+		}
+	}
+	if subscribers != 1 {
+		t.Errorf("expected a notification subscriber: %+v", status.Repositories)
+	}
+	if len(status.Leases) != 1 || status.Leases[0].LeasePath != "test2.repo.org/other/path" ||
+		status.Leases[0].Hostname != "publisher" || status.Leases[0].TimeRemaining <= 0 {
+		t.Errorf("invalid active leases: %+v", status.Leases)
+	}
+	if len(status.Publications) != 1 || status.Publications[0].Revision != 1 ||
+		status.Publications[0].LeasePath != "test2.repo.org/some/path" {
+		t.Errorf("invalid recent publications: %+v", status.Publications)
+	}
+	if status.Receivers.Workers != 1 || status.Receivers.Tasks != 1 {
+		t.Errorf("invalid receiver statistics: %+v", status.Receivers)
+	}
+}
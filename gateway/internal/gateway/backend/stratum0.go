@@ -0,0 +1,83 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrNotOnStratum0 is returned when registering a repository which is not
+// served by the stratum 0
+var ErrNotOnStratum0 = fmt.Errorf("repo_not_on_stratum0")
+
+// ErrNoStratum0URL is returned when registering a repository while the gateway
+// configuration has no stratum 0 URL, the registration can then not be checked
+var ErrNoStratum0URL = fmt.Errorf("stratum0_url_not_configured")
+
+// manifestTimeout bounds the download of a repository manifest
+const manifestTimeout = 10 * time.Second
+
+// fetchManifest downloads the manifest (.cvmfspublished) of a repository from
+// a CernVM-FS server and returns its fields, indexed by their key
+func fetchManifest(ctx context.Context, baseURL, repo string) (map[byte]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(baseURL, "/") + "/" + repo + "/.cvmfspublished"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not download %v: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not download %v: %v", url, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %v: %w", url, err)
+	}
+
+	return parseManifest(body), nil
+}
+
+// parseManifest returns the fields of a repository manifest. The signature
+// following the "--" separator is ignored.
+func parseManifest(data []byte) map[byte]string {
+	fields := make(map[byte]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "--" {
+			break
+		}
+		if len(line) > 0 {
+			fields[line[0]] = line[1:]
+		}
+	}
+	return fields
+}
+
+// checkStratum0 verifies that the repository is served by the stratum 0
+func (s *Services) checkStratum0(ctx context.Context, repo string) error {
+	if s.Config.Stratum0URL == "" {
+		return ErrNoStratum0URL
+	}
+	manifest, err := fetchManifest(ctx, s.Config.Stratum0URL, repo)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNotOnStratum0, err)
+	}
+	if manifest['N'] != repo {
+		return fmt.Errorf("%w: manifest belongs to %q", ErrNotOnStratum0, manifest['N'])
+	}
+	return nil
+}
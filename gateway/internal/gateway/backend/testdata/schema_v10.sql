@@ -0,0 +1,102 @@
+-- Lease DB created by gateway releases using schema version 10
+create table SchemaVersion (
+    VersionNumber integer not null unique primary key,
+    ValidFrom timestamp not null,
+    ValidTo timestamp
+);
+insert into SchemaVersion (VersionNumber, ValidFrom) values (10, '2026-10-19 12:00:00');
+create table if not exists Lease (
+	Token string not null unique primary key,
+	Repository string not null,
+	Path string not null,
+	KeyID string not null,
+	Expiration integer not null,
+	ProtocolVersion integer not null,
+	Hostname string
+);
+create index lease_repository_path_idx ON Lease(Repository,Path);
+create table if not exists Repository (
+	Name string not null unique primary key,
+	Manifest string,
+	Enabled bool not null
+);
+create table if not exists Payload (
+	ID integer primary key autoincrement,
+	Token string not null,
+	Digest string not null,
+	HeaderSize integer not null,
+	Size integer not null,
+	Received integer not null,
+	Duration integer not null,
+	Result string not null,
+	Encoding string not null default '',
+	TransferredSize integer not null default 0
+);
+create index payload_token_digest_idx ON Payload(Token,Digest);
+insert into Lease values ('fixture_token', 'test2.repo.org', 'some/path', 'keyid1', 4102444800000, 4, 'publisher.example.org');
+insert into Repository values ('test2.repo.org', '', 1);
+insert into Payload (Token, Digest, HeaderSize, Size, Received, Duration, Result, Encoding, TransferredSize) values ('fixture_token', 'abcdef', 10, 100, 1790000000000, 12, 'ok', 'zstd', 40);
+create table if not exists ApiKey (
+	ID string not null unique primary key,
+	Secret string not null,
+	PreviousSecret string not null,
+	PreviousExpiration integer not null,
+	Admin bool not null,
+	Status string not null,
+	Created integer not null,
+	Rotated integer not null
+);
+create table if not exists KeyBinding (
+	KeyID string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (KeyID, Repository)
+);
+insert into ApiKey values ('managed_key', 'c2VhbGVk', '', 0, 0, 'disabled', 1790000000000, 0);
+insert into KeyBinding values ('managed_key', 'test2.repo.org', '/');
+create table if not exists RepositoryRegistration (
+	Name string not null unique primary key,
+	MaxLeaseTime integer not null,
+	MaxLeases integer not null,
+	Registered integer not null
+);
+insert into RepositoryRegistration values ('registered.repo.org', 600000, 2, 1790000000000);
+insert into Repository values ('registered.repo.org', '', 1);
+create table if not exists HookRun (
+	ID integer primary key autoincrement,
+	Hook string not null,
+	Stage string not null,
+	Repository string not null,
+	LeasePath string not null,
+	Revision integer not null,
+	Status string not null,
+	Attempts integer not null,
+	Output string not null,
+	Started integer not null,
+	Finished integer not null
+);
+create index hookrun_repository_idx ON HookRun(Repository,Revision);
+insert into HookRun (Hook, Stage, Repository, LeasePath, Revision, Status, Attempts, Output, Started, Finished) values ('snapshot', 'post_commit', 'test2.repo.org', 'test2.repo.org/some/path', 12, 'success', 1, '', 1790000000000, 1790000001000);
+create table if not exists PublicationStatistics (
+	Repository string not null,
+	KeyID string not null,
+	Day string not null,
+	Commits integer not null,
+	ChunksAdded integer not null,
+	ChunksDuplicated integer not null,
+	CatalogsAdded integer not null,
+	UploadedBytes integer not null,
+	UploadedCatalogBytes integer not null,
+	CommitDuration integer not null,
+	MaxCommitDuration integer not null,
+	primary key (Repository, Day, KeyID)
+);
+insert into PublicationStatistics values ('test2.repo.org', 'keyid1', '2026-10-19', 2, 10, 4, 1, 2048, 512, 3000, 2000);
+create table if not exists LeasePath (
+	Token string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (Token, Path)
+);
+create index leasepath_repository_path_idx ON LeasePath(Repository,Path);
+insert into LeasePath values ('fixture_token', 'test2.repo.org', 'some/path');
@@ -0,0 +1,23 @@
+-- Lease DB created by gateway releases using schema version 2
+create table SchemaVersion (
+    VersionNumber integer not null unique primary key,
+    ValidFrom timestamp not null,
+    ValidTo timestamp
+);
+insert into SchemaVersion (VersionNumber, ValidFrom) values (2, '2019-10-01 12:00:00');
+create table if not exists Lease (
+	Token string not null unique primary key,
+	Repository string not null,
+	Path string not null,
+	KeyID string not null,
+	Expiration integer not null,
+	ProtocolVersion integer not null
+);
+create index lease_repository_path_idx ON Lease(Repository,Path);
+create table if not exists Repository (
+	Name string not null unique primary key,
+	Manifest string,
+	Enabled bool not null
+);
+insert into Lease values ('fixture_token', 'test2.repo.org', 'some/path', 'keyid1', 4102444800000, 2);
+insert into Repository values ('test2.repo.org', '', 1);
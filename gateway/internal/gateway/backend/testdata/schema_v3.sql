@@ -0,0 +1,24 @@
+-- Lease DB created by gateway releases using schema version 3
+create table SchemaVersion (
+    VersionNumber integer not null unique primary key,
+    ValidFrom timestamp not null,
+    ValidTo timestamp
+);
+insert into SchemaVersion (VersionNumber, ValidFrom) values (3, '2021-03-01 12:00:00');
+create table if not exists Lease (
+	Token string not null unique primary key,
+	Repository string not null,
+	Path string not null,
+	KeyID string not null,
+	Expiration integer not null,
+	ProtocolVersion integer not null,
+	Hostname string
+);
+create index lease_repository_path_idx ON Lease(Repository,Path);
+create table if not exists Repository (
+	Name string not null unique primary key,
+	Manifest string,
+	Enabled bool not null
+);
+insert into Lease values ('fixture_token', 'test2.repo.org', 'some/path', 'keyid1', 4102444800000, 3, 'publisher.example.org');
+insert into Repository values ('test2.repo.org', '', 1);
@@ -0,0 +1,36 @@
+-- Lease DB created by gateway releases using schema version 4
+create table SchemaVersion (
+    VersionNumber integer not null unique primary key,
+    ValidFrom timestamp not null,
+    ValidTo timestamp
+);
+insert into SchemaVersion (VersionNumber, ValidFrom) values (4, '2026-10-19 12:00:00');
+create table if not exists Lease (
+	Token string not null unique primary key,
+	Repository string not null,
+	Path string not null,
+	KeyID string not null,
+	Expiration integer not null,
+	ProtocolVersion integer not null,
+	Hostname string
+);
+create index lease_repository_path_idx ON Lease(Repository,Path);
+create table if not exists Repository (
+	Name string not null unique primary key,
+	Manifest string,
+	Enabled bool not null
+);
+create table if not exists Payload (
+	ID integer primary key autoincrement,
+	Token string not null,
+	Digest string not null,
+	HeaderSize integer not null,
+	Size integer not null,
+	Received integer not null,
+	Duration integer not null,
+	Result string not null
+);
+create index payload_token_digest_idx ON Payload(Token,Digest);
+insert into Lease values ('fixture_token', 'test2.repo.org', 'some/path', 'keyid1', 4102444800000, 4, 'publisher.example.org');
+insert into Repository values ('test2.repo.org', '', 1);
+insert into Payload (Token, Digest, HeaderSize, Size, Received, Duration, Result) values ('fixture_token', 'abcdef', 10, 100, 1790000000000, 12, 'ok');
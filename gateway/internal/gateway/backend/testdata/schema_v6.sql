@@ -0,0 +1,62 @@
+-- Lease DB created by gateway releases using schema version 6
+create table SchemaVersion (
+    VersionNumber integer not null unique primary key,
+    ValidFrom timestamp not null,
+    ValidTo timestamp
+);
+insert into SchemaVersion (VersionNumber, ValidFrom) values (6, '2026-10-19 12:00:00');
+create table if not exists Lease (
+	Token string not null unique primary key,
+	Repository string not null,
+	Path string not null,
+	KeyID string not null,
+	Expiration integer not null,
+	ProtocolVersion integer not null,
+	Hostname string
+);
+create index lease_repository_path_idx ON Lease(Repository,Path);
+create table if not exists Repository (
+	Name string not null unique primary key,
+	Manifest string,
+	Enabled bool not null
+);
+create table if not exists Payload (
+	ID integer primary key autoincrement,
+	Token string not null,
+	Digest string not null,
+	HeaderSize integer not null,
+	Size integer not null,
+	Received integer not null,
+	Duration integer not null,
+	Result string not null
+);
+create index payload_token_digest_idx ON Payload(Token,Digest);
+insert into Lease values ('fixture_token', 'test2.repo.org', 'some/path', 'keyid1', 4102444800000, 4, 'publisher.example.org');
+insert into Repository values ('test2.repo.org', '', 1);
+insert into Payload (Token, Digest, HeaderSize, Size, Received, Duration, Result) values ('fixture_token', 'abcdef', 10, 100, 1790000000000, 12, 'ok');
+create table if not exists ApiKey (
+	ID string not null unique primary key,
+	Secret string not null,
+	PreviousSecret string not null,
+	PreviousExpiration integer not null,
+	Admin bool not null,
+	Status string not null,
+	Created integer not null,
+	Rotated integer not null
+);
+create table if not exists KeyBinding (
+	KeyID string not null,
+	Repository string not null,
+	Path string not null,
+	primary key (KeyID, Repository)
+);
+insert into ApiKey values ('managed_key', 'c2VhbGVk', '', 0, 0, 'disabled', 1790000000000, 0);
+insert into KeyBinding values ('managed_key', 'test2.repo.org', '/');
+create table if not exists RepositoryRegistration (
+	Name string not null unique primary key,
+	MaxLeaseTime integer not null,
+	MaxLeases integer not null,
+	Registered integer not null
+);
+insert into RepositoryRegistration values ('registered.repo.org', 600000, 2, 1790000000000);
+insert into Repository values ('registered.repo.org', '', 1);
@@ -142,12 +142,30 @@ func StartTestBackend(name string, maxLeaseTime time.Duration) (*Services, strin
 
 	smgr := stats.NewStatisticsMgr()
 
-	pool, err := receiver.StartPool(cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr)
+	pool, err := receiver.StartPool(cfg.ReceiverPath, cfg.NumReceivers, cfg.MockReceiver, smgr,
+		receiver.PoolOptions{TaskTimeout: cfg.ReceiverTimeout, Limits: cfg.ReceiverLimits})
 	if err != nil {
 		os.Exit(4)
 	}
 
-	services := Services{Config: cfg, Access: ac, DB: db, Pool: pool, StatsMgr: smgr}
+	secrets, err := LoadSecretBox("", tmp)
+	if err != nil {
+		os.Exit(6)
+	}
+
+	hooks, err := NewHooks(cfg.Hooks, db)
+	if err != nil {
+		os.Exit(7)
+	}
+
+	ns, err := NewNotificationSystem(tmp)
+	if err != nil {
+		os.Exit(8)
+	}
+
+	services := Services{
+		Config: cfg, Access: ac, DB: db, Pool: pool, Notifications: ns, StatsMgr: smgr,
+		Secrets: secrets, Hooks: hooks, Replication: NewReplicationTracker(cfg)}
 
 	if err := PopulateRepositories(&services); err != nil {
 		os.Exit(5)
@@ -1,7 +1,10 @@
 package backend
 
 import (
+	crand "crypto/rand"
 	"encoding/base32"
+	"encoding/hex"
+	"fmt"
 	"math/rand"
 	"time"
 )
@@ -17,3 +20,21 @@ func NewLeaseToken() string {
 	rng.Read(tokenBytes)
 	return base32.StdEncoding.EncodeToString(tokenBytes)
 }
+
+// newKeySecret generates the secret of a new API key
+func newKeySecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := crand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate key secret: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// newKeyID generates the ID of a new API key, when none is requested
+func newKeyID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := crand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate key ID: %w", err)
+	}
+	return "key_" + hex.EncodeToString(buf), nil
+}
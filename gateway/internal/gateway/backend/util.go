@@ -14,3 +14,19 @@ func logAction(ctx context.Context, actionName string, outcome *string, t0 time.
 		Dur("action_dt", time.Since(t0)).
 		Msg("action complete")
 }
+
+// unixMilli converts a time to milliseconds since the epoch, mapping the zero
+// time to 0
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -1,7 +1,9 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -10,8 +12,12 @@ import (
 
 // Config stores all the configuration options
 type Config struct {
-	// Port used by the HTTP frontend
+	// UserConfigFile is the file the configuration was read from
+	UserConfigFile string `mapstructure:"user_config_file"`
+	// Port used by the HTTP frontend, when no listeners are configured
 	Port int `mapstructure:"port"`
+	// Listeners are the sockets on which the HTTP frontend is served
+	Listeners []ListenerConfig `mapstructure:"listeners"`
 	// MaxLeaseTime is the maximum lease duration in seconds
 	MaxLeaseTime time.Duration `mapstructure:"max_lease_time"`
 	// LogLevel sets the logging level
@@ -28,6 +34,218 @@ type Config struct {
 	WorkDir string `mapstructure:"work_dir"`
 	// MockReceiver enables a mocked implementation of the receiver worker
 	MockReceiver bool `mapstructure:"mock_receiver"`
+	// MasterKeyFile holds the key encrypting the secrets of the API keys stored
+	// in the lease DB (default: <WorkDir>/master.key)
+	MasterKeyFile string `mapstructure:"master_key_file"`
+	// Stratum0URL is the base URL of the stratum 0 serving the repositories,
+	// used to validate the repositories registered through the API
+	Stratum0URL string `mapstructure:"stratum0_url"`
+	// Hooks are run before and after each commit
+	Hooks []HookConfig `mapstructure:"hooks"`
+	// ReceiverTimeout is the maximum duration of a receiver task (payload
+	// submission or commit), given in seconds. Tasks running longer are
+	// interrupted. Zero disables the timeout.
+	ReceiverTimeout time.Duration `mapstructure:"receiver_timeout"`
+	// ReceiverLimits are the resource limits applied to each receiver worker
+	ReceiverLimits ReceiverLimits `mapstructure:"receiver_limits"`
+	// MaxLeasePayloadSize is the maximum total size in bytes of the
+	// (decompressed) payloads accepted for a lease. Zero disables the limit.
+	MaxLeasePayloadSize int64 `mapstructure:"max_lease_payload_size"`
+	// ManifestKeyDir contains the certificates (<REPO>.crt) of the
+	// repositories. When set, the signature of the manifests published to the
+	// notification system is verified with the public key of the certificate.
+	ManifestKeyDir string `mapstructure:"manifest_key_dir"`
+	// GatewayID identifies the gateway in the relayed notifications, to
+	// prevent relay loops (default: the hostname)
+	GatewayID string `mapstructure:"gateway_id"`
+	// Relays are the upstream gateways whose notifications are re-published
+	// to the subscribers of this gateway
+	Relays []RelayConfig `mapstructure:"relays"`
+	// Replication configures the tracking of the stratum 1 replicas of the
+	// repositories
+	Replication ReplicationConfig `mapstructure:"replication"`
+	// CommitBatching lists the repositories whose concurrent commits are
+	// merged into a single revision
+	CommitBatching []CommitBatchConfig `mapstructure:"commit_batching"`
+	// Tracing configures the export of the spans of the requests
+	Tracing TracingConfig `mapstructure:"tracing"`
+	// Quotas limit the content published by each lease of a repository or
+	// of a key
+	Quotas []QuotaConfig `mapstructure:"quotas"`
+}
+
+// QuotaConfig limits the content published by each lease of a repository, of
+// a key, or of a key in a repository. A lease is subject to the lowest of the
+// limits matching its repository and key. Zero disables a limit.
+type QuotaConfig struct {
+	Repository string `mapstructure:"repository"`
+	KeyID      string `mapstructure:"key_id"`
+	// MaxUploadedBytes is the maximum size of the objects uploaded to the
+	// storage by the lease
+	MaxUploadedBytes int64 `mapstructure:"max_uploaded_bytes"`
+	// MaxPayloads is the maximum number of payloads submitted for the lease
+	MaxPayloads int64 `mapstructure:"max_payloads"`
+	// MaxCatalogs is the maximum number of catalogs added by the lease
+	MaxCatalogs int64 `mapstructure:"max_catalogs"`
+}
+
+// TracingConfig configures the export of the spans to an OpenTelemetry
+// collector
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP traces URL of the collector, e.g.
+	// http://localhost:4318/v1/traces. The spans are not exported when empty.
+	Endpoint string `mapstructure:"endpoint"`
+	// ServiceName is the service name of the spans (default: cvmfs-gateway)
+	ServiceName string `mapstructure:"service_name"`
+}
+
+// CommitBatchConfig enables the batching of the commits of a repository.
+// Merging the commits requires a cvmfs_receiver supporting batched commits,
+// otherwise the leases of a batch are committed one after the other. The
+// leases are also committed one by one if their changes cannot be merged
+// together.
+type CommitBatchConfig struct {
+	Repository string `mapstructure:"repository"`
+	// Window is the time, in seconds, during which the commits of disjoint
+	// leases are collected into a batch after the first one arrives
+	Window time.Duration `mapstructure:"window"`
+}
+
+// ReplicationConfig lists the stratum 1 replicas whose revisions are tracked
+type ReplicationConfig struct {
+	// Interval between two checks of the replicas, given in seconds (default
+	// 60)
+	Interval time.Duration `mapstructure:"interval"`
+	// Replicas are the stratum 1 servers of each repository
+	Replicas []ReplicaConfig `mapstructure:"replicas"`
+}
+
+// ReplicaConfig are the stratum 1 servers replicating a repository
+type ReplicaConfig struct {
+	Repository string `mapstructure:"repository"`
+	// URLs are the base URLs of the stratum 1 servers, e.g.
+	// http://stratum1.example.org/cvmfs
+	URLs []string `mapstructure:"urls"`
+}
+
+// RelayConfig is an upstream gateway relayed by the gateway
+type RelayConfig struct {
+	// URL is the API root of the upstream gateway, e.g.
+	// http://gateway.example.org:4929/api/v1
+	URL string `mapstructure:"url"`
+	// Repositories are the repositories whose notifications are relayed
+	Repositories []string `mapstructure:"repositories"`
+}
+
+// ReceiverLimits are the cgroup v2 resource limits of the receiver workers.
+// Each worker of the pool is placed in its own child cgroup of Cgroup, so the
+// limits apply per worker. Zero values leave the resource unlimited.
+type ReceiverLimits struct {
+	// Cgroup is the cgroup v2 directory under which the worker cgroups are
+	// created (e.g. /sys/fs/cgroup/cvmfs-gateway). It must be writable by the
+	// gateway and is required when any limit is set.
+	Cgroup string `mapstructure:"cgroup"`
+	// MemoryMax is the memory limit of each worker, in bytes
+	MemoryMax int64 `mapstructure:"memory_max"`
+	// CPUMax is the CPU bandwidth of each worker, in number of CPUs
+	CPUMax float64 `mapstructure:"cpu_max"`
+	// IOMax are "io.max" entries of the form "MAJ:MIN rbps=... wbps=..."
+	IOMax []string `mapstructure:"io_max"`
+}
+
+// Enabled returns true if any resource limit is set
+func (l ReceiverLimits) Enabled() bool {
+	return l.MemoryMax > 0 || l.CPUMax > 0 || len(l.IOMax) > 0
+}
+
+// Types of listeners
+const (
+	ListenTCP     = "tcp"
+	ListenUnix    = "unix"
+	ListenSystemd = "systemd"
+)
+
+// Endpoints served by the listeners
+const (
+	// EndpointAPI are the public and publisher routes of the API, and the
+	// web dashboard
+	EndpointAPI = "api"
+	// EndpointAdmin are the routes requiring an admin key
+	EndpointAdmin = "admin"
+	// EndpointDebug are the pprof handlers, under "/debug/pprof/"
+	EndpointDebug = "debug"
+)
+
+// ListenerConfig is the configuration of a socket serving the HTTP frontend
+type ListenerConfig struct {
+	// Type is ListenTCP, ListenUnix or ListenSystemd
+	Type string `mapstructure:"type"`
+	// Address is "HOST:PORT" for TCP listeners, the socket path for Unix
+	// listeners, and the FileDescriptorName= of the socket unit for sockets
+	// passed by systemd
+	Address string `mapstructure:"address"`
+	// Mode are the permissions of a Unix socket, in octal (default: "0660")
+	Mode string `mapstructure:"mode"`
+	// Group owning a Unix socket (default: the group of the gateway)
+	Group string `mapstructure:"group"`
+	// Endpoints served by the listener
+	Endpoints []string `mapstructure:"endpoints"`
+}
+
+// Validate checks the type and endpoints of the listener
+func (l ListenerConfig) Validate() error {
+	switch l.Type {
+	case ListenTCP, ListenUnix, ListenSystemd, "":
+	default:
+		return fmt.Errorf("invalid listener type: %v", l.Type)
+	}
+	for _, e := range l.Endpoints {
+		if e != EndpointAPI && e != EndpointAdmin && e != EndpointDebug {
+			return fmt.Errorf("invalid endpoint for listener %v: %v", l.Address, e)
+		}
+	}
+	return nil
+}
+
+// ListenerConfigs returns the configured listeners. Without configuration, the
+// API and admin routes are served on Port and the pprof handlers on
+// localhost:6060.
+func (c *Config) ListenerConfigs() []ListenerConfig {
+	if len(c.Listeners) > 0 {
+		return c.Listeners
+	}
+	return []ListenerConfig{
+		{Type: ListenTCP, Address: fmt.Sprintf(":%d", c.Port), Endpoints: []string{EndpointAPI, EndpointAdmin}},
+		{Type: ListenTCP, Address: "localhost:6060", Endpoints: []string{EndpointDebug}},
+	}
+}
+
+// Stages of a publication where hooks are run
+const (
+	PreCommit  = "pre_commit"
+	PostCommit = "post_commit"
+)
+
+// HookConfig is the configuration of a commit hook. Hooks are either local
+// executables, called with the repository name as argument, or HTTP endpoints;
+// both receive the description of the commit as a JSON document (on the
+// standard input or in the body of a POST request).
+type HookConfig struct {
+	Name string `mapstructure:"name"`
+	// Stage is PreCommit or PostCommit. Pre-commit hooks can veto a commit by
+	// exiting with a non-zero status or replying with an HTTP error; their
+	// output is returned to the client as the reason.
+	Stage string `mapstructure:"stage"`
+	// Command is the path of the executable run by the hook
+	Command string `mapstructure:"command"`
+	// URL is the endpoint receiving the hook requests
+	URL string `mapstructure:"url"`
+	// Timeout of each hook run, given in seconds in the config file
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Retries is the number of additional attempts of a failed post-commit hook
+	Retries int `mapstructure:"retries"`
+	// Repositories restricts the hook to some repositories (all if empty)
+	Repositories []string `mapstructure:"repositories"`
 }
 
 // ReadConfig reads configuration files and commandline flags, and populates a Config object
@@ -43,11 +261,22 @@ func ReadConfig() (*Config, error) {
 	pflag.String("receiver_path", "/usr/bin/cvmfs_receiver", "the path of the cvmfs_receiver executable")
 	pflag.String("work_dir", "/var/lib/cvmfs-gateway", "the working directory for database files")
 	pflag.Bool("mock_receiver", false, "enable the mocked implementation of the receiver process (for testing)")
+	pflag.String("master_key_file", "", "file with the key encrypting the stored API key secrets (default: <work_dir>/master.key)")
+	pflag.String("stratum0_url", "http://localhost/cvmfs", "base URL of the stratum 0 serving the repositories")
+	pflag.Int("receiver_timeout", 0, "maximum duration of a receiver task in seconds (0 for no limit)")
+	pflag.Int64("max_lease_payload_size", 0, "maximum total size in bytes of the decompressed payloads of a lease (0 for no limit)")
+	pflag.String("manifest_key_dir", "", "directory with the repository certificates used to verify the published manifests (verification disabled if empty)")
 	pflag.Parse()
 
 	viper.SetConfigFile(configFile)
 	viper.BindPFlags(pflag.CommandLine)
-	viper.ReadInConfig()
+	if err := viper.ReadInConfig(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("could not read user configuration file %v: %w", configFile, err)
+		}
+		Log("config", LogWarn).
+			Msgf("user configuration file %v not found, using the defaults", configFile)
+	}
 
 	var conf Config
 	if err := viper.Unmarshal(&conf); err != nil {
@@ -56,6 +285,14 @@ func ReadConfig() (*Config, error) {
 
 	// max_lease_time is given in seconds in the config file or at the command line
 	conf.MaxLeaseTime = conf.MaxLeaseTime * time.Second
+	conf.ReceiverTimeout = conf.ReceiverTimeout * time.Second
+	conf.Replication.Interval = conf.Replication.Interval * time.Second
+	for i := range conf.CommitBatching {
+		conf.CommitBatching[i].Window = conf.CommitBatching[i].Window * time.Second
+	}
+	for i := range conf.Hooks {
+		conf.Hooks[i].Timeout = conf.Hooks[i].Timeout * time.Second
+	}
 
 	// Manually handler legacy parameter names
 
@@ -0,0 +1,214 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// legacyConfigFields are the fields of the user configuration file still
+// accepted for backward compatibility
+var legacyConfigFields = map[string]bool{
+	"fe_tcp_port":            true,
+	"receiver_config":        true,
+	"receiver_worker_config": true,
+}
+
+// redactedSecret replaces the secrets in the printed configuration
+const redactedSecret = "xxxxx"
+
+// CheckConfig validates the configuration and the user configuration file it
+// was read from, and returns the problems found
+func CheckConfig(cfg *Config) []string {
+	var problems []string
+
+	if cfg.UserConfigFile != "" {
+		v := viper.New()
+		v.SetConfigFile(cfg.UserConfigFile)
+		if err := v.ReadInConfig(); err != nil {
+			problems = append(problems, fmt.Sprintf("could not read user configuration file: %v", err))
+		} else {
+			problems = append(problems, unknownConfigFields(v.AllSettings(), reflect.TypeOf(Config{}), "")...)
+		}
+	}
+
+	if cfg.NumReceivers < 1 {
+		problems = append(problems, fmt.Sprintf("invalid number of receivers: %v", cfg.NumReceivers))
+	}
+	if cfg.ReceiverLimits.Enabled() && cfg.ReceiverLimits.Cgroup == "" {
+		problems = append(problems, "receiver limits set without a cgroup")
+	}
+	for _, r := range cfg.Relays {
+		if r.URL == "" {
+			problems = append(problems, "relay without an upstream URL")
+		} else if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("invalid upstream URL of relay: %v", r.URL))
+		}
+		if len(r.Repositories) == 0 {
+			problems = append(problems, fmt.Sprintf("relay of %v without repositories", r.URL))
+		}
+	}
+	replicated := make(map[string]bool)
+	for _, r := range cfg.Replication.Replicas {
+		if r.Repository == "" {
+			problems = append(problems, "stratum 1 replicas without a repository")
+		} else if replicated[r.Repository] {
+			problems = append(problems, fmt.Sprintf("stratum 1 replicas of %v listed more than once", r.Repository))
+		}
+		replicated[r.Repository] = true
+		if len(r.URLs) == 0 {
+			problems = append(problems, fmt.Sprintf("no stratum 1 URLs for repository %v", r.Repository))
+		}
+	}
+	batched := make(map[string]bool)
+	for _, b := range cfg.CommitBatching {
+		if b.Repository == "" {
+			problems = append(problems, "commit batching without a repository")
+		} else if batched[b.Repository] {
+			problems = append(problems, fmt.Sprintf("commit batching of %v listed more than once", b.Repository))
+		}
+		batched[b.Repository] = true
+		if b.Window <= 0 {
+			problems = append(problems, fmt.Sprintf("invalid commit batching window for repository %v", b.Repository))
+		}
+	}
+	for _, q := range cfg.Quotas {
+		if q.Repository == "" && q.KeyID == "" {
+			problems = append(problems, "quota without a repository or a key")
+		}
+		if q.MaxUploadedBytes < 0 || q.MaxPayloads < 0 || q.MaxCatalogs < 0 {
+			problems = append(problems, fmt.Sprintf("negative quota limit for %v", quotaTarget(q)))
+		} else if q.MaxUploadedBytes == 0 && q.MaxPayloads == 0 && q.MaxCatalogs == 0 {
+			problems = append(problems, fmt.Sprintf("quota without limits for %v", quotaTarget(q)))
+		}
+	}
+	if cfg.Tracing.Endpoint != "" {
+		if u, err := url.Parse(cfg.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("invalid tracing endpoint: %v", cfg.Tracing.Endpoint))
+		}
+	}
+	for _, l := range cfg.Listeners {
+		if err := l.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if l.Address == "" {
+			problems = append(problems, fmt.Sprintf("listener of type %v without an address", l.Type))
+		}
+	}
+
+	return problems
+}
+
+// unknownConfigFields returns the fields of the settings which do not
+// correspond to a field of the configuration struct t
+func unknownConfigFields(settings map[string]interface{}, t reflect.Type, prefix string) []string {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("mapstructure"); tag != "" {
+			fields[tag] = t.Field(i)
+		}
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var unknown []string
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			if prefix == "" && legacyConfigFields[name] {
+				continue
+			}
+			unknown = append(unknown, fmt.Sprintf("unknown field in user configuration file: %v%v", prefix, name))
+			continue
+		}
+		switch value := settings[name].(type) {
+		case map[string]interface{}:
+			if field.Type.Kind() == reflect.Struct {
+				unknown = append(unknown, unknownConfigFields(value, field.Type, prefix+name+".")...)
+			}
+		case []interface{}:
+			if field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			for i, item := range value {
+				if m, ok := item.(map[string]interface{}); ok {
+					unknown = append(unknown,
+						unknownConfigFields(m, field.Type.Elem(), fmt.Sprintf("%v%v[%v].", prefix, name, i))...)
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+// Redacted returns the configuration keyed like the user configuration file,
+// with the durations in seconds and the credentials of the hook, relay and
+// tracing URLs redacted
+func (c *Config) Redacted() map[string]interface{} {
+	cfg := *c
+	cfg.Hooks = make([]HookConfig, len(c.Hooks))
+	for i, hook := range c.Hooks {
+		hook.URL = redactURL(hook.URL)
+		cfg.Hooks[i] = hook
+	}
+	cfg.Relays = make([]RelayConfig, len(c.Relays))
+	for i, relay := range c.Relays {
+		relay.URL = redactURL(relay.URL)
+		cfg.Relays[i] = relay
+	}
+	cfg.Tracing.Endpoint = redactURL(c.Tracing.Endpoint)
+	return configValue(reflect.ValueOf(cfg)).(map[string]interface{})
+}
+
+// redactURL replaces the password of a URL
+func redactURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+		u.User = url.UserPassword(u.User.Username(), redactedSecret)
+		return u.String()
+	}
+	return rawURL
+}
+
+func configValue(v reflect.Value) interface{} {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return time.Duration(v.Int()).Seconds()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			if tag := v.Type().Field(i).Tag.Get("mapstructure"); tag != "" {
+				m[tag] = configValue(v.Field(i))
+			}
+		}
+		return m
+	case reflect.Slice:
+		items := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, configValue(v.Index(i)))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
+
+// quotaTarget describes the leases subject to a quota, in the reported
+// problems
+func quotaTarget(q QuotaConfig) string {
+	switch {
+	case q.Repository != "" && q.KeyID != "":
+		return fmt.Sprintf("key %v in repository %v", q.KeyID, q.Repository)
+	case q.KeyID != "":
+		return fmt.Sprintf("key %v", q.KeyID)
+	}
+	return fmt.Sprintf("repository %v", q.Repository)
+}
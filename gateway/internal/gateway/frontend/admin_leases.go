@@ -14,21 +14,21 @@ func MakeAdminLeasesHandler(services be.ActionController) httprouter.Handle {
 	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
 		ctx := h.Context()
 
-		msg := map[string]interface{}{"status": "ok"}
+		version := requestAPIVersion(h)
 
 		repoPath := strings.TrimPrefix(ps.ByName("path"), "/")
 		if repoPath == "" {
-			errMsg := "missing path argument"
-			gw.LogC(ctx, "http", gw.LogError).Msg(errMsg)
-			http.Error(w, errMsg, http.StatusBadRequest)
+			replyError(ctx, w, version, invalidRequest("missing path argument"))
 			return
 		}
 
 		if err := services.CancelLeases(ctx, repoPath); err != nil {
-			msg["status"] = "error"
-			msg["reason"] = err.Error()
+			replyError(ctx, w, version, ToAPIError(err))
+			return
 		}
 
-		replyJSON(ctx, w, msg)
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok"})
 	}
 }
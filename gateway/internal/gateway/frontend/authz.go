@@ -23,12 +23,13 @@ type message map[string]interface{}
 func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		ctx := req.Context()
+		version := requestAPIVersion(req)
 		keyID, HMAC, err := parseHeader(&req.Header)
 		if err != nil {
 			gw.LogC(ctx, "http", gw.LogError).
 				Err(err).
 				Msg("authorization failure")
-			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_authorization_header"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "invalid_authorization_header"))
 			return
 		}
 
@@ -36,21 +37,21 @@ func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.H
 		if keyCfg == nil {
 			gw.LogC(ctx, "http", gw.LogError).
 				Msg("invalid key ID specified")
-			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_key"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "invalid_key"))
 			return
 		}
 
 		if !keyCfg.Admin {
 			gw.LogC(ctx, "http", gw.LogError).
 				Msg("key does not have admin rights")
-			replyJSON(ctx, w, message{"status": "error", "reason": "no_admin_key"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "no_admin_key"))
 			return
 		}
 
 		var HMACInput []byte
 		switch req.Method {
-		case "DELETE":
-			// For DELETE requests, use the path component of the URL to compute the HMAC
+		case "GET", "DELETE":
+			// For GET and DELETE requests, use the path component of the URL to compute the HMAC
 			HMACInput = []byte(req.URL.Path)
 		case "POST":
 			// For POST requests, the request body is used to compute HMAC
@@ -64,12 +65,13 @@ func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.H
 			gw.LogC(ctx, "http", gw.LogError).
 				Msgf(msg)
 			http.Error(w, msg, http.StatusMethodNotAllowed)
+			return
 		}
 
-		if !CheckHMAC(HMACInput, HMAC, keyCfg.Secret) {
+		if !checkKeyHMAC(HMACInput, HMAC, keyCfg) {
 			gw.LogC(ctx, "http", gw.LogError).
 				Msg("invalid HMAC")
-			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "invalid_hmac"))
 			return
 		}
 
@@ -81,12 +83,13 @@ func WithAdminAuthz(ac be.ActionController, next httprouter.Handle) httprouter.H
 func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		ctx := req.Context()
+		version := requestAPIVersion(req)
 		keyID, HMAC, err := parseHeader(&req.Header)
 		if err != nil {
 			gw.LogC(ctx, "http", gw.LogError).
 				Err(err).
 				Msg("authorization failure")
-			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "invalid_hmac"))
 			return
 		}
 
@@ -94,7 +97,7 @@ func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle
 		if keyCfg == nil {
 			gw.LogC(ctx, "http", gw.LogError).
 				Msg("invalid key ID specified")
-			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "invalid_hmac"))
 			return
 		}
 
@@ -126,7 +129,7 @@ func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle
 				sz := req.Header.Get("message-size")
 				msgSize, err := strconv.Atoi(sz)
 				if err != nil {
-					httpWrapError(ctx, err, "missing message-size header", w, http.StatusBadRequest)
+					replyError(ctx, w, version, invalidRequest("missing message-size header"))
 					return
 				}
 				HMACInput, err = readBody(req, int64(msgSize))
@@ -135,18 +138,36 @@ func WithAuthz(ac be.ActionController, next httprouter.Handle) httprouter.Handle
 					return
 				}
 			}
+		} else if strings.HasPrefix(req.URL.Path, APIRoot+"/notifications") {
+			// For manifest publication requests, the request body is used to compute the HMAC
+			HMACInput, err = readBody(req, req.ContentLength)
+			if err != nil {
+				httpWrapError(ctx, err, "could not read request body", w, http.StatusInternalServerError)
+				return
+			}
 		}
 
-		if !CheckHMAC(HMACInput, HMAC, keyCfg.Secret) {
+		if !checkKeyHMAC(HMACInput, HMAC, keyCfg) {
 			gw.LogC(ctx, "http", gw.LogError).
 				Msg("invalid HMAC")
-			replyJSON(ctx, w, message{"status": "error", "reason": "invalid_hmac"})
+			replyError(ctx, w, version, NewAPIError(ErrUnauthorized, "invalid_hmac"))
 			return
 		}
 		next(w, req, ps)
 	}
 }
 
+// checkKeyHMAC checks the HMAC against the current secret of the key and, for
+// recently rotated keys, against the previous one
+func checkKeyHMAC(input, HMAC []byte, keyCfg *be.KeyConfig) bool {
+	for _, secret := range keyCfg.Secrets() {
+		if CheckHMAC(input, HMAC, secret) {
+			return true
+		}
+	}
+	return false
+}
+
 // The recombineReadCloser is used during payload submission requests to recombine the request message,
 // already read inside the authorization middleware with the remaining request body and ensure that the
 // body (io.ReadCloser) is eventually closed and does not leak
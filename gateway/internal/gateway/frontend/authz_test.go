@@ -289,4 +289,40 @@ func TestAdminAuthorizationMiddleware(t *testing.T) {
 			t.Errorf("Invalid response body: %v", string(respBody))
 		}
 	})
+	t.Run("GET", func(t *testing.T) {
+		HMAC := ComputeHMAC([]byte("/api/v1/keys"), backend.GetKey(context.TODO(), "admin0").Secret)
+		req := httptest.NewRequest("GET", "/api/v1/keys", nil)
+		ps := httprouter.Params{}
+
+		req.Header["Authorization"] = []string{"admin0 " + base64.StdEncoding.EncodeToString(HMAC)}
+		w := httptest.NewRecorder()
+		handler := WithAdminAuthz(&backend, forwardBody)
+
+		handler(w, req, ps)
+
+		resp := w.Result()
+
+		if resp.StatusCode != 200 {
+			t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+		}
+	})
+	t.Run("previous secret of a rotated key", func(t *testing.T) {
+		for secret, valid := range map[string]bool{"big_secret": true, "old_secret": true, "other_secret": false} {
+			HMAC := ComputeHMAC([]byte("/api/v1/keys"), secret)
+			req := httptest.NewRequest("GET", "/api/v1/keys", nil)
+			ps := httprouter.Params{}
+
+			req.Header["Authorization"] = []string{"admin_rotated " + base64.StdEncoding.EncodeToString(HMAC)}
+			w := httptest.NewRecorder()
+			handler := WithAdminAuthz(&backend, forwardBody)
+
+			handler(w, req, ps)
+
+			respBody, _ := ioutil.ReadAll(w.Result().Body)
+			rejected := bytes.Contains(respBody, []byte("invalid_hmac"))
+			if rejected == valid {
+				t.Errorf("Secret %v: unexpected response body: %v", secret, string(respBody))
+			}
+		}
+	})
 }
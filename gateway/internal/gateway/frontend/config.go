@@ -0,0 +1,29 @@
+package frontend
+
+import (
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeConfigHandler creates an HTTP handler returning the effective
+// configuration of the gateway, with the secrets redacted, and the problems
+// found in the configuration files
+func MakeConfigHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		report, err := services.GetConfig(ctx)
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok", "data": report})
+	}
+}
@@ -0,0 +1,32 @@
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/julienschmidt/httprouter"
+)
+
+// DashboardRoot is the URL path of the web dashboard
+const DashboardRoot = "/dashboard/"
+
+// dashboardFiles are the static files of the web dashboard. The dashboard
+// only uses the public API: it polls "/status", and signs the admin requests
+// with a key entered by the operator, which is never sent to the gateway.
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// registerDashboard serves the embedded web dashboard
+func registerDashboard(router *httprouter.Router) {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		gw.Log("http", gw.LogError).
+			Err(err).
+			Msg("could not load the web dashboard")
+		return
+	}
+	router.ServeFiles(DashboardRoot+"*filepath", http.FS(files))
+}
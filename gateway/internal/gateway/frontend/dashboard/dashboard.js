@@ -0,0 +1,181 @@
+// Web dashboard of the repository gateway. The state is polled from the
+// "/status" endpoint; admin actions are signed like in the gateway clients:
+// "Authorization: <key ID> <base64(hex(HMAC-SHA1))>" over the URL path for
+// DELETE requests and over the body for POST requests.
+"use strict";
+
+const apiRoot = new URL("../api/v1", document.baseURI).pathname;
+const apiVersion = "4";
+const refreshInterval = 5000;
+
+function adminKey() {
+  const id = sessionStorage.getItem("admin_key_id");
+  const secret = sessionStorage.getItem("admin_secret");
+  return id && secret ? { id, secret } : null;
+}
+
+async function authorization(key, input) {
+  const enc = new TextEncoder();
+  const cryptoKey = await crypto.subtle.importKey(
+    "raw", enc.encode(key.secret), { name: "HMAC", hash: "SHA-1" }, false, ["sign"]);
+  const mac = new Uint8Array(await crypto.subtle.sign("HMAC", cryptoKey, enc.encode(input)));
+  const hex = Array.from(mac, (b) => b.toString(16).padStart(2, "0")).join("");
+  return key.id + " " + btoa(hex);
+}
+
+async function adminRequest(method, path, body) {
+  const key = adminKey();
+  if (!key) {
+    throw new Error("no admin key");
+  }
+  const url = apiRoot + path;
+  const headers = { "X-Gateway-API-Version": apiVersion };
+  let input = url;
+  if (body !== undefined) {
+    input = JSON.stringify(body);
+    headers["Content-Type"] = "application/json";
+  }
+  headers["Authorization"] = await authorization(key, input);
+  const rep = await fetch(encodeURI(url), { method, headers, body: body === undefined ? undefined : input });
+  const msg = await rep.json();
+  if (msg.status !== "ok") {
+    throw new Error(msg.reason || "request failed");
+  }
+  return msg;
+}
+
+function cell(row, text, cls) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (cls) {
+    td.className = cls;
+  }
+  return td;
+}
+
+function actionButton(row, label, confirmation, action) {
+  const button = document.createElement("button");
+  button.className = "action";
+  button.textContent = label;
+  button.onclick = async () => {
+    if (!window.confirm(confirmation)) {
+      return;
+    }
+    try {
+      await action();
+      setAdminStatus(confirmation.replace(/\?$/, "") + ": done");
+    } catch (err) {
+      setAdminStatus(confirmation.replace(/\?$/, "") + ": " + err.message);
+    }
+    refresh();
+  };
+  row.insertCell().appendChild(button);
+}
+
+function fillTable(id, items, fill) {
+  const body = document.querySelector("#" + id + " tbody");
+  body.replaceChildren();
+  for (const item of items || []) {
+    fill(body.insertRow(), item);
+  }
+}
+
+function duration(seconds) {
+  seconds = Math.max(0, Math.round(seconds));
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  const s = seconds % 60;
+  return (h > 0 ? h + "h " : "") + (h > 0 || m > 0 ? m + "m " : "") + s + "s";
+}
+
+function render(status) {
+  fillTable("repositories", status.repositories, (row, repo) => {
+    cell(row, repo.name);
+    cell(row, repo.enabled ? "enabled" : "disabled", repo.enabled ? "" : "disabled");
+    cell(row, repo.subscribers);
+    const enable = !repo.enabled;
+    actionButton(row, enable ? "Enable" : "Disable",
+      (enable ? "Enable " : "Disable ") + repo.name + "?",
+      () => adminRequest("POST", "/repos/" + repo.name, { enable }));
+  });
+  fillTable("leases", status.leases, (row, lease) => {
+    const paths = lease.paths || [lease.lease_path];
+    cell(row, paths.join(", "));
+    cell(row, lease.key_id);
+    cell(row, lease.hostname);
+    cell(row, duration(lease.time_remaining), lease.time_remaining < 60 ? "expiring" : "");
+    // Leases on several subpaths are cancelled through one of them, since
+    // their common parent may contain other leases
+    actionButton(row, "Cancel", "Cancel the lease on " + paths.join(", ") + "?",
+      () => adminRequest("DELETE", "/leases-by-path/" + paths[0]));
+  });
+  fillTable("receivers", [status.receivers], (row, st) => {
+    cell(row, st.busy + " / " + st.workers);
+    cell(row, st.tasks);
+    cell(row, st.failed);
+    cell(row, st.timed_out);
+    cell(row, st.out_of_memory);
+  });
+  fillTable("publications", status.publications, (row, p) => {
+    cell(row, new Date(p.time).toLocaleString());
+    cell(row, p.lease_path);
+    cell(row, p.key_id);
+    cell(row, p.hostname);
+    cell(row, p.revision);
+    cell(row, duration(p.duration_ms / 1000));
+  });
+  fillTable("gc-runs", status.gc_runs, (row, r) => {
+    cell(row, new Date(r.time).toLocaleString());
+    cell(row, r.repository);
+    cell(row, r.dry_run ? "yes" : "no");
+    cell(row, r.outcome, r.outcome === "success" ? "" : "disabled");
+    cell(row, duration(r.duration_ms / 1000));
+  });
+}
+
+async function refresh() {
+  const updated = document.getElementById("updated");
+  try {
+    const rep = await fetch(apiRoot + "/status", { headers: { "X-Gateway-API-Version": apiVersion } });
+    const msg = await rep.json();
+    if (msg.status !== "ok") {
+      throw new Error(msg.reason || "request failed");
+    }
+    render(msg.data);
+    updated.textContent = "Updated " + new Date().toLocaleTimeString();
+  } catch (err) {
+    updated.textContent = "Could not update the status: " + err.message;
+  }
+}
+
+function setAdminStatus(text) {
+  document.getElementById("admin-status").textContent = text;
+}
+
+function updateAdminState() {
+  const key = adminKey();
+  document.body.classList.toggle("admin", key !== null);
+  setAdminStatus(key ? "Admin actions signed with key " + key.id : "No admin key, the dashboard is read-only");
+}
+
+document.getElementById("admin-form").onsubmit = (ev) => {
+  ev.preventDefault();
+  if (!window.crypto || !crypto.subtle) {
+    setAdminStatus("Signing requests requires a secure context (HTTPS or localhost)");
+    return;
+  }
+  sessionStorage.setItem("admin_key_id", document.getElementById("admin-key-id").value);
+  sessionStorage.setItem("admin_secret", document.getElementById("admin-secret").value);
+  document.getElementById("admin-secret").value = "";
+  updateAdminState();
+};
+
+document.getElementById("admin-forget").onclick = () => {
+  sessionStorage.removeItem("admin_key_id");
+  sessionStorage.removeItem("admin_secret");
+  updateAdminState();
+};
+
+updateAdminState();
+refresh();
+setInterval(refresh, refreshInterval);
@@ -0,0 +1,52 @@
+package frontend
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+)
+
+func TestDashboard(t *testing.T) {
+	srv := NewFrontend(&mockBackend{}, 4929, 10*time.Second)
+
+	t.Run("static files", func(t *testing.T) {
+		for _, path := range []string{DashboardRoot, DashboardRoot + "dashboard.js"} {
+			req := httptest.NewRequest("GET", path, nil)
+			w := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(w, req)
+			resp := w.Result()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != http.StatusOK || len(body) == 0 {
+				t.Errorf("could not load %v: %v", path, resp.StatusCode)
+			}
+		}
+		req := httptest.NewRequest("GET", DashboardRoot, nil)
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, req)
+		if body, _ := ioutil.ReadAll(w.Result().Body); !strings.Contains(string(body), "dashboard.js") {
+			t.Errorf("dashboard page does not load the script: %v", string(body))
+		}
+	})
+	t.Run("status", func(t *testing.T) {
+		req := httptest.NewRequest("GET", APIRoot+"/status", nil)
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, req)
+
+		var reply struct {
+			Status string       `json:"status"`
+			Data   be.StatusDTO `json:"data"`
+		}
+		if err := json.NewDecoder(w.Result().Body).Decode(&reply); err != nil {
+			t.Fatalf("could not decode status: %v", err)
+		}
+		if reply.Status != "ok" || len(reply.Data.Repositories) != 1 || len(reply.Data.Leases) != 1 {
+			t.Errorf("invalid status reply: %+v", reply)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package frontend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+)
+
+// ErrorCode is a stable, machine-readable identifier of an API error, returned
+// in the "code" field of error replies
+type ErrorCode string
+
+// The different error codes returned by the API
+const (
+	ErrInvalidLease        ErrorCode = "invalid_lease"
+	ErrPathBusy            ErrorCode = "path_busy"
+	ErrRepoDisabled        ErrorCode = "repo_disabled"
+	ErrRepoBusy            ErrorCode = "repo_busy"
+	ErrUnauthorized        ErrorCode = "unauthorized"
+	ErrRateLimited         ErrorCode = "rate_limited"
+	ErrReceiverFailure     ErrorCode = "receiver_failure"
+	ErrTaskKilled          ErrorCode = "task_killed"
+	ErrPayloadTooLarge     ErrorCode = "payload_too_large"
+	ErrQuotaExceeded       ErrorCode = "quota_exceeded"
+	ErrCommitVetoed        ErrorCode = "commit_vetoed"
+	ErrOutdatedManifest    ErrorCode = "outdated_manifest"
+	ErrInvalidRequest      ErrorCode = "invalid_request"
+	ErrNotFound            ErrorCode = "not_found"
+	ErrIncompatibleVersion ErrorCode = "incompatible_version"
+	ErrInternal            ErrorCode = "internal_error"
+)
+
+// errorStatus maps each error code to the HTTP status code sent to clients
+// which negotiated protocol version StructuredErrorsVersion or later
+var errorStatus = map[ErrorCode]int{
+	ErrInvalidLease:        http.StatusNotFound,
+	ErrPathBusy:            http.StatusConflict,
+	ErrRepoDisabled:        http.StatusForbidden,
+	ErrRepoBusy:            http.StatusConflict,
+	ErrUnauthorized:        http.StatusForbidden,
+	ErrRateLimited:         http.StatusTooManyRequests,
+	ErrReceiverFailure:     http.StatusBadGateway,
+	ErrTaskKilled:          http.StatusServiceUnavailable,
+	ErrPayloadTooLarge:     http.StatusRequestEntityTooLarge,
+	ErrQuotaExceeded:       http.StatusForbidden,
+	ErrCommitVetoed:        http.StatusForbidden,
+	ErrOutdatedManifest:    http.StatusConflict,
+	ErrInvalidRequest:      http.StatusBadRequest,
+	ErrNotFound:            http.StatusNotFound,
+	ErrIncompatibleVersion: http.StatusBadRequest,
+	ErrInternal:            http.StatusInternalServerError,
+}
+
+// APIError is an error reply of the HTTP API
+type APIError struct {
+	Code ErrorCode
+	// Reason is the human-readable explanation, sent in the "reason" field
+	Reason string
+	// LegacyStatus is the HTTP status code sent to clients older than
+	// StructuredErrorsVersion (usually 200, with the error in the body)
+	LegacyStatus int
+	// Details are additional fields added to the reply
+	Details map[string]interface{}
+}
+
+func (e *APIError) Error() string {
+	return e.Reason
+}
+
+// Status returns the HTTP status code of the error
+func (e *APIError) Status() int {
+	if s, ok := errorStatus[e.Code]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// NewAPIError creates an error reply with the given code and reason
+func NewAPIError(code ErrorCode, reason string) *APIError {
+	return &APIError{Code: code, Reason: reason, LegacyStatus: http.StatusOK}
+}
+
+// invalidRequest creates an error reply for malformed requests, which have
+// always been rejected with "400 Bad Request"
+func invalidRequest(reason string) *APIError {
+	e := NewAPIError(ErrInvalidRequest, reason)
+	e.LegacyStatus = http.StatusBadRequest
+	return e
+}
+
+// ToAPIError classifies an error returned by the backend services
+func ToAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	var busyErr be.PathBusyError
+	if errors.As(err, &busyErr) {
+		e := NewAPIError(ErrPathBusy, err.Error())
+		e.Details = map[string]interface{}{"time_remaining": busyErr.Remaining().String()}
+		return e
+	}
+
+	var vetoErr be.HookVetoError
+	if errors.As(err, &vetoErr) {
+		e := NewAPIError(ErrCommitVetoed, err.Error())
+		e.Details = map[string]interface{}{"hook": vetoErr.Hook}
+		return e
+	}
+
+	var killedErr receiver.TaskKilledError
+	if errors.As(err, &killedErr) {
+		e := NewAPIError(ErrTaskKilled, err.Error())
+		e.Details = map[string]interface{}{"kill_reason": killedErr.Reason}
+		return e
+	}
+
+	var authErr *be.AuthError
+	if errors.As(err, &authErr) {
+		return NewAPIError(ErrUnauthorized, err.Error())
+	}
+
+	switch {
+	case errors.As(err, &be.InvalidLeaseError{}):
+		return NewAPIError(ErrInvalidLease, err.Error())
+	case errors.Is(err, be.ErrRepoDisabled):
+		return NewAPIError(ErrRepoDisabled, err.Error())
+	case errors.As(err, &be.RepoBusyError{}):
+		return NewAPIError(ErrRepoBusy, err.Error())
+	case errors.Is(err, be.ErrPayloadTooLarge):
+		return NewAPIError(ErrPayloadTooLarge, err.Error())
+	case errors.Is(err, be.ErrQuotaExceeded):
+		return NewAPIError(ErrQuotaExceeded, err.Error())
+	case errors.As(err, &be.ReceiverError{}):
+		return NewAPIError(ErrReceiverFailure, err.Error())
+	case errors.As(err, &be.AuthError{}):
+		return NewAPIError(ErrUnauthorized, err.Error())
+	case errors.Is(err, be.ErrKeyNotFound), errors.Is(err, be.ErrRepoNotFound):
+		return NewAPIError(ErrNotFound, err.Error())
+	case errors.Is(err, be.ErrKeyExists), errors.Is(err, be.ErrInvalidKeyID),
+		errors.Is(err, be.ErrStaticKey), errors.Is(err, be.ErrKeyRevoked):
+		return invalidRequest(err.Error())
+	case errors.Is(err, be.ErrRepoExists), errors.Is(err, be.ErrStaticRepo),
+		errors.Is(err, be.ErrInvalidRegistration), errors.Is(err, be.ErrNotOnStratum0), errors.Is(err, be.ErrInvalidDate),
+		errors.Is(err, be.ErrOverlappingPaths), errors.Is(err, be.ErrMultipleRepositories),
+		errors.Is(err, be.ErrInvalidEncoding), errors.Is(err, be.ErrInvalidManifest),
+		errors.Is(err, be.ErrNotEnoughReplicas):
+		return invalidRequest(err.Error())
+	case errors.Is(err, be.ErrInvalidSignature):
+		return NewAPIError(ErrUnauthorized, err.Error())
+	case errors.Is(err, be.ErrOutdatedManifest):
+		return NewAPIError(ErrOutdatedManifest, err.Error())
+	case errors.Is(err, be.ErrLeaseLimit):
+		return NewAPIError(ErrRateLimited, err.Error())
+	}
+
+	return NewAPIError(ErrInternal, err.Error())
+}
+
+// requestAPIVersion returns the protocol version announced by the client in
+// the APIVersionHeader, or MinAPIProtocolVersion if it is missing
+func requestAPIVersion(req *http.Request) int {
+	v, err := strconv.Atoi(req.Header.Get(APIVersionHeader))
+	if err != nil {
+		return MinAPIProtocolVersion
+	}
+	return v
+}
+
+// replyError sends an error reply. Clients which negotiated protocol version
+// StructuredErrorsVersion or later receive the error code and the HTTP status
+// code matching the error; older clients receive the same reply as before,
+// usually with a "200 OK" status code
+func replyError(ctx context.Context, w http.ResponseWriter, version int, err *APIError) {
+	gw.LogC(ctx, "http", gw.LogError).
+		Str("code", string(err.Code)).
+		Msg(err.Reason)
+
+	msg := message{"status": "error", "reason": err.Reason}
+	for k, v := range err.Details {
+		msg[k] = v
+	}
+
+	status := err.LegacyStatus
+	if version >= StructuredErrorsVersion {
+		status = err.Status()
+		msg["code"] = err.Code
+	} else if err.Code == ErrPathBusy {
+		// Legacy clients expect the "path_busy" status instead of "error"
+		msg["status"] = "path_busy"
+	}
+
+	replyJSONStatus(ctx, w, msg, status)
+}
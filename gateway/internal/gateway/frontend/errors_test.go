@@ -0,0 +1,160 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
+	"github.com/julienschmidt/httprouter"
+)
+
+// failingBackend returns errors from the lease operations
+type failingBackend struct {
+	mockBackend
+}
+
+func (b *failingBackend) NewLease(ctx context.Context, keyID, leasePath, hostname string, protocolVersion int) (string, error) {
+	return "", fmt.Errorf("could not create lease: %w", be.ErrRepoDisabled)
+}
+
+func (b *failingBackend) CommitLease(ctx context.Context, tokenStr, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
+	return 0, be.InvalidLeaseError{}
+}
+
+func TestToAPIError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{be.InvalidLeaseError{}, ErrInvalidLease},
+		{be.ErrRepoDisabled, ErrRepoDisabled},
+		{be.RepoBusyError{}, ErrRepoBusy},
+		{&be.AuthError{Reason: "invalid_key"}, ErrUnauthorized},
+		{be.ReceiverError{Err: fmt.Errorf("crash")}, ErrReceiverFailure},
+		{be.ReceiverError{Err: receiver.TaskKilledError{Task: "payload", Reason: receiver.KilledTimeout}}, ErrTaskKilled},
+		{be.HookVetoError{Hook: "check", Reason: "no"}, ErrCommitVetoed},
+		{fmt.Errorf("%w: key1", be.ErrKeyNotFound), ErrNotFound},
+		{be.ErrLeaseLimit, ErrRateLimited},
+		{fmt.Errorf("%w: yesterday", be.ErrInvalidDate), ErrInvalidRequest},
+		{fmt.Errorf("%w: unreachable", be.ErrNotOnStratum0), ErrInvalidRequest},
+		{be.ErrNoStratum0URL, ErrInternal},
+		{fmt.Errorf("%w: {Name:}", be.ErrInvalidRegistration), ErrInvalidRequest},
+		{fmt.Errorf("%w: a/b, a/b/c", be.ErrOverlappingPaths), ErrInvalidRequest},
+		{fmt.Errorf("%w: br", be.ErrInvalidEncoding), ErrInvalidRequest},
+		{fmt.Errorf("%w: more than 10 bytes", be.ErrPayloadTooLarge), ErrPayloadTooLarge},
+		{fmt.Errorf("%w: more than 2 payloads submitted", be.ErrQuotaExceeded), ErrQuotaExceeded},
+		{fmt.Errorf("%w: missing root hash", be.ErrInvalidManifest), ErrInvalidRequest},
+		{fmt.Errorf("%w: hash mismatch", be.ErrInvalidSignature), ErrUnauthorized},
+		{fmt.Errorf("%w: revision 1 is older than revision 2", be.ErrOutdatedManifest), ErrOutdatedManifest},
+		{fmt.Errorf("%w: 3 replicas requested", be.ErrNotEnoughReplicas), ErrInvalidRequest},
+		{fmt.Errorf("something else"), ErrInternal},
+	}
+	for _, c := range cases {
+		if e := ToAPIError(c.err); e.Code != c.code {
+			t.Errorf("error %v classified as %v instead of %v", c.err, e.Code, c.code)
+		}
+	}
+}
+
+func TestStructuredErrorReplies(t *testing.T) {
+	backend := failingBackend{}
+	token := "lease_token"
+
+	commit := func(version int) (*http.Response, map[string]interface{}) {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"old_root_hash": "abcdef",
+			"new_root_hash": "defabc",
+		})
+		req := httptest.NewRequest("POST", "/api/v1/leases/"+token, bytes.NewReader(msg))
+		HMAC := ComputeHMAC([]byte(token), backend.GetKey(context.TODO(), "keyid2").Secret)
+		req.Header["Authorization"] = []string{"keyid2 " + base64.StdEncoding.EncodeToString(HMAC)}
+		if version != 0 {
+			req.Header.Set(APIVersionHeader, strconv.Itoa(version))
+		}
+		w := httptest.NewRecorder()
+		ps := httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+		MakeLeasesHandler(&backend)(w, req, ps)
+		resp := w.Result()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	t.Run("legacy client", func(t *testing.T) {
+		resp, body := commit(0)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+		}
+		if body["status"] != "error" || body["reason"] != "invalid lease" {
+			t.Errorf("Invalid response body: %v", body)
+		}
+		if _, present := body["code"]; present {
+			t.Errorf("Legacy reply should not contain an error code: %v", body)
+		}
+	})
+	t.Run("versioned client", func(t *testing.T) {
+		resp, body := commit(StructuredErrorsVersion)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+		}
+		if body["code"] != string(ErrInvalidLease) {
+			t.Errorf("Invalid response body: %v", body)
+		}
+	})
+	t.Run("new lease version from body", func(t *testing.T) {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"path":        "test2.repo.org/some/path",
+			"api_version": strconv.Itoa(APIProtocolVersion),
+		})
+		req := httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(msg))
+		w := httptest.NewRecorder()
+		MakeLeasesHandler(&backend)(w, req, httprouter.Params{})
+		resp := w.Result()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if resp.StatusCode != http.StatusForbidden || body["code"] != string(ErrRepoDisabled) {
+			t.Errorf("Invalid response: %v %v", resp.StatusCode, body)
+		}
+	})
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	srv := NewFrontend(&mockBackend{}, 4929, 10*time.Second)
+
+	req := httptest.NewRequest("GET", APIRoot+"/openapi.json", nil)
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, req)
+
+	var doc struct {
+		OpenAPI string                                       `json:"openapi"`
+		Paths   map[string]map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(w.Result().Body).Decode(&doc); err != nil {
+		t.Fatalf("could not decode OpenAPI document: %v", err)
+	}
+
+	if doc.OpenAPI == "" {
+		t.Errorf("missing OpenAPI version")
+	}
+	op, present := doc.Paths["/leases/{token}"]["post"]
+	if !present {
+		t.Fatalf("missing commit operation: %v", doc.Paths)
+	}
+	responses := op["responses"].(map[string]interface{})
+	if _, present := responses["404"]; !present {
+		t.Errorf("commit operation should document the invalid_lease error: %v", responses)
+	}
+	if _, present := doc.Paths["/leases-by-path/{path}"]["delete"]; !present {
+		t.Errorf("missing admin operation: %v", doc.Paths)
+	}
+}
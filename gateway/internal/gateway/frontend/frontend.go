@@ -5,14 +5,29 @@ import (
 	"net/http"
 	"time"
 
+	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
 
 	"github.com/julienschmidt/httprouter"
 )
 
-// NewFrontend builds and configures a new HTTP server, but does not start it
+// NewFrontend builds and configures a new HTTP server for the API and admin
+// endpoints on port, but does not start it
 func NewFrontend(services be.ActionController, port int, timeout time.Duration) *http.Server {
+	srv := NewServer(services, []string{gw.EndpointAPI, gw.EndpointAdmin}, timeout)
+	srv.Addr = fmt.Sprintf(":%d", port)
+	return srv
+}
+
+// NewServer builds an HTTP server for the given endpoints (gw.EndpointAPI,
+// gw.EndpointAdmin and gw.EndpointDebug). The routes of the other endpoints
+// are not registered.
+func NewServer(services be.ActionController, endpoints []string, timeout time.Duration) *http.Server {
 	router := httprouter.New()
+	serves := make(map[string]bool)
+	for _, e := range endpoints {
+		serves[e] = true
+	}
 
 	// middleware which only tags requests for GET
 	tag := func(h httprouter.Handle) httprouter.Handle {
@@ -21,48 +36,155 @@ func NewFrontend(services be.ActionController, port int, timeout time.Duration)
 
 	// middleware which tags requests and performs HMAC authorization
 	mw := func(h httprouter.Handle) httprouter.Handle {
-		return WithTag(WithAuthz(services, h))
+		return WithTag(traceAuthz(func(next httprouter.Handle) httprouter.Handle {
+			return WithAuthz(services, next)
+		}, h))
 	}
 
 	// middleware with tagging and admin authorization
 	amw := func(h httprouter.Handle) httprouter.Handle {
-		return WithTag(WithAdminAuthz(services, h))
+		return WithTag(traceAuthz(func(next httprouter.Handle) httprouter.Handle {
+			return WithAdminAuthz(services, next)
+		}, h))
 	}
 
-	// Regular routes
-
-	// Root handler
-	router.GET(APIRoot, tag(NewRootHandler()))
-
-	// Repositories
-	router.GET(APIRoot+"/repos", tag(MakeReposHandler(services)))
-	router.GET(APIRoot+"/repos/:name", tag(MakeReposHandler(services)))
+	routes := []route{
+		// Root handler
+		{"GET", "", "API root", authNone, nil, NewRootHandler()},
+
+		// Repositories
+		{"GET", "/repos", "List repositories", authNone,
+			[]ErrorCode{ErrInternal}, MakeReposHandler(services)},
+		{"GET", "/repos/:name", "Get repository", authNone,
+			[]ErrorCode{ErrNotFound, ErrInternal}, MakeReposHandler(services)},
+		{"GET", "/repos/:name/stats", "Get the publication statistics of a repository", authNone,
+			[]ErrorCode{ErrInvalidRequest, ErrNotFound, ErrInternal}, MakeRepoStatisticsHandler(services)},
+
+		// Receiver pool
+		{"GET", "/receivers", "Receiver pool statistics", authNone,
+			nil, MakeReceiversHandler(services)},
+
+		// Overview used by the web dashboard
+		{"GET", "/status", "Overview of the repositories, leases and recent activity", authNone,
+			[]ErrorCode{ErrInternal}, MakeStatusHandler(services)},
+
+		// Leases
+		{"GET", "/leases", "List active leases", authNone,
+			[]ErrorCode{ErrInternal}, MakeLeasesHandler(services)},
+		{"GET", "/leases/:token", "Get lease", authNone,
+			[]ErrorCode{ErrInvalidLease, ErrInternal}, MakeLeasesHandler(services)},
+		{"GET", "/leases/:token/payloads", "List the payloads submitted for a lease", authNone,
+			[]ErrorCode{ErrInvalidLease, ErrInternal}, MakeLeasePayloadsHandler(services)},
+		{"POST", "/leases", "Request a new lease", authKey,
+			[]ErrorCode{ErrInvalidRequest, ErrIncompatibleVersion, ErrUnauthorized, ErrPathBusy, ErrRepoDisabled, ErrRateLimited, ErrInternal},
+			MakeLeasesHandler(services)},
+		{"POST", "/leases/:token", "Commit a lease", authKey,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrInvalidLease, ErrCommitVetoed, ErrQuotaExceeded, ErrReceiverFailure, ErrTaskKilled, ErrInternal},
+			MakeLeasesHandler(services)},
+		{"DELETE", "/leases/:token", "Cancel a lease", authKey,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrInvalidLease, ErrInternal},
+			MakeLeasesHandler(services)},
+
+		// Payloads (legacy endpoint)
+		{"POST", "/payloads", "Submit a payload (legacy)", authKey,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrInvalidLease, ErrPayloadTooLarge, ErrQuotaExceeded, ErrReceiverFailure, ErrTaskKilled, ErrInternal},
+			MakePayloadsHandler(services)},
+		// Payloads (new and improved)
+		{"POST", "/payloads/:token", "Submit a payload", authKey,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrInvalidLease, ErrPayloadTooLarge, ErrQuotaExceeded, ErrReceiverFailure, ErrTaskKilled, ErrInternal},
+			MakePayloadsHandler(services)},
+
+		// Notification system endpoints
+		{"POST", "/notifications/publish", "Publish a repository manifest", authKey,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrOutdatedManifest, ErrInternal},
+			MakeNotificationsHandler(services)},
+		{"GET", "/notifications/subscribe", "Subscribe to repository notifications", authNone,
+			[]ErrorCode{ErrInvalidRequest}, MakeNotificationsHandler(services)},
+
+		// Admin routes
+		{"POST", "/repos/:name", "Enable or disable a repository", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrRepoBusy, ErrInternal},
+			MakeAdminReposHandler(services)},
+		{"POST", "/repos", "Register a repository", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeRepoRegistrationHandler(services)},
+		{"DELETE", "/repos/:name", "Deregister a repository", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrRepoBusy, ErrInternal},
+			MakeRepoRegistrationHandler(services)},
+		{"GET", "/repos/:name/hooks", "List the recent commit hook runs of a repository", authAdmin,
+			[]ErrorCode{ErrUnauthorized, ErrInternal}, MakeHookRunsHandler(services)},
+		{"DELETE", "/leases-by-path/*path", "Cancel the leases below a path", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrInternal},
+			MakeAdminLeasesHandler(services)},
+		{"POST", "/gc", "Run garbage collection", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrInternal},
+			MakeGCHandler(services)},
+		{"GET", "/config", "Effective configuration and configuration problems", authAdmin,
+			[]ErrorCode{ErrUnauthorized, ErrInternal}, MakeConfigHandler(services)},
+		{"GET", "/relays", "State of the subscriptions to the relayed upstream gateways", authAdmin,
+			[]ErrorCode{ErrUnauthorized, ErrInternal}, MakeRelaysHandler(services)},
+
+		// Key management
+		{"GET", "/keys", "List keys", authAdmin,
+			[]ErrorCode{ErrUnauthorized, ErrInternal}, MakeKeysHandler(services)},
+		{"GET", "/keys/:id", "Get key", authAdmin,
+			[]ErrorCode{ErrUnauthorized, ErrNotFound, ErrInternal}, MakeKeysHandler(services)},
+		{"POST", "/keys", "Create a key", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeKeysHandler(services)},
+		{"POST", "/keys/:id", "Enable or disable a key", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeKeyActionsHandler(services)},
+		{"DELETE", "/keys/:id", "Revoke a key", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeKeyActionsHandler(services)},
+		{"POST", "/keys/:id/rotate", "Rotate the secret of a key", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeKeyRotateHandler(services)},
+		{"POST", "/keys/:id/bindings", "Grant a key access to a repository path", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeKeyBindingsHandler(services)},
+		{"DELETE", "/keys/:id/bindings/:repo", "Remove the access of a key to a repository", authAdmin,
+			[]ErrorCode{ErrInvalidRequest, ErrUnauthorized, ErrNotFound, ErrInternal},
+			MakeKeyBindingsHandler(services)},
+	}
 
-	// Leases
-	router.GET(APIRoot+"/leases", tag(MakeLeasesHandler(services)))
-	router.GET(APIRoot+"/leases/:token", tag(MakeLeasesHandler(services)))
-	router.POST(APIRoot+"/leases", mw(MakeLeasesHandler(services)))
-	router.POST(APIRoot+"/leases/:token", mw(MakeLeasesHandler(services)))
-	router.DELETE(APIRoot+"/leases/:token", mw(MakeLeasesHandler(services)))
+	// Only keep the routes of the served endpoints
+	served := routes[:0]
+	for _, r := range routes {
+		if (r.Auth == authAdmin && serves[gw.EndpointAdmin]) || (r.Auth != authAdmin && serves[gw.EndpointAPI]) {
+			served = append(served, r)
+		}
+	}
+	routes = served
 
-	// Payloads (legacy endpoint)
-	router.POST(APIRoot+"/payloads", mw(MakePayloadsHandler(services)))
-	// Payloads (new and improved)
-	router.POST(APIRoot+"/payloads/:token", mw(MakePayloadsHandler(services)))
+	// API description
+	if len(routes) > 0 {
+		routes = append(routes, route{"GET", "/openapi.json", "OpenAPI description of the API", authNone,
+			nil, MakeOpenAPIHandler(&routes)})
+	}
 
-	// Notification system endpoints
-	router.POST(APIRoot+"/notifications/publish", tag(MakeNotificationsHandler(services)))
-	router.GET(APIRoot+"/notifications/subscribe", tag(MakeNotificationsHandler(services)))
+	for _, r := range routes {
+		handler := tag(r.Handler)
+		switch r.Auth {
+		case authKey:
+			handler = mw(r.Handler)
+		case authAdmin:
+			handler = amw(r.Handler)
+		}
+		router.Handle(r.Method, APIRoot+r.Path, WithTrace(r.Method+" "+APIRoot+r.Path, handler))
+	}
 
-	// Admin routes
-	router.POST(APIRoot+"/repos/:name", amw(MakeAdminReposHandler(services)))
-	router.DELETE(APIRoot+"/leases-by-path/*path", amw(MakeAdminLeasesHandler(services)))
-	router.POST(APIRoot+"/gc", amw(MakeGCHandler(services)))
+	if serves[gw.EndpointAPI] {
+		registerDashboard(router)
+	}
+	if serves[gw.EndpointDebug] {
+		registerDebug(router)
+	}
 
-	// Configure and start the HTTP server
+	// Configure the HTTP server
 	srv := &http.Server{
 		Handler:      router,
-		Addr:         fmt.Sprintf(":%d", port),
 		WriteTimeout: timeout,
 		ReadTimeout:  timeout,
 	}
@@ -70,12 +192,26 @@ func NewFrontend(services be.ActionController, port int, timeout time.Duration)
 	return srv
 }
 
-// Start HTTP frontend
-func Start(services *be.Services, port int, timeout time.Duration) error {
-	srv := NewFrontend(services, port, timeout)
-	if err := srv.ListenAndServe(); err != nil {
-		return fmt.Errorf("could not run HTTP front-end: %w", err)
+// Start HTTP frontend on each of the configured listeners. It returns when one
+// of the servers fails.
+func Start(services *be.Services, listeners []gw.ListenerConfig, timeout time.Duration) error {
+	errs := make(chan error, len(listeners))
+	for _, cfg := range listeners {
+		l, err := Listen(cfg)
+		if err != nil {
+			return err
+		}
+		gw.Log("http", gw.LogInfo).
+			Str("address", l.Addr().String()).
+			Strs("endpoints", cfg.Endpoints).
+			Msg("listening")
+		srv := NewServer(services, cfg.Endpoints, timeout)
+		go func(cfg gw.ListenerConfig) {
+			if err := srv.Serve(l); err != nil {
+				errs <- fmt.Errorf("could not run HTTP front-end on %v %v: %w", cfg.Type, cfg.Address, err)
+			}
+		}(cfg)
 	}
 
-	return nil
+	return <-errs
 }
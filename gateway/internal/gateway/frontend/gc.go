@@ -14,22 +14,22 @@ func MakeGCHandler(services be.ActionController) httprouter.Handle {
 	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
 		ctx := h.Context()
 
+		version := requestAPIVersion(h)
+
 		var options be.GCOptions
 		if err := json.NewDecoder(h.Body).Decode(&options); err != nil {
-			httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+			replyError(ctx, w, version, invalidRequest("invalid request body"))
 			return
 		}
 
-		msg := map[string]interface{}{"status": "ok"}
-		if output, err := services.RunGC(ctx, options); err != nil {
-			msg["status"] = "error"
-			msg["reason"] = err.Error()
-		} else {
-			msg["output"] = output
+		output, err := services.RunGC(ctx, options)
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
 		}
 
 		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
 
-		replyJSON(ctx, w, msg)
+		replyJSON(ctx, w, message{"status": "ok", "output": output})
 	}
 }
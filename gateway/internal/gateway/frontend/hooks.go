@@ -0,0 +1,28 @@
+package frontend
+
+import (
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeHookRunsHandler creates an HTTP handler listing the commit hook runs of
+// a repository
+func MakeHookRunsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		runs, err := services.GetHookRuns(ctx, ps.ByName("name"))
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok", "data": runs})
+	}
+}
@@ -0,0 +1,149 @@
+package frontend
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeKeysHandler creates an HTTP handler for the "/keys" endpoints, used to
+// list and create keys
+func MakeKeysHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		switch h.Method {
+		case "GET":
+			if keyID := ps.ByName("id"); keyID != "" {
+				key, err := services.GetKeyInfo(ctx, keyID)
+				if err != nil {
+					replyError(ctx, w, version, ToAPIError(err))
+					return
+				}
+				replyJSON(ctx, w, message{"status": "ok", "data": key})
+			} else {
+				keys, err := services.GetKeys(ctx)
+				if err != nil {
+					replyError(ctx, w, version, ToAPIError(err))
+					return
+				}
+				replyJSON(ctx, w, message{"status": "ok", "data": keys})
+			}
+		case "POST":
+			var options be.NewKeyOptions
+			if err := json.NewDecoder(h.Body).Decode(&options); err != nil {
+				replyError(ctx, w, version, invalidRequest("invalid request body"))
+				return
+			}
+			keyID, secret, err := services.NewKey(ctx, options)
+			if err != nil {
+				replyError(ctx, w, version, ToAPIError(err))
+				return
+			}
+			replyJSON(ctx, w, message{"status": "ok", "id": keyID, "secret": secret})
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+	}
+}
+
+// MakeKeyActionsHandler creates an HTTP handler for enabling, disabling and
+// revoking an existing key
+func MakeKeyActionsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+		keyID := ps.ByName("id")
+
+		if h.Method == "DELETE" {
+			if err := services.RevokeKey(ctx, keyID); err != nil {
+				replyError(ctx, w, version, ToAPIError(err))
+				return
+			}
+			gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+			replyJSON(ctx, w, message{"status": "ok"})
+			return
+		}
+
+		var reqMsg struct {
+			Enable bool `json:"enable"`
+		}
+		if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
+			replyError(ctx, w, version, invalidRequest("invalid request body"))
+			return
+		}
+		if err := services.SetKeyEnabled(ctx, keyID, reqMsg.Enable); err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok"})
+	}
+}
+
+// MakeKeyRotateHandler creates an HTTP handler for replacing the secret of a
+// key. The new secret is returned in the reply.
+func MakeKeyRotateHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+		keyID := ps.ByName("id")
+
+		var reqMsg struct {
+			OverlapSeconds int64 `json:"overlap_seconds"`
+		}
+		if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil || reqMsg.OverlapSeconds < 0 {
+			replyError(ctx, w, version, invalidRequest("invalid request body"))
+			return
+		}
+
+		secret, err := services.RotateKey(ctx, keyID, time.Duration(reqMsg.OverlapSeconds)*time.Second)
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok", "id": keyID, "secret": secret})
+	}
+}
+
+// MakeKeyBindingsHandler creates an HTTP handler for adding and removing the
+// repository subpaths where a key is valid
+func MakeKeyBindingsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+		keyID := ps.ByName("id")
+
+		switch h.Method {
+		case "POST":
+			var binding be.KeyBindingDTO
+			if err := json.NewDecoder(h.Body).Decode(&binding); err != nil || binding.Repository == "" {
+				replyError(ctx, w, version, invalidRequest("invalid request body"))
+				return
+			}
+			if err := services.BindKey(ctx, keyID, binding.Repository, binding.Path); err != nil {
+				replyError(ctx, w, version, ToAPIError(err))
+				return
+			}
+		case "DELETE":
+			if err := services.UnbindKey(ctx, keyID, ps.ByName("repo")); err != nil {
+				replyError(ctx, w, version, ToAPIError(err))
+				return
+			}
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok"})
+	}
+}
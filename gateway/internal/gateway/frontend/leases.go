@@ -6,12 +6,17 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
 	"github.com/julienschmidt/httprouter"
 )
 
+// defaultReplicationTimeout bounds the wait for the stratum 1 replicas after a
+// commit, when the request does not specify it
+const defaultReplicationTimeout = 5 * time.Minute
+
 // MakeLeasesHandler creates an HTTP handler for the API root
 func MakeLeasesHandler(services be.ActionController) httprouter.Handle {
 	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
@@ -45,7 +50,9 @@ func handleGetLeases(services be.ActionController, token string, w http.Response
 	if token == "" {
 		leases, err := services.GetLeases(ctx)
 		if err != nil {
-			httpWrapError(ctx, err, err.Error(), w, http.StatusInternalServerError)
+			apiErr := ToAPIError(err)
+			apiErr.LegacyStatus = http.StatusInternalServerError
+			replyError(ctx, w, requestAPIVersion(h), apiErr)
 			return
 		}
 		msg["status"] = "ok"
@@ -53,7 +60,9 @@ func handleGetLeases(services be.ActionController, token string, w http.Response
 	} else {
 		lease, err := services.GetLease(ctx, token)
 		if err != nil {
-			httpWrapError(ctx, err, err.Error(), w, http.StatusInternalServerError)
+			apiErr := ToAPIError(err)
+			apiErr.LegacyStatus = http.StatusInternalServerError
+			replyError(ctx, w, requestAPIVersion(h), apiErr)
 			return
 		}
 		msg["data"] = lease
@@ -69,15 +78,19 @@ func handleNewLease(services be.ActionController, w http.ResponseWriter, h *http
 		Path     string `json:"path"`
 		Version  string `json:"api_version"` // cvmfs_swissknife sends this field as a string
 		Hostname string `json:"hostname"` // May be empty for cvmfs < 2.11
+
+		// Paths requests a single lease on several disjoint subpaths of a
+		// repository, instead of Path
+		Paths []string `json:"paths"`
 	}
 	if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
-		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+		replyError(ctx, w, requestAPIVersion(h), invalidRequest("invalid request body"))
 		return
 	}
 
 	clientVersion, err := strconv.Atoi(reqMsg.Version)
 	if err != nil {
-		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+		replyError(ctx, w, requestAPIVersion(h), invalidRequest("invalid request body"))
 		return
 	}
 
@@ -86,33 +99,34 @@ func handleNewLease(services be.ActionController, w http.ResponseWriter, h *http
 		hostname = reqMsg.Hostname;
 	}
 
-	msg := make(map[string]interface{})
+	// Clients announce their protocol version in the request body
 	if clientVersion < MinAPIProtocolVersion {
-		msg["status"] = "error"
-		msg["reason"] = fmt.Sprintf(
+		replyError(ctx, w, clientVersion, NewAPIError(ErrIncompatibleVersion, fmt.Sprintf(
 			"incompatible request version: %v, min version: %v",
 			clientVersion,
-			MinAPIProtocolVersion)
+			MinAPIProtocolVersion)))
+		return
+	}
+
+	// The authorization is expected to have the correct format, since it has already been checked.
+	keyID := strings.Split(h.Header.Get("Authorization"), " ")[0]
+	protocolVersion := MaxAPIVersion(clientVersion)
+	var token string
+	if len(reqMsg.Paths) > 0 {
+		token, err = services.NewMultiPathLease(ctx, keyID, reqMsg.Paths, hostname, protocolVersion)
 	} else {
-		// The authorization is expected to have the correct format, since it has already been checked.
-		keyID := strings.Split(h.Header.Get("Authorization"), " ")[0]
-		protocolVersion := MaxAPIVersion(clientVersion)
-		token, err := services.NewLease(ctx, keyID, reqMsg.Path, hostname, protocolVersion)
-		if err != nil {
-			if busyError, ok := err.(be.PathBusyError); ok {
-				msg["status"] = "path_busy"
-				msg["time_remaining"] = busyError.Remaining().String()
-			} else {
-				msg["status"] = "error"
-				msg["reason"] = err.Error()
-			}
-		} else {
-			msg["status"] = "ok"
-			msg["session_token"] = token
-			msg["max_api_version"] = protocolVersion
-		}
+		token, err = services.NewLease(ctx, keyID, reqMsg.Path, hostname, protocolVersion)
+	}
+	if err != nil {
+		replyError(ctx, w, clientVersion, ToAPIError(err))
+		return
 	}
 
+	msg := make(map[string]interface{})
+	msg["status"] = "ok"
+	msg["session_token"] = token
+	msg["max_api_version"] = protocolVersion
+
 	replyJSON(ctx, w, msg)
 }
 
@@ -123,41 +137,76 @@ func handleCommitLease(services be.ActionController, token string, w http.Respon
 		OldRootHash string `json:"old_root_hash"`
 		NewRootHash string `json:"new_root_hash"`
 		gw.RepositoryTag
+		// WaitReplicas is the number of stratum 1 replicas which must have
+		// the final revision before replying
+		WaitReplicas int `json:"wait_replicas"`
+		// WaitTimeout bounds the wait for the replicas, in seconds
+		WaitTimeout int `json:"wait_timeout"`
 	}
 	if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
-		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+		replyError(ctx, w, requestAPIVersion(h), invalidRequest("invalid request body"))
+		return
+	}
+
+	// The repository of the lease is needed to wait for the replicas, and
+	// the number of replicas is checked before committing
+	var repository string
+	if reqMsg.WaitReplicas > 0 {
+		lease, err := services.GetLease(ctx, token)
+		if err != nil {
+			replyError(ctx, w, requestAPIVersion(h), ToAPIError(err))
+			return
+		}
+		repository = strings.SplitN(lease.LeasePath, "/", 2)[0]
+		replication := services.GetReplication(ctx, repository)
+		if replication == nil || len(replication.Replicas) < reqMsg.WaitReplicas {
+			replyError(ctx, w, requestAPIVersion(h), ToAPIError(fmt.Errorf(
+				"%w: %v replicas requested", be.ErrNotEnoughReplicas, reqMsg.WaitReplicas)))
+			return
+		}
+	}
+
+	finalRev, err := services.CommitLease(
+		ctx, token, reqMsg.OldRootHash, reqMsg.NewRootHash, reqMsg.RepositoryTag)
+	if err != nil {
+		replyError(ctx, w, requestAPIVersion(h), ToAPIError(err))
 		return
 	}
 
 	msg := make(map[string]interface{})
-	if finalRev, err := services.CommitLease(
-		ctx, token, reqMsg.OldRootHash, reqMsg.NewRootHash, reqMsg.RepositoryTag); err != nil {
-		msg["status"] = "error"
-		msg["reason"] = err.Error()
-	} else {
-		msg["status"] = "ok"
-		msg["final_revision"] = finalRev
+	msg["status"] = "ok"
+	msg["final_revision"] = finalRev
+
+	// The commit succeeded even if the revision is not replicated in time
+	if reqMsg.WaitReplicas > 0 {
+		timeout := defaultReplicationTimeout
+		if reqMsg.WaitTimeout > 0 {
+			timeout = time.Duration(reqMsg.WaitTimeout) * time.Second
+		}
+		replicated, err := services.WaitForReplication(ctx, repository, finalRev, reqMsg.WaitReplicas, timeout)
+		if err != nil {
+			replyError(ctx, w, requestAPIVersion(h), ToAPIError(err))
+			return
+		}
+		msg["replicated"] = replicated
+		msg["replication_complete"] = replicated >= reqMsg.WaitReplicas
 	}
 
 	replyJSON(ctx, w, msg)
 }
 
 func handleCancelLease(services be.ActionController, token string, w http.ResponseWriter, h *http.Request) {
+	ctx := h.Context()
+
 	if token == "" {
-		http.Error(w, "missing token", http.StatusBadRequest)
+		replyError(ctx, w, requestAPIVersion(h), invalidRequest("missing token"))
 		return
 	}
 
-	ctx := h.Context()
-
-	msg := make(map[string]interface{})
-
 	if err := services.CancelLease(ctx, token); err != nil {
-		msg["status"] = "error"
-		msg["reason"] = err.Error()
-	} else {
-		msg["status"] = "ok"
+		replyError(ctx, w, requestAPIVersion(h), ToAPIError(err))
+		return
 	}
 
-	replyJSON(ctx, w, msg)
+	replyJSON(ctx, w, message{"status": "ok"})
 }
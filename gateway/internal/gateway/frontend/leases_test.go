@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 
@@ -149,3 +150,45 @@ func TestLeaseHandlerCommitLease(t *testing.T) {
 		t.Errorf("Invalid response body: %v", string(respBody))
 	}
 }
+
+func TestLeaseHandlerCommitLeaseWaitReplicas(t *testing.T) {
+	backend := mockBackend{}
+	token := "lease_token"
+
+	commit := func(replicas int) *http.Response {
+		msg, _ := json.Marshal(map[string]interface{}{
+			"old_root_hash": "abcdef",
+			"new_root_hash": "defabc",
+			"wait_replicas": replicas,
+			"wait_timeout":  10,
+		})
+		req := httptest.NewRequest("POST", "/api/v1/leases/"+token, bytes.NewReader(msg))
+		HMAC := ComputeHMAC([]byte(token), backend.GetKey(context.TODO(), "keyid2").Secret)
+		req.Header["Authorization"] = []string{"keyid2 " + base64.StdEncoding.EncodeToString(HMAC)}
+		w := httptest.NewRecorder()
+		ps := httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+		MakeLeasesHandler(&backend)(w, req, ps)
+		return w.Result()
+	}
+
+	resp := commit(1)
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+	expected, _ := json.Marshal(map[string]interface{}{
+		"status":               "ok",
+		"final_revision":       1,
+		"replicated":           1,
+		"replication_complete": true,
+	})
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Equal(respBody, expected) {
+		t.Errorf("Invalid response body: %v", string(respBody))
+	}
+
+	// Only one replica is tracked by the mock backend
+	resp = commit(2)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+}
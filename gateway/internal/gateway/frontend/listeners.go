@@ -0,0 +1,152 @@
+package frontend
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/julienschmidt/httprouter"
+)
+
+// defaultSocketMode are the permissions of the Unix sockets without a
+// configured mode: the socket is restricted to the user and group of the
+// gateway
+const defaultSocketMode = 0660
+
+// systemdFirstFD is the first file descriptor passed by systemd socket
+// activation (SD_LISTEN_FDS_START)
+const systemdFirstFD = 3
+
+var (
+	systemdOnce      sync.Once
+	systemdSockets   map[string][]net.Listener
+	systemdSocketErr error
+)
+
+// Listen opens the socket of a listener
+func Listen(cfg gw.ListenerConfig) (net.Listener, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case gw.ListenTCP, "":
+		l, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("could not listen on %v: %w", cfg.Address, err)
+		}
+		return l, nil
+	case gw.ListenUnix:
+		return listenUnix(cfg)
+	case gw.ListenSystemd:
+		systemdOnce.Do(func() {
+			systemdSockets, systemdSocketErr = systemdListeners(os.Getenv, systemdFirstFD)
+		})
+		if systemdSocketErr != nil {
+			return nil, systemdSocketErr
+		}
+		sockets := systemdSockets[cfg.Address]
+		if len(sockets) == 0 {
+			return nil, fmt.Errorf("no socket named %v passed by systemd", cfg.Address)
+		}
+		// Several sockets with the same name are consumed in order
+		systemdSockets[cfg.Address] = sockets[1:]
+		return sockets[0], nil
+	default:
+		return nil, fmt.Errorf("invalid listener type: %v", cfg.Type)
+	}
+}
+
+// listenUnix creates a Unix domain socket with the configured permissions,
+// replacing the socket left over by a previous run
+func listenUnix(cfg gw.ListenerConfig) (net.Listener, error) {
+	mode := int64(defaultSocketMode)
+	if cfg.Mode != "" {
+		m, err := strconv.ParseInt(cfg.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode of socket %v: %v", cfg.Address, cfg.Mode)
+		}
+		mode = m
+	}
+	gid := -1
+	if cfg.Group != "" {
+		g, err := user.LookupGroup(cfg.Group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group of socket %v: %w", cfg.Address, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	if st, err := os.Stat(cfg.Address); err == nil && st.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(cfg.Address); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket %v: %w", cfg.Address, err)
+		}
+	}
+	l, err := net.Listen("unix", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %v: %w", cfg.Address, err)
+	}
+	if err := os.Chmod(cfg.Address, os.FileMode(mode)); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("could not set the mode of socket %v: %w", cfg.Address, err)
+	}
+	if gid != -1 {
+		if err := os.Chown(cfg.Address, -1, gid); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("could not set the group of socket %v: %w", cfg.Address, err)
+		}
+	}
+	return l, nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// indexed by their FileDescriptorName=. The environment variables are only
+// taken into account if they are meant for this process.
+func systemdListeners(getenv func(string) string, firstFD int) (map[string][]net.Listener, error) {
+	sockets := make(map[string][]net.Listener)
+	if pid, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return sockets, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %w", err)
+	}
+	var names []string
+	if fdNames := getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+	for i := 0; i < n; i++ {
+		// Sockets without a name are named after the socket unit by systemd
+		name := "unknown"
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket %v passed by systemd: %w", name, err)
+		}
+		sockets[name] = append(sockets[name], l)
+	}
+	return sockets, nil
+}
+
+// registerDebug serves the pprof handlers
+func registerDebug(router *httprouter.Router) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.Handler("GET", "/debug/pprof/*profile", mux)
+	router.Handler("POST", "/debug/pprof/*profile", mux)
+}
@@ -0,0 +1,136 @@
+package frontend
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+func TestServerEndpoints(t *testing.T) {
+	cases := []struct {
+		endpoints []string
+		path      string
+		served    bool
+	}{
+		{[]string{gw.EndpointAPI}, APIRoot + "/repos", true},
+		{[]string{gw.EndpointAPI}, APIRoot + "/keys", false},
+		{[]string{gw.EndpointAPI}, DashboardRoot, true},
+		{[]string{gw.EndpointAPI}, "/debug/pprof/", false},
+		{[]string{gw.EndpointAdmin}, APIRoot + "/repos", false},
+		{[]string{gw.EndpointAdmin}, APIRoot + "/keys", true},
+		{[]string{gw.EndpointAdmin}, DashboardRoot, false},
+		{[]string{gw.EndpointDebug}, "/debug/pprof/", true},
+		{[]string{gw.EndpointDebug}, APIRoot + "/repos", false},
+		{[]string{gw.EndpointAPI, gw.EndpointAdmin}, APIRoot + "/keys", true},
+	}
+	for _, c := range cases {
+		srv := NewServer(&mockBackend{}, c.endpoints, 10*time.Second)
+		req := httptest.NewRequest("GET", c.path, nil)
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, req)
+		// Routes of other endpoints with the same path give "405 Method Not Allowed"
+		served := w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed
+		if served != c.served {
+			t.Errorf("%v on %v: unexpected status %v", c.path, c.endpoints, w.Code)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := gw.ListenerConfig{
+		Type:      gw.ListenUnix,
+		Address:   filepath.Join(tmp, "admin.sock"),
+		Mode:      "0600",
+		Endpoints: []string{gw.EndpointAdmin},
+	}
+
+	// A stale socket is replaced
+	stale, err := net.Listen("unix", cfg.Address)
+	if err != nil {
+		t.Fatalf("could not create socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := Listen(cfg)
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+	st, err := os.Stat(cfg.Address)
+	if err != nil {
+		t.Fatalf("could not stat socket: %v", err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("unexpected socket mode: %v", st.Mode())
+	}
+
+	srv := NewServer(&mockBackend{}, cfg.Endpoints, 10*time.Second)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", cfg.Address)
+		},
+	}}
+	resp, err := c.Get("http://localhost" + APIRoot + "/keys")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		t.Errorf("admin route not served on the socket")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("could not get socket file: %v", err)
+	}
+	// The descriptor is closed by systemdListeners
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatalf("could not duplicate socket: %v", err)
+	}
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "publisher",
+	}
+	sockets, err := systemdListeners(func(k string) string { return env[k] }, fd)
+	if err != nil {
+		t.Fatalf("could not get systemd sockets: %v", err)
+	}
+	if len(sockets["publisher"]) != 1 {
+		t.Fatalf("unexpected sockets: %v", sockets)
+	}
+	defer sockets["publisher"][0].Close()
+	if addr := sockets["publisher"][0].Addr().String(); addr != l.Addr().String() {
+		t.Errorf("unexpected socket address: %v", addr)
+	}
+
+	// Sockets meant for another process are ignored
+	env["LISTEN_PID"] = "1"
+	if sockets, err := systemdListeners(func(k string) string { return env[k] }, 0); err != nil || len(sockets) != 0 {
+		t.Errorf("unexpected sockets: %v %v", sockets, err)
+	}
+}
@@ -1,9 +1,7 @@
 package frontend
 
 import (
-	"bytes"
 	"encoding/json"
-	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -33,32 +31,31 @@ func handlePublish(
 	services be.ActionController, w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
 	ctx := h.Context()
 
+	version := requestAPIVersion(h)
+
+	// The manifest is encoded in base64
 	var req struct {
 		Version    int             `json:"version"`
 		Timestamp  json.RawMessage `json:"timestamp"`
 		Type       string          `json:"type"`
 		Repository string          `json:"repository"`
-		Manifest   string          `json:"manifest"`
+		Manifest   []byte          `json:"manifest"`
 	}
 
-	var body bytes.Buffer
-	if _, err := io.Copy(&body, h.Body); err != nil {
-		httpWrapError(ctx, err, "could not read request body", w, http.StatusInternalServerError)
+	if err := json.NewDecoder(h.Body).Decode(&req); err != nil {
+		replyError(ctx, w, version, invalidRequest("invalid request body"))
 		return
 	}
 
-	if err := json.Unmarshal(body.Bytes(), &req); err != nil {
-		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+	keyID := strings.Split(h.Header.Get("Authorization"), " ")[0]
+	if err := services.PublishManifest(ctx, keyID, req.Repository, req.Manifest); err != nil {
+		replyError(ctx, w, version, ToAPIError(err))
 		return
 	}
 
-	rep := map[string]interface{}{"status": "ok"}
-
-	services.PublishManifest(ctx, req.Repository, be.NotificationMessage(body.String()))
-
 	gw.LogC(ctx, "http", gw.LogInfo).Msg("request_processed")
 
-	replyJSON(ctx, w, rep)
+	replyJSON(ctx, w, message{"status": "ok"})
 }
 
 func handleSubscribe(
@@ -71,7 +68,7 @@ func handleSubscribe(
 	}
 
 	if err := json.NewDecoder(h.Body).Decode(&req); err != nil {
-		httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+		replyError(ctx, w, requestAPIVersion(h), invalidRequest("invalid request body"))
 		return
 	}
 
@@ -88,18 +85,31 @@ func handleSubscribe(
 		msg := "response writer does not support flushing"
 		gw.LogC(ctx, "http", gw.LogError).Msg(msg)
 		http.Error(w, msg, http.StatusInternalServerError)
+		return
 	}
+	// Send the headers right away, so clients know the subscription is active
+	flusher.Flush()
 	for {
 		timeout := time.NewTimer(notificationTimeout)
 		select {
 		case event, ok := <-eventSource:
-			if ok {
-				w.Write([]byte("data: " + event + "\n\n"))
-				flusher.Flush()
-			} else {
-				break
+			timeout.Stop()
+			if !ok {
+				return
 			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				gw.LogC(ctx, "http", gw.LogError).
+					Err(err).
+					Msg("could not encode notification")
+				continue
+			}
+			w.Write([]byte("data: " + string(data) + "\n\n"))
+			flusher.Flush()
+		case <-ctx.Done():
+			// The client has disconnected
 			timeout.Stop()
+			return
 		case <-timeout.C:
 			gw.LogC(ctx, "http", gw.LogInfo).Msg("notification timeout")
 			replyJSON(ctx, w, map[string]interface{}{"status": "timeout"})
@@ -0,0 +1,158 @@
+package frontend
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/julienschmidt/httprouter"
+)
+
+// authLevel is the authorization required to access a route
+type authLevel int
+
+// The different authorization levels
+const (
+	authNone authLevel = iota
+	authKey
+	authAdmin
+)
+
+// route describes an endpoint of the HTTP API. The description is used both
+// to register the handler and to generate the OpenAPI document of the API
+type route struct {
+	Method  string
+	Path    string // httprouter path, relative to APIRoot
+	Summary string
+	Auth    authLevel
+	Errors  []ErrorCode
+	Handler httprouter.Handle
+}
+
+// MakeOpenAPIHandler creates an HTTP handler serving the OpenAPI description
+// of the given routes
+func MakeOpenAPIHandler(routes *[]route) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, _ httprouter.Params) {
+		ctx := h.Context()
+		replyJSON(ctx, w, OpenAPIDocument(*routes))
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+	}
+}
+
+// OpenAPIDocument generates an OpenAPI 3 document from route descriptions
+func OpenAPIDocument(routes []route) message {
+	paths := make(map[string]message)
+	for _, r := range routes {
+		p, params := openAPIPath(r.Path)
+		if _, present := paths[p]; !present {
+			paths[p] = make(message)
+		}
+		paths[p][strings.ToLower(r.Method)] = openAPIOperation(r, params)
+	}
+
+	return message{
+		"openapi": "3.0.3",
+		"info": message{
+			"title":   "CernVM-FS Repository Gateway API",
+			"version": strconv.Itoa(APIProtocolVersion),
+		},
+		"servers": []message{{"url": APIRoot}},
+		"paths":   paths,
+		"components": message{
+			"securitySchemes": message{
+				"hmac": message{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "Authorization",
+					"description": "\"<KEY_ID> <BASE64(HMAC-SHA1)>\", computed with the key secret over " +
+						"the request body (new leases, admin POST requests), the lease token (commit, " +
+						"cancel and payload requests), the message at the start of the body (legacy " +
+						"payload requests) or the URL path (admin DELETE requests)",
+				},
+			},
+			"schemas": message{
+				"Error": message{
+					"type": "object",
+					"properties": message{
+						"status": message{"type": "string", "enum": []string{"error"}},
+						"reason": message{"type": "string"},
+						"code":   message{"type": "string", "enum": allErrorCodes()},
+					},
+				},
+			},
+		},
+	}
+}
+
+func openAPIOperation(r route, params []string) message {
+	parameters := []message{{
+		"name":        APIVersionHeader,
+		"in":          "header",
+		"required":    false,
+		"description": "API protocol version understood by the client",
+		"schema":      message{"type": "integer"},
+	}}
+	for _, p := range params {
+		parameters = append(parameters, message{
+			"name":     p,
+			"in":       "path",
+			"required": true,
+			"schema":   message{"type": "string"},
+		})
+	}
+
+	responses := message{"200": message{"description": "success"}}
+	codesByStatus := make(map[int][]string)
+	for _, code := range r.Errors {
+		status := NewAPIError(code, "").Status()
+		codesByStatus[status] = append(codesByStatus[status], string(code))
+	}
+	for status, codes := range codesByStatus {
+		responses[strconv.Itoa(status)] = message{
+			"description": strings.Join(codes, ", "),
+			"content": message{
+				"application/json": message{
+					"schema": message{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		}
+	}
+
+	op := message{
+		"summary":    r.Summary,
+		"parameters": parameters,
+		"responses":  responses,
+	}
+	if r.Auth != authNone {
+		op["security"] = []message{{"hmac": []string{}}}
+	}
+	if r.Auth == authAdmin {
+		op["description"] = "Requires an administration key"
+	}
+	return op
+}
+
+// openAPIPath converts an httprouter path to an OpenAPI path template, and
+// returns the names of the path parameters
+func openAPIPath(routerPath string) (string, []string) {
+	tokens := strings.Split(routerPath, "/")
+	params := make([]string, 0)
+	for i, t := range tokens {
+		if strings.HasPrefix(t, ":") || strings.HasPrefix(t, "*") {
+			params = append(params, t[1:])
+			tokens[i] = "{" + t[1:] + "}"
+		}
+	}
+	return strings.Join(tokens, "/"), params
+}
+
+func allErrorCodes() []string {
+	codes := make([]string, 0, len(errorStatus))
+	for code := range errorStatus {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	return codes
+}
@@ -18,11 +18,27 @@ func MakePayloadsHandler(services be.ActionController) httprouter.Handle {
 
 		ctx := h.Context()
 
+		version := requestAPIVersion(h)
+
 		msgSize, err := strconv.Atoi(h.Header.Get("message-size"))
 		if err != nil {
-			httpWrapError(ctx, err, "missing message-size header", w, http.StatusBadRequest)
+			replyError(ctx, w, version, invalidRequest("missing message-size header"))
+			return
+		}
+
+		// The request message and the payload are compressed together. Legacy
+		// requests can not be compressed: the HMAC is computed over the
+		// uncompressed message.
+		if enc := h.Header.Get("Content-Encoding"); token == "" && enc != "" && enc != be.EncodingIdentity {
+			replyError(ctx, w, version, invalidRequest("compressed payloads require the token in the URL"))
+			return
+		}
+		body, err := be.DecodePayload(h.Body, h.Header.Get("Content-Encoding"))
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
 			return
 		}
+		defer body.Close()
 
 		var req struct {
 			TokenStr   string `json:"session_token"`
@@ -31,14 +47,14 @@ func MakePayloadsHandler(services be.ActionController) httprouter.Handle {
 			Version    string `json:"api_version"` // cvmfs_swissknife sends this field as a string
 		}
 
-		msgRdr := io.LimitReader(h.Body, int64(msgSize))
+		msgRdr := io.LimitReader(body, int64(msgSize))
 		if err := json.NewDecoder(msgRdr).Decode(&req); err != nil {
-			httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+			replyError(ctx, w, version, invalidRequest("invalid request body"))
 			return
 		}
 		headerSize, err := strconv.Atoi(req.HeaderSize)
 		if err != nil {
-			httpWrapError(ctx, err, "invalid header_size", w, http.StatusBadRequest)
+			replyError(ctx, w, version, invalidRequest("invalid header_size"))
 			return
 		}
 
@@ -47,16 +63,32 @@ func MakePayloadsHandler(services be.ActionController) httprouter.Handle {
 			token = req.TokenStr
 		}
 
-		msg := make(map[string]interface{})
-		if err := services.SubmitPayload(ctx, token, h.Body, req.Digest, headerSize); err != nil {
-			msg["status"] = "error"
-			msg["reason"] = err.Error()
-		} else {
-			msg["status"] = "ok"
+		if err := services.SubmitPayload(ctx, token, body, req.Digest, headerSize); err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
 		}
 
 		gw.LogC(ctx, "http", gw.LogInfo).Msg("request_processed")
 
-		replyJSON(ctx, w, msg)
+		replyJSON(ctx, w, message{"status": "ok"})
+	}
+}
+
+// MakeLeasePayloadsHandler creates an HTTP handler listing the payloads
+// submitted for a lease
+func MakeLeasePayloadsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		token := ps.ByName("token")
+
+		payloads, err := services.GetLeasePayloads(ctx, token)
+		if err != nil {
+			replyError(ctx, w, requestAPIVersion(h), ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok", "data": payloads})
 	}
 }
@@ -90,3 +90,36 @@ func TestPayloadHandlerNew(t *testing.T) {
 	}
 
 }
+
+func TestPayloadHandlerLeasePayloads(t *testing.T) {
+	backend := mockBackend{}
+	token := "lease_token"
+
+	req := httptest.NewRequest("GET", "/api/v1/leases/"+token+"/payloads", nil)
+
+	w := httptest.NewRecorder()
+	handler := MakeLeasePayloadsHandler(&backend)
+
+	ps := httprouter.Params{httprouter.Param{Key: "token", Value: token}}
+	handler(w, req, ps)
+
+	resp := w.Result()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Invalid HTTP response status code: %v", resp.StatusCode)
+	}
+
+	var reply struct {
+		Status string `json:"status"`
+		Data   []struct {
+			Digest string `json:"digest"`
+			Size   int64  `json:"size"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		t.Fatalf("Invalid response body: %v", err)
+	}
+	if reply.Status != "ok" || len(reply.Data) != 1 || reply.Data[0].Digest != "abcdef" {
+		t.Errorf("Invalid response body: %+v", reply)
+	}
+}
@@ -0,0 +1,28 @@
+package frontend
+
+import (
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeRelaysHandler creates an HTTP handler returning the state of the
+// subscriptions to the upstream gateways relayed by the gateway
+func MakeRelaysHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		relays, err := services.GetRelayStatus(ctx)
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok", "data": relays})
+	}
+}
@@ -13,25 +13,26 @@ import (
 func MakeReposHandler(services be.ActionController) httprouter.Handle {
 	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
 		ctx := h.Context()
+		version := requestAPIVersion(h)
 		msg := make(map[string]interface{})
 
 		if repoName := ps.ByName("name"); repoName != "" {
 			rc, err := services.GetRepo(ctx, repoName)
 			if err != nil {
-				msg["status"] = "error"
-				msg["reason"] = err.Error()
-			} else if rc == nil {
-				msg["status"] = "error"
-				msg["reason"] = "invalid_repo"
-			} else {
-				msg["status"] = "ok"
-				msg["data"] = rc
+				replyError(ctx, w, version, ToAPIError(err))
+				return
 			}
+			if rc == nil {
+				replyError(ctx, w, version, NewAPIError(ErrNotFound, "invalid_repo"))
+				return
+			}
+			msg["status"] = "ok"
+			msg["data"] = rc
 		} else {
 			repos, err := services.GetRepos(ctx)
 			if err != nil {
-				msg["status"] = "error"
-				msg["reason"] = err.Error()
+				replyError(ctx, w, version, ToAPIError(err))
+				return
 			}
 			msg["status"] = "ok"
 			msg["data"] = repos
@@ -53,27 +54,53 @@ func MakeAdminReposHandler(services be.ActionController) httprouter.Handle {
 			Wait   bool `json:"wait"`
 		}
 
+		version := requestAPIVersion(h)
+
 		if err := json.NewDecoder(h.Body).Decode(&reqMsg); err != nil {
-			httpWrapError(ctx, err, "invalid request body", w, http.StatusBadRequest)
+			replyError(ctx, w, version, invalidRequest("invalid request body"))
 			return
 		}
 
 		repoName := ps.ByName("name")
 
-		msg := make(map[string]interface{})
 		if err := services.SetRepoEnabled(ctx, repoName, reqMsg.Enable); err != nil {
-			if _, ok := err.(be.RepoBusyError); ok {
-				msg["status"] = "repo_busy"
-			} else {
-				msg["status"] = "error"
-				msg["reason"] = err.Error()
-			}
+			replyError(ctx, w, version, ToAPIError(err))
+			return
 		}
 
-		msg["status"] = "ok"
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok"})
+	}
+}
+
+// MakeRepoRegistrationHandler creates an HTTP handler for registering and
+// deregistering repositories
+func MakeRepoRegistrationHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		switch h.Method {
+		case "POST":
+			var options be.RepoRegistrationOptions
+			if err := json.NewDecoder(h.Body).Decode(&options); err != nil || options.Name == "" {
+				replyError(ctx, w, version, invalidRequest("invalid request body"))
+				return
+			}
+			if err := services.RegisterRepo(ctx, options); err != nil {
+				replyError(ctx, w, version, ToAPIError(err))
+				return
+			}
+		case "DELETE":
+			if err := services.DeregisterRepo(ctx, ps.ByName("name")); err != nil {
+				replyError(ctx, w, version, ToAPIError(err))
+				return
+			}
+		}
 
 		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
 
-		replyJSON(ctx, w, msg)
+		replyJSON(ctx, w, message{"status": "ok"})
 	}
 }
@@ -0,0 +1,73 @@
+package frontend
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// statisticsCSVHeader is the header line of the statistics in CSV format
+var statisticsCSVHeader = []string{
+	"day", "key_id", "commits",
+	"n_chunks_added", "n_chunks_duplicated", "n_catalogs_added",
+	"sz_uploaded_bytes", "sz_uploaded_catalog_bytes",
+	"commit_duration_ms", "max_commit_duration_ms",
+}
+
+// MakeRepoStatisticsHandler creates an HTTP handler returning the publication
+// statistics of a repository per key and day. The period and key are selected
+// with the "from", "to" and "key" query parameters. The statistics are
+// returned in CSV format with "format=csv" or when the client accepts
+// "text/csv".
+func MakeRepoStatisticsHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		params := h.URL.Query()
+		query := be.StatisticsQuery{
+			From:  params.Get("from"),
+			To:    params.Get("to"),
+			KeyID: params.Get("key"),
+		}
+
+		entries, err := services.GetRepoStatistics(ctx, ps.ByName("name"), query)
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogInfo).Msg("request processed")
+
+		if params.Get("format") == "csv" || strings.Contains(h.Header.Get("Accept"), "text/csv") {
+			replyStatisticsCSV(w, entries)
+			return
+		}
+
+		replyJSON(ctx, w, message{"status": "ok", "data": entries})
+	}
+}
+
+func replyStatisticsCSV(w http.ResponseWriter, entries []be.PublicationStatisticsDTO) {
+	w.Header().Set("Content-Type", "text/csv")
+	out := csv.NewWriter(w)
+	out.Write(statisticsCSVHeader)
+	for _, e := range entries {
+		out.Write([]string{
+			e.Day, e.KeyID, strconv.FormatInt(e.Commits, 10),
+			strconv.FormatInt(e.ChunksAdded, 10),
+			strconv.FormatInt(e.ChunksDuplicated, 10),
+			strconv.FormatInt(e.CatalogsAdded, 10),
+			strconv.FormatInt(e.UploadedBytes, 10),
+			strconv.FormatInt(e.UploadedCatalogBytes, 10),
+			strconv.FormatInt(e.CommitDurationMs, 10),
+			strconv.FormatInt(e.MaxCommitDurationMs, 10),
+		})
+	}
+	out.Flush()
+}
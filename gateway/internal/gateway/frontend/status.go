@@ -0,0 +1,28 @@
+package frontend
+
+import (
+	"net/http"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/julienschmidt/httprouter"
+)
+
+// MakeStatusHandler creates an HTTP handler returning an overview of the
+// repositories, active leases, receiver pool and recent activity
+func MakeStatusHandler(services be.ActionController) httprouter.Handle {
+	return func(w http.ResponseWriter, h *http.Request, ps httprouter.Params) {
+		ctx := h.Context()
+		version := requestAPIVersion(h)
+
+		status, err := services.GetStatus(ctx)
+		if err != nil {
+			replyError(ctx, w, version, ToAPIError(err))
+			return
+		}
+
+		gw.LogC(ctx, "http", gw.LogDebug).Msg("request processed")
+
+		replyJSON(ctx, w, message{"status": "ok", "data": status})
+	}
+}
@@ -6,12 +6,17 @@ import (
 	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 )
 
+// RequestIDHeader is the response header carrying the UUID of the request
+const RequestIDHeader = "X-Request-ID"
+
 // WithTag returns a middleware that tags requests with an UUID and the time the
-// request was received
+// request was received. The UUID is returned in the X-Request-ID header and
+// recorded in the span of the request.
 func WithTag(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 		reqID := uuid.New()
@@ -19,6 +24,8 @@ func WithTag(next httprouter.Handle) httprouter.Handle {
 		ctx := context.WithValue(
 			context.WithValue(req.Context(), gw.IDKey, reqID),
 			gw.T0Key, time.Now())
+		w.Header().Set(RequestIDHeader, reqID.String())
+		tracing.SpanFromContext(ctx).SetAttribute("request_id", reqID.String())
 		gw.LogC(ctx, "http", gw.LogInfo).
 			Str("method", req.Method).
 			Str("url", req.URL.String()).
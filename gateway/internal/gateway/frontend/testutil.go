@@ -10,6 +10,7 @@ import (
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -26,7 +27,11 @@ func (b *mockBackend) GetKey(ctx context.Context, keyID string) *be.KeyConfig {
 	if strings.HasPrefix(keyID, "admin") {
 		admin = true
 	}
-	return &be.KeyConfig{Secret: "big_secret", Admin: admin}
+	cfg := &be.KeyConfig{Secret: "big_secret", Admin: admin}
+	if strings.HasSuffix(keyID, "_rotated") {
+		cfg.PreviousSecret = "old_secret"
+	}
+	return cfg
 }
 
 func (b *mockBackend) GetRepo(ctx context.Context, repoName string) (*be.RepositoryConfig, error) {
@@ -52,6 +57,10 @@ func (b *mockBackend) NewLease(ctx context.Context, keyID, leasePath, hostname s
 	return "lease_token_string", nil
 }
 
+func (b *mockBackend) NewMultiPathLease(ctx context.Context, keyID string, leasePaths []string, hostname string, protocolVersion int) (string, error) {
+	return "lease_token_string", nil
+}
+
 func (b *mockBackend) GetLeases(ctx context.Context) (map[string]be.LeaseDTO, error) {
 	return map[string]be.LeaseDTO{
 		"test2.repo.org/some/path/one": {
@@ -89,11 +98,25 @@ func (b *mockBackend) SubmitPayload(ctx context.Context, token string, payload i
 	return nil
 }
 
+func (b *mockBackend) GetLeasePayloads(ctx context.Context, tokenStr string) ([]be.PayloadDTO, error) {
+	return []be.PayloadDTO{
+		{
+			Digest:     "abcdef",
+			HeaderSize: 123,
+			Size:       4567,
+			Received:   time.Now().String(),
+			DurationMs: 8,
+			Result:     be.PayloadAccepted,
+		},
+	}, nil
+}
+
 func (b *mockBackend) RunGC(ctx context.Context, options be.GCOptions) (string, error) {
 	return "", nil
 }
 
-func (b *mockBackend) PublishManifest(ctx context.Context, repository string, message be.NotificationMessage) {
+func (b *mockBackend) PublishManifest(ctx context.Context, keyID, repository string, manifest []byte) error {
+	return nil
 }
 
 func (b *mockBackend) SubscribeToNotifications(ctx context.Context, repository string) be.SubscriberHandle {
@@ -104,3 +127,101 @@ func (b *mockBackend) UnsubscribeFromNotifications(
 	ctx context.Context, repository string, handle be.SubscriberHandle) error {
 	return nil
 }
+
+func (b *mockBackend) NewKey(ctx context.Context, options be.NewKeyOptions) (string, string, error) {
+	return "key_0123456789abcdef", "new_secret", nil
+}
+
+func (b *mockBackend) GetKeys(ctx context.Context) ([]be.KeyDTO, error) {
+	return []be.KeyDTO{
+		{ID: "keyid1", Source: be.KeySourceConfig, Status: be.KeyActive},
+		{ID: "key_0123456789abcdef", Source: be.KeySourceAPI, Status: be.KeyActive},
+	}, nil
+}
+
+func (b *mockBackend) GetKeyInfo(ctx context.Context, keyID string) (*be.KeyDTO, error) {
+	if keyID != "keyid1" {
+		return nil, be.ErrKeyNotFound
+	}
+	return &be.KeyDTO{ID: "keyid1", Source: be.KeySourceConfig, Status: be.KeyActive}, nil
+}
+
+func (b *mockBackend) RotateKey(ctx context.Context, keyID string, overlap time.Duration) (string, error) {
+	return "rotated_secret", nil
+}
+
+func (b *mockBackend) SetKeyEnabled(ctx context.Context, keyID string, enable bool) error {
+	return nil
+}
+
+func (b *mockBackend) RevokeKey(ctx context.Context, keyID string) error {
+	return nil
+}
+
+func (b *mockBackend) BindKey(ctx context.Context, keyID, repository, path string) error {
+	return nil
+}
+
+func (b *mockBackend) UnbindKey(ctx context.Context, keyID, repository string) error {
+	return nil
+}
+
+func (b *mockBackend) RegisterRepo(ctx context.Context, options be.RepoRegistrationOptions) error {
+	return nil
+}
+
+func (b *mockBackend) DeregisterRepo(ctx context.Context, repoName string) error {
+	return nil
+}
+
+func (b *mockBackend) GetReceiverStatistics(ctx context.Context) receiver.PoolStatistics {
+	return receiver.PoolStatistics{Workers: 1, Tasks: 3, Failed: 1, TimedOut: 1}
+}
+
+func (b *mockBackend) GetStatus(ctx context.Context) (*be.StatusDTO, error) {
+	return &be.StatusDTO{
+		Repositories: []be.RepoStatusDTO{{Name: "test2.repo.org", Enabled: true, Subscribers: 1}},
+		Leases:       []be.LeaseStatusDTO{{LeasePath: "test2.repo.org/some/path", KeyID: "keyid1", TimeRemaining: 60}},
+		Publications: []be.PublicationDTO{},
+		GCRuns:       []be.GCRunDTO{},
+	}, nil
+}
+
+func (b *mockBackend) GetRepoStatistics(ctx context.Context, repository string, query be.StatisticsQuery) ([]be.PublicationStatisticsDTO, error) {
+	st := be.PublicationStatisticsDTO{Day: "2026-10-19", KeyID: "keyid1", Commits: 2, CommitDurationMs: 1500}
+	st.ChunksAdded = 10
+	st.UploadedBytes = 2048
+	return []be.PublicationStatisticsDTO{st}, nil
+}
+
+func (b *mockBackend) GetConfig(ctx context.Context) (*be.ConfigReport, error) {
+	return &be.ConfigReport{
+		Gateway:      map[string]interface{}{"port": 4929},
+		Repositories: map[string]be.RepositoryConfig{"test2.repo.org": {Keys: be.KeyPaths{"keyid1": "/"}, Enabled: true}},
+		Keys:         map[string]be.KeyConfig{"keyid1": {Secret: "xxxxx"}},
+		Problems:     []string{"unknown field max_leases in repository test2.repo.org"},
+	}, nil
+}
+
+func (b *mockBackend) GetRelayStatus(ctx context.Context) ([]be.RelayStatusDTO, error) {
+	return []be.RelayStatusDTO{
+		{Upstream: "http://upstream.example.org:4929/api/v1", Repository: "test2.repo.org", Connected: true, Received: 2},
+	}, nil
+}
+
+func (b *mockBackend) GetHookRuns(ctx context.Context, repository string) ([]be.HookRunDTO, error) {
+	return []be.HookRunDTO{
+		{Hook: "snapshot", Stage: "post_commit", Status: be.HookSuccess, Attempts: 1},
+	}, nil
+}
+
+func (b *mockBackend) GetReplication(ctx context.Context, repository string) *be.ReplicationDTO {
+	return &be.ReplicationDTO{
+		Revision: 1,
+		Replicas: []be.ReplicaStatusDTO{{URL: "http://stratum1.example.org/cvmfs", Revision: 1}},
+	}
+}
+
+func (b *mockBackend) WaitForReplication(ctx context.Context, repository string, revision uint64, replicas int, timeout time.Duration) (int, error) {
+	return 1, nil
+}
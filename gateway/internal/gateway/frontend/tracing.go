@@ -0,0 +1,76 @@
+package frontend
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
+	"github.com/julienschmidt/httprouter"
+)
+
+// statusRecorder records the status code of a reply
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush is needed by the notification streams
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// WithTrace returns a middleware that runs each request in a span named after
+// its route. The span continues the trace of the traceparent header of the
+// request, if any.
+func WithTrace(name string, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		ctx := req.Context()
+		if parent, ok := tracing.ParseTraceparent(req.Header.Get(tracing.TraceparentHeader)); ok {
+			ctx = tracing.ContextWithRemote(ctx, parent)
+		}
+		ctx, span := tracing.Start(ctx, name, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.method", req.Method)
+		span.SetAttribute("http.target", req.URL.Path)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, req.WithContext(ctx), ps)
+
+		span.SetAttribute("http.status_code", rec.status)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetError(fmt.Errorf("%v", http.StatusText(rec.status)))
+		}
+	}
+}
+
+// traceAuthz runs an authorization middleware in a span, which ends when the
+// request is either authorized or rejected
+func traceAuthz(authz func(httprouter.Handle) httprouter.Handle, next httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		parent := req.Context()
+		ctx, span := tracing.Start(parent, "authorization", tracing.KindInternal)
+		span.SetAttribute("key_id", strings.SplitN(req.Header.Get("Authorization"), " ", 2)[0])
+
+		authorized := false
+		authz(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			authorized = true
+			span.End()
+			// The request may have been modified by the middleware, e.g. to
+			// restore its body
+			next(w, r.WithContext(parent), ps)
+		})(w, req.WithContext(ctx), ps)
+
+		if !authorized {
+			span.SetError(fmt.Errorf("request not authorized"))
+			span.End()
+		}
+	}
+}
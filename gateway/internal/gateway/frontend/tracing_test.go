@@ -0,0 +1,61 @@
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	backend := mockBackend{}
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var handlerSpan *tracing.Span
+	handler := func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		handlerSpan = tracing.SpanFromContext(req.Context())
+		forwardBody(w, req, ps)
+	}
+	authz := func(next httprouter.Handle) httprouter.Handle {
+		return WithAuthz(&backend, next)
+	}
+	traced := WithTrace("POST /api/v1/leases", WithTag(traceAuthz(authz, handler)))
+
+	reqBody := []byte("hello")
+	HMAC := ComputeHMAC(reqBody, backend.GetKey(context.TODO(), "keyid2").Secret)
+	req := httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "keyid2 "+base64.StdEncoding.EncodeToString(HMAC))
+	req.Header.Set("traceparent", traceparent)
+	w := httptest.NewRecorder()
+	traced(w, req, httprouter.Params{})
+
+	resp := w.Result()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Equal(reqBody, respBody) {
+		t.Errorf("request body not forwarded after authorization: %v", string(respBody))
+	}
+	if resp.Header.Get(RequestIDHeader) == "" {
+		t.Errorf("missing %v header", RequestIDHeader)
+	}
+	// The handler runs in the span of the request, which continues the trace
+	// of the caller
+	if handlerSpan == nil || handlerSpan.Context().TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("the request does not continue the trace of the caller")
+	}
+
+	// Rejected requests are not handled, but still tagged
+	req = httptest.NewRequest("POST", "/api/v1/leases", bytes.NewReader(reqBody))
+	req.Header.Set("Authorization", "keyid2 rubbish")
+	w = httptest.NewRecorder()
+	handlerSpan = nil
+	traced(w, req, httprouter.Params{})
+	if handlerSpan != nil || w.Result().Header.Get(RequestIDHeader) == "" {
+		t.Errorf("unauthorized request was handled")
+	}
+}
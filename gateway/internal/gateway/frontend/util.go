@@ -9,11 +9,19 @@ import (
 )
 
 func replyJSON(ctx context.Context, w http.ResponseWriter, msg message) {
+	replyJSONStatus(ctx, w, msg, http.StatusOK)
+}
+
+func replyJSONStatus(ctx context.Context, w http.ResponseWriter, msg message, status int) {
 	rep, err := json.Marshal(msg)
 	if err != nil {
 		httpWrapError(ctx, err, "JSON serialization failed", w, http.StatusInternalServerError)
 		return
 	}
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+	}
 	w.Write(rep)
 }
 
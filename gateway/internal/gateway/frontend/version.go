@@ -3,12 +3,18 @@ package frontend
 const (
 	// APIProtocolVersion is the latest API protocol version understood by the
 	// server
-	APIProtocolVersion = 3
+	APIProtocolVersion = 4
 	// MinAPIProtocolVersion is the oldest API protocol version understood by the
 	// server
 	MinAPIProtocolVersion = 2
+	// StructuredErrorsVersion is the first API protocol version for which error
+	// replies are sent with the HTTP status code matching the error
+	StructuredErrorsVersion = 4
 	// APIRoot is the current HTTP API root
 	APIRoot = "/api/v1"
+	// APIVersionHeader is the request header in which clients announce the
+	// API protocol version they understand
+	APIVersionHeader = "X-Gateway-API-Version"
 )
 
 // MaxAPIVersion returns min(requestVersion, APIProtocolVersion)
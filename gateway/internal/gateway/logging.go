@@ -5,6 +5,7 @@ import (
 	"io"
 	"time"
 
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 )
@@ -46,15 +47,20 @@ func ConfigLogging(cfg *Config) {
 // package. It takes a context, the component name (i.e. "http", "leasedb",
 // etc.) and the log level, and returns a *zerolog.Event which is tagged with
 // the component name, unique ID of the request and the time (in milliseconds as
-// float) since the request was received. This event can be extended with new
-// fields or logged using the Msg/Msgf methods
+// float) since the request was received, and with the trace ID of the request
+// if it is traced. This event can be extended with new fields or logged using
+// the Msg/Msgf methods
 func LogC(ctx context.Context, component string, level LogLevel) *zerolog.Event {
 	reqID, _ := ctx.Value(IDKey).(uuid.UUID)
 	t0, _ := ctx.Value(T0Key).(time.Time)
 
-	return Log(component, level).
+	event := Log(component, level).
 		Str("req_id", reqID.String()).
 		Dur("req_dt", time.Since(t0))
+	if span := tracing.SpanFromContext(ctx); span != nil && event != nil {
+		event = event.Str("trace_id", span.Context().TraceIDString())
+	}
+	return event
 }
 
 // Log is a convenience wrapper on top of the global Logger of the gateway
@@ -66,6 +72,8 @@ func Log(component string, level LogLevel) *zerolog.Event {
 		event = Logger.Debug()
 	case LogInfo:
 		event = Logger.Info()
+	case LogWarn:
+		event = Logger.Warn()
 	case LogError:
 		event = Logger.Error()
 	default:
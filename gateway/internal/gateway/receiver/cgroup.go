@@ -0,0 +1,127 @@
+package receiver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	gw "github.com/cvmfs/gateway/internal/gateway"
+)
+
+// cpuPeriod is the period (in microseconds) used for the "cpu.max" limit
+const cpuPeriod = 100000
+
+// workerCgroup is the cgroup v2 directory holding the receiver processes of a
+// single pool worker
+type workerCgroup struct {
+	path string
+}
+
+// setupCgroups creates one child cgroup per worker below limits.Cgroup and
+// applies the resource limits to each of them
+func setupCgroups(limits gw.ReceiverLimits, numWorkers int) ([]*workerCgroup, error) {
+	if limits.Cgroup == "" {
+		return nil, fmt.Errorf("receiver limits require a cgroup directory")
+	}
+	root := limits.Cgroup
+	parent := filepath.Dir(root)
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%v is not in a cgroup v2 hierarchy: %w", root, err)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("could not create cgroup %v: %w", root, err)
+	}
+
+	controllers := []string{}
+	if limits.MemoryMax > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if limits.CPUMax > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if len(limits.IOMax) > 0 {
+		controllers = append(controllers, "+io")
+	}
+	if err := writeCgroupFile(root, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+		return nil, fmt.Errorf("could not enable cgroup controllers: %w", err)
+	}
+
+	cgroups := make([]*workerCgroup, numWorkers)
+	for i := range cgroups {
+		cg := &workerCgroup{filepath.Join(root, fmt.Sprintf("worker-%d", i))}
+		if err := os.Mkdir(cg.path, 0755); err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("could not create cgroup %v: %w", cg.path, err)
+		}
+		if err := cg.apply(limits); err != nil {
+			return nil, err
+		}
+		cgroups[i] = cg
+	}
+
+	return cgroups, nil
+}
+
+func (cg *workerCgroup) apply(limits gw.ReceiverLimits) error {
+	if limits.MemoryMax > 0 {
+		if err := writeCgroupFile(cg.path, "memory.max", strconv.FormatInt(limits.MemoryMax, 10)); err != nil {
+			return err
+		}
+		// Without swap, a worker exceeding its limit is killed instead of
+		// slowing down the whole host (swap accounting may be disabled)
+		if _, err := os.Stat(filepath.Join(cg.path, "memory.swap.max")); err == nil {
+			if err := writeCgroupFile(cg.path, "memory.swap.max", "0"); err != nil {
+				return err
+			}
+		}
+	}
+	if limits.CPUMax > 0 {
+		quota := int64(limits.CPUMax * cpuPeriod)
+		if err := writeCgroupFile(cg.path, "cpu.max", fmt.Sprintf("%d %d", quota, cpuPeriod)); err != nil {
+			return err
+		}
+	}
+	for _, entry := range limits.IOMax {
+		if err := writeCgroupFile(cg.path, "io.max", entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addProcess moves a process into the cgroup
+func (cg *workerCgroup) addProcess(pid int) error {
+	return writeCgroupFile(cg.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// oomKills returns the number of processes of the cgroup killed by the OOM killer
+func (cg *workerCgroup) oomKills() (uint64, error) {
+	f, err := os.Open(filepath.Join(cg.path, "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			return strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// remove deletes the cgroup, which must not contain any process
+func (cg *workerCgroup) remove() error {
+	return os.Remove(cg.path)
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("could not write %v of cgroup %v: %w", name, dir, err)
+	}
+	return nil
+}
@@ -4,20 +4,130 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
+	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
 )
 
+// MockScript controls the behaviour of all the mock receivers of a pool, to
+// inject faults in tests. The zero value lets every operation succeed.
+type MockScript struct {
+	mu              sync.Mutex
+	latency         time.Duration
+	payloadFailures int
+	payloadCrashes  int
+	commitFailures  int
+	finalRevision   uint64
+	revisions       map[string]uint64
+	numPayloads     int
+	numCommits      int
+}
+
+// NewMockScript creates a mock receiver script where every operation succeeds
+func NewMockScript() *MockScript {
+	return &MockScript{revisions: make(map[string]uint64)}
+}
+
+// SetLatency adds a delay to every payload submission and commit
+func (s *MockScript) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+}
+
+// FailPayloads makes the next n payload submissions fail with an error reply
+func (s *MockScript) FailPayloads(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloadFailures = n
+}
+
+// CrashPayloads makes the receiver crash halfway through the next n payloads
+func (s *MockScript) CrashPayloads(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloadCrashes = n
+}
+
+// FailCommits makes the next n commits fail with an error reply
+func (s *MockScript) FailCommits(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commitFailures = n
+}
+
+// SetFinalRevision makes the next commit report the given revision instead of
+// the next revision of the repository
+func (s *MockScript) SetFinalRevision(rev uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finalRevision = rev
+}
+
+// Counts returns the number of successful payload submissions and commits
+func (s *MockScript) Counts() (payloads int, commits int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numPayloads, s.numCommits
+}
+
+func (s *MockScript) getLatency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency
+}
+
+// take decrements a fault counter, returning true if the fault should happen
+func (s *MockScript) take(counter *int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *counter > 0 {
+		*counter--
+		return true
+	}
+	return false
+}
+
+func (s *MockScript) payloadDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numPayloads++
+}
+
+func (s *MockScript) commitDone(repository string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.numCommits++
+	s.revisions[repository]++
+	if s.finalRevision != 0 {
+		rev := s.finalRevision
+		s.finalRevision = 0
+		return rev
+	}
+	return s.revisions[repository]
+}
+
 // MockReceiver is a mocked implementation of the Receiver interface, for testing
-// Can implement fault injection
+// Faults are injected according to the MockScript of the pool
 type MockReceiver struct {
-	ctx context.Context
+	ctx      context.Context
+	script   *MockScript
+	statsMgr *stats.StatisticsMgr
+	// stopped is closed when the receiver is interrupted or killed
+	stopped  chan struct{}
+	stopOnce sync.Once
 }
 
 // NewMockReceiver constructs a new MockReceiver object which implements the
 // Receiver interface
-func NewMockReceiver(ctx context.Context) (Receiver, error) {
-	return &MockReceiver{ctx}, nil
+func NewMockReceiver(ctx context.Context, script *MockScript, statsMgr *stats.StatisticsMgr) (Receiver, error) {
+	if script == nil {
+		script = NewMockScript()
+	}
+	return &MockReceiver{ctx: ctx, script: script, statsMgr: statsMgr, stopped: make(chan struct{})}, nil
 }
 
 func (r *MockReceiver) Quit() error {
@@ -35,14 +145,96 @@ func (r *MockReceiver) Echo() error {
 }
 
 func (r *MockReceiver) Commit(leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
+	// Like the real receiver, the statistics of the lease are consumed by the commit
+	if r.statsMgr != nil {
+		if _, err := r.statsMgr.PopLease(leasePath); err != nil {
+			return 0, fmt.Errorf("could not obtain statistics counters: %w", err)
+		}
+	}
+	if err := r.delay(); err != nil {
+		return 0, err
+	}
+	if r.script.take(&r.script.commitFailures) {
+		return 0, fmt.Errorf("mock receiver commit failure")
+	}
+
+	repository := strings.SplitN(leasePath, "/", 2)[0]
+	finalRev := r.script.commitDone(repository)
+
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "commit").
 		Str("lease_path", leasePath).
 		Msgf("new revision committed")
-	return 1, nil
+	return finalRev, nil
+}
+
+// CommitBatch commits all the leases in a single revision. Each commit
+// consumes one of the commit failures of the script.
+func (r *MockReceiver) CommitBatch(commits []CommitRequest) (uint64, []error, error) {
+	if len(commits) == 0 {
+		return 0, nil, fmt.Errorf("empty commit batch")
+	}
+	if r.statsMgr != nil {
+		for _, c := range commits {
+			if _, err := r.statsMgr.PopLease(c.LeasePath); err != nil {
+				return 0, nil, fmt.Errorf("could not obtain statistics counters: %w", err)
+			}
+		}
+	}
+	if err := r.delay(); err != nil {
+		return 0, nil, err
+	}
+
+	errs := make([]error, len(commits))
+	committed := 0
+	for i := range commits {
+		if r.script.take(&r.script.commitFailures) {
+			errs[i] = fmt.Errorf("mock receiver commit failure")
+		} else {
+			committed++
+		}
+	}
+	if committed == 0 {
+		return 0, errs, nil
+	}
+
+	repository := strings.SplitN(commits[0].LeasePath, "/", 2)[0]
+	finalRev := r.script.commitDone(repository)
+
+	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
+		Str("command", "commit batch").
+		Int("commits", committed).
+		Msgf("new revision committed")
+	return finalRev, errs, nil
 }
 
 func (r *MockReceiver) SubmitPayload(leasePath string, payload io.Reader, digest string, headerSize int) error {
+	if err := r.delay(); err != nil {
+		return err
+	}
+
+	if r.script.take(&r.script.payloadCrashes) {
+		// Read a part of the payload before crashing
+		io.CopyN(io.Discard, payload, int64(headerSize)+1)
+		return fmt.Errorf("worker 'payload submission' call failed: mock receiver has crashed")
+	}
+
+	// Consume the payload, like the real receiver does
+	n, err := io.Copy(io.Discard, payload)
+	if err != nil {
+		return fmt.Errorf("could not read payload: %w", err)
+	}
+
+	if r.script.take(&r.script.payloadFailures) {
+		return fmt.Errorf("mock receiver payload failure")
+	}
+
+	r.script.payloadDone()
+	if r.statsMgr != nil {
+		st := stats.Statistics{Publish: stats.PublishCounters{ChunksAdded: 1, UploadedBytes: n}}
+		r.statsMgr.MergeIntoLeaseStatistics(leasePath, &st)
+	}
+
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "submit payload").
 		Str("lease_path", leasePath).
@@ -50,13 +242,36 @@ func (r *MockReceiver) SubmitPayload(leasePath string, payload io.Reader, digest
 	return nil
 }
 
+// Interrupt aborts the operation in progress, which fails like after a crash
+// of the real receiver
 func (r *MockReceiver) Interrupt() error {
+	r.stopOnce.Do(func() { close(r.stopped) })
 	return nil
 }
 
+func (r *MockReceiver) Kill() error {
+	return r.Interrupt()
+}
+
 func (r *MockReceiver) TestCrash() error {
 	gw.LogC(r.ctx, "mock_receiver", gw.LogDebug).
 		Str("command", "test crash").
 		Msgf("worker process is crashing")
 	return fmt.Errorf("mock receiver has crashed")
 }
+
+// delay waits for the configured latency, or until the task is cancelled
+func (r *MockReceiver) delay() error {
+	latency := r.script.getLatency()
+	if latency == 0 {
+		return nil
+	}
+	select {
+	case <-time.After(latency):
+		return nil
+	case <-r.ctx.Done():
+		return r.ctx.Err()
+	case <-r.stopped:
+		return fmt.Errorf("possible that the receiver crashed: mock receiver was interrupted")
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
 )
 
 // task is the common interface of all receiver tasks
@@ -58,6 +59,30 @@ func (p commitTask) Context() context.Context {
 	return p.ctx
 }
 
+// commitBatchTask is the input data for a batched commit task
+type commitBatchTask struct {
+	ctx        context.Context
+	commits    []CommitRequest
+	replyChan  chan<- error
+	resultChan chan<- commitBatchResult
+}
+
+// commitBatchResult is the outcome of a batched commit task
+type commitBatchResult struct {
+	finalRev uint64
+	errs     []error
+}
+
+// Reply returns the reply channel
+func (p commitBatchTask) Reply() chan<- error {
+	return p.replyChan
+}
+
+// Context returns the context associated with the task
+func (p commitBatchTask) Context() context.Context {
+	return p.ctx
+}
+
 type testCrashTask struct {
 	ctx       context.Context
 	replyChan chan<- error
@@ -71,6 +96,54 @@ func (p testCrashTask) Context() context.Context {
 	return p.ctx
 }
 
+// Reasons for which a receiver task is killed
+const (
+	KilledTimeout     = "timeout"
+	KilledOutOfMemory = "out_of_memory"
+)
+
+// killGracePeriod is the time given to an interrupted receiver to exit, before
+// it is killed
+var killGracePeriod = 5 * time.Second
+
+// TaskKilledError is returned when a receiver task was killed before completion
+type TaskKilledError struct {
+	Task    string
+	Reason  string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e TaskKilledError) Error() string {
+	if e.Reason == KilledTimeout {
+		return fmt.Sprintf("receiver %v task killed after exceeding the timeout of %v", e.Task, e.Timeout)
+	}
+	return fmt.Sprintf("receiver %v task killed: worker exceeded its memory limit", e.Task)
+}
+
+func (e TaskKilledError) Unwrap() error {
+	return e.Err
+}
+
+// PoolOptions are the limits applied to the receivers of a pool
+type PoolOptions struct {
+	// TaskTimeout is the maximum duration of a task (0 for no limit)
+	TaskTimeout time.Duration
+	// Limits are the cgroup resource limits of each worker
+	Limits gw.ReceiverLimits
+}
+
+// PoolStatistics are the counters of the tasks run by the pool. Failed tasks
+// include the killed ones.
+type PoolStatistics struct {
+	Workers     int    `json:"workers"`
+	Busy        int    `json:"busy"`
+	Tasks       uint64 `json:"tasks"`
+	Failed      uint64 `json:"failed"`
+	TimedOut    uint64 `json:"timed_out"`
+	OutOfMemory uint64 `json:"out_of_memory"`
+}
+
 // Pool maintains a number of parallel receiver workers to service
 // payload submission and commit requests. Payload submissions are done in
 // parallel, using Config.NumReceivers workers, while only a single commit
@@ -80,15 +153,32 @@ type Pool struct {
 	wg         sync.WaitGroup
 	workerExec string
 	mock       bool
+	mockScript *MockScript
 	smgr       *stats.StatisticsMgr
+	opts       PoolOptions
+	cgroups    []*workerCgroup
+	statsMu    sync.Mutex
+	stats      PoolStatistics
 }
 
 // StartPool the receiver pool using the specified executable and number of payload
 // submission workers
-func StartPool(workerExec string, numWorkers int, mock bool, smgr *stats.StatisticsMgr) (*Pool, error) {
+func StartPool(workerExec string, numWorkers int, mock bool, smgr *stats.StatisticsMgr, opts PoolOptions) (*Pool, error) {
 	// Start payload submission workers
 	tasks := make(chan task)
-	pool := &Pool{tasks, sync.WaitGroup{}, workerExec, mock, smgr}
+	pool := &Pool{tasks: tasks, workerExec: workerExec, mock: mock, smgr: smgr, opts: opts}
+	pool.stats.Workers = numWorkers
+	if mock {
+		pool.mockScript = NewMockScript()
+	}
+
+	if opts.Limits.Enabled() && !mock {
+		cgroups, err := setupCgroups(opts.Limits, numWorkers)
+		if err != nil {
+			return nil, fmt.Errorf("could not set up receiver cgroups: %w", err)
+		}
+		pool.cgroups = cgroups
+	}
 
 	for i := 0; i < numWorkers; i++ {
 		pool.wg.Add(1)
@@ -96,33 +186,53 @@ func StartPool(workerExec string, numWorkers int, mock bool, smgr *stats.Statist
 	}
 
 	gw.Log("worker_pool", gw.LogInfo).
+		Dur("task_timeout", opts.TaskTimeout).
+		Bool("cgroup_limits", pool.cgroups != nil).
 		Msg("worker pool started")
 
 	return pool, nil
 }
 
+// MockScript returns the script shared by the mock receivers of the pool, or
+// nil if the pool runs real receivers
+func (p *Pool) MockScript() *MockScript {
+	return p.mockScript
+}
+
+// Statistics returns the task counters of the pool
+func (p *Pool) Statistics() PoolStatistics {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	return p.stats
+}
+
 // Stop all the background workers
 func (p *Pool) Stop() error {
 	close(p.tasks)
 	p.wg.Wait()
+	for _, cg := range p.cgroups {
+		if err := cg.remove(); err != nil {
+			gw.Log("worker_pool", gw.LogWarn).
+				Err(err).
+				Msgf("could not remove cgroup %v", cg.path)
+		}
+	}
 	return nil
 }
 
 // SubmitPayload to be unpacked into the repository
-// TODO: implement timeout or context?
 func (p *Pool) SubmitPayload(ctx context.Context, leasePath string, payload io.Reader, digest string, headerSize int) error {
 	reply := make(chan error, 1)
-	p.tasks <- payloadTask{ctx, leasePath, payload, digest, headerSize, reply}
+	p.enqueue(ctx, payloadTask{ctx, leasePath, payload, digest, headerSize, reply})
 	result := <-reply
 	return result
 }
 
 // CommitLease associated with the token (transaction commit)
-// TODO: implement timeout or context?
 func (p *Pool) CommitLease(ctx context.Context, leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error) {
 	reply := make(chan error, 1)
 	finalRevChan := make(chan uint64, 1)
-	p.tasks <- commitTask{ctx, leasePath, oldRootHash, newRootHash, tag, reply, finalRevChan}
+	p.enqueue(ctx, commitTask{ctx, leasePath, oldRootHash, newRootHash, tag, reply, finalRevChan})
 	result := <-reply
 	if result == nil {
 		return <-finalRevChan, nil
@@ -130,11 +240,39 @@ func (p *Pool) CommitLease(ctx context.Context, leasePath, oldRootHash, newRootH
 	return 0, result
 }
 
+// CommitBatch merges the commits of disjoint leases of a repository into a
+// single revision, see Receiver.CommitBatch. ErrBatchNotSupported is returned
+// if the cvmfs_receiver is too old to merge batches.
+func (p *Pool) CommitBatch(ctx context.Context, commits []CommitRequest) (uint64, []error, error) {
+	reply := make(chan error, 1)
+	resultChan := make(chan commitBatchResult, 1)
+	p.enqueue(ctx, commitBatchTask{ctx, commits, reply, resultChan})
+	result := <-reply
+	if result == nil {
+		res := <-resultChan
+		return res.finalRev, res.errs, nil
+	}
+	return 0, nil, result
+}
+
+// enqueue waits until a worker picks the task. The wait is recorded in a span,
+// if the context is traced.
+func (p *Pool) enqueue(ctx context.Context, t task) {
+	_, span := tracing.StartChild(ctx, "receiver.queue")
+	p.tasks <- t
+	span.End()
+}
+
 func worker(tasks <-chan task, pool *Pool, workerIdx int) {
 	gw.Log("worker_pool", gw.LogDebug).
 		Int("worker_id", workerIdx).
 		Msg("started")
 
+	var cgroup *workerCgroup
+	if pool.cgroups != nil {
+		cgroup = pool.cgroups[workerIdx]
+	}
+
 	defer pool.wg.Done()
 M:
 	for {
@@ -146,8 +284,20 @@ M:
 
 		func() {
 			t0 := time.Now()
-			receiver, err := NewReceiver(task.Context(), pool.workerExec, pool.mock, pool.smgr)
+			// The span of the task is propagated to the receiver process
+			ctx, span := tracing.StartChild(task.Context(), "receiver.task")
+			span.SetAttribute("worker_id", workerIdx)
+			defer span.End()
+
+			var receiver Receiver
+			var err error
+			if pool.mockScript != nil {
+				receiver, err = NewMockReceiver(ctx, pool.mockScript, pool.smgr)
+			} else {
+				receiver, err = NewReceiver(ctx, pool.workerExec, pool.mock, pool.smgr)
+			}
 			if err != nil {
+				span.SetError(err)
 				task.Reply() <- err
 				return
 			}
@@ -159,6 +309,24 @@ M:
 				}
 			}()
 
+			// The receiver is placed in the cgroup of the worker before it is
+			// sent any work
+			var oomKills uint64
+			if cr, ok := receiver.(*CvmfsReceiver); ok && cgroup != nil {
+				if err := cgroup.addProcess(cr.Pid()); err != nil {
+					receiver.Kill()
+					task.Reply() <- fmt.Errorf("could not apply receiver limits: %w", err)
+					return
+				}
+				oomKills, _ = cgroup.oomKills()
+			}
+
+			pool.taskStarted()
+			var killer *taskKiller
+			if pool.opts.TaskTimeout > 0 {
+				killer = startTaskKiller(task.Context(), receiver, pool.opts.TaskTimeout, workerIdx)
+			}
+
 			var taskType string
 			var result error
 			var finalRev uint64
@@ -171,14 +339,41 @@ M:
 				taskType = "commit"
 				t.finalRevChan <- finalRev
 				close(t.finalRevChan)
+			case commitBatchTask:
+				var res commitBatchResult
+				res.finalRev, res.errs, result = receiver.CommitBatch(t.commits)
+				taskType = "commit"
+				t.resultChan <- res
+				close(t.resultChan)
 			case testCrashTask:
 				result = receiver.TestCrash()
 				taskType = "testcrash"
 			default:
+				pool.taskDone(nil, true)
 				task.Reply() <- fmt.Errorf("unknown task type")
 				return
 			}
 
+			var killed *TaskKilledError
+			if killer != nil && killer.stop() {
+				killed = &TaskKilledError{Task: taskType, Reason: KilledTimeout, Timeout: pool.opts.TaskTimeout, Err: result}
+			} else if result != nil && cgroup != nil {
+				if n, err := cgroup.oomKills(); err == nil && n > oomKills {
+					killed = &TaskKilledError{Task: taskType, Reason: KilledOutOfMemory, Err: result}
+				}
+			}
+			if killed != nil {
+				result = *killed
+				gw.LogC(task.Context(), "worker_pool", gw.LogWarn).
+					Int("worker_id", workerIdx).
+					Str("reason", killed.Reason).
+					Dur("task_dt", time.Since(t0)).
+					Msgf("%v task killed", taskType)
+			}
+			pool.taskDone(killed, result != nil)
+			span.SetAttribute("task", taskType)
+			span.SetError(result)
+
 			task.Reply() <- result
 			close(task.Reply())
 
@@ -193,3 +388,67 @@ M:
 		Int("worker_id", workerIdx).
 		Msg("finished")
 }
+
+func (p *Pool) taskStarted() {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.stats.Busy++
+	p.stats.Tasks++
+}
+
+func (p *Pool) taskDone(killed *TaskKilledError, failed bool) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	p.stats.Busy--
+	if failed {
+		p.stats.Failed++
+	}
+	if killed != nil {
+		switch killed.Reason {
+		case KilledTimeout:
+			p.stats.TimedOut++
+		case KilledOutOfMemory:
+			p.stats.OutOfMemory++
+		}
+	}
+}
+
+// taskKiller interrupts a receiver when its task exceeds the timeout, and
+// kills it if it is still running after the grace period
+type taskKiller struct {
+	timer *time.Timer
+	mu    sync.Mutex
+	fired bool
+	grace *time.Timer
+}
+
+func startTaskKiller(ctx context.Context, receiver Receiver, timeout time.Duration, workerIdx int) *taskKiller {
+	k := &taskKiller{}
+	k.timer = time.AfterFunc(timeout, func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		k.fired = true
+		gw.LogC(ctx, "worker_pool", gw.LogWarn).
+			Int("worker_id", workerIdx).
+			Msgf("task exceeded the timeout of %v, interrupting the receiver", timeout)
+		if err := receiver.Interrupt(); err != nil {
+			receiver.Kill()
+			return
+		}
+		k.grace = time.AfterFunc(killGracePeriod, func() {
+			receiver.Kill()
+		})
+	})
+	return k
+}
+
+// stop disarms the killer, returning true if the task was interrupted
+func (k *taskKiller) stop() bool {
+	k.timer.Stop()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.grace != nil {
+		k.grace.Stop()
+	}
+	return k.fired
+}
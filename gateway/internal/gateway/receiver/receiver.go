@@ -13,6 +13,7 @@ import (
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	stats "github.com/cvmfs/gateway/internal/gateway/statistics"
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
 )
 
 // Error is returned by the various receiver commands in case of error
@@ -38,16 +39,41 @@ const (
 	receiverCommit
 	receiverError     // Unused
 	receiverTestCrash // Used only in testing
+	receiverCommitBatch
 )
 
+// featureCommitBatch is advertised in the 'echo' reply of the workers which
+// support receiverCommitBatch
+const featureCommitBatch = "commit_batch"
+
+// ErrBatchNotSupported is returned by the receivers which cannot merge several
+// lease commits into a single revision
+var ErrBatchNotSupported = errors.New("batched commits are not supported by the receiver")
+
+// ErrBatchNotMerged is returned when the commits of a batch could not be merged
+// together. Nothing was committed, the leases can be committed one by one.
+var ErrBatchNotMerged = errors.New("batched commits could not be merged")
+
+// CommitRequest is one of the lease commits merged by CommitBatch
+type CommitRequest struct {
+	LeasePath   string
+	OldRootHash string
+	NewRootHash string
+	Tag         gw.RepositoryTag
+}
+
 // Receiver contains the operations that "receiver" worker processes perform
 type Receiver interface {
 	Quit() error
 	Echo() error
 	SubmitPayload(leasePath string, payload io.Reader, digest string, headerSize int) error
 	Commit(leasePath, oldRootHash, newRootHash string, tag gw.RepositoryTag) (uint64, error)
+	// CommitBatch merges the commits of disjoint leases of a repository into
+	// a single revision. The error of each commit is returned separately: the
+	// revision contains the commits without error.
+	CommitBatch(commits []CommitRequest) (uint64, []error, error)
 	Interrupt() error // like Ctrl-C SIGTERM -2
-	// Kill() error // like Crtl-D SIGKILL -9
+	Kill() error      // like Crtl-D SIGKILL -9
 	TestCrash() error
 }
 
@@ -61,7 +87,7 @@ type ReceiverReply struct {
 // NewReceiver is the factory method for Receiver types
 func NewReceiver(ctx context.Context, execPath string, mock bool, statsMgr *stats.StatisticsMgr, args ...string) (Receiver, error) {
 	if mock {
-		return NewMockReceiver(ctx)
+		return NewMockReceiver(ctx, nil, statsMgr)
 	}
 
 	return NewCvmfsReceiver(ctx, execPath, statsMgr, args...)
@@ -87,6 +113,11 @@ func NewCvmfsReceiver(ctx context.Context, execPath string, statsMgr *stats.Stat
 	cmdLine := []string{"-i", "3", "-o", "4"}
 	cmdLine = append(cmdLine, args...)
 	cmd := exec.Command(execPath, cmdLine...)
+	// The trace context is passed to the worker like to other processes
+	// instrumented with OpenTelemetry
+	if span := tracing.SpanFromContext(ctx); span != nil && span.Context().Sampled() {
+		cmd.Env = append(os.Environ(), "TRACEPARENT="+span.Context().Traceparent())
+	}
 
 	workerInRead, workerInWrite, err := os.Pipe()
 	if err != nil {
@@ -177,21 +208,28 @@ func (r *CvmfsReceiver) Quit() error {
 
 // Echo command is sent to the worker
 func (r *CvmfsReceiver) Echo() error {
+	_, err := r.echo()
+	return err
+}
+
+// echo returns the reply of the worker to the 'echo' command, "PID: <pid>"
+// followed by the features of the worker
+func (r *CvmfsReceiver) echo() (string, error) {
 	rep, err := r.call(receiverEcho, []byte("Ping"), nil)
 	if err != nil {
-		return fmt.Errorf("worker 'echo' call failed: %w", err)
+		return "", fmt.Errorf("worker 'echo' call failed: %w", err)
 	}
 	reply := string(rep)
 
 	if !strings.HasPrefix(reply, "PID: ") {
-		return fmt.Errorf("invalid 'echo' reply received: %v", reply)
+		return "", fmt.Errorf("invalid 'echo' reply received: %v", reply)
 	}
 
 	gw.LogC(r.ctx, "receiver", gw.LogDebug).
 		Str("command", "echo").
 		Msgf("reply: %v", reply)
 
-	return nil
+	return reply, nil
 }
 
 // SubmitPayload command is sent to the worker
@@ -254,6 +292,90 @@ func (r *CvmfsReceiver) Commit(leasePath, oldRootHash, newRootHash string, tag g
 	return parsedReply.FinalRevision, result
 }
 
+// CommitBatch command is sent to the worker. The statistics of the leases are
+// added together. ErrBatchNotSupported is returned by the workers which don't
+// advertise the feature, and ErrBatchNotMerged if one of the commits could not
+// be merged; the statistics of the leases are kept in both cases, for the
+// commits of the leases one by one.
+func (r *CvmfsReceiver) CommitBatch(commits []CommitRequest) (uint64, []error, error) {
+	if len(commits) == 0 {
+		return 0, nil, fmt.Errorf("empty commit batch")
+	}
+	features, err := r.echo()
+	if err != nil {
+		return 0, nil, err
+	}
+	if !strings.Contains(features, featureCommitBatch) {
+		return 0, nil, ErrBatchNotSupported
+	}
+
+	var batchStats stats.Statistics
+	reqCommits := make([]map[string]interface{}, 0, len(commits))
+	for _, c := range commits {
+		leaseStats, err := r.statsMgr.GetLeaseStatistics(c.LeasePath)
+		if err != nil {
+			return 0, nil, fmt.Errorf("could not obtain statistics counters: %w", err)
+		}
+		addStatistics(&batchStats, leaseStats)
+		reqCommits = append(reqCommits, map[string]interface{}{
+			"lease_path":      c.LeasePath,
+			"old_root_hash":   c.OldRootHash,
+			"new_root_hash":   c.NewRootHash,
+			"tag_name":        c.Tag.Name,
+			"tag_description": c.Tag.Description,
+		})
+	}
+	req := map[string]interface{}{
+		"commits":    reqCommits,
+		"statistics": batchStats,
+	}
+	buf, err := json.Marshal(&req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("request encoding failed: %w", err)
+	}
+
+	reply, err := r.call(receiverCommitBatch, buf, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("worker 'commit batch' call failed: %w", err)
+	}
+
+	parsedReply, result := parseReceiverReply(reply)
+
+	gw.LogC(r.ctx, "receiver", gw.LogDebug).
+		Str("command", "commit batch").
+		Int("commits", len(commits)).
+		Msgf("result: %v", result)
+
+	if result == Error("merge_error") {
+		return 0, nil, fmt.Errorf("%w: %v", ErrBatchNotMerged, result)
+	}
+	for _, c := range commits {
+		r.statsMgr.PopLease(c.LeasePath)
+	}
+	if result != nil {
+		return 0, nil, result
+	}
+	return parsedReply.FinalRevision, nil, nil
+}
+
+// addStatistics adds the counters of a lease to the statistics of a batch,
+// which starts with its earliest lease
+func addStatistics(batch *stats.Statistics, lease stats.Statistics) {
+	batch.Publish.ChunksAdded += lease.Publish.ChunksAdded
+	batch.Publish.ChunksDuplicated += lease.Publish.ChunksDuplicated
+	batch.Publish.CatalogsAdded += lease.Publish.CatalogsAdded
+	batch.Publish.UploadedBytes += lease.Publish.UploadedBytes
+	batch.Publish.UploadedCatalogBytes += lease.Publish.UploadedCatalogBytes
+	batch.Transfer.PayloadBytes += lease.Transfer.PayloadBytes
+	batch.Transfer.TransferredBytes += lease.Transfer.TransferredBytes
+	if batch.Transfer.TransferredBytes > 0 {
+		batch.Transfer.CompressionRatio = float64(batch.Transfer.PayloadBytes) / float64(batch.Transfer.TransferredBytes)
+	}
+	if batch.StartTime == "" || lease.StartTime < batch.StartTime {
+		batch.StartTime = lease.StartTime
+	}
+}
+
 func (r *CvmfsReceiver) Interrupt() error {
 	err := r.worker.Process.Signal(os.Interrupt)
 	gw.LogC(r.ctx, "receiver", gw.LogDebug).
@@ -262,6 +384,20 @@ func (r *CvmfsReceiver) Interrupt() error {
 	return err
 }
 
+// Kill the worker process, when it does not react to Interrupt
+func (r *CvmfsReceiver) Kill() error {
+	err := r.worker.Process.Kill()
+	gw.LogC(r.ctx, "receiver", gw.LogDebug).
+		Str("command", "kill").
+		Msgf("result (err): %v", err)
+	return err
+}
+
+// Pid returns the process ID of the worker process
+func (r *CvmfsReceiver) Pid() int {
+	return r.worker.Process.Pid
+}
+
 // Method used only in testing, we provide an empty implementation here
 func (r *CvmfsReceiver) TestCrash() error {
 	reply, err := r.call(receiverTestCrash, nil, nil)
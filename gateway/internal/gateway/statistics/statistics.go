@@ -2,7 +2,6 @@ package statistics
 
 import (
 	"fmt"
-	"os/exec"
 	"sync"
 	"time"
 )
@@ -15,18 +14,72 @@ type PublishCounters struct {
 	UploadedCatalogBytes int64 `json:"sz_uploaded_catalog_bytes"`
 }
 
+// TransferCounters measure the payloads received from the publisher
+type TransferCounters struct {
+	// PayloadBytes is the size of the payloads handed to the receiver
+	PayloadBytes int64 `json:"sz_payload_bytes"`
+	// TransferredBytes is the size of the payloads as received, which is
+	// smaller than PayloadBytes for compressed payloads
+	TransferredBytes int64 `json:"sz_transferred_bytes"`
+	// CompressionRatio is PayloadBytes / TransferredBytes
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
 type Statistics struct {
-	Publish   PublishCounters `json:"publish"`
-	StartTime string          `json:"start_time"`
+	Publish   PublishCounters  `json:"publish"`
+	Transfer  TransferCounters `json:"transfer"`
+	StartTime string           `json:"start_time"`
+}
+
+// ErrQuotaExceeded is returned once the content published by a lease exceeds
+// its quota
+var ErrQuotaExceeded = fmt.Errorf("quota_exceeded")
+
+// Quota limits the content published by a lease. Zero disables a limit.
+type Quota struct {
+	MaxUploadedBytes int64 `json:"max_uploaded_bytes,omitempty"`
+	MaxPayloads      int64 `json:"max_payloads,omitempty"`
+	MaxCatalogs      int64 `json:"max_catalogs,omitempty"`
+}
+
+// QuotaUsage is the content published so far by a lease, with its quota
+type QuotaUsage struct {
+	Quota
+	UploadedBytes int64 `json:"uploaded_bytes"`
+	Payloads      int64 `json:"payloads"`
+	Catalogs      int64 `json:"catalogs"`
+	// Exceeded explains which limit was exceeded, empty within the quota
+	Exceeded string `json:"exceeded,omitempty"`
+}
+
+// check records and returns the first limit exceeded by the usage
+func (u *QuotaUsage) check() error {
+	if u.Exceeded == "" {
+		switch {
+		case u.MaxUploadedBytes > 0 && u.UploadedBytes > u.MaxUploadedBytes:
+			u.Exceeded = fmt.Sprintf("more than %v bytes uploaded", u.MaxUploadedBytes)
+		case u.MaxPayloads > 0 && u.Payloads > u.MaxPayloads:
+			u.Exceeded = fmt.Sprintf("more than %v payloads submitted", u.MaxPayloads)
+		case u.MaxCatalogs > 0 && u.Catalogs > u.MaxCatalogs:
+			u.Exceeded = fmt.Sprintf("more than %v catalogs added", u.MaxCatalogs)
+		default:
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrQuotaExceeded, u.Exceeded)
 }
 
 type StatisticsMgr struct {
 	leaseStatistics map[string]Statistics
+	leaseQuotas     map[string]QuotaUsage
 	readLock        sync.Mutex
 }
 
 func NewStatisticsMgr() *StatisticsMgr {
-	return &StatisticsMgr{leaseStatistics: make(map[string]Statistics)}
+	return &StatisticsMgr{
+		leaseStatistics: make(map[string]Statistics),
+		leaseQuotas:     make(map[string]QuotaUsage),
+	}
 }
 
 func (m *StatisticsMgr) CreateLease(leasePath string) error {
@@ -36,6 +89,7 @@ func (m *StatisticsMgr) CreateLease(leasePath string) error {
 		return fmt.Errorf("could not create statistics entry for lease %s, entry already exists", leasePath)
 	}
 	m.leaseStatistics[leasePath] = Statistics{StartTime: time.Now().Format("2006-01-02 15:04:05")}
+	m.leaseQuotas[leasePath] = QuotaUsage{}
 	return nil
 }
 
@@ -47,9 +101,12 @@ func (m *StatisticsMgr) PopLease(leasePath string) (Statistics, error) {
 		return Statistics{}, fmt.Errorf("no statistics counters for lease %s", leasePath)
 	}
 	delete(m.leaseStatistics, leasePath)
+	delete(m.leaseQuotas, leasePath)
 	return res, nil
 }
 
+// MergeIntoLeaseStatistics adds the counters of a payload to the statistics of
+// the lease. It returns ErrQuotaExceeded once the lease exceeds its quota.
 func (m *StatisticsMgr) MergeIntoLeaseStatistics(leasePath string, other *Statistics) error {
 	m.readLock.Lock()
 	defer m.readLock.Unlock()
@@ -63,15 +120,110 @@ func (m *StatisticsMgr) MergeIntoLeaseStatistics(leasePath string, other *Statis
 	c.Publish.UploadedBytes += other.Publish.UploadedBytes
 	c.Publish.UploadedCatalogBytes += other.Publish.UploadedCatalogBytes
 	m.leaseStatistics[leasePath] = c
+
+	u := m.leaseQuotas[leasePath]
+	u.UploadedBytes += other.Publish.UploadedBytes
+	u.Payloads++
+	u.Catalogs += other.Publish.CatalogsAdded
+	err := u.check()
+	m.leaseQuotas[leasePath] = u
+	return err
+}
+
+// SetLeaseQuota sets the quota of a lease
+func (m *StatisticsMgr) SetLeaseQuota(leasePath string, quota Quota) error {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	u, prs := m.leaseQuotas[leasePath]
+	if !prs {
+		return fmt.Errorf("statistics counters not found for lease %s", leasePath)
+	}
+	u.Quota = quota
+	u.Exceeded = ""
+	u.check()
+	m.leaseQuotas[leasePath] = u
+	return nil
+}
+
+// RestoreLeaseUsage sets the content published so far by a lease, recorded
+// before a restart. The quota of the lease is kept.
+func (m *StatisticsMgr) RestoreLeaseUsage(leasePath string, usage QuotaUsage) error {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	u, prs := m.leaseQuotas[leasePath]
+	if !prs {
+		return fmt.Errorf("statistics counters not found for lease %s", leasePath)
+	}
+	u.UploadedBytes = usage.UploadedBytes
+	u.Payloads = usage.Payloads
+	u.Catalogs = usage.Catalogs
+	u.Exceeded = ""
+	u.check()
+	m.leaseQuotas[leasePath] = u
+	return nil
+}
+
+// GetLeaseQuota returns the quota usage of a lease
+func (m *StatisticsMgr) GetLeaseQuota(leasePath string) (QuotaUsage, error) {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	u, prs := m.leaseQuotas[leasePath]
+	if !prs {
+		return QuotaUsage{}, fmt.Errorf("no statistics counters for lease %s", leasePath)
+	}
+	return u, nil
+}
+
+// CheckLeaseQuota returns ErrQuotaExceeded if the lease exceeded its quota,
+// in which case it must not be committed
+func (m *StatisticsMgr) CheckLeaseQuota(leasePath string) error {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	u := m.leaseQuotas[leasePath]
+	return u.check()
+}
+
+// AdmitPayload returns ErrQuotaExceeded if a new payload of the lease would be
+// rejected: the quota is already exceeded, or the lease has submitted as many
+// payloads as allowed
+func (m *StatisticsMgr) AdmitPayload(leasePath string) error {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	u := m.leaseQuotas[leasePath]
+	if err := u.check(); err != nil {
+		return err
+	}
+	if u.MaxPayloads > 0 && u.Payloads >= u.MaxPayloads {
+		return fmt.Errorf("%w: no more than %v payloads allowed", ErrQuotaExceeded, u.MaxPayloads)
+	}
 	return nil
 }
 
-func (m *StatisticsMgr) UploadStatsPlots(repoName string) error {
-	scriptPath := "/usr/share/cvmfs-server/upload_stats_plots.sh"
-	cmd := exec.Command(scriptPath, repoName)
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("statistics plots upload failed: %w", err)
+// AddTransfer accounts for a payload of the lease, received with
+// transferredBytes and decompressed into payloadBytes
+func (m *StatisticsMgr) AddTransfer(leasePath string, payloadBytes, transferredBytes int64) error {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	c, prs := m.leaseStatistics[leasePath]
+	if !prs {
+		return fmt.Errorf("statistics counters not found for lease %s", leasePath)
 	}
+	c.Transfer.PayloadBytes += payloadBytes
+	c.Transfer.TransferredBytes += transferredBytes
+	if c.Transfer.TransferredBytes > 0 {
+		c.Transfer.CompressionRatio = float64(c.Transfer.PayloadBytes) / float64(c.Transfer.TransferredBytes)
+	}
+	m.leaseStatistics[leasePath] = c
 	return nil
 }
+
+// GetLeaseStatistics returns a copy of the statistics counters of a lease
+func (m *StatisticsMgr) GetLeaseStatistics(leasePath string) (Statistics, error) {
+	m.readLock.Lock()
+	defer m.readLock.Unlock()
+	res, prs := m.leaseStatistics[leasePath]
+	if !prs {
+		return Statistics{}, fmt.Errorf("no statistics counters for lease %s", leasePath)
+	}
+	return res, nil
+}
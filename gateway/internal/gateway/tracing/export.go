@@ -0,0 +1,276 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options configure the export of the spans
+type Options struct {
+	// Endpoint is the OTLP/HTTP traces URL of the collector, e.g.
+	// http://localhost:4318/v1/traces
+	Endpoint string
+	// ServiceName is the service.name resource attribute of the spans
+	ServiceName string
+	// OnError is called when spans could not be exported
+	OnError func(error)
+}
+
+var (
+	// exportInterval is the maximum time a span waits before being exported
+	exportInterval = 5 * time.Second
+	// exportTimeout bounds each request to the collector
+	exportTimeout = 10 * time.Second
+)
+
+const (
+	// maxBatchSize is the number of queued spans triggering an export
+	maxBatchSize = 512
+	// maxQueuedSpans are kept when the collector is unreachable, the new
+	// spans are dropped
+	maxQueuedSpans = 4096
+)
+
+type exporter struct {
+	opts   Options
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+var (
+	exporterMu sync.RWMutex
+	current    *exporter
+)
+
+// Enabled returns true if the spans are exported
+func Enabled() bool {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return current != nil
+}
+
+// StartExporter starts exporting the sampled spans to the collector. The new
+// traces started by the gateway are sampled once the export is started.
+func StartExporter(opts Options) error {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid OTLP endpoint: %v", opts.Endpoint)
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(error) {}
+	}
+	e := &exporter{
+		opts:   opts,
+		client: &http.Client{Timeout: exportTimeout},
+		flush:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	if current != nil {
+		return fmt.Errorf("span export already started")
+	}
+	current = e
+	go e.run()
+	return nil
+}
+
+// StopExporter exports the queued spans and stops the export
+func StopExporter(ctx context.Context) {
+	exporterMu.Lock()
+	e := current
+	current = nil
+	exporterMu.Unlock()
+	if e == nil {
+		return
+	}
+	close(e.stop)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+// export queues an ended span
+func export(s *Span) {
+	exporterMu.RLock()
+	e := current
+	exporterMu.RUnlock()
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, s)
+	if len(e.queue) >= maxBatchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			e.send()
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		e.send()
+	}
+}
+
+// send the queued spans to the collector. The spans are kept for the next
+// attempt if the collector cannot be reached.
+func (e *exporter) send() {
+	e.mu.Lock()
+	spans := e.queue
+	e.queue = nil
+	dropped := e.dropped
+	e.dropped = 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		e.opts.OnError(fmt.Errorf("%v spans dropped, the export queue is full", dropped))
+	}
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		if err := e.post(spans[:n]); err != nil {
+			e.opts.OnError(err)
+			e.requeue(spans)
+			return
+		}
+		spans = spans[n:]
+	}
+}
+
+// requeue spans which could not be exported, before the spans ended since
+func (e *exporter) requeue(spans []*Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	queue := append(spans, e.queue...)
+	if len(queue) > maxQueuedSpans {
+		e.dropped += len(queue) - maxQueuedSpans
+		queue = queue[len(queue)-maxQueuedSpans:]
+	}
+	e.queue = queue
+}
+
+func (e *exporter) post(spans []*Span) error {
+	body, err := json.Marshal(encodeSpans(e.opts.ServiceName, spans))
+	if err != nil {
+		return fmt.Errorf("could not encode spans: %w", err)
+	}
+	resp, err := e.client.Post(e.opts.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not export spans: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("could not export spans: collector replied %v", resp.Status)
+	}
+	return nil
+}
+
+// encodeSpans returns an OTLP ExportTraceServiceRequest, in the JSON encoding
+// of OTLP/HTTP
+func encodeSpans(serviceName string, spans []*Span) map[string]interface{} {
+	encoded := make([]interface{}, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, encodeSpan(s))
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{encodeAttribute(attribute{"service.name", serviceName})},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/cvmfs/gateway"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+func encodeSpan(s *Span) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attrs := make([]interface{}, 0, len(s.attrs))
+	for _, a := range s.attrs {
+		attrs = append(attrs, encodeAttribute(a))
+	}
+	span := map[string]interface{}{
+		"traceId":           s.sc.TraceIDString(),
+		"spanId":            s.sc.SpanIDString(),
+		"name":              s.name,
+		"kind":              s.kind,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        attrs,
+	}
+	if s.parent != [8]byte{} {
+		span["parentSpanId"] = SpanContext{SpanID: s.parent}.SpanIDString()
+	}
+	if s.errorMsg != "" {
+		// STATUS_CODE_ERROR
+		span["status"] = map[string]interface{}{"code": 2, "message": s.errorMsg}
+	}
+	return span
+}
+
+func encodeAttribute(a attribute) map[string]interface{} {
+	var value map[string]interface{}
+	switch v := a.value.(type) {
+	case string:
+		value = map[string]interface{}{"stringValue": v}
+	case bool:
+		value = map[string]interface{}{"boolValue": v}
+	case int:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(int64(v), 10)}
+	case int64:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case uint64:
+		value = map[string]interface{}{"intValue": strconv.FormatUint(v, 10)}
+	case float64:
+		value = map[string]interface{}{"doubleValue": v}
+	default:
+		value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+	return map[string]interface{}{"key": a.key, "value": value}
+}
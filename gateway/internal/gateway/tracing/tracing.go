@@ -0,0 +1,211 @@
+// Package tracing implements the spans of the gateway, propagated with the W3C
+// trace context headers (https://www.w3.org/TR/trace-context/) and exported
+// to an OpenTelemetry collector with OTLP over HTTP, in JSON encoding.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C header carrying the trace context
+const TraceparentHeader = "traceparent"
+
+// flagSampled is the trace flag of the sampled traces
+const flagSampled = 0x01
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+}
+
+// Valid returns true if the trace and span IDs are set
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled returns true if the spans of the trace are recorded
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&flagSampled != 0
+}
+
+// TraceIDString returns the hex-encoded trace ID
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// SpanIDString returns the hex-encoded span ID
+func (sc SpanContext) SpanIDString() string {
+	return hex.EncodeToString(sc.SpanID[:])
+}
+
+// Traceparent returns the value of the traceparent header for the span
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%v-%v-%02x", sc.TraceIDString(), sc.SpanIDString(), sc.Flags)
+}
+
+// ParseTraceparent parses the value of a traceparent header. Headers of
+// later versions are accepted if they start with the fields of version 00.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	header = strings.TrimSpace(header)
+	if len(header) < 55 || (len(header) > 55 && header[55] != '-') {
+		return sc, false
+	}
+	version := header[0:2]
+	if version == "ff" || !isLowerHex(version) || (version == "00" && len(header) != 55) {
+		return sc, false
+	}
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return sc, false
+	}
+	traceID, spanID, flags := header[3:35], header[36:52], header[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return sc, false
+	}
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+	var f [1]byte
+	hex.Decode(f[:], []byte(flags))
+	sc.Flags = f[0]
+	return sc, sc.Valid()
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Kinds of spans, numbered like the SpanKind of OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+)
+
+// Span is a timed operation of a trace. The methods of a nil span do nothing,
+// so that the spans of a context can be used without checking them.
+type Span struct {
+	mu       sync.Mutex
+	name     string
+	kind     int
+	sc       SpanContext
+	parent   [8]byte
+	start    time.Time
+	end      time.Time
+	attrs    []attribute
+	errorMsg string
+	ended    bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start a span of the given kind, child of the span of the context. Without a
+// parent span, the span continues the remote trace of the context (see
+// ContextWithRemote), or starts a new trace.
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.sc.TraceID = parent.sc.TraceID
+		span.sc.Flags = parent.sc.Flags
+		span.parent = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.Valid() {
+		span.sc.TraceID = remote.TraceID
+		span.sc.Flags = remote.Flags
+		span.parent = remote.SpanID
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		if Enabled() {
+			span.sc.Flags = flagSampled
+		}
+	}
+	rand.Read(span.sc.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartChild starts an internal span if the context is traced, and returns a
+// nil span otherwise. It is used for the operations which are also run
+// outside of requests, and would otherwise start many single-span traces.
+func StartChild(ctx context.Context, name string) (context.Context, *Span) {
+	if SpanFromContext(ctx) == nil {
+		return ctx, nil
+	}
+	return Start(ctx, name, KindInternal)
+}
+
+// ContextWithRemote returns a context carrying the span context received from
+// a remote caller, which becomes the parent of the next span started
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext returns the current span of the context, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Context returns the span context of the span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute records an attribute of the span. The values are strings,
+// booleans, integers or floats.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attribute{key, value})
+}
+
+// SetError marks the span as failed, if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorMsg = err.Error()
+}
+
+// End the span and export it, if the trace is sampled. Only the first call
+// has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.sc.Sampled() {
+		export(s)
+	}
+}
@@ -0,0 +1,162 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(valid)
+	if !ok || !sc.Sampled() {
+		t.Fatalf("could not parse %v", valid)
+	}
+	if sc.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanIDString() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected span context: %v", sc.Traceparent())
+	}
+	if sc.Traceparent() != valid {
+		t.Errorf("unexpected traceparent: %v", sc.Traceparent())
+	}
+
+	cases := []struct {
+		header string
+		valid  bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		// Later versions may have more fields
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if _, ok := ParseTraceparent(c.header); ok != c.valid {
+			t.Errorf("unexpected validity of %q: %v", c.header, ok)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, server := Start(ContextWithRemote(context.Background(), remote), "server", KindServer)
+	if server.Context().TraceID != remote.TraceID || server.parent != remote.SpanID {
+		t.Errorf("server span does not continue the remote trace")
+	}
+	_, child := StartChild(ctx, "child")
+	if child == nil || child.Context().TraceID != remote.TraceID || child.parent != server.Context().SpanID {
+		t.Errorf("child span is not part of the trace")
+	}
+	if child.Context().SpanID == server.Context().SpanID {
+		t.Errorf("child span has the ID of its parent")
+	}
+
+	if _, span := StartChild(context.Background(), "untraced"); span != nil {
+		t.Errorf("span started outside of a trace")
+	}
+	// The methods of nil spans do nothing
+	var span *Span
+	span.SetAttribute("key", "value")
+	span.SetError(fmt.Errorf("error"))
+	span.End()
+}
+
+// collector is a stand-in for the OTLP/HTTP endpoint of a collector
+type collector struct {
+	mu    sync.Mutex
+	spans []map[string]interface{}
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []map[string]interface{} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if r.URL.Path != "/v1/traces" || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func (c *collector) received() map[string]map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := make(map[string]map[string]interface{})
+	for _, s := range c.spans {
+		spans[s["name"].(string)] = s
+	}
+	return spans
+}
+
+func TestExport(t *testing.T) {
+	col := &collector{}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	if err := StartExporter(Options{Endpoint: "localhost:4318"}); err == nil {
+		t.Errorf("invalid endpoint accepted")
+	}
+	if err := StartExporter(Options{Endpoint: srv.URL + "/v1/traces", ServiceName: "test"}); err != nil {
+		t.Fatalf("could not start exporter: %v", err)
+	}
+
+	ctx, root := Start(context.Background(), "root", KindServer)
+	if !root.Context().Sampled() {
+		t.Errorf("new traces are not sampled")
+	}
+	_, child := StartChild(ctx, "child")
+	child.SetAttribute("count", 3)
+	child.SetError(fmt.Errorf("failed"))
+	child.End()
+	root.End()
+
+	// The traces not sampled by the caller are not exported
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := Start(ContextWithRemote(context.Background(), unsampled), "unsampled", KindServer)
+	span.End()
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	StopExporter(stopCtx)
+	if Enabled() {
+		t.Errorf("export not stopped")
+	}
+
+	spans := col.received()
+	if len(spans) != 2 || spans["root"] == nil || spans["child"] == nil {
+		t.Fatalf("unexpected spans exported: %v", spans)
+	}
+	exported := spans["child"]
+	if exported["traceId"] != root.Context().TraceIDString() || exported["parentSpanId"] != root.Context().SpanIDString() {
+		t.Errorf("unexpected trace of exported span: %v", exported)
+	}
+	if status, _ := exported["status"].(map[string]interface{}); status["message"] != "failed" {
+		t.Errorf("unexpected status of exported span: %v", exported["status"])
+	}
+	attrs, _ := exported["attributes"].([]interface{})
+	if len(attrs) != 1 || fmt.Sprint(attrs[0]) != "map[key:count value:map[intValue:3]]" {
+		t.Errorf("unexpected attributes of exported span: %v", attrs)
+	}
+	if _, ok := spans["root"]["parentSpanId"]; ok {
+		t.Errorf("root span has a parent: %v", spans["root"])
+	}
+}
@@ -1,49 +1,317 @@
 package test
 
-/*
-
 import (
-	"net/http"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
 	"os"
+	"path"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/cvmfs/gateway/client"
+	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
 	fe "github.com/cvmfs/gateway/internal/gateway/frontend"
+	"github.com/cvmfs/gateway/internal/gateway/receiver"
 )
 
-const (
-	testServerURLRoot = "http://localhost:4929/api/v1"
-)
+const accessConfig = `
+{
+	"version": 2,
+	"repos": [
+		{
+			"domain": "test.repo.org",
+			"keys": [
+				{
+					"id": "key1",
+					"path": "/"
+				}
+			]
+		}
+	],
+	"keys": [
+		{
+			"type": "plain_text",
+			"id": "key1",
+			"secret": "secret1"
+		},
+		{
+			"type": "plain_text",
+			"id": "admin",
+			"secret": "admin_secret",
+			"admin": true
+		}
+	]
+}
+`
+
+// gateway is a complete gateway instance (frontend and backend with the
+// mock receiver) serving requests on a local port
+type gateway struct {
+	services *be.Services
+	server   *httptest.Server
+	client   *client.Client
+}
+
+func (g *gateway) script() *receiver.MockScript {
+	return g.services.Pool.MockScript()
+}
+
+func (g *gateway) stop() {
+	g.server.Close()
+	g.services.Stop()
+}
+
+// startGateway starts a gateway using workDir for its databases. Starting a
+// second gateway on the same workDir after stopping the first one simulates
+// a restart.
+func startGateway(t *testing.T, workDir string, maxLeaseTime time.Duration) *gateway {
+	accessConfigFile := path.Join(workDir, "repo.json")
+	if err := os.WriteFile(accessConfigFile, []byte(accessConfig), 0644); err != nil {
+		t.Fatalf("could not write access configuration: %v", err)
+	}
+
+	cfg := gw.Config{
+		MaxLeaseTime:     maxLeaseTime,
+		LogLevel:         "info",
+		AccessConfigFile: accessConfigFile,
+		NumReceivers:     4,
+		WorkDir:          workDir,
+		MockReceiver:     true,
+	}
+	services, err := be.StartBackend(cfg)
+	if err != nil {
+		t.Fatalf("could not start backend: %v", err)
+	}
+
+	server := httptest.NewServer(fe.NewFrontend(services, 0, 10*time.Second).Handler)
+	return &gateway{
+		services: services,
+		server:   server,
+		client:   client.New(server.URL, "key1", "secret1"),
+	}
+}
+
+func submit(ctx context.Context, c *client.Client, token, digest string) error {
+	payload := []byte("object pack " + digest)
+	return c.SubmitPayload(ctx, token, digest, 4, bytes.NewReader(payload), int64(len(payload)))
+}
+
+func TestEndToEndFullSession(t *testing.T) {
+	g := startGateway(t, t.TempDir(), 10*time.Second)
+	defer g.stop()
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		lease, err := g.client.NewLease(ctx, "test.repo.org/some/path", "publisher")
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
+		}
+		for _, digest := range []string{"abc", "def"} {
+			if err := submit(ctx, g.client, lease.Token, digest); err != nil {
+				t.Fatalf("could not submit payload: %v", err)
+			}
+		}
+		payloads, err := g.client.GetLeasePayloads(ctx, lease.Token)
+		if err != nil || len(payloads) != 2 {
+			t.Fatalf("unexpected payloads: %v %v", payloads, err)
+		}
+		rev, err := g.client.CommitLease(ctx, lease.Token, "old", "new", client.Tag{})
+		if err != nil {
+			t.Fatalf("could not commit lease: %v", err)
+		}
+		if rev != uint64(i) {
+			t.Errorf("unexpected final revision: %v", rev)
+		}
+	}
+
+	if payloads, commits := g.script().Counts(); payloads != 4 || commits != 2 {
+		t.Errorf("unexpected receiver activity: %v payloads, %v commits", payloads, commits)
+	}
+	leases, err := g.client.GetLeases(ctx)
+	if err != nil || len(leases) != 0 {
+		t.Errorf("no lease should remain: %v %v", leases, err)
+	}
+}
 
-func startTestServer() (*http.Server, *be.Services) {
-	backend := be.StartTestBackend("end_to_end_test", 1*time.Second)
+func TestEndToEndOverlappingPaths(t *testing.T) {
+	g := startGateway(t, t.TempDir(), 10*time.Second)
+	defer g.stop()
+	ctx := context.Background()
 
-	srv := fe.NewFrontend(
-		backend, backend.Config.Port, backend.Config.MaxLeaseTime)
+	// Concurrent requests for overlapping paths: only one can succeed
+	paths := []string{"/a", "/a/b", "/a/b/c", "/a", "/a/b"}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	granted := 0
+	for _, p := range paths {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			_, err := g.client.NewLease(ctx, "test.repo.org"+p, "")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				granted++
+			case !client.IsCode(err, "path_busy"):
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(p)
+	}
+	wg.Wait()
+	if granted != 1 {
+		t.Errorf("%v leases granted on overlapping paths", granted)
+	}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != nil {
-			os.Exit(1)
+	// Disjoint paths are committed concurrently, one at a time in the receiver
+	g.script().SetLatency(20 * time.Millisecond)
+	tokens := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		lease, err := g.client.NewLease(ctx, fmt.Sprintf("test.repo.org/disjoint/%v", i), "")
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
 		}
-	}()
+		tokens = append(tokens, lease.Token)
+	}
+	revisions := make(map[uint64]bool)
+	for _, token := range tokens {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+			if err := submit(ctx, g.client, token, token); err != nil {
+				t.Errorf("could not submit payload: %v", err)
+				return
+			}
+			rev, err := g.client.CommitLease(ctx, token, "old", "new", client.Tag{})
+			if err != nil {
+				t.Errorf("could not commit lease: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			revisions[rev] = true
+		}(token)
+	}
+	wg.Wait()
+	if len(revisions) != len(tokens) {
+		t.Errorf("commits should produce distinct revisions: %v", revisions)
+	}
+}
+
+func TestEndToEndLeaseExpiry(t *testing.T) {
+	g := startGateway(t, t.TempDir(), 200*time.Millisecond)
+	defer g.stop()
+	ctx := context.Background()
 
-	time.Sleep(50 * time.Millisecond)
+	lease, err := g.client.NewLease(ctx, "test.repo.org/expiring", "")
+	if err != nil {
+		t.Fatalf("could not obtain lease: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
 
-	return srv, backend
+	if err := submit(ctx, g.client, lease.Token, "abc"); !client.IsCode(err, "invalid_lease") {
+		t.Errorf("payload for an expired lease should be rejected: %v", err)
+	}
+	if _, err := g.client.CommitLease(ctx, lease.Token, "old", "new", client.Tag{}); !client.IsCode(err, "invalid_lease") {
+		t.Errorf("commit of an expired lease should be rejected: %v", err)
+	}
+	if _, err := g.client.NewLease(ctx, "test.repo.org/expiring/below", ""); err != nil {
+		t.Errorf("path of an expired lease should be available: %v", err)
+	}
 }
 
-func TestMain(m *testing.M) {
-	srv, backend := startTestServer()
-	defer func() { srv.Close(); backend.Stop() }()
+func TestEndToEndReceiverFaults(t *testing.T) {
+	g := startGateway(t, t.TempDir(), 10*time.Second)
+	defer g.stop()
+	ctx := context.Background()
 
-	os.Exit(m.Run())
+	lease, err := g.client.NewLease(ctx, "test.repo.org/faults", "")
+	if err != nil {
+		t.Fatalf("could not obtain lease: %v", err)
+	}
+
+	t.Run("payload failure", func(t *testing.T) {
+		g.script().FailPayloads(1)
+		if err := submit(ctx, g.client, lease.Token, "abc"); !client.IsCode(err, "receiver_failure") {
+			t.Errorf("expected receiver failure, got: %v", err)
+		}
+		if err := submit(ctx, g.client, lease.Token, "abc"); err != nil {
+			t.Errorf("resubmission should succeed: %v", err)
+		}
+	})
+	t.Run("crash mid-payload", func(t *testing.T) {
+		g.script().CrashPayloads(1)
+		if err := submit(ctx, g.client, lease.Token, "def"); !client.IsCode(err, "receiver_failure") {
+			t.Errorf("expected receiver failure, got: %v", err)
+		}
+		if err := submit(ctx, g.client, lease.Token, "def"); err != nil {
+			t.Errorf("resubmission should succeed: %v", err)
+		}
+		payloads, err := g.client.GetLeasePayloads(ctx, lease.Token)
+		if err != nil || len(payloads) != 4 {
+			t.Errorf("all the attempts should be recorded: %v %v", payloads, err)
+		}
+	})
+	t.Run("wrong final revision", func(t *testing.T) {
+		g.script().SetFinalRevision(42)
+		rev, err := g.client.CommitLease(ctx, lease.Token, "old", "new", client.Tag{})
+		if err != nil {
+			t.Fatalf("could not commit lease: %v", err)
+		}
+		if rev != 42 {
+			t.Errorf("final revision reported by the receiver should be returned: %v", rev)
+		}
+	})
+	t.Run("commit failure", func(t *testing.T) {
+		lease, err := g.client.NewLease(ctx, "test.repo.org/faults", "")
+		if err != nil {
+			t.Fatalf("could not obtain lease: %v", err)
+		}
+		g.script().FailCommits(1)
+		if _, err := g.client.CommitLease(ctx, lease.Token, "old", "new", client.Tag{}); !client.IsCode(err, "receiver_failure") {
+			t.Errorf("expected receiver failure, got: %v", err)
+		}
+		if _, err := g.client.GetLease(ctx, lease.Token); err != nil {
+			t.Errorf("lease should survive a failed commit: %v", err)
+		}
+		if err := g.client.CancelLease(ctx, lease.Token); err != nil {
+			t.Errorf("could not cancel lease: %v", err)
+		}
+	})
 }
 
-func TestEndToEndFullSession(t *testing.T) {
-	_, err := http.DefaultClient.Get(testServerURLRoot)
+func TestEndToEndCrashRecovery(t *testing.T) {
+	workDir := t.TempDir()
+	ctx := context.Background()
+
+	g := startGateway(t, workDir, 10*time.Second)
+	lease, err := g.client.NewLease(ctx, "test.repo.org/recovered", "publisher")
 	if err != nil {
-		t.Fatalf("could not make GET request to API root")
+		t.Fatalf("could not obtain lease: %v", err)
+	}
+	if err := submit(ctx, g.client, lease.Token, "abc"); err != nil {
+		t.Fatalf("could not submit payload: %v", err)
+	}
+	g.stop()
+
+	g = startGateway(t, workDir, 10*time.Second)
+	defer g.stop()
+
+	info, err := g.client.GetLease(ctx, lease.Token)
+	if err != nil || info.Hostname != "publisher" {
+		t.Fatalf("lease should survive a restart: %v %v", info, err)
+	}
+	if err := submit(ctx, g.client, lease.Token, "abc"); err != nil {
+		t.Errorf("resubmission after restart should succeed: %v", err)
+	}
+	if payloads, _ := g.script().Counts(); payloads != 0 {
+		t.Errorf("accepted payload should not be resubmitted to the receiver")
+	}
+	if _, err := g.client.CommitLease(ctx, lease.Token, "old", "new", client.Tag{}); err != nil {
+		t.Errorf("lease should be committed after a restart: %v", err)
 	}
 }
-*/
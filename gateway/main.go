@@ -1,35 +1,62 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"net/http"
-	_ "net/http/pprof"
 	"os"
+	"time"
 
 	gw "github.com/cvmfs/gateway/internal/gateway"
 	be "github.com/cvmfs/gateway/internal/gateway/backend"
 	fe "github.com/cvmfs/gateway/internal/gateway/frontend"
+	"github.com/cvmfs/gateway/internal/gateway/tracing"
+	"github.com/spf13/pflag"
 )
 
 var Version = "development"
 
 func main() {
 	fmt.Println("CernVM-FS Gateway Service Version:\t", Version)
+	checkSchema := pflag.Bool("check-schema", false, "report the lease DB schema version and pending migrations, then exit")
+	migrateOnly := pflag.Bool("migrate-only", false, "migrate the lease DB to the latest schema version, then exit")
+	checkConfig := pflag.Bool("check-config", false, "validate the configuration files and print the effective configuration, then exit")
 	gw.InitLogging(os.Stderr)
 	cfg, err := gw.ReadConfig()
 	if err != nil {
 		gw.Log("main", gw.LogError).
+			Err(err).
 			Msg("reading configuration failed")
 		os.Exit(1)
 	}
 	gw.ConfigLogging(cfg)
 
+	if *checkConfig {
+		os.Exit(runCheckConfig(*cfg))
+	}
+	if *checkSchema {
+		os.Exit(runCheckSchema(*cfg))
+	}
+	if *migrateOnly {
+		os.Exit(runMigrateOnly(*cfg))
+	}
+
 	gw.Log("main", gw.LogInfo).
 		Msgf("configuration read: %+v", cfg)
 
 	gw.Log("main", gw.LogInfo).
 		Msg("starting repository gateway")
 
+	if cfg.Tracing.Endpoint != "" {
+		if err := startTracing(cfg.Tracing); err != nil {
+			gw.Log("main", gw.LogError).
+				Err(err).
+				Msg("could not start the export of the spans")
+			os.Exit(1)
+		}
+		defer stopTracing()
+	}
+
 	services, err := be.StartBackend(*cfg)
 	if err != nil {
 		gw.Log("main", gw.LogError).
@@ -39,19 +66,9 @@ func main() {
 	}
 	defer services.Stop()
 
-	// Start the default HTTP server for pprof requests (restricted to localhost)
-	go func() {
-		if err := http.ListenAndServe("localhost:6060", nil); err != nil {
-			gw.Log("main", gw.LogError).
-				Err(err).
-				Msg("pprof HTTP server failed")
-			os.Exit(1)
-		}
-	}()
-
 	go func() {
 		timeout := services.Config.MaxLeaseTime
-		if err := fe.Start(services, cfg.Port, timeout); err != nil {
+		if err := fe.Start(services, cfg.ListenerConfigs(), timeout); err != nil {
 			gw.Log("main", gw.LogError).
 				Err(err).
 				Msg("starting the HTTP front-end failed")
@@ -64,3 +81,97 @@ func main() {
 	gw.Log("main", gw.LogInfo).Msg("waiting for interrupt")
 	<-done
 }
+
+// startTracing starts the export of the spans to the OpenTelemetry collector
+func startTracing(cfg gw.TracingConfig) error {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "cvmfs-gateway"
+	}
+	return tracing.StartExporter(tracing.Options{
+		Endpoint:    cfg.Endpoint,
+		ServiceName: serviceName,
+		OnError: func(err error) {
+			gw.Log("tracing", gw.LogWarn).
+				Err(err).
+				Msg("span export failed")
+		},
+	})
+}
+
+// stopTracing exports the remaining spans
+func stopTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tracing.StopExporter(ctx)
+}
+
+// runCheckConfig validates the configuration files and prints the effective
+// configuration with the secrets redacted. The exit code is 0 if no problems
+// were found, 1 otherwise.
+func runCheckConfig(cfg gw.Config) int {
+	report := be.CheckConfig(cfg)
+	problems := report.Problems
+	report.Problems = nil
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		gw.Log("main", gw.LogError).
+			Err(err).
+			Msg("could not print configuration")
+		return 1
+	}
+	fmt.Println(string(buf))
+	for _, p := range problems {
+		fmt.Printf("problem: %v\n", p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	fmt.Println("configuration OK")
+	return 0
+}
+
+// runCheckSchema reports the schema version of the lease DB. The exit code is
+// 0 if the DB is up to date, 2 if migrations are pending, 1 on error.
+func runCheckSchema(cfg gw.Config) int {
+	status, err := be.CheckSchema(cfg)
+	if err != nil {
+		gw.Log("main", gw.LogError).
+			Err(err).
+			Msg("could not check lease DB schema")
+		return 1
+	}
+	if status.Version == 0 {
+		fmt.Printf("lease DB does not exist, it will be created with schema version %v\n", status.Latest)
+		return 0
+	}
+	fmt.Printf("lease DB schema version: %v (latest: %v)\n", status.Version, status.Latest)
+	for _, m := range status.Pending {
+		fmt.Printf("pending migration: %v\n", m)
+	}
+	if len(status.Pending) > 0 {
+		return 2
+	}
+	return 0
+}
+
+// runMigrateOnly opens the lease DB, which migrates it to the latest schema
+// version, and closes it without starting the services
+func runMigrateOnly(cfg gw.Config) int {
+	db, err := be.OpenDB(cfg)
+	if err != nil {
+		gw.Log("main", gw.LogError).
+			Err(err).
+			Msg("could not migrate lease DB")
+		return 1
+	}
+	if err := db.Close(); err != nil {
+		gw.Log("main", gw.LogError).
+			Err(err).
+			Msg("could not close lease DB")
+		return 1
+	}
+	gw.Log("main", gw.LogInfo).
+		Msg("lease DB is up to date")
+	return 0
+}
@@ -100,15 +100,16 @@ def gc(args):
     print(json.dumps(rep.json()))
 
 def publish_manifest(args):
-    manifest = requests.get(args.manifest).text
+    manifest = requests.get(args.manifest).content
     req = {
         'version': 1,
         'timestamp': time.strftime('%d %b %Y %H:%M:%S', time.gmtime()),
         'type': 'activity',
         'repository': args.repo,
-        'manifest': base64.b64encode(manifest.encode('utf-8')).decode('utf-8')
+        'manifest': base64.b64encode(manifest).decode('utf-8')
     }
-    rep = requests.post(args.gw_url + '/notifications/publish', json=req)
+    headers = make_headers(args.key_id, args.secret, json.dumps(req))
+    rep = requests.post(args.gw_url + '/notifications/publish', json=req, headers=headers)
     print(json.dumps(rep.json()))
 
 def subscribe(args):
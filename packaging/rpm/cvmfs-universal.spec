@@ -692,6 +692,7 @@ systemctl daemon-reload
 %if 0%{?build_gateway}
 %files gateway
 %{_bindir}/cvmfs_gateway
+%{_bindir}/cvmfs-gw
 /usr/libexec/cvmfs-gateway/scripts/run_cvmfs_gateway.sh
 %{_unitdir}/cvmfs-gateway.service
 %{_unitdir}/cvmfs-gateway@.service